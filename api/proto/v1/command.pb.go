@@ -23,12 +23,35 @@ const (
 
 // Command represents a business command
 type Command struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
-	Payload       []byte                 `protobuf:"bytes,3,opt,name=payload,proto3" json:"payload,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	Id           string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Type         string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Payload      []byte                 `protobuf:"bytes,3,opt,name=payload,proto3" json:"payload,omitempty"`
+	Priority     int32                  `protobuf:"varint,4,opt,name=priority,proto3" json:"priority,omitempty"`
+	Status       string                 `protobuf:"bytes,5,opt,name=status,proto3" json:"status,omitempty"`
+	Metadata     []byte                 `protobuf:"bytes,6,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	ErrorDetails *ErrorDetails          `protobuf:"bytes,7,opt,name=error_details,json=errorDetails,proto3" json:"error_details,omitempty"`
+	// Timestamps are Unix milliseconds, zero when unset
+	CreatedAt      int64  `protobuf:"varint,8,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedAt      int64  `protobuf:"varint,9,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	ScheduledFor   int64  `protobuf:"varint,10,opt,name=scheduled_for,json=scheduledFor,proto3" json:"scheduled_for,omitempty"`
+	ProcessedAt    int64  `protobuf:"varint,11,opt,name=processed_at,json=processedAt,proto3" json:"processed_at,omitempty"`
+	CompletedAt    int64  `protobuf:"varint,12,opt,name=completed_at,json=completedAt,proto3" json:"completed_at,omitempty"`
+	RetryCount     int32  `protobuf:"varint,13,opt,name=retry_count,json=retryCount,proto3" json:"retry_count,omitempty"`
+	MaxRetries     int32  `protobuf:"varint,14,opt,name=max_retries,json=maxRetries,proto3" json:"max_retries,omitempty"`
+	RetryBackoffMs int64  `protobuf:"varint,15,opt,name=retry_backoff_ms,json=retryBackoffMs,proto3" json:"retry_backoff_ms,omitempty"`
+	TimeoutAfterMs int64  `protobuf:"varint,16,opt,name=timeout_after_ms,json=timeoutAfterMs,proto3" json:"timeout_after_ms,omitempty"`
+	CorrelationId  string `protobuf:"bytes,17,opt,name=correlation_id,json=correlationId,proto3" json:"correlation_id,omitempty"`
+	UserId         string `protobuf:"bytes,18,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	IdempotencyKey string `protobuf:"bytes,19,opt,name=idempotency_key,json=idempotencyKey,proto3" json:"idempotency_key,omitempty"`
+	EntityId       string `protobuf:"bytes,20,opt,name=entity_id,json=entityId,proto3" json:"entity_id,omitempty"`
+	Error          string `protobuf:"bytes,21,opt,name=error,proto3" json:"error,omitempty"`
+	CancelledAt    int64  `protobuf:"varint,22,opt,name=cancelled_at,json=cancelledAt,proto3" json:"cancelled_at,omitempty"`
+	CancelledBy    string `protobuf:"bytes,23,opt,name=cancelled_by,json=cancelledBy,proto3" json:"cancelled_by,omitempty"`
+	CancelReason   string `protobuf:"bytes,24,opt,name=cancel_reason,json=cancelReason,proto3" json:"cancel_reason,omitempty"`
+	CallbackUrl    string `protobuf:"bytes,25,opt,name=callback_url,json=callbackUrl,proto3" json:"callback_url,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
 }
 
 func (x *Command) Reset() {
@@ -82,18 +105,256 @@ func (x *Command) GetPayload() []byte {
 	return nil
 }
 
+func (x *Command) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *Command) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Command) GetMetadata() []byte {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *Command) GetErrorDetails() *ErrorDetails {
+	if x != nil {
+		return x.ErrorDetails
+	}
+	return nil
+}
+
+func (x *Command) GetCreatedAt() int64 {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return 0
+}
+
+func (x *Command) GetUpdatedAt() int64 {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return 0
+}
+
+func (x *Command) GetScheduledFor() int64 {
+	if x != nil {
+		return x.ScheduledFor
+	}
+	return 0
+}
+
+func (x *Command) GetProcessedAt() int64 {
+	if x != nil {
+		return x.ProcessedAt
+	}
+	return 0
+}
+
+func (x *Command) GetCompletedAt() int64 {
+	if x != nil {
+		return x.CompletedAt
+	}
+	return 0
+}
+
+func (x *Command) GetRetryCount() int32 {
+	if x != nil {
+		return x.RetryCount
+	}
+	return 0
+}
+
+func (x *Command) GetMaxRetries() int32 {
+	if x != nil {
+		return x.MaxRetries
+	}
+	return 0
+}
+
+func (x *Command) GetRetryBackoffMs() int64 {
+	if x != nil {
+		return x.RetryBackoffMs
+	}
+	return 0
+}
+
+func (x *Command) GetTimeoutAfterMs() int64 {
+	if x != nil {
+		return x.TimeoutAfterMs
+	}
+	return 0
+}
+
+func (x *Command) GetCorrelationId() string {
+	if x != nil {
+		return x.CorrelationId
+	}
+	return ""
+}
+
+func (x *Command) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *Command) GetIdempotencyKey() string {
+	if x != nil {
+		return x.IdempotencyKey
+	}
+	return ""
+}
+
+func (x *Command) GetEntityId() string {
+	if x != nil {
+		return x.EntityId
+	}
+	return ""
+}
+
+func (x *Command) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *Command) GetCancelledAt() int64 {
+	if x != nil {
+		return x.CancelledAt
+	}
+	return 0
+}
+
+func (x *Command) GetCancelledBy() string {
+	if x != nil {
+		return x.CancelledBy
+	}
+	return ""
+}
+
+func (x *Command) GetCancelReason() string {
+	if x != nil {
+		return x.CancelReason
+	}
+	return ""
+}
+
+func (x *Command) GetCallbackUrl() string {
+	if x != nil {
+		return x.CallbackUrl
+	}
+	return ""
+}
+
+// ErrorDetails holds information about command failures
+type ErrorDetails struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Code          string                 `protobuf:"bytes,1,opt,name=code,proto3" json:"code,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	Details       string                 `protobuf:"bytes,3,opt,name=details,proto3" json:"details,omitempty"`
+	OccurredAt    int64                  `protobuf:"varint,4,opt,name=occurred_at,json=occurredAt,proto3" json:"occurred_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ErrorDetails) Reset() {
+	*x = ErrorDetails{}
+	mi := &file_api_proto_v1_command_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ErrorDetails) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ErrorDetails) ProtoMessage() {}
+
+func (x *ErrorDetails) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_command_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ErrorDetails.ProtoReflect.Descriptor instead.
+func (*ErrorDetails) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_command_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ErrorDetails) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *ErrorDetails) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *ErrorDetails) GetDetails() string {
+	if x != nil {
+		return x.Details
+	}
+	return ""
+}
+
+func (x *ErrorDetails) GetOccurredAt() int64 {
+	if x != nil {
+		return x.OccurredAt
+	}
+	return 0
+}
+
 // SubmitCommandRequest is the request for submitting a command
 type SubmitCommandRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
-	Payload       []byte                 `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Type     string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Payload  []byte                 `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	EntityId string                 `protobuf:"bytes,3,opt,name=entity_id,json=entityId,proto3" json:"entity_id,omitempty"`
+	// Priority from 1 (low) to 4 (critical), zero selects normal priority
+	Priority       int32  `protobuf:"varint,4,opt,name=priority,proto3" json:"priority,omitempty"`
+	Metadata       []byte `protobuf:"bytes,5,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	CorrelationId  string `protobuf:"bytes,6,opt,name=correlation_id,json=correlationId,proto3" json:"correlation_id,omitempty"`
+	MaxRetries     int32  `protobuf:"varint,7,opt,name=max_retries,json=maxRetries,proto3" json:"max_retries,omitempty"`
+	TimeoutAfterMs int64  `protobuf:"varint,8,opt,name=timeout_after_ms,json=timeoutAfterMs,proto3" json:"timeout_after_ms,omitempty"`
+	// Unix milliseconds before which the command is held back, zero runs it immediately
+	ScheduledFor int64 `protobuf:"varint,9,opt,name=scheduled_for,json=scheduledFor,proto3" json:"scheduled_for,omitempty"`
+	// Payload schema version such as "v2", empty selects the latest
+	SchemaVersion string `protobuf:"bytes,10,opt,name=schema_version,json=schemaVersion,proto3" json:"schema_version,omitempty"`
+	// Idempotency key of this command, takes precedence over the metadata header
+	IdempotencyKey string `protobuf:"bytes,11,opt,name=idempotency_key,json=idempotencyKey,proto3" json:"idempotency_key,omitempty"`
+	// URL receiving a signed POST once the command has finished
+	CallbackUrl   string `protobuf:"bytes,12,opt,name=callback_url,json=callbackUrl,proto3" json:"callback_url,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *SubmitCommandRequest) Reset() {
 	*x = SubmitCommandRequest{}
-	mi := &file_api_proto_v1_command_proto_msgTypes[1]
+	mi := &file_api_proto_v1_command_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -105,7 +366,7 @@ func (x *SubmitCommandRequest) String() string {
 func (*SubmitCommandRequest) ProtoMessage() {}
 
 func (x *SubmitCommandRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_api_proto_v1_command_proto_msgTypes[1]
+	mi := &file_api_proto_v1_command_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -118,7 +379,7 @@ func (x *SubmitCommandRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SubmitCommandRequest.ProtoReflect.Descriptor instead.
 func (*SubmitCommandRequest) Descriptor() ([]byte, []int) {
-	return file_api_proto_v1_command_proto_rawDescGZIP(), []int{1}
+	return file_api_proto_v1_command_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *SubmitCommandRequest) GetType() string {
@@ -135,17 +396,89 @@ func (x *SubmitCommandRequest) GetPayload() []byte {
 	return nil
 }
 
+func (x *SubmitCommandRequest) GetEntityId() string {
+	if x != nil {
+		return x.EntityId
+	}
+	return ""
+}
+
+func (x *SubmitCommandRequest) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *SubmitCommandRequest) GetMetadata() []byte {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *SubmitCommandRequest) GetCorrelationId() string {
+	if x != nil {
+		return x.CorrelationId
+	}
+	return ""
+}
+
+func (x *SubmitCommandRequest) GetMaxRetries() int32 {
+	if x != nil {
+		return x.MaxRetries
+	}
+	return 0
+}
+
+func (x *SubmitCommandRequest) GetTimeoutAfterMs() int64 {
+	if x != nil {
+		return x.TimeoutAfterMs
+	}
+	return 0
+}
+
+func (x *SubmitCommandRequest) GetScheduledFor() int64 {
+	if x != nil {
+		return x.ScheduledFor
+	}
+	return 0
+}
+
+func (x *SubmitCommandRequest) GetSchemaVersion() string {
+	if x != nil {
+		return x.SchemaVersion
+	}
+	return ""
+}
+
+func (x *SubmitCommandRequest) GetIdempotencyKey() string {
+	if x != nil {
+		return x.IdempotencyKey
+	}
+	return ""
+}
+
+func (x *SubmitCommandRequest) GetCallbackUrl() string {
+	if x != nil {
+		return x.CallbackUrl
+	}
+	return ""
+}
+
 // SubmitCommandResponse is the response for command submission
 type SubmitCommandResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
+	Status        string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	Location      string                 `protobuf:"bytes,3,opt,name=location,proto3" json:"location,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *SubmitCommandResponse) Reset() {
 	*x = SubmitCommandResponse{}
-	mi := &file_api_proto_v1_command_proto_msgTypes[2]
+	mi := &file_api_proto_v1_command_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -157,7 +490,7 @@ func (x *SubmitCommandResponse) String() string {
 func (*SubmitCommandResponse) ProtoMessage() {}
 
 func (x *SubmitCommandResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_api_proto_v1_command_proto_msgTypes[2]
+	mi := &file_api_proto_v1_command_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -170,7 +503,7 @@ func (x *SubmitCommandResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SubmitCommandResponse.ProtoReflect.Descriptor instead.
 func (*SubmitCommandResponse) Descriptor() ([]byte, []int) {
-	return file_api_proto_v1_command_proto_rawDescGZIP(), []int{2}
+	return file_api_proto_v1_command_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *SubmitCommandResponse) GetCommandId() string {
@@ -180,17 +513,34 @@ func (x *SubmitCommandResponse) GetCommandId() string {
 	return ""
 }
 
+func (x *SubmitCommandResponse) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *SubmitCommandResponse) GetLocation() string {
+	if x != nil {
+		return x.Location
+	}
+	return ""
+}
+
 // GetCommandStatusRequest is the request for getting command status
 type GetCommandStatusRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	CommandId string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
+	// Milliseconds to wait for the command to reach a terminal status before
+	// answering with its current state, zero answers right away
+	WaitMs        int64 `protobuf:"varint,2,opt,name=wait_ms,json=waitMs,proto3" json:"wait_ms,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *GetCommandStatusRequest) Reset() {
 	*x = GetCommandStatusRequest{}
-	mi := &file_api_proto_v1_command_proto_msgTypes[3]
+	mi := &file_api_proto_v1_command_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -202,7 +552,7 @@ func (x *GetCommandStatusRequest) String() string {
 func (*GetCommandStatusRequest) ProtoMessage() {}
 
 func (x *GetCommandStatusRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_api_proto_v1_command_proto_msgTypes[3]
+	mi := &file_api_proto_v1_command_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -215,7 +565,7 @@ func (x *GetCommandStatusRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetCommandStatusRequest.ProtoReflect.Descriptor instead.
 func (*GetCommandStatusRequest) Descriptor() ([]byte, []int) {
-	return file_api_proto_v1_command_proto_rawDescGZIP(), []int{3}
+	return file_api_proto_v1_command_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *GetCommandStatusRequest) GetCommandId() string {
@@ -225,19 +575,28 @@ func (x *GetCommandStatusRequest) GetCommandId() string {
 	return ""
 }
 
+func (x *GetCommandStatusRequest) GetWaitMs() int64 {
+	if x != nil {
+		return x.WaitMs
+	}
+	return 0
+}
+
 // GetCommandStatusResponse is the response with command status
 type GetCommandStatusResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
 	Status        string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
 	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	ErrorDetails  *ErrorDetails          `protobuf:"bytes,4,opt,name=error_details,json=errorDetails,proto3" json:"error_details,omitempty"`
+	Command       *Command               `protobuf:"bytes,5,opt,name=command,proto3" json:"command,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *GetCommandStatusResponse) Reset() {
 	*x = GetCommandStatusResponse{}
-	mi := &file_api_proto_v1_command_proto_msgTypes[4]
+	mi := &file_api_proto_v1_command_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -249,7 +608,7 @@ func (x *GetCommandStatusResponse) String() string {
 func (*GetCommandStatusResponse) ProtoMessage() {}
 
 func (x *GetCommandStatusResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_api_proto_v1_command_proto_msgTypes[4]
+	mi := &file_api_proto_v1_command_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -262,7 +621,7 @@ func (x *GetCommandStatusResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetCommandStatusResponse.ProtoReflect.Descriptor instead.
 func (*GetCommandStatusResponse) Descriptor() ([]byte, []int) {
-	return file_api_proto_v1_command_proto_rawDescGZIP(), []int{4}
+	return file_api_proto_v1_command_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *GetCommandStatusResponse) GetCommandId() string {
@@ -286,32 +645,620 @@ func (x *GetCommandStatusResponse) GetError() string {
 	return ""
 }
 
-var File_api_proto_v1_command_proto protoreflect.FileDescriptor
+func (x *GetCommandStatusResponse) GetErrorDetails() *ErrorDetails {
+	if x != nil {
+		return x.ErrorDetails
+	}
+	return nil
+}
 
-const file_api_proto_v1_command_proto_rawDesc = "" +
-	"\n" +
-	"\x1aapi/proto/v1/command.proto\x12\rmiddleware.v1\"G\n" +
-	"\aCommand\x12\x0e\n" +
-	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
-	"\x04type\x18\x02 \x01(\tR\x04type\x12\x18\n" +
-	"\apayload\x18\x03 \x01(\fR\apayload\"D\n" +
-	"\x14SubmitCommandRequest\x12\x12\n" +
-	"\x04type\x18\x01 \x01(\tR\x04type\x12\x18\n" +
-	"\apayload\x18\x02 \x01(\fR\apayload\"6\n" +
-	"\x15SubmitCommandResponse\x12\x1d\n" +
-	"\n" +
-	"command_id\x18\x01 \x01(\tR\tcommandId\"8\n" +
-	"\x17GetCommandStatusRequest\x12\x1d\n" +
-	"\n" +
-	"command_id\x18\x01 \x01(\tR\tcommandId\"g\n" +
-	"\x18GetCommandStatusResponse\x12\x1d\n" +
-	"\n" +
-	"command_id\x18\x01 \x01(\tR\tcommandId\x12\x16\n" +
+func (x *GetCommandStatusResponse) GetCommand() *Command {
+	if x != nil {
+		return x.Command
+	}
+	return nil
+}
+
+// WatchCommandRequest is the request for watching command status changes
+type WatchCommandRequest struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	CommandId string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
+	// Interval between status checks, zero selects the server default
+	PollIntervalMs int64 `protobuf:"varint,2,opt,name=poll_interval_ms,json=pollIntervalMs,proto3" json:"poll_interval_ms,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *WatchCommandRequest) Reset() {
+	*x = WatchCommandRequest{}
+	mi := &file_api_proto_v1_command_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchCommandRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchCommandRequest) ProtoMessage() {}
+
+func (x *WatchCommandRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_command_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchCommandRequest.ProtoReflect.Descriptor instead.
+func (*WatchCommandRequest) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_command_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *WatchCommandRequest) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
+
+func (x *WatchCommandRequest) GetPollIntervalMs() int64 {
+	if x != nil {
+		return x.PollIntervalMs
+	}
+	return 0
+}
+
+// WatchCommandResponse carries a command whose status has changed
+type WatchCommandResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Command       *Command               `protobuf:"bytes,1,opt,name=command,proto3" json:"command,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchCommandResponse) Reset() {
+	*x = WatchCommandResponse{}
+	mi := &file_api_proto_v1_command_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchCommandResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchCommandResponse) ProtoMessage() {}
+
+func (x *WatchCommandResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_command_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchCommandResponse.ProtoReflect.Descriptor instead.
+func (*WatchCommandResponse) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_command_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *WatchCommandResponse) GetCommand() *Command {
+	if x != nil {
+		return x.Command
+	}
+	return nil
+}
+
+// CancelCommandRequest is the request for cancelling a command
+type CancelCommandRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
+	Reason        string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelCommandRequest) Reset() {
+	*x = CancelCommandRequest{}
+	mi := &file_api_proto_v1_command_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelCommandRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelCommandRequest) ProtoMessage() {}
+
+func (x *CancelCommandRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_command_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelCommandRequest.ProtoReflect.Descriptor instead.
+func (*CancelCommandRequest) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_command_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *CancelCommandRequest) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
+
+func (x *CancelCommandRequest) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+// CancelCommandResponse carries the command after cancellation. A running
+// command keeps the processing status until its handler stops.
+type CancelCommandResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Command       *Command               `protobuf:"bytes,1,opt,name=command,proto3" json:"command,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelCommandResponse) Reset() {
+	*x = CancelCommandResponse{}
+	mi := &file_api_proto_v1_command_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelCommandResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelCommandResponse) ProtoMessage() {}
+
+func (x *CancelCommandResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_command_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelCommandResponse.ProtoReflect.Descriptor instead.
+func (*CancelCommandResponse) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_command_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *CancelCommandResponse) GetCommand() *Command {
+	if x != nil {
+		return x.Command
+	}
+	return nil
+}
+
+// SubmitCommandBatchRequest is the request for submitting several commands
+type SubmitCommandBatchRequest struct {
+	state    protoimpl.MessageState  `protogen:"open.v1"`
+	Commands []*SubmitCommandRequest `protobuf:"bytes,1,rep,name=commands,proto3" json:"commands,omitempty"`
+	// "atomic" (default) stores all commands or none, "best_effort" stores
+	// the valid ones and reports the rest
+	Mode          string `protobuf:"bytes,2,opt,name=mode,proto3" json:"mode,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitCommandBatchRequest) Reset() {
+	*x = SubmitCommandBatchRequest{}
+	mi := &file_api_proto_v1_command_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitCommandBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitCommandBatchRequest) ProtoMessage() {}
+
+func (x *SubmitCommandBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_command_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitCommandBatchRequest.ProtoReflect.Descriptor instead.
+func (*SubmitCommandBatchRequest) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_command_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *SubmitCommandBatchRequest) GetCommands() []*SubmitCommandRequest {
+	if x != nil {
+		return x.Commands
+	}
+	return nil
+}
+
+func (x *SubmitCommandBatchRequest) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+// SubmitCommandBatchResponse reports the outcome of every command in request order.
+// A rejected atomic batch stores nothing and marks the valid commands "rejected".
+type SubmitCommandBatchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Mode          string                 `protobuf:"bytes,1,opt,name=mode,proto3" json:"mode,omitempty"`
+	Accepted      int32                  `protobuf:"varint,2,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	Failed        int32                  `protobuf:"varint,3,opt,name=failed,proto3" json:"failed,omitempty"`
+	Results       []*BatchItemResult     `protobuf:"bytes,4,rep,name=results,proto3" json:"results,omitempty"`
+	Rejected      bool                   `protobuf:"varint,5,opt,name=rejected,proto3" json:"rejected,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitCommandBatchResponse) Reset() {
+	*x = SubmitCommandBatchResponse{}
+	mi := &file_api_proto_v1_command_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitCommandBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitCommandBatchResponse) ProtoMessage() {}
+
+func (x *SubmitCommandBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_command_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitCommandBatchResponse.ProtoReflect.Descriptor instead.
+func (*SubmitCommandBatchResponse) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_command_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *SubmitCommandBatchResponse) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+func (x *SubmitCommandBatchResponse) GetAccepted() int32 {
+	if x != nil {
+		return x.Accepted
+	}
+	return 0
+}
+
+func (x *SubmitCommandBatchResponse) GetFailed() int32 {
+	if x != nil {
+		return x.Failed
+	}
+	return 0
+}
+
+func (x *SubmitCommandBatchResponse) GetResults() []*BatchItemResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+func (x *SubmitCommandBatchResponse) GetRejected() bool {
+	if x != nil {
+		return x.Rejected
+	}
+	return false
+}
+
+// BatchItemResult is the outcome of one command of a batch
+type BatchItemResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Index         int32                  `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	CommandId     string                 `protobuf:"bytes,2,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
+	Status        string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	Location      string                 `protobuf:"bytes,4,opt,name=location,proto3" json:"location,omitempty"`
+	Duplicate     bool                   `protobuf:"varint,5,opt,name=duplicate,proto3" json:"duplicate,omitempty"`
+	Error         string                 `protobuf:"bytes,6,opt,name=error,proto3" json:"error,omitempty"`
+	FieldErrors   []*FieldError          `protobuf:"bytes,7,rep,name=field_errors,json=fieldErrors,proto3" json:"field_errors,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchItemResult) Reset() {
+	*x = BatchItemResult{}
+	mi := &file_api_proto_v1_command_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchItemResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchItemResult) ProtoMessage() {}
+
+func (x *BatchItemResult) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_command_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchItemResult.ProtoReflect.Descriptor instead.
+func (*BatchItemResult) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_command_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *BatchItemResult) GetIndex() int32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *BatchItemResult) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
+
+func (x *BatchItemResult) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *BatchItemResult) GetLocation() string {
+	if x != nil {
+		return x.Location
+	}
+	return ""
+}
+
+func (x *BatchItemResult) GetDuplicate() bool {
+	if x != nil {
+		return x.Duplicate
+	}
+	return false
+}
+
+func (x *BatchItemResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *BatchItemResult) GetFieldErrors() []*FieldError {
+	if x != nil {
+		return x.FieldErrors
+	}
+	return nil
+}
+
+// FieldError describes a payload field that failed schema validation
+type FieldError struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// JSON Pointer of the field
+	Field         string `protobuf:"bytes,1,opt,name=field,proto3" json:"field,omitempty"`
+	Rule          string `protobuf:"bytes,2,opt,name=rule,proto3" json:"rule,omitempty"`
+	Message       string `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FieldError) Reset() {
+	*x = FieldError{}
+	mi := &file_api_proto_v1_command_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FieldError) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FieldError) ProtoMessage() {}
+
+func (x *FieldError) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_v1_command_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FieldError.ProtoReflect.Descriptor instead.
+func (*FieldError) Descriptor() ([]byte, []int) {
+	return file_api_proto_v1_command_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *FieldError) GetField() string {
+	if x != nil {
+		return x.Field
+	}
+	return ""
+}
+
+func (x *FieldError) GetRule() string {
+	if x != nil {
+		return x.Rule
+	}
+	return ""
+}
+
+func (x *FieldError) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+var File_api_proto_v1_command_proto protoreflect.FileDescriptor
+
+const file_api_proto_v1_command_proto_rawDesc = "" +
+	"\n" +
+	"\x1aapi/proto/v1/command.proto\x12\rmiddleware.v1\"\xc2\x06\n" +
+	"\aCommand\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x18\n" +
+	"\apayload\x18\x03 \x01(\fR\apayload\x12\x1a\n" +
+	"\bpriority\x18\x04 \x01(\x05R\bpriority\x12\x16\n" +
+	"\x06status\x18\x05 \x01(\tR\x06status\x12\x1a\n" +
+	"\bmetadata\x18\x06 \x01(\fR\bmetadata\x12@\n" +
+	"\rerror_details\x18\a \x01(\v2\x1b.middleware.v1.ErrorDetailsR\ferrorDetails\x12\x1d\n" +
+	"\n" +
+	"created_at\x18\b \x01(\x03R\tcreatedAt\x12\x1d\n" +
+	"\n" +
+	"updated_at\x18\t \x01(\x03R\tupdatedAt\x12#\n" +
+	"\rscheduled_for\x18\n" +
+	" \x01(\x03R\fscheduledFor\x12!\n" +
+	"\fprocessed_at\x18\v \x01(\x03R\vprocessedAt\x12!\n" +
+	"\fcompleted_at\x18\f \x01(\x03R\vcompletedAt\x12\x1f\n" +
+	"\vretry_count\x18\r \x01(\x05R\n" +
+	"retryCount\x12\x1f\n" +
+	"\vmax_retries\x18\x0e \x01(\x05R\n" +
+	"maxRetries\x12(\n" +
+	"\x10retry_backoff_ms\x18\x0f \x01(\x03R\x0eretryBackoffMs\x12(\n" +
+	"\x10timeout_after_ms\x18\x10 \x01(\x03R\x0etimeoutAfterMs\x12%\n" +
+	"\x0ecorrelation_id\x18\x11 \x01(\tR\rcorrelationId\x12\x17\n" +
+	"\auser_id\x18\x12 \x01(\tR\x06userId\x12'\n" +
+	"\x0fidempotency_key\x18\x13 \x01(\tR\x0eidempotencyKey\x12\x1b\n" +
+	"\tentity_id\x18\x14 \x01(\tR\bentityId\x12\x14\n" +
+	"\x05error\x18\x15 \x01(\tR\x05error\x12!\n" +
+	"\fcancelled_at\x18\x16 \x01(\x03R\vcancelledAt\x12!\n" +
+	"\fcancelled_by\x18\x17 \x01(\tR\vcancelledBy\x12#\n" +
+	"\rcancel_reason\x18\x18 \x01(\tR\fcancelReason\x12!\n" +
+	"\fcallback_url\x18\x19 \x01(\tR\vcallbackUrl\"w\n" +
+	"\fErrorDetails\x12\x12\n" +
+	"\x04code\x18\x01 \x01(\tR\x04code\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12\x18\n" +
+	"\adetails\x18\x03 \x01(\tR\adetails\x12\x1f\n" +
+	"\voccurred_at\x18\x04 \x01(\x03R\n" +
+	"occurredAt\"\xa3\x03\n" +
+	"\x14SubmitCommandRequest\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x18\n" +
+	"\apayload\x18\x02 \x01(\fR\apayload\x12\x1b\n" +
+	"\tentity_id\x18\x03 \x01(\tR\bentityId\x12\x1a\n" +
+	"\bpriority\x18\x04 \x01(\x05R\bpriority\x12\x1a\n" +
+	"\bmetadata\x18\x05 \x01(\fR\bmetadata\x12%\n" +
+	"\x0ecorrelation_id\x18\x06 \x01(\tR\rcorrelationId\x12\x1f\n" +
+	"\vmax_retries\x18\a \x01(\x05R\n" +
+	"maxRetries\x12(\n" +
+	"\x10timeout_after_ms\x18\b \x01(\x03R\x0etimeoutAfterMs\x12#\n" +
+	"\rscheduled_for\x18\t \x01(\x03R\fscheduledFor\x12%\n" +
+	"\x0eschema_version\x18\n" +
+	" \x01(\tR\rschemaVersion\x12'\n" +
+	"\x0fidempotency_key\x18\v \x01(\tR\x0eidempotencyKey\x12!\n" +
+	"\fcallback_url\x18\f \x01(\tR\vcallbackUrl\"j\n" +
+	"\x15SubmitCommandResponse\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x01 \x01(\tR\tcommandId\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\x12\x1a\n" +
+	"\blocation\x18\x03 \x01(\tR\blocation\"Q\n" +
+	"\x17GetCommandStatusRequest\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x01 \x01(\tR\tcommandId\x12\x17\n" +
+	"\await_ms\x18\x02 \x01(\x03R\x06waitMs\"\xdb\x01\n" +
+	"\x18GetCommandStatusResponse\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x01 \x01(\tR\tcommandId\x12\x16\n" +
 	"\x06status\x18\x02 \x01(\tR\x06status\x12\x14\n" +
-	"\x05error\x18\x03 \x01(\tR\x05error2\xd5\x01\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\x12@\n" +
+	"\rerror_details\x18\x04 \x01(\v2\x1b.middleware.v1.ErrorDetailsR\ferrorDetails\x120\n" +
+	"\acommand\x18\x05 \x01(\v2\x16.middleware.v1.CommandR\acommand\"^\n" +
+	"\x13WatchCommandRequest\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x01 \x01(\tR\tcommandId\x12(\n" +
+	"\x10poll_interval_ms\x18\x02 \x01(\x03R\x0epollIntervalMs\"H\n" +
+	"\x14WatchCommandResponse\x120\n" +
+	"\acommand\x18\x01 \x01(\v2\x16.middleware.v1.CommandR\acommand\"M\n" +
+	"\x14CancelCommandRequest\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x01 \x01(\tR\tcommandId\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\"I\n" +
+	"\x15CancelCommandResponse\x120\n" +
+	"\acommand\x18\x01 \x01(\v2\x16.middleware.v1.CommandR\acommand\"p\n" +
+	"\x19SubmitCommandBatchRequest\x12?\n" +
+	"\bcommands\x18\x01 \x03(\v2#.middleware.v1.SubmitCommandRequestR\bcommands\x12\x12\n" +
+	"\x04mode\x18\x02 \x01(\tR\x04mode\"\xba\x01\n" +
+	"\x1aSubmitCommandBatchResponse\x12\x12\n" +
+	"\x04mode\x18\x01 \x01(\tR\x04mode\x12\x1a\n" +
+	"\baccepted\x18\x02 \x01(\x05R\baccepted\x12\x16\n" +
+	"\x06failed\x18\x03 \x01(\x05R\x06failed\x128\n" +
+	"\aresults\x18\x04 \x03(\v2\x1e.middleware.v1.BatchItemResultR\aresults\x12\x1a\n" +
+	"\brejected\x18\x05 \x01(\bR\brejected\"\xec\x01\n" +
+	"\x0fBatchItemResult\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x05R\x05index\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x02 \x01(\tR\tcommandId\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12\x1a\n" +
+	"\blocation\x18\x04 \x01(\tR\blocation\x12\x1c\n" +
+	"\tduplicate\x18\x05 \x01(\bR\tduplicate\x12\x14\n" +
+	"\x05error\x18\x06 \x01(\tR\x05error\x12<\n" +
+	"\ffield_errors\x18\a \x03(\v2\x19.middleware.v1.FieldErrorR\vfieldErrors\"P\n" +
+	"\n" +
+	"FieldError\x12\x14\n" +
+	"\x05field\x18\x01 \x01(\tR\x05field\x12\x12\n" +
+	"\x04rule\x18\x02 \x01(\tR\x04rule\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage2\xfd\x03\n" +
 	"\x0eCommandService\x12\\\n" +
 	"\rSubmitCommand\x12#.middleware.v1.SubmitCommandRequest\x1a$.middleware.v1.SubmitCommandResponse\"\x00\x12e\n" +
-	"\x10GetCommandStatus\x12&.middleware.v1.GetCommandStatusRequest\x1a'.middleware.v1.GetCommandStatusResponse\"\x00BFZDgithub.com/linkmeAman/universal-middleware/api/proto/v1;middlewarev1b\x06proto3"
+	"\x10GetCommandStatus\x12&.middleware.v1.GetCommandStatusRequest\x1a'.middleware.v1.GetCommandStatusResponse\"\x00\x12[\n" +
+	"\fWatchCommand\x12\".middleware.v1.WatchCommandRequest\x1a#.middleware.v1.WatchCommandResponse\"\x000\x01\x12\\\n" +
+	"\rCancelCommand\x12#.middleware.v1.CancelCommandRequest\x1a$.middleware.v1.CancelCommandResponse\"\x00\x12k\n" +
+	"\x12SubmitCommandBatch\x12(.middleware.v1.SubmitCommandBatchRequest\x1a).middleware.v1.SubmitCommandBatchResponse\"\x00BFZDgithub.com/linkmeAman/universal-middleware/api/proto/v1;middlewarev1b\x06proto3"
 
 var (
 	file_api_proto_v1_command_proto_rawDescOnce sync.Once
@@ -325,24 +1272,47 @@ func file_api_proto_v1_command_proto_rawDescGZIP() []byte {
 	return file_api_proto_v1_command_proto_rawDescData
 }
 
-var file_api_proto_v1_command_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_api_proto_v1_command_proto_msgTypes = make([]protoimpl.MessageInfo, 14)
 var file_api_proto_v1_command_proto_goTypes = []any{
-	(*Command)(nil),                  // 0: middleware.v1.Command
-	(*SubmitCommandRequest)(nil),     // 1: middleware.v1.SubmitCommandRequest
-	(*SubmitCommandResponse)(nil),    // 2: middleware.v1.SubmitCommandResponse
-	(*GetCommandStatusRequest)(nil),  // 3: middleware.v1.GetCommandStatusRequest
-	(*GetCommandStatusResponse)(nil), // 4: middleware.v1.GetCommandStatusResponse
+	(*Command)(nil),                    // 0: middleware.v1.Command
+	(*ErrorDetails)(nil),               // 1: middleware.v1.ErrorDetails
+	(*SubmitCommandRequest)(nil),       // 2: middleware.v1.SubmitCommandRequest
+	(*SubmitCommandResponse)(nil),      // 3: middleware.v1.SubmitCommandResponse
+	(*GetCommandStatusRequest)(nil),    // 4: middleware.v1.GetCommandStatusRequest
+	(*GetCommandStatusResponse)(nil),   // 5: middleware.v1.GetCommandStatusResponse
+	(*WatchCommandRequest)(nil),        // 6: middleware.v1.WatchCommandRequest
+	(*WatchCommandResponse)(nil),       // 7: middleware.v1.WatchCommandResponse
+	(*CancelCommandRequest)(nil),       // 8: middleware.v1.CancelCommandRequest
+	(*CancelCommandResponse)(nil),      // 9: middleware.v1.CancelCommandResponse
+	(*SubmitCommandBatchRequest)(nil),  // 10: middleware.v1.SubmitCommandBatchRequest
+	(*SubmitCommandBatchResponse)(nil), // 11: middleware.v1.SubmitCommandBatchResponse
+	(*BatchItemResult)(nil),            // 12: middleware.v1.BatchItemResult
+	(*FieldError)(nil),                 // 13: middleware.v1.FieldError
 }
 var file_api_proto_v1_command_proto_depIdxs = []int32{
-	1, // 0: middleware.v1.CommandService.SubmitCommand:input_type -> middleware.v1.SubmitCommandRequest
-	3, // 1: middleware.v1.CommandService.GetCommandStatus:input_type -> middleware.v1.GetCommandStatusRequest
-	2, // 2: middleware.v1.CommandService.SubmitCommand:output_type -> middleware.v1.SubmitCommandResponse
-	4, // 3: middleware.v1.CommandService.GetCommandStatus:output_type -> middleware.v1.GetCommandStatusResponse
-	2, // [2:4] is the sub-list for method output_type
-	0, // [0:2] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	1,  // 0: middleware.v1.Command.error_details:type_name -> middleware.v1.ErrorDetails
+	1,  // 1: middleware.v1.GetCommandStatusResponse.error_details:type_name -> middleware.v1.ErrorDetails
+	0,  // 2: middleware.v1.GetCommandStatusResponse.command:type_name -> middleware.v1.Command
+	0,  // 3: middleware.v1.WatchCommandResponse.command:type_name -> middleware.v1.Command
+	0,  // 4: middleware.v1.CancelCommandResponse.command:type_name -> middleware.v1.Command
+	2,  // 5: middleware.v1.SubmitCommandBatchRequest.commands:type_name -> middleware.v1.SubmitCommandRequest
+	12, // 6: middleware.v1.SubmitCommandBatchResponse.results:type_name -> middleware.v1.BatchItemResult
+	13, // 7: middleware.v1.BatchItemResult.field_errors:type_name -> middleware.v1.FieldError
+	2,  // 8: middleware.v1.CommandService.SubmitCommand:input_type -> middleware.v1.SubmitCommandRequest
+	4,  // 9: middleware.v1.CommandService.GetCommandStatus:input_type -> middleware.v1.GetCommandStatusRequest
+	6,  // 10: middleware.v1.CommandService.WatchCommand:input_type -> middleware.v1.WatchCommandRequest
+	8,  // 11: middleware.v1.CommandService.CancelCommand:input_type -> middleware.v1.CancelCommandRequest
+	10, // 12: middleware.v1.CommandService.SubmitCommandBatch:input_type -> middleware.v1.SubmitCommandBatchRequest
+	3,  // 13: middleware.v1.CommandService.SubmitCommand:output_type -> middleware.v1.SubmitCommandResponse
+	5,  // 14: middleware.v1.CommandService.GetCommandStatus:output_type -> middleware.v1.GetCommandStatusResponse
+	7,  // 15: middleware.v1.CommandService.WatchCommand:output_type -> middleware.v1.WatchCommandResponse
+	9,  // 16: middleware.v1.CommandService.CancelCommand:output_type -> middleware.v1.CancelCommandResponse
+	11, // 17: middleware.v1.CommandService.SubmitCommandBatch:output_type -> middleware.v1.SubmitCommandBatchResponse
+	13, // [13:18] is the sub-list for method output_type
+	8,  // [8:13] is the sub-list for method input_type
+	8,  // [8:8] is the sub-list for extension type_name
+	8,  // [8:8] is the sub-list for extension extendee
+	0,  // [0:8] is the sub-list for field type_name
 }
 
 func init() { file_api_proto_v1_command_proto_init() }
@@ -356,7 +1326,7 @@ func file_api_proto_v1_command_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_proto_v1_command_proto_rawDesc), len(file_api_proto_v1_command_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   5,
+			NumMessages:   14,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -19,8 +19,11 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	CommandService_SubmitCommand_FullMethodName    = "/middleware.v1.CommandService/SubmitCommand"
-	CommandService_GetCommandStatus_FullMethodName = "/middleware.v1.CommandService/GetCommandStatus"
+	CommandService_SubmitCommand_FullMethodName      = "/middleware.v1.CommandService/SubmitCommand"
+	CommandService_GetCommandStatus_FullMethodName   = "/middleware.v1.CommandService/GetCommandStatus"
+	CommandService_WatchCommand_FullMethodName       = "/middleware.v1.CommandService/WatchCommand"
+	CommandService_CancelCommand_FullMethodName      = "/middleware.v1.CommandService/CancelCommand"
+	CommandService_SubmitCommandBatch_FullMethodName = "/middleware.v1.CommandService/SubmitCommandBatch"
 )
 
 // CommandServiceClient is the client API for CommandService service.
@@ -29,10 +32,17 @@ const (
 //
 // Command service definition
 type CommandServiceClient interface {
-	// SubmitCommand submits a new command for processing
+	// SubmitCommand submits a new command for processing.
+	// An idempotency key may be supplied with the "idempotency-key" metadata header.
 	SubmitCommand(ctx context.Context, in *SubmitCommandRequest, opts ...grpc.CallOption) (*SubmitCommandResponse, error)
 	// GetCommandStatus retrieves the status of a command
 	GetCommandStatus(ctx context.Context, in *GetCommandStatusRequest, opts ...grpc.CallOption) (*GetCommandStatusResponse, error)
+	// WatchCommand streams status changes of a command until it reaches a terminal status
+	WatchCommand(ctx context.Context, in *WatchCommandRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchCommandResponse], error)
+	// CancelCommand cancels a queued command or interrupts a running one
+	CancelCommand(ctx context.Context, in *CancelCommandRequest, opts ...grpc.CallOption) (*CancelCommandResponse, error)
+	// SubmitCommandBatch submits several commands in one transaction
+	SubmitCommandBatch(ctx context.Context, in *SubmitCommandBatchRequest, opts ...grpc.CallOption) (*SubmitCommandBatchResponse, error)
 }
 
 type commandServiceClient struct {
@@ -63,16 +73,62 @@ func (c *commandServiceClient) GetCommandStatus(ctx context.Context, in *GetComm
 	return out, nil
 }
 
+func (c *commandServiceClient) WatchCommand(ctx context.Context, in *WatchCommandRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchCommandResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &CommandService_ServiceDesc.Streams[0], CommandService_WatchCommand_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchCommandRequest, WatchCommandResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CommandService_WatchCommandClient = grpc.ServerStreamingClient[WatchCommandResponse]
+
+func (c *commandServiceClient) CancelCommand(ctx context.Context, in *CancelCommandRequest, opts ...grpc.CallOption) (*CancelCommandResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CancelCommandResponse)
+	err := c.cc.Invoke(ctx, CommandService_CancelCommand_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *commandServiceClient) SubmitCommandBatch(ctx context.Context, in *SubmitCommandBatchRequest, opts ...grpc.CallOption) (*SubmitCommandBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SubmitCommandBatchResponse)
+	err := c.cc.Invoke(ctx, CommandService_SubmitCommandBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CommandServiceServer is the server API for CommandService service.
 // All implementations must embed UnimplementedCommandServiceServer
 // for forward compatibility.
 //
 // Command service definition
 type CommandServiceServer interface {
-	// SubmitCommand submits a new command for processing
+	// SubmitCommand submits a new command for processing.
+	// An idempotency key may be supplied with the "idempotency-key" metadata header.
 	SubmitCommand(context.Context, *SubmitCommandRequest) (*SubmitCommandResponse, error)
 	// GetCommandStatus retrieves the status of a command
 	GetCommandStatus(context.Context, *GetCommandStatusRequest) (*GetCommandStatusResponse, error)
+	// WatchCommand streams status changes of a command until it reaches a terminal status
+	WatchCommand(*WatchCommandRequest, grpc.ServerStreamingServer[WatchCommandResponse]) error
+	// CancelCommand cancels a queued command or interrupts a running one
+	CancelCommand(context.Context, *CancelCommandRequest) (*CancelCommandResponse, error)
+	// SubmitCommandBatch submits several commands in one transaction
+	SubmitCommandBatch(context.Context, *SubmitCommandBatchRequest) (*SubmitCommandBatchResponse, error)
 	mustEmbedUnimplementedCommandServiceServer()
 }
 
@@ -89,6 +145,15 @@ func (UnimplementedCommandServiceServer) SubmitCommand(context.Context, *SubmitC
 func (UnimplementedCommandServiceServer) GetCommandStatus(context.Context, *GetCommandStatusRequest) (*GetCommandStatusResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetCommandStatus not implemented")
 }
+func (UnimplementedCommandServiceServer) WatchCommand(*WatchCommandRequest, grpc.ServerStreamingServer[WatchCommandResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchCommand not implemented")
+}
+func (UnimplementedCommandServiceServer) CancelCommand(context.Context, *CancelCommandRequest) (*CancelCommandResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelCommand not implemented")
+}
+func (UnimplementedCommandServiceServer) SubmitCommandBatch(context.Context, *SubmitCommandBatchRequest) (*SubmitCommandBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitCommandBatch not implemented")
+}
 func (UnimplementedCommandServiceServer) mustEmbedUnimplementedCommandServiceServer() {}
 func (UnimplementedCommandServiceServer) testEmbeddedByValue()                        {}
 
@@ -146,6 +211,53 @@ func _CommandService_GetCommandStatus_Handler(srv interface{}, ctx context.Conte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CommandService_WatchCommand_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchCommandRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CommandServiceServer).WatchCommand(m, &grpc.GenericServerStream[WatchCommandRequest, WatchCommandResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CommandService_WatchCommandServer = grpc.ServerStreamingServer[WatchCommandResponse]
+
+func _CommandService_CancelCommand_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelCommandRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CommandServiceServer).CancelCommand(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CommandService_CancelCommand_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CommandServiceServer).CancelCommand(ctx, req.(*CancelCommandRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CommandService_SubmitCommandBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitCommandBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CommandServiceServer).SubmitCommandBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CommandService_SubmitCommandBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CommandServiceServer).SubmitCommandBatch(ctx, req.(*SubmitCommandBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // CommandService_ServiceDesc is the grpc.ServiceDesc for CommandService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -161,7 +273,21 @@ var CommandService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetCommandStatus",
 			Handler:    _CommandService_GetCommandStatus_Handler,
 		},
+		{
+			MethodName: "CancelCommand",
+			Handler:    _CommandService_CancelCommand_Handler,
+		},
+		{
+			MethodName: "SubmitCommandBatch",
+			Handler:    _CommandService_SubmitCommandBatch_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchCommand",
+			Handler:       _CommandService_WatchCommand_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "api/proto/v1/command.proto",
 }
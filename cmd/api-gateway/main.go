@@ -4,10 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -183,6 +185,60 @@ func main() {
 	if err != nil {
 		log.Fatal("Failed to initialize command service", zap.Error(err))
 	}
+	if cfg.Command.SchemaDir != "" {
+		if err := commandSvc.Schemas().Load(os.DirFS(cfg.Command.SchemaDir), "."); err != nil {
+			log.Fatal("Failed to load command schemas", zap.Error(err))
+		}
+	}
+
+	if cfg.Command.CallbackSecret != "" {
+		commandSvc.EnableCallbacks(command.NewCallbackSender(command.CallbackConfig{
+			Secret:               cfg.Command.CallbackSecret,
+			Timeout:              cfg.Command.CallbackTimeout,
+			MaxAttempts:          cfg.Command.CallbackMaxAttempts,
+			AllowPrivateNetworks: cfg.Command.CallbackAllowPrivateNetworks,
+		}, zapLogger))
+	}
+
+	// Redact sensitive payload fields from responses and, with a key file,
+	// seal them before they are stored
+	for cmdType, pointers := range cfg.Command.SensitiveFields {
+		commandSvc.SensitiveFields().Add(cmdType, pointers...)
+	}
+	if cfg.Command.EncryptionKeyFile != "" {
+		keys, err := command.NewFileKeyProvider(cfg.Command.EncryptionKeyFile)
+		if err != nil {
+			log.Fatal("Failed to load payload encryption keys", zap.Error(err))
+		}
+		commandSvc.EnableEncryption(keys)
+	}
+
+	// Reject commands no processor can run
+	if len(cfg.Command.HandledTypes) > 0 {
+		commandSvc.AcceptTypes(cfg.Command.HandledTypes...)
+	}
+
+	// Check every submitted command against the command.submit policy
+	commandSvc.EnableAuthorization(opaAuthorizer)
+
+	if cfg.Command.IdempotencyFingerprintKey == "" {
+		log.Warn("No idempotency fingerprint key configured, retries are only recognised until this gateway restarts")
+	}
+	commandSvc.ConfigureIdempotency(command.IdempotencyConfig{
+		TTL:            cfg.Command.IdempotencyTTL,
+		ClientTTLs:     cfg.Command.IdempotencyClientTTLs,
+		SweepInterval:  cfg.Command.IdempotencySweepInterval,
+		FingerprintKey: []byte(cfg.Command.IdempotencyFingerprintKey),
+	})
+
+	// Release delayed commands once they are due
+	go commandSvc.StartScheduler(ctx)
+
+	// Purge idempotency keys once they expire
+	go commandSvc.StartIdempotencySweeper(ctx)
+
+	// Keep cached statuses current as commands finish
+	go commandSvc.WatchCompletions(ctx)
 
 	// Initialize security middleware
 	securityMw := middleware.NewSecurityMiddleware(
@@ -194,13 +250,18 @@ func main() {
 	// Security middleware added only to specific routes that need it
 	commandRouter := chi.NewRouter()
 	commandRouter.Use(securityMw.RateLimitMiddleware)
+	commandRouter.Use(securityMw.Authenticate)
 
 	// Command endpoints with rate limiting
 	commandRouter.Post("/v1/commands", func(w http.ResponseWriter, r *http.Request) {
 		var req struct {
-			Type     string                 `json:"type"`
-			EntityID string                 `json:"entity_id"`
-			Payload  map[string]interface{} `json:"payload"`
+			Type          string                 `json:"type"`
+			SchemaVersion string                 `json:"schema_version,omitempty"`
+			EntityID      string                 `json:"entity_id"`
+			Payload       map[string]interface{} `json:"payload"`
+			Priority      command.Priority       `json:"priority,omitempty"`
+			ScheduledFor  *time.Time             `json:"scheduled_for,omitempty"`
+			CallbackURL   string                 `json:"callback_url,omitempty"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -208,28 +269,233 @@ func main() {
 			return
 		}
 
-		cmd := &command.Command{
-			Type:           req.Type,
-			EntityID:       req.EntityID,
-			Payload:        req.Payload,
-			IdempotencyKey: r.Header.Get("Idempotency-Key"),
+		cmd := command.NewCommand(req.Type, req.Payload)
+		cmd.SchemaVersion = req.SchemaVersion
+		cmd.EntityID = req.EntityID
+		cmd.IdempotencyKey = r.Header.Get("Idempotency-Key")
+		cmd.ClientID = r.Header.Get("X-Client-ID")
+		cmd.CorrelationID = r.Header.Get("X-Correlation-ID")
+		cmd.ScheduledFor = req.ScheduledFor
+		cmd.CallbackURL = req.CallbackURL
+		if req.Priority != 0 {
+			if !req.Priority.Valid() {
+				http.Error(w, fmt.Sprintf("invalid priority: %d", req.Priority), http.StatusBadRequest)
+				return
+			}
+			cmd.Priority = req.Priority
+		}
+
+		dryRun, err := dryRunRequested(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if dryRun {
+			result, err := commandSvc.DryRun(r.Context(), cmd)
+			if err != nil {
+				if writeValidationError(w, err) || writeIdempotencyError(w, err) || writeAuthorizationError(w, err) {
+					return
+				}
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(result)
+			return
 		}
 
 		result, err := commandSvc.SubmitCommand(r.Context(), cmd)
 		if err != nil {
+			if writeValidationError(w, err) || writeIdempotencyError(w, err) || writeAuthorizationError(w, err) {
+				return
+			}
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		if result.Replayed {
+			w.Header().Set("Idempotent-Replayed", "true")
+		}
 		w.Header().Set("Location", result.Location)
 		w.WriteHeader(http.StatusAccepted)
 		json.NewEncoder(w).Encode(result)
 	})
 
+	commandRouter.Post("/v1/commands:batch", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Mode     command.BatchMode `json:"mode"`
+			Commands []struct {
+				Type           string                 `json:"type"`
+				SchemaVersion  string                 `json:"schema_version,omitempty"`
+				EntityID       string                 `json:"entity_id"`
+				Payload        map[string]interface{} `json:"payload"`
+				Priority       command.Priority       `json:"priority,omitempty"`
+				ScheduledFor   *time.Time             `json:"scheduled_for,omitempty"`
+				IdempotencyKey string                 `json:"idempotency_key,omitempty"`
+				CallbackURL    string                 `json:"callback_url,omitempty"`
+			} `json:"commands"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		cmds := make([]*command.Command, len(req.Commands))
+		for i, item := range req.Commands {
+			cmd := command.NewCommand(item.Type, item.Payload)
+			cmd.SchemaVersion = item.SchemaVersion
+			cmd.EntityID = item.EntityID
+			cmd.IdempotencyKey = item.IdempotencyKey
+			cmd.ClientID = r.Header.Get("X-Client-ID")
+			cmd.CorrelationID = r.Header.Get("X-Correlation-ID")
+			cmd.ScheduledFor = item.ScheduledFor
+			cmd.CallbackURL = item.CallbackURL
+			if item.Priority != 0 {
+				if !item.Priority.Valid() {
+					http.Error(w, fmt.Sprintf("command %d: invalid priority: %d", i, item.Priority), http.StatusBadRequest)
+					return
+				}
+				cmd.Priority = item.Priority
+			}
+			cmds[i] = cmd
+		}
+
+		result, err := commandSvc.SubmitBatch(r.Context(), cmds, req.Mode)
+		switch {
+		case errors.Is(err, command.ErrEmptyBatch),
+			errors.Is(err, command.ErrBatchTooLarge),
+			errors.Is(err, command.ErrInvalidBatchMode):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case writeIdempotencyError(w, err):
+			return
+		case err != nil && !errors.Is(err, command.ErrBatchRejected):
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// 202 when every command was accepted, 207 when a best-effort batch
+		// was stored in part and 422 when an atomic batch was rejected
+		code := http.StatusAccepted
+		switch {
+		case errors.Is(err, command.ErrBatchRejected):
+			code = http.StatusUnprocessableEntity
+		case result.Failed > 0:
+			code = http.StatusMultiStatus
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(result)
+	})
+
+	commandRouter.With(securityMw.RequireScope(command.AdminScope)).Post("/v1/commands:replay", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			command.ReplayFilter
+			PayloadPatch map[string]interface{} `json:"payload_patch,omitempty"`
+			DryRun       bool                   `json:"dry_run"`
+			Reason       string                 `json:"reason,omitempty"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		requestedBy := "anonymous"
+		if user, ok := auth.UserFromContext(r.Context()); ok && user.ID != "" {
+			requestedBy = user.ID
+		}
+
+		result, err := commandSvc.ReplayCommands(r.Context(), command.ReplayRequest{
+			Filter:       req.ReplayFilter,
+			PayloadPatch: req.PayloadPatch,
+			DryRun:       req.DryRun,
+			RequestedBy:  requestedBy,
+			Reason:       req.Reason,
+		})
+		switch {
+		case errors.Is(err, command.ErrEmptyReplayFilter):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case writeValidationError(w, err):
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+
+	commandRouter.With(securityMw.RequireScope(command.AdminScope)).Get("/v1/commands", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter := command.ListFilter{
+			Status:        command.Status(q.Get("status")),
+			Type:          q.Get("type"),
+			EntityID:      q.Get("entity_id"),
+			UserID:        q.Get("user_id"),
+			CorrelationID: q.Get("correlation_id"),
+			Cursor:        q.Get("cursor"),
+		}
+
+		for name, dst := range map[string]**time.Time{
+			"created_after":  &filter.CreatedAfter,
+			"created_before": &filter.CreatedBefore,
+		} {
+			if raw := q.Get(name); raw != "" {
+				t, err := time.Parse(time.RFC3339, raw)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Invalid %s, expected an RFC 3339 time", name), http.StatusBadRequest)
+					return
+				}
+				*dst = &t
+			}
+		}
+		if raw := q.Get("limit"); raw != "" {
+			limit, err := strconv.Atoi(raw)
+			if err != nil || limit <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			filter.Limit = limit
+		}
+
+		page, err := commandSvc.ListCommands(r.Context(), filter)
+		switch {
+		case errors.Is(err, command.ErrInvalidCursor):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	})
+
 	commandRouter.Get("/v1/commands/{id}", func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
 
-		status, err := commandSvc.GetCommandStatus(r.Context(), id)
+		var status *command.Command
+		var err error
+		if raw := r.URL.Query().Get("wait"); raw != "" {
+			wait, perr := time.ParseDuration(raw)
+			if perr != nil || wait < 0 {
+				http.Error(w, "Invalid wait duration", http.StatusBadRequest)
+				return
+			}
+			// Answer before the request timeout cuts the long-poll off
+			if deadline, ok := r.Context().Deadline(); ok && wait > time.Until(deadline)-time.Second {
+				wait = time.Until(deadline) - time.Second
+			}
+			status, err = commandSvc.WaitForCommand(r.Context(), id, wait)
+		} else {
+			status, err = commandSvc.GetCommandStatus(r.Context(), id)
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return
@@ -239,6 +505,96 @@ func main() {
 		json.NewEncoder(w).Encode(status)
 	})
 
+	commandRouter.Get("/v1/commands/{id}/history", func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		history, err := commandSvc.History(r.Context(), id)
+		switch {
+		case errors.Is(err, command.ErrCommandNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if history == nil {
+			history = []*command.Transition{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"command_id":  id,
+			"transitions": history,
+		})
+	})
+
+	commandRouter.With(securityMw.RequireScope(command.AdminScope)).Get("/v1/commands/{id}/attempts", func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		attempts, err := commandSvc.Attempts(r.Context(), id)
+		switch {
+		case errors.Is(err, command.ErrCommandNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if attempts == nil {
+			attempts = []*command.Attempt{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"command_id": id,
+			"attempts":   attempts,
+		})
+	})
+
+	commandRouter.Delete("/v1/commands/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		var req struct {
+			Reason string `json:"reason"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+		}
+
+		cancelledBy := "anonymous"
+		if user, ok := auth.UserFromContext(r.Context()); ok {
+			cancelledBy = user.ID
+		}
+
+		cmd, err := commandSvc.CancelCommand(r.Context(), id, cancelledBy, req.Reason)
+		switch {
+		case errors.Is(err, command.ErrCommandNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, command.ErrCancelForbidden):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case errors.Is(err, command.ErrCommandNotCancellable):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// A running command is only signalled, it is cancelled once its handler stops
+		w.Header().Set("Content-Type", "application/json")
+		if cmd.Status != command.StatusCancelled {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		json.NewEncoder(w).Encode(cmd)
+	})
+
 	// Mount the command router
 	r.Mount("/", commandRouter)
 
@@ -317,7 +673,10 @@ func main() {
 
 	// Create gRPC server
 	grpcPort := cfg.Server.Port + 1 // Use next port for gRPC
-	grpcServer := grpc.NewServer(zapLogger, m, grpcPort)
+	grpcServer := grpc.NewServer(zapLogger, m, grpcPort, securityMw)
+
+	// Register gRPC services
+	grpc.NewCommandServer(commandSvc, zapLogger).Register(grpcServer.GetServer())
 
 	// Start HTTP server
 	go func() {
@@ -357,3 +716,93 @@ func main() {
 
 	log.Info("Servers stopped")
 }
+
+// writeIdempotencyError answers submissions whose idempotency key cannot be
+// used: 422 when it belongs to a different request, 409 while a concurrent
+// request with the same key is being stored
+func writeIdempotencyError(w http.ResponseWriter, err error) bool {
+	var code int
+	var body map[string]interface{}
+	switch {
+	case errors.Is(err, command.ErrIdempotencyKeyMismatch):
+		code = http.StatusUnprocessableEntity
+		body = map[string]interface{}{
+			"code":    "IDEMPOTENCY_KEY_MISMATCH",
+			"message": err.Error(),
+		}
+	case errors.Is(err, command.ErrIdempotencyKeyInUse):
+		code = http.StatusConflict
+		body = map[string]interface{}{
+			"code":    "IDEMPOTENCY_KEY_IN_USE",
+			"message": err.Error(),
+		}
+		w.Header().Set("Retry-After", "1")
+	default:
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+	return true
+}
+
+// writeAuthorizationError answers 403 when the policy does not allow the
+// caller to submit the command and reports whether it did
+func writeAuthorizationError(w http.ResponseWriter, err error) bool {
+	var denial *command.AuthorizationError
+	if !errors.As(err, &denial) {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    "COMMAND_FORBIDDEN",
+		"message": denial.Error(),
+		"details": denial,
+	})
+	return true
+}
+
+// writeValidationError answers 400, with the offending fields for payload
+// validation failures, when err rejects the submitted command and reports
+// whether it did
+func writeValidationError(w http.ResponseWriter, err error) bool {
+	var validationErr *command.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    "VALIDATION_ERROR",
+			"message": "Invalid command payload",
+			"details": validationErr,
+		})
+		return true
+	case errors.Is(err, command.ErrUnsupportedCommandType),
+		errors.Is(err, command.ErrUnknownSchemaVersion),
+		errors.Is(err, command.ErrInvalidCallbackURL),
+		errors.Is(err, command.ErrInvalidPayload):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return true
+	}
+	return false
+}
+
+// dryRunRequested reports whether a submission asked to be validated only,
+// through the X-Dry-Run header or the dry_run query parameter
+func dryRunRequested(r *http.Request) (bool, error) {
+	value := r.Header.Get("X-Dry-Run")
+	if value == "" {
+		value = r.URL.Query().Get("dry_run")
+	}
+	if value == "" {
+		return false, nil
+	}
+	dryRun, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid dry run flag %q", value)
+	}
+	return dryRun, nil
+}
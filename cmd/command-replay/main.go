@@ -0,0 +1,125 @@
+// Command command-replay requeues failed commands through the API gateway.
+//
+//	command-replay -type email.send -error-code SMTP_UNAVAILABLE -since 2025-01-02T10:00:00Z -dry-run
+//
+// The token must carry the commands:admin scope and is read from -token or
+// UMW_ADMIN_TOKEN.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type replayRequest struct {
+	Type          string                 `json:"type,omitempty"`
+	ErrorCode     string                 `json:"error_code,omitempty"`
+	CreatedAfter  *time.Time             `json:"created_after,omitempty"`
+	CreatedBefore *time.Time             `json:"created_before,omitempty"`
+	CommandIDs    []string               `json:"command_ids,omitempty"`
+	Limit         int                    `json:"limit,omitempty"`
+	PayloadPatch  map[string]interface{} `json:"payload_patch,omitempty"`
+	DryRun        bool                   `json:"dry_run"`
+	Reason        string                 `json:"reason,omitempty"`
+}
+
+func main() {
+	var (
+		gateway   = flag.String("gateway", "http://localhost:8080", "API gateway URL")
+		token     = flag.String("token", os.Getenv("UMW_ADMIN_TOKEN"), "bearer token with the commands:admin scope")
+		cmdType   = flag.String("type", "", "replay commands of this type")
+		errorCode = flag.String("error-code", "", "replay commands that failed with this error code")
+		since     = flag.String("since", "", "replay commands created at or after this RFC 3339 time")
+		until     = flag.String("until", "", "replay commands created before this RFC 3339 time")
+		ids       = flag.String("ids", "", "comma separated IDs of the commands to replay")
+		limit     = flag.Int("limit", 0, "replay at most this many commands (server default 100)")
+		patch     = flag.String("patch", "", "JSON object merged into the payload of every command, null removes a field")
+		dryRun    = flag.Bool("dry-run", false, "list the commands that would be replayed without requeueing them")
+		reason    = flag.String("reason", "", "reason recorded with the replay")
+	)
+	flag.Parse()
+
+	if *token == "" {
+		fail("no token given, set -token or UMW_ADMIN_TOKEN")
+	}
+
+	req := replayRequest{
+		Type:      *cmdType,
+		ErrorCode: *errorCode,
+		Limit:     *limit,
+		DryRun:    *dryRun,
+		Reason:    *reason,
+	}
+	var err error
+	if req.CreatedAfter, err = parseTime("since", *since); err != nil {
+		fail(err.Error())
+	}
+	if req.CreatedBefore, err = parseTime("until", *until); err != nil {
+		fail(err.Error())
+	}
+	if *ids != "" {
+		req.CommandIDs = strings.Split(*ids, ",")
+	}
+	if *patch != "" {
+		if err := json.Unmarshal([]byte(*patch), &req.PayloadPatch); err != nil {
+			fail(fmt.Sprintf("invalid -patch: %v", err))
+		}
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		fail(err.Error())
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, strings.TrimRight(*gateway, "/")+"/v1/commands:replay", bytes.NewReader(body))
+	if err != nil {
+		fail(err.Error())
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+*token)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		fail(fmt.Sprintf("replay request failed: %v", err))
+	}
+	defer resp.Body.Close()
+
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fail(fmt.Sprintf("failed to read response: %v", err))
+	}
+	if resp.StatusCode != http.StatusOK {
+		fail(fmt.Sprintf("replay rejected with %s: %s", resp.Status, strings.TrimSpace(string(out))))
+	}
+
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, out, "", "  "); err != nil {
+		os.Stdout.Write(out)
+		return
+	}
+	fmt.Println(pretty.String())
+}
+
+func parseTime(name, value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -%s, expected an RFC 3339 time: %v", name, err)
+	}
+	return &t, nil
+}
+
+func fail(msg string) {
+	fmt.Fprintln(os.Stderr, msg)
+	os.Exit(1)
+}
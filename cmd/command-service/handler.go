@@ -2,8 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/linkmeAman/universal-middleware/internal/command"
 	"github.com/linkmeAman/universal-middleware/pkg/logger"
 	"go.uber.org/zap"
@@ -11,8 +13,10 @@ import (
 
 // CommandRequest represents an incoming command request
 type CommandRequest struct {
-	Type    string                 `json:"type"`
-	Payload map[string]interface{} `json:"payload"`
+	Type          string                 `json:"type"`
+	SchemaVersion string                 `json:"schema_version,omitempty"`
+	Payload       map[string]interface{} `json:"payload"`
+	CallbackURL   string                 `json:"callback_url,omitempty"`
 }
 
 // HandleCommand creates a handler for processing commands
@@ -26,9 +30,37 @@ func HandleCommand(processor *command.Processor, log *logger.Logger) http.Handle
 
 		// Create command
 		cmd := command.NewCommand(req.Type, req.Payload)
+		cmd.SchemaVersion = req.SchemaVersion
+		cmd.CallbackURL = req.CallbackURL
 
 		// Process command
 		if err := processor.Process(r.Context(), cmd); err != nil {
+			var validationErr *command.ValidationError
+			switch {
+			case errors.As(err, &validationErr):
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"code":    "VALIDATION_ERROR",
+					"message": "Invalid command payload",
+					"details": validationErr,
+				})
+				return
+			case errors.Is(err, command.ErrUnknownSchemaVersion),
+				errors.Is(err, command.ErrInvalidCallbackURL):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			case errors.Is(err, command.ErrQueueFull):
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "Command queue is full", http.StatusTooManyRequests)
+				return
+			case errors.Is(err, command.ErrProcessorStopped):
+				http.Error(w, "Command processor is shutting down", http.StatusServiceUnavailable)
+				return
+			case errors.Is(err, command.ErrCommandCancelled):
+				http.Error(w, "Command cancelled", http.StatusConflict)
+				return
+			}
 			log.Error("Failed to process command", zap.Error(err))
 			http.Error(w, "Command processing failed", http.StatusInternalServerError)
 			return
@@ -41,3 +73,19 @@ func HandleCommand(processor *command.Processor, log *logger.Logger) http.Handle
 		})
 	}
 }
+
+// HandleCancelCommand creates a handler interrupting a queued or running command
+func HandleCancelCommand(processor *command.Processor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if !processor.Cancel(id) {
+			http.Error(w, "Command is not queued or running", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"command_id": id,
+		})
+	}
+}
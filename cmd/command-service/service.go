@@ -19,6 +19,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/go-redis/redis/v8"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/linkmeAman/universal-middleware/internal/api/handlers"
@@ -223,18 +224,121 @@ func run() error {
 	if cfg.Outbox.RetryInterval > 0 {
 		outboxProcessorConfig.RetryDelay = cfg.Outbox.RetryInterval
 	}
+	if cfg.Outbox.MaxRetryInterval > 0 {
+		outboxProcessorConfig.MaxRetryDelay = cfg.Outbox.MaxRetryInterval
+	}
 	if cfg.Outbox.MaxRetries > 0 {
 		outboxProcessorConfig.MaxRetries = cfg.Outbox.MaxRetries
 	}
-	outboxProcessor := outbox.NewProcessor(outboxProcessorConfig, outboxRepo, pub, log)
+	if cfg.Outbox.MaxBatchSize > 0 {
+		outboxProcessorConfig.MaxBatchSize = cfg.Outbox.MaxBatchSize
+	}
+	if cfg.Outbox.Listen {
+		outboxProcessorConfig.Notifier = db
+	}
+	if cfg.Outbox.FallbackInterval > 0 {
+		outboxProcessorConfig.FallbackInterval = cfg.Outbox.FallbackInterval
+	}
+	outboxProcessorConfig.OrderByAggregate = cfg.Outbox.OrderByAggregate
+	outboxProcessorConfig.Metrics = metrics
+	var outboxPub outbox.Publisher = pub
+	if cfg.Outbox.TransactionalID != "" {
+		txPub, err := outbox.NewTransactionalPublisher(cfg.Kafka.Brokers, cfg.Outbox.TransactionalID, "command-service", log)
+		if err != nil {
+			return fmt.Errorf("failed to create transactional outbox publisher: %w", err)
+		}
+		defer txPub.Close()
+		outboxPub = txPub
+	}
+	outboxProcessor := outbox.NewProcessor(outboxProcessorConfig, outboxRepo, outboxPub, log)
 
 	// Create command processor
+	schemas := command.DefaultSchemaRegistry()
+	if cfg.Command.SchemaDir != "" {
+		if err := schemas.Load(os.DirFS(cfg.Command.SchemaDir), "."); err != nil {
+			return fmt.Errorf("failed to load command schemas: %w", err)
+		}
+	}
+
+	// Announce finished commands to long-polling callers and their callbacks
+	var rdb *redis.Client
+	if len(cfg.Redis.Addresses) > 0 {
+		rdb = redis.NewClient(&redis.Options{
+			Addr:     cfg.Redis.Addresses[0],
+			Password: cfg.Redis.Password,
+			DB:       command.StatusCacheDB,
+		})
+		defer rdb.Close()
+	}
+	// Callbacks are queued with the final status of their command and sent
+	// from the queue, so deliveries in progress survive restarts
+	var callbacks *command.CallbackQueue
+	if cfg.Command.CallbackSecret != "" {
+		sender := command.NewCallbackSender(command.CallbackConfig{
+			Secret:               cfg.Command.CallbackSecret,
+			Timeout:              cfg.Command.CallbackTimeout,
+			MaxAttempts:          cfg.Command.CallbackMaxAttempts,
+			AllowPrivateNetworks: cfg.Command.CallbackAllowPrivateNetworks,
+		}, log.Logger)
+		callbacks = command.NewCallbackQueue(db, sender, command.DefaultCallbackQueueConfig(), log)
+		go callbacks.Start(serviceCtx)
+	}
+	completions := command.NewCompletions(rdb, callbacks, log.Logger)
+
+	// Serialize the commands of an entity across replicas
+	var entityLocks command.EntityLocker
+	if cfg.Command.OrderByEntity && rdb != nil {
+		entityLocks = command.NewRedisEntityLocker(rdb)
+	}
+
+	// Seal sensitive payload fields at rest when a key file is configured
+	var encryptor *command.FieldEncryptor
+	if cfg.Command.EncryptionKeyFile != "" {
+		keys, err := command.NewFileKeyProvider(cfg.Command.EncryptionKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load payload encryption keys: %w", err)
+		}
+		encryptor = command.NewFieldEncryptor(keys, command.NewSensitiveFieldsFromConfig(cfg.Command.SensitiveFields))
+	}
+
+	retryPolicies := command.NewRetryPoliciesFromConfig(cfg.Command.RetryPolicies)
 	cmdProcessor := command.NewProcessor(command.ProcessorConfig{
 		MaxWorkers:     cfg.Command.MaxWorkers,
 		QueueSize:      cfg.Command.QueueSize,
 		DefaultTimeout: cfg.Command.DefaultTimeout,
+		MaxQueueWait:   cfg.Command.MaxQueueWait,
+		Metrics:        metrics,
+		Leases:         command.NewLeaseRepository(db),
+		LeaseTTL:       cfg.Command.LeaseTTL,
+		RetryPolicies:  retryPolicies,
+		Attempts:       command.NewRetryRepository(db),
+		Schemas:        schemas,
+		Completions:    completions,
+		OrderByEntity:  cfg.Command.OrderByEntity,
+		EntityLocks:    entityLocks,
+		EntityLockTTL:  cfg.Command.EntityLockTTL,
+		Encryptor:      encryptor,
+		Middleware: []command.Middleware{
+			command.TracingMiddleware(),
+			command.MetricsMiddleware(metrics),
+			command.LoggingMiddleware(log),
+			command.RecoveryMiddleware(log, metrics),
+		},
 	}, log)
 
+	// Recover commands whose worker died while running them
+	reaperConfig := command.DefaultReaperConfig()
+	reaperConfig.Completions = completions
+	if cfg.Command.ReaperInterval > 0 {
+		reaperConfig.Interval = cfg.Command.ReaperInterval
+	}
+	go command.NewReaper(db, reaperConfig, retryPolicies, log).Start(serviceCtx)
+
+	// Interrupt running commands that are cancelled through the API gateway
+	if rdb != nil {
+		go cmdProcessor.WatchCancellations(serviceCtx, rdb)
+	}
+
 	// Start outbox processor
 	if err := outboxProcessor.Start(serviceCtx); err != nil {
 		log.Error("Failed to start outbox processor", zap.Error(err))
@@ -278,6 +382,7 @@ func run() error {
 
 	// Command endpoints
 	r.Post("/v1/commands", HandleCommand(cmdProcessor, log))
+	r.Delete("/v1/commands/{id}", HandleCancelCommand(cmdProcessor))
 
 	// Start server
 	srv := &http.Server{
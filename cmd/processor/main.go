@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
@@ -10,6 +9,12 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/go-redis/redis/v8"
+	"github.com/linkmeAman/universal-middleware/internal/api/handlers"
+	"github.com/linkmeAman/universal-middleware/internal/cache"
+	"github.com/linkmeAman/universal-middleware/internal/command"
+	"github.com/linkmeAman/universal-middleware/internal/command/outbox"
+	"github.com/linkmeAman/universal-middleware/internal/database/postgres"
 	"github.com/linkmeAman/universal-middleware/internal/processor"
 	"github.com/linkmeAman/universal-middleware/pkg/config"
 	"github.com/linkmeAman/universal-middleware/pkg/logger"
@@ -33,13 +38,129 @@ func main() {
 	}
 	defer log.Sync()
 
-	// Create processor service with config values
+	// Initialize metrics
+	m := metrics.New("event_processor")
+
+	serviceCtx, serviceCancel := context.WithCancel(context.Background())
+	defer serviceCancel()
+
+	// Commands are written back to the same database the API gateway stores them in
+	db, err := postgres.InitFromConfig(cfg, log, m)
+	if err != nil {
+		log.Error("Failed to connect to database", zap.Error(err))
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	if len(cfg.Redis.Addresses) == 0 {
+		log.Error("No Redis address configured")
+		os.Exit(1)
+	}
+
+	// The status cache lives in its own database, pub/sub channels are shared
+	statuses := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Addresses[0],
+		Password: cfg.Redis.Password,
+		DB:       command.StatusCacheDB,
+	})
+	defer statuses.Close()
+
+	// Callbacks are queued with the final status of their command and sent
+	// from the queue, so deliveries in progress survive restarts
+	var callbacks *command.CallbackQueue
+	if cfg.Command.CallbackSecret != "" {
+		sender := command.NewCallbackSender(command.CallbackConfig{
+			Secret:               cfg.Command.CallbackSecret,
+			Timeout:              cfg.Command.CallbackTimeout,
+			MaxAttempts:          cfg.Command.CallbackMaxAttempts,
+			AllowPrivateNetworks: cfg.Command.CallbackAllowPrivateNetworks,
+		}, log.Logger)
+		callbacks = command.NewCallbackQueue(db, sender, command.DefaultCallbackQueueConfig(), log)
+		go callbacks.Start(serviceCtx)
+	}
+	completions := command.NewCompletions(statuses, callbacks, log.Logger)
+
+	var entityLocks command.EntityLocker
+	if cfg.Command.OrderByEntity {
+		entityLocks = command.NewRedisEntityLocker(statuses)
+	}
+
+	schemas := command.DefaultSchemaRegistry()
+	if cfg.Command.SchemaDir != "" {
+		if err := schemas.Load(os.DirFS(cfg.Command.SchemaDir), "."); err != nil {
+			log.Error("Failed to load command schemas", zap.Error(err))
+			os.Exit(1)
+		}
+	}
+
+	// Seal sensitive payload fields at rest when a key file is configured
+	var encryptor *command.FieldEncryptor
+	if cfg.Command.EncryptionKeyFile != "" {
+		keys, err := command.NewFileKeyProvider(cfg.Command.EncryptionKeyFile)
+		if err != nil {
+			log.Error("Failed to load payload encryption keys", zap.Error(err))
+			os.Exit(1)
+		}
+		encryptor = command.NewFieldEncryptor(keys, command.NewSensitiveFieldsFromConfig(cfg.Command.SensitiveFields))
+	}
+
+	leases := command.NewLeaseRepository(db)
+	retryPolicies := command.NewRetryPoliciesFromConfig(cfg.Command.RetryPolicies)
+	cmdProcessor := command.NewProcessor(command.ProcessorConfig{
+		MaxWorkers:     cfg.Command.MaxWorkers,
+		QueueSize:      cfg.Command.QueueSize,
+		DefaultTimeout: cfg.Command.DefaultTimeout,
+		MaxQueueWait:   cfg.Command.MaxQueueWait,
+		Metrics:        m,
+		Leases:         leases,
+		LeaseTTL:       cfg.Command.LeaseTTL,
+		RetryPolicies:  retryPolicies,
+		Attempts:       command.NewRetryRepository(db),
+		Schemas:        schemas,
+		Completions:    completions,
+		OrderByEntity:  cfg.Command.OrderByEntity,
+		EntityLocks:    entityLocks,
+		EntityLockTTL:  cfg.Command.EntityLockTTL,
+		Encryptor:      encryptor,
+		Middleware: []command.Middleware{
+			command.TracingMiddleware(),
+			command.MetricsMiddleware(m),
+			command.LoggingMiddleware(log),
+			command.RecoveryMiddleware(log, m),
+		},
+	}, log)
+	defer cmdProcessor.Stop()
+
+	// Register command handlers
+	cmdProcessor.RegisterHandler(processor.NewCacheInvalidateHandler(
+		cache.NewRedisCache(cache.CacheOptions{
+			Addresses: cfg.Redis.Addresses,
+			Password:  cfg.Redis.Password,
+			DB:        cfg.Redis.DB,
+			PoolSize:  cfg.Redis.PoolSize,
+		}, log, m),
+		log,
+	))
+
+	// Recover commands whose worker died while running them
+	reaperConfig := command.DefaultReaperConfig()
+	reaperConfig.Completions = completions
+	if cfg.Command.ReaperInterval > 0 {
+		reaperConfig.Interval = cfg.Command.ReaperInterval
+	}
+	go command.NewReaper(db, reaperConfig, retryPolicies, log).Start(serviceCtx)
+
+	// Interrupt running commands that are cancelled through the API gateway
+	go cmdProcessor.WatchCancellations(serviceCtx, statuses)
+
+	// Create processor service consuming the commands dispatched by the outbox
 	proc, err := processor.NewService(
 		cfg.Kafka.Brokers,
-		cfg.Kafka.Consumer.Topics[0], // Use the 'commands' topic for processor
+		command.CommandTopic,
 		cfg.Kafka.GroupID,
 		cfg.Kafka.Consumer.MinBytes,
 		cfg.Kafka.Consumer.MaxBytes,
+		processor.NewCommandHandler(cmdProcessor, leases, statuses, log),
 		log,
 	)
 	if err != nil {
@@ -47,28 +168,71 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Publish the lifecycle events queued while commands run
+	outboxConfig := outbox.DefaultConfig()
+	if cfg.Outbox.BatchSize > 0 {
+		outboxConfig.BatchSize = cfg.Outbox.BatchSize
+	}
+	if cfg.Outbox.PollingInterval > 0 {
+		outboxConfig.PollingInterval = cfg.Outbox.PollingInterval
+	}
+	if cfg.Outbox.RetryInterval > 0 {
+		outboxConfig.RetryDelay = cfg.Outbox.RetryInterval
+	}
+	if cfg.Outbox.MaxRetryInterval > 0 {
+		outboxConfig.MaxRetryDelay = cfg.Outbox.MaxRetryInterval
+	}
+	if cfg.Outbox.MaxRetries > 0 {
+		outboxConfig.MaxRetries = cfg.Outbox.MaxRetries
+	}
+	if cfg.Outbox.MaxBatchSize > 0 {
+		outboxConfig.MaxBatchSize = cfg.Outbox.MaxBatchSize
+	}
+	if cfg.Outbox.Listen {
+		outboxConfig.Notifier = db
+	}
+	if cfg.Outbox.FallbackInterval > 0 {
+		outboxConfig.FallbackInterval = cfg.Outbox.FallbackInterval
+	}
+	outboxConfig.OrderByAggregate = cfg.Outbox.OrderByAggregate
+	outboxConfig.Metrics = m
+	var outboxPub outbox.Publisher = proc.Publisher()
+	if cfg.Outbox.TransactionalID != "" {
+		txPub, err := outbox.NewTransactionalPublisher(cfg.Kafka.Brokers, cfg.Outbox.TransactionalID, "processor", log)
+		if err != nil {
+			log.Error("Failed to create transactional outbox publisher", zap.Error(err))
+			os.Exit(1)
+		}
+		defer txPub.Close()
+		outboxPub = txPub
+	}
+	outboxProcessor := outbox.NewProcessor(outboxConfig, outbox.NewRepository(db, log), outboxPub, log)
+	if err := outboxProcessor.Start(serviceCtx); err != nil {
+		log.Error("Failed to start outbox processor", zap.Error(err))
+		os.Exit(1)
+	}
+
 	// Start processing
-	// Note: Context will be used for graceful shutdown in the future
 	if err := proc.Start(); err != nil {
 		log.Error("Failed to start processor service", zap.Error(err))
 		os.Exit(1)
 	}
 
-	// Initialize metrics
-	metrics.New("event_processor")
-
 	// Create HTTP server for health checks
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status":  "healthy",
-			"version": "1.0.0",
-			"services": map[string]string{
-				"kafka": "healthy",
-			},
-		})
-	})
+	http.HandleFunc("/health", handlers.HealthHandler("1.0.0", map[string]func() error{
+		"kafka": proc.Ping,
+		"database": func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			return db.Ping(ctx)
+		},
+		"redis": func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			return statuses.Ping(ctx).Err()
+		},
+		"processor": cmdProcessor.Status,
+	}))
 
 	// Start HTTP server using processor config
 	port := 8083 // Default
@@ -105,7 +269,9 @@ func main() {
 		log.Error("HTTP server shutdown failed", zap.Error(err))
 	}
 
-	// Stop processor
+	// Stop the outbox relay and the reaper, then wait for the commands being
+	// consumed to finish
+	serviceCancel()
 	if err := proc.Stop(); err != nil {
 		log.Error("Failed to stop processor", zap.Error(err))
 	}
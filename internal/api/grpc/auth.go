@@ -0,0 +1,73 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/linkmeAman/universal-middleware/internal/auth"
+)
+
+// AuthorizationHeader is the metadata key carrying the caller's bearer token
+const AuthorizationHeader = "authorization"
+
+// TokenAuthenticator returns the user a bearer token was issued to
+type TokenAuthenticator interface {
+	AuthenticateToken(token string) (*auth.User, error)
+}
+
+// UnaryAuthInterceptor stores the user of a valid bearer token in the call
+// context, like the HTTP Authenticate middleware. Calls without a token pass
+// through anonymously, calls with an invalid one fail with Unauthenticated.
+func UnaryAuthInterceptor(authenticator TokenAuthenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, authenticator)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuthInterceptor authenticates streaming calls like
+// UnaryAuthInterceptor
+func StreamAuthInterceptor(authenticator TokenAuthenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), authenticator)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authenticate returns ctx with the user of the call's bearer token
+func authenticate(ctx context.Context, authenticator TokenAuthenticator) (context.Context, error) {
+	header := metadataValue(ctx, AuthorizationHeader)
+	if header == "" || authenticator == nil {
+		return ctx, nil
+	}
+
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "missing authentication token")
+	}
+	user, err := authenticator.AuthenticateToken(token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return context.WithValue(ctx, auth.UserContextKey, user), nil
+}
+
+// authenticatedStream is a server stream whose context carries the caller
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
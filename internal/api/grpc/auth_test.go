@@ -0,0 +1,126 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/linkmeAman/universal-middleware/internal/auth"
+)
+
+type fakeAuthenticator struct{}
+
+func (fakeAuthenticator) AuthenticateToken(token string) (*auth.User, error) {
+	if token != "valid" {
+		return nil, errors.New("invalid authentication token")
+	}
+	return &auth.User{ID: "user-1", Scopes: []string{"commands:write"}}, nil
+}
+
+// callUser runs a unary call through the interceptor and returns the user
+// the handler saw
+func callUser(t *testing.T, md metadata.MD) (*auth.User, error) {
+	ctx := context.Background()
+	if md != nil {
+		ctx = metadata.NewIncomingContext(ctx, md)
+	}
+
+	var seen *auth.User
+	_, err := UnaryAuthInterceptor(fakeAuthenticator{})(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/middleware.v1.CommandService/SubmitCommand"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			seen, _ = auth.UserFromContext(ctx)
+			return nil, nil
+		})
+	return seen, err
+}
+
+func TestUnaryAuthInterceptor(t *testing.T) {
+	user, err := callUser(t, metadata.Pairs(AuthorizationHeader, "Bearer valid"))
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	assert.Equal(t, "user-1", user.ID)
+
+	user, err = callUser(t, nil)
+	require.NoError(t, err)
+	assert.Nil(t, user)
+
+	_, err = callUser(t, metadata.Pairs(AuthorizationHeader, "Bearer forged"))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = callUser(t, metadata.Pairs(AuthorizationHeader, "Basic dXNlcg=="))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamAuthInterceptor(t *testing.T) {
+	stream := &fakeServerStream{ctx: metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(AuthorizationHeader, "Bearer valid"))}
+
+	var seen *auth.User
+	err := StreamAuthInterceptor(fakeAuthenticator{})(nil, stream, &grpc.StreamServerInfo{},
+		func(srv interface{}, ss grpc.ServerStream) error {
+			seen, _ = auth.UserFromContext(ss.Context())
+			return nil
+		})
+	require.NoError(t, err)
+	require.NotNil(t, seen)
+	assert.Equal(t, "user-1", seen.ID)
+
+	stream.ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(AuthorizationHeader, "Bearer forged"))
+	err = StreamAuthInterceptor(fakeAuthenticator{})(nil, stream, &grpc.StreamServerInfo{},
+		func(srv interface{}, ss grpc.ServerStream) error { return nil })
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestServerAuthenticatesCalls(t *testing.T) {
+	srv := NewServer(zap.NewNop(), nil, 0, fakeAuthenticator{})
+	healthpb.RegisterHealthServer(srv.GetServer(), health.NewServer())
+
+	listener := bufconn.Listen(1 << 20)
+	go srv.GetServer().Serve(listener)
+	t.Cleanup(srv.GetServer().Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	client := healthpb.NewHealthClient(conn)
+
+	check := func(token string) error {
+		ctx := context.Background()
+		if token != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, AuthorizationHeader, "Bearer "+token)
+		}
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		return err
+	}
+
+	assert.NoError(t, check("valid"))
+	assert.NoError(t, check(""))
+	assert.Equal(t, codes.Unauthenticated, status.Code(check("forged")))
+}
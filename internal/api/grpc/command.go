@@ -0,0 +1,454 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	middlewarev1 "github.com/linkmeAman/universal-middleware/api/proto/v1"
+	"github.com/linkmeAman/universal-middleware/internal/auth"
+	"github.com/linkmeAman/universal-middleware/internal/command"
+)
+
+const (
+	// IdempotencyKeyHeader is the metadata key carrying the idempotency key
+	IdempotencyKeyHeader = "idempotency-key"
+
+	// ClientIDHeader is the metadata key identifying the calling client,
+	// which selects the lifetime of its idempotency keys
+	ClientIDHeader = "client-id"
+
+	// IdempotentReplayedHeader is set on responses that replay an earlier
+	// submission with the same idempotency key
+	IdempotentReplayedHeader = "idempotent-replayed"
+
+	defaultWatchInterval = time.Second
+	minWatchInterval     = 100 * time.Millisecond
+)
+
+// CommandServer implements the CommandService gRPC API on top of command.CommandService
+type CommandServer struct {
+	middlewarev1.UnimplementedCommandServiceServer
+
+	svc    *command.CommandService
+	logger *zap.Logger
+}
+
+// NewCommandServer creates a new CommandService gRPC implementation
+func NewCommandServer(svc *command.CommandService, logger *zap.Logger) *CommandServer {
+	return &CommandServer{
+		svc:    svc,
+		logger: logger,
+	}
+}
+
+// Register registers the command service on a gRPC server
+func (s *CommandServer) Register(srv *grpc.Server) {
+	middlewarev1.RegisterCommandServiceServer(srv, s)
+}
+
+// SubmitCommand accepts a command for asynchronous processing
+func (s *CommandServer) SubmitCommand(ctx context.Context, req *middlewarev1.SubmitCommandRequest) (*middlewarev1.SubmitCommandResponse, error) {
+	cmd, err := commandFromRequest(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if cmd.IdempotencyKey == "" {
+		cmd.IdempotencyKey = metadataValue(ctx, IdempotencyKeyHeader)
+	}
+	cmd.ClientID = metadataValue(ctx, ClientIDHeader)
+
+	result, err := s.svc.SubmitCommand(ctx, cmd)
+	var validationErr *command.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		return nil, validationStatus(validationErr)
+	case errors.Is(err, command.ErrUnsupportedCommandType),
+		errors.Is(err, command.ErrUnknownSchemaVersion),
+		errors.Is(err, command.ErrInvalidCallbackURL),
+		errors.Is(err, command.ErrInvalidPayload):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, command.ErrIdempotencyKeyMismatch):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, command.ErrIdempotencyKeyInUse):
+		return nil, status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, command.ErrCommandForbidden):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		s.logger.Error("Failed to submit command",
+			zap.String("type", cmd.Type),
+			zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to submit command")
+	}
+
+	if result.Replayed {
+		grpc.SetHeader(ctx, metadata.Pairs(IdempotentReplayedHeader, "true"))
+	}
+
+	return &middlewarev1.SubmitCommandResponse{
+		CommandId: result.CommandID,
+		Status:    result.Status,
+		Location:  result.Location,
+	}, nil
+}
+
+// SubmitCommandBatch accepts several commands in one transaction. Item
+// failures are reported per command; a rejected atomic batch is not an error.
+func (s *CommandServer) SubmitCommandBatch(ctx context.Context, req *middlewarev1.SubmitCommandBatchRequest) (*middlewarev1.SubmitCommandBatchResponse, error) {
+	cmds := make([]*command.Command, len(req.GetCommands()))
+	for i, item := range req.GetCommands() {
+		cmd, err := commandFromRequest(item)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "command %d: %v", i, err)
+		}
+		cmd.ClientID = metadataValue(ctx, ClientIDHeader)
+		cmds[i] = cmd
+	}
+
+	result, err := s.svc.SubmitBatch(ctx, cmds, command.BatchMode(req.GetMode()))
+	switch {
+	case errors.Is(err, command.ErrEmptyBatch),
+		errors.Is(err, command.ErrBatchTooLarge),
+		errors.Is(err, command.ErrInvalidBatchMode):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, command.ErrIdempotencyKeyMismatch):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, command.ErrIdempotencyKeyInUse):
+		return nil, status.Error(codes.Aborted, err.Error())
+	case err != nil && !errors.Is(err, command.ErrBatchRejected):
+		s.logger.Error("Failed to submit command batch",
+			zap.Int("size", len(cmds)),
+			zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to submit command batch")
+	}
+
+	resp := &middlewarev1.SubmitCommandBatchResponse{
+		Mode:     string(result.Mode),
+		Accepted: int32(result.Accepted),
+		Failed:   int32(result.Failed),
+		Rejected: errors.Is(err, command.ErrBatchRejected),
+	}
+	for _, item := range result.Items {
+		pb := &middlewarev1.BatchItemResult{
+			Index:     int32(item.Index),
+			CommandId: item.CommandID,
+			Status:    item.Status,
+			Location:  item.Location,
+			Duplicate: item.Duplicate,
+			Error:     item.Error,
+		}
+		for _, f := range item.Fields {
+			pb.FieldErrors = append(pb.FieldErrors, &middlewarev1.FieldError{
+				Field:   f.Field,
+				Rule:    f.Rule,
+				Message: f.Message,
+			})
+		}
+		resp.Results = append(resp.Results, pb)
+	}
+
+	return resp, nil
+}
+
+// GetCommandStatus returns the current state of a command. With wait_ms set
+// it first waits for the command to finish.
+func (s *CommandServer) GetCommandStatus(ctx context.Context, req *middlewarev1.GetCommandStatusRequest) (*middlewarev1.GetCommandStatusResponse, error) {
+	var cmd *command.Command
+	var err error
+	if req.GetWaitMs() > 0 {
+		cmd, err = s.waitForCommand(ctx, req.GetCommandId(), time.Duration(req.GetWaitMs())*time.Millisecond)
+	} else {
+		cmd, err = s.getCommand(ctx, req.GetCommandId())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pb, err := commandToProto(cmd)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to encode command: %v", err)
+	}
+
+	return &middlewarev1.GetCommandStatusResponse{
+		CommandId:    cmd.ID,
+		Status:       string(cmd.Status),
+		Error:        cmd.Error,
+		ErrorDetails: pb.ErrorDetails,
+		Command:      pb,
+	}, nil
+}
+
+// WatchCommand streams the command every time its status changes and
+// returns once the command reaches a terminal status
+func (s *CommandServer) WatchCommand(req *middlewarev1.WatchCommandRequest, stream grpc.ServerStreamingServer[middlewarev1.WatchCommandResponse]) error {
+	ctx := stream.Context()
+
+	interval := defaultWatchInterval
+	if req.GetPollIntervalMs() > 0 {
+		interval = time.Duration(req.GetPollIntervalMs()) * time.Millisecond
+		if interval < minWatchInterval {
+			interval = minWatchInterval
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastStatus command.Status
+	for {
+		cmd, err := s.getCommand(ctx, req.GetCommandId())
+		if err != nil {
+			return err
+		}
+
+		if cmd.Status != lastStatus {
+			pb, err := commandToProto(cmd)
+			if err != nil {
+				return status.Errorf(codes.Internal, "failed to encode command: %v", err)
+			}
+			if err := stream.Send(&middlewarev1.WatchCommandResponse{Command: pb}); err != nil {
+				return err
+			}
+			lastStatus = cmd.Status
+		}
+
+		if cmd.Status.IsTerminal() {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// CancelCommand cancels a queued command or signals a running one to stop
+func (s *CommandServer) CancelCommand(ctx context.Context, req *middlewarev1.CancelCommandRequest) (*middlewarev1.CancelCommandResponse, error) {
+	if req.GetCommandId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "command ID is required")
+	}
+
+	cancelledBy := "anonymous"
+	if user, ok := auth.UserFromContext(ctx); ok {
+		cancelledBy = user.ID
+	}
+
+	cmd, err := s.svc.CancelCommand(ctx, req.GetCommandId(), cancelledBy, req.GetReason())
+	switch {
+	case errors.Is(err, command.ErrCommandNotFound):
+		return nil, status.Errorf(codes.NotFound, "command %s not found", req.GetCommandId())
+	case errors.Is(err, command.ErrCancelForbidden):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, command.ErrCommandNotCancellable):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case err != nil:
+		s.logger.Error("Failed to cancel command",
+			zap.String("command_id", req.GetCommandId()),
+			zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to cancel command")
+	}
+
+	pb, err := commandToProto(cmd)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to encode command: %v", err)
+	}
+
+	return &middlewarev1.CancelCommandResponse{Command: pb}, nil
+}
+
+// getCommand loads a command and maps service errors to gRPC status errors
+func (s *CommandServer) getCommand(ctx context.Context, commandID string) (*command.Command, error) {
+	if commandID == "" {
+		return nil, status.Error(codes.InvalidArgument, "command ID is required")
+	}
+
+	cmd, err := s.svc.GetCommandStatus(ctx, commandID)
+	if errors.Is(err, command.ErrCommandNotFound) {
+		return nil, status.Errorf(codes.NotFound, "command %s not found", commandID)
+	}
+	if err != nil {
+		s.logger.Error("Failed to get command status",
+			zap.String("command_id", commandID),
+			zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to get command status")
+	}
+
+	return cmd, nil
+}
+
+// waitForCommand is getCommand blocking until the command finished or wait elapsed
+func (s *CommandServer) waitForCommand(ctx context.Context, commandID string, wait time.Duration) (*command.Command, error) {
+	if commandID == "" {
+		return nil, status.Error(codes.InvalidArgument, "command ID is required")
+	}
+
+	cmd, err := s.svc.WaitForCommand(ctx, commandID, wait)
+	if errors.Is(err, command.ErrCommandNotFound) {
+		return nil, status.Errorf(codes.NotFound, "command %s not found", commandID)
+	}
+	if err != nil {
+		s.logger.Error("Failed to wait for command",
+			zap.String("command_id", commandID),
+			zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to get command status")
+	}
+
+	return cmd, nil
+}
+
+// commandFromRequest builds a command from a submission request
+func commandFromRequest(req *middlewarev1.SubmitCommandRequest) (*command.Command, error) {
+	if req.GetType() == "" {
+		return nil, errors.New("command type is required")
+	}
+
+	cmd := command.NewCommand(req.GetType(), nil)
+	if err := decodeJSON(req.GetPayload(), &cmd.Payload); err != nil {
+		return nil, fmt.Errorf("invalid payload: %v", err)
+	}
+	if err := decodeJSON(req.GetMetadata(), &cmd.Metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata: %v", err)
+	}
+
+	if req.GetPriority() != 0 {
+		priority := command.Priority(req.GetPriority())
+		if !priority.Valid() {
+			return nil, fmt.Errorf("invalid priority: %d", req.GetPriority())
+		}
+		cmd.Priority = priority
+	}
+	if req.GetMaxRetries() < 0 {
+		return nil, errors.New("max retries cannot be negative")
+	}
+	if req.GetMaxRetries() > 0 {
+		cmd.MaxRetries = int(req.GetMaxRetries())
+	}
+	if req.GetTimeoutAfterMs() > 0 {
+		cmd.TimeoutAfter = time.Duration(req.GetTimeoutAfterMs()) * time.Millisecond
+	}
+	if req.GetScheduledFor() > 0 {
+		scheduledFor := time.UnixMilli(req.GetScheduledFor())
+		cmd.ScheduledFor = &scheduledFor
+	}
+
+	cmd.SchemaVersion = req.GetSchemaVersion()
+	cmd.EntityID = req.GetEntityId()
+	cmd.CorrelationID = req.GetCorrelationId()
+	cmd.IdempotencyKey = req.GetIdempotencyKey()
+	cmd.CallbackURL = req.GetCallbackUrl()
+
+	return cmd, nil
+}
+
+// metadataValue extracts the first value of a key from incoming metadata
+func metadataValue(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// validationStatus reports invalid payload fields as BadRequest details
+func validationStatus(err *command.ValidationError) error {
+	badRequest := &errdetails.BadRequest{}
+	for _, f := range err.Fields {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       f.Field,
+			Description: f.Message,
+			Reason:      f.Rule,
+		})
+	}
+
+	st, detailErr := status.New(codes.InvalidArgument, err.Error()).WithDetails(badRequest)
+	if detailErr != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return st.Err()
+}
+
+// commandToProto converts a command to its protobuf representation
+func commandToProto(cmd *command.Command) (*middlewarev1.Command, error) {
+	payload, err := encodeJSON(cmd.Payload)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := encodeJSON(cmd.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	pb := &middlewarev1.Command{
+		Id:             cmd.ID,
+		Type:           cmd.Type,
+		Payload:        payload,
+		Priority:       int32(cmd.Priority),
+		Status:         string(cmd.Status),
+		Metadata:       meta,
+		CreatedAt:      unixMilli(&cmd.CreatedAt),
+		UpdatedAt:      unixMilli(&cmd.UpdatedAt),
+		ScheduledFor:   unixMilli(cmd.ScheduledFor),
+		ProcessedAt:    unixMilli(cmd.ProcessedAt),
+		CompletedAt:    unixMilli(cmd.CompletedAt),
+		RetryCount:     int32(cmd.RetryCount),
+		MaxRetries:     int32(cmd.MaxRetries),
+		RetryBackoffMs: cmd.RetryBackoff.Milliseconds(),
+		TimeoutAfterMs: cmd.TimeoutAfter.Milliseconds(),
+		CorrelationId:  cmd.CorrelationID,
+		UserId:         cmd.UserID,
+		IdempotencyKey: cmd.IdempotencyKey,
+		EntityId:       cmd.EntityID,
+		Error:          cmd.Error,
+		CancelledAt:    unixMilli(cmd.CancelledAt),
+		CancelledBy:    cmd.CancelledBy,
+		CancelReason:   cmd.CancelReason,
+		CallbackUrl:    cmd.CallbackURL,
+	}
+
+	if cmd.ErrorDetails != nil {
+		pb.ErrorDetails = &middlewarev1.ErrorDetails{
+			Code:       cmd.ErrorDetails.Code,
+			Message:    cmd.ErrorDetails.Message,
+			Details:    cmd.ErrorDetails.Details,
+			OccurredAt: unixMilli(&cmd.ErrorDetails.OccurredAt),
+		}
+	}
+
+	return pb, nil
+}
+
+func encodeJSON(v map[string]interface{}) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+func decodeJSON(data []byte, v *map[string]interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+func unixMilli(t *time.Time) int64 {
+	if t == nil || t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
@@ -22,19 +22,22 @@ type Server struct {
 	port    int
 }
 
-// NewServer creates a new gRPC server with middleware
-func NewServer(logger *zap.Logger, m *metrics.Metrics, port int) *Server {
+// NewServer creates a new gRPC server with middleware. Calls carrying a
+// bearer token are authenticated by authenticator.
+func NewServer(logger *zap.Logger, m *metrics.Metrics, port int, authenticator TokenAuthenticator) *Server {
 	// Create gRPC server with middleware chain
 	srv := grpc.NewServer(
 		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
 			grpc_prometheus.UnaryServerInterceptor,
 			grpc_zap.UnaryServerInterceptor(logger),
 			grpc_recovery.UnaryServerInterceptor(),
+			UnaryAuthInterceptor(authenticator),
 		)),
 		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
 			grpc_prometheus.StreamServerInterceptor,
 			grpc_zap.StreamServerInterceptor(logger),
 			grpc_recovery.StreamServerInterceptor(),
+			StreamAuthInterceptor(authenticator),
 		)),
 	)
 
@@ -16,9 +16,10 @@ import (
 
 // User represents an authenticated user
 type User struct {
-	ID    string `json:"id"`
-	Role  string `json:"role"`
-	Email string `json:"email"`
+	ID     string   `json:"id"`
+	Role   string   `json:"role"`
+	Email  string   `json:"email"`
+	Scopes []string `json:"scopes,omitempty"`
 }
 
 // UserContextKey is used to store user information in context
@@ -26,6 +27,12 @@ type contextKey string
 
 const UserContextKey contextKey = "user"
 
+// UserFromContext returns the authenticated user stored in the context, if any
+func UserFromContext(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(UserContextKey).(*User)
+	return user, ok && user != nil
+}
+
 // opaAuthorizer implements the OPAAuthorizer interface
 type opaAuthorizer struct {
 	store      storage.Store
@@ -123,4 +123,38 @@ has_required_scope(claims, method, path) {
 token_is_valid(claims) {
     current_time := time.now_ns() / 1000000000  # Convert to seconds
     claims.exp > current_time
-}
\ No newline at end of file
+}
+
+# Command submission, evaluated per command by the command service
+allow {
+    input.action == "command.submit"
+    input.user.id != ""
+    can_submit(input.user, input.command.type)
+    not command_denied
+}
+
+can_submit(user, cmd_type) {
+    user.role == "admin"
+}
+
+can_submit(user, cmd_type) {
+    user.scopes[_] == sprintf("submit:%s", [cmd_type])
+}
+
+can_submit(user, cmd_type) {
+    user.scopes[_] == "submit:*"
+}
+
+# Users may only delete their own account unless they are admins
+command_denied {
+    input.command.type == "user.delete"
+    input.user.role != "admin"
+    input.command.entity_id != input.user.id
+}
+
+# Large payments need an admin
+command_denied {
+    input.command.type == "payment.process"
+    input.user.role != "admin"
+    input.command.payload.amount > 10000
+}
@@ -0,0 +1,132 @@
+package command
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/linkmeAman/universal-middleware/internal/database"
+)
+
+// Attempt is one execution of a command
+type Attempt struct {
+	CommandID     string     `json:"commandId"`
+	Number        int        `json:"number"`
+	WorkerID      string     `json:"workerId,omitempty"`
+	Status        Status     `json:"status"`
+	ErrorCode     string     `json:"errorCode,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	StartedAt     time.Time  `json:"startedAt"`
+	FinishedAt    time.Time  `json:"finishedAt"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+}
+
+// AttemptStore persists the attempt history of commands
+type AttemptStore interface {
+	RecordAttempt(ctx context.Context, attempt *Attempt) error
+}
+
+// execer is implemented by both database.DB and database.Tx
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (database.CommandTag, error)
+}
+
+// RetryRepository stores the attempt history of commands
+type RetryRepository struct {
+	db database.DB
+}
+
+// NewRetryRepository creates a new retry repository
+func NewRetryRepository(db database.DB) *RetryRepository {
+	return &RetryRepository{db: db}
+}
+
+// RecordAttempt appends an attempt to the history of its command
+func (r *RetryRepository) RecordAttempt(ctx context.Context, attempt *Attempt) error {
+	return insertAttempt(ctx, r.db, attempt)
+}
+
+// Attempts returns the attempt history of a command, oldest first. Commands
+// that have not run yet have none.
+func (s *CommandService) Attempts(ctx context.Context, commandID string) ([]*Attempt, error) {
+	query := `
+		SELECT command_id, attempt, worker_id, status, error_code, error,
+			   started_at, finished_at, next_attempt_at
+		FROM command_attempts
+		WHERE command_id = $1
+		ORDER BY attempt ASC, id ASC`
+
+	rows, err := s.db.QueryContext(ctx, query, commandID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attempts: %w", err)
+	}
+	defer rows.Close()
+
+	var attempts []*Attempt
+	for rows.Next() {
+		a, err := scanAttempt(rows)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating attempts: %w", err)
+	}
+
+	if len(attempts) == 0 {
+		var exists bool
+		err := s.db.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM commands WHERE id = $1)`, commandID,
+		).Scan(&exists)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load command: %w", err)
+		}
+		if !exists {
+			return nil, ErrCommandNotFound
+		}
+	}
+
+	return attempts, nil
+}
+
+// scanAttempt reads a row of command_attempts
+func scanAttempt(row rowScanner) (*Attempt, error) {
+	a := &Attempt{}
+	var workerID, errorCode, errorMsg sql.NullString
+	if err := row.Scan(
+		&a.CommandID, &a.Number, &workerID, &a.Status, &errorCode, &errorMsg,
+		&a.StartedAt, &a.FinishedAt, &a.NextAttemptAt,
+	); err != nil {
+		return nil, fmt.Errorf("failed to scan attempt: %w", err)
+	}
+	a.WorkerID = workerID.String
+	a.ErrorCode = errorCode.String
+	a.Error = errorMsg.String
+	return a, nil
+}
+
+func insertAttempt(ctx context.Context, db execer, a *Attempt) error {
+	query := `
+		INSERT INTO command_attempts (
+			command_id, attempt, worker_id, status, error_code, error,
+			started_at, finished_at, next_attempt_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+	_, err := db.Exec(ctx, query,
+		a.CommandID, a.Number, nullString(a.WorkerID), a.Status,
+		nullString(a.ErrorCode), nullString(a.Error),
+		a.StartedAt, a.FinishedAt, a.NextAttemptAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record attempt: %w", err)
+	}
+
+	return nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,45 @@
+package command
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanAttempt(t *testing.T) {
+	started := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	next := started.Add(time.Minute)
+
+	a, err := scanAttempt(fakeRow{
+		"cmd-1", 2, sql.NullString{String: "worker-1", Valid: true}, StatusRetrying,
+		sql.NullString{String: "HANDLER_ERROR", Valid: true}, sql.NullString{String: "smtp unavailable", Valid: true},
+		started, started.Add(time.Second), &next,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "cmd-1", a.CommandID)
+	assert.Equal(t, 2, a.Number)
+	assert.Equal(t, "worker-1", a.WorkerID)
+	assert.Equal(t, StatusRetrying, a.Status)
+	assert.Equal(t, "HANDLER_ERROR", a.ErrorCode)
+	assert.Equal(t, "smtp unavailable", a.Error)
+	assert.Equal(t, &next, a.NextAttemptAt)
+
+	// The last attempt of a dead command has no worker error and no next one
+	a, err = scanAttempt(fakeRow{
+		"cmd-1", 3, sql.NullString{}, StatusDead, sql.NullString{}, sql.NullString{},
+		started, started, (*time.Time)(nil),
+	})
+	require.NoError(t, err)
+	assert.Empty(t, a.WorkerID)
+	assert.Empty(t, a.Error)
+	assert.Nil(t, a.NextAttemptAt)
+}
+
+func TestListDeadCommands(t *testing.T) {
+	query, args := buildListQuery(ListFilter{Status: StatusDead, Type: CommandTypeEmailSend}, nil)
+	assert.Contains(t, query, "WHERE status = $1 AND type = $2")
+	assert.Equal(t, []interface{}{StatusDead, CommandTypeEmailSend, defaultListLimit + 1}, args)
+}
@@ -0,0 +1,102 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/linkmeAman/universal-middleware/internal/auth"
+)
+
+type fakeAuthorizer struct {
+	allow  bool
+	err    error
+	inputs []map[string]interface{}
+}
+
+func (a *fakeAuthorizer) IsAllowed(ctx context.Context, input interface{}) (bool, error) {
+	a.inputs = append(a.inputs, input.(map[string]interface{}))
+	return a.allow, a.err
+}
+
+func (a *fakeAuthorizer) RefreshPolicies(ctx context.Context) error {
+	return nil
+}
+
+func userContext(user *auth.User) context.Context {
+	return context.WithValue(context.Background(), auth.UserContextKey, user)
+}
+
+func TestAuthorizeWithoutAuthorizer(t *testing.T) {
+	s := newTestCommandService()
+	cmd := NewCommand(CommandTypeUserDelete, map[string]interface{}{})
+
+	require.NoError(t, s.authorize(userContext(&auth.User{ID: "user-1"}), cmd))
+	assert.Equal(t, "user-1", cmd.UserID)
+}
+
+func TestAuthorizeInput(t *testing.T) {
+	authorizer := &fakeAuthorizer{allow: true}
+	s := newTestCommandService()
+	s.EnableAuthorization(authorizer)
+
+	cmd := NewCommand(CommandTypeUserDelete, map[string]interface{}{"reason": "closed"})
+	cmd.EntityID = "user-1"
+	user := &auth.User{ID: "user-1", Role: "member", Email: "a@example.com", Scopes: []string{"submit:user.delete"}}
+
+	require.NoError(t, s.authorize(userContext(user), cmd))
+	require.Len(t, authorizer.inputs, 1)
+	assert.Equal(t, map[string]interface{}{
+		"action": SubmitAction,
+		"command": map[string]interface{}{
+			"type":      CommandTypeUserDelete,
+			"entity_id": "user-1",
+			"priority":  cmd.Priority.String(),
+			"payload":   map[string]interface{}{"reason": "closed"},
+		},
+		"user": map[string]interface{}{
+			"id":     "user-1",
+			"role":   "member",
+			"email":  "a@example.com",
+			"scopes": []string{"submit:user.delete"},
+		},
+	}, authorizer.inputs[0])
+}
+
+func TestAuthorizeDenied(t *testing.T) {
+	s := newTestCommandService()
+	s.EnableAuthorization(&fakeAuthorizer{allow: false})
+
+	cmd := NewCommand(CommandTypeUserDelete, map[string]interface{}{})
+	cmd.EntityID = "user-2"
+	err := s.authorize(userContext(&auth.User{ID: "user-1"}), cmd)
+
+	assert.ErrorIs(t, err, ErrCommandForbidden)
+	var denial *AuthorizationError
+	require.True(t, errors.As(err, &denial))
+	assert.Equal(t, &AuthorizationError{CommandType: CommandTypeUserDelete, EntityID: "user-2", UserID: "user-1"}, denial)
+}
+
+func TestAuthorizeError(t *testing.T) {
+	s := newTestCommandService()
+	s.EnableAuthorization(&fakeAuthorizer{err: errors.New("opa unavailable")})
+
+	err := s.authorize(context.Background(), NewCommand(CommandTypeUserDelete, map[string]interface{}{}))
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrCommandForbidden)
+}
+
+func TestSubmitBatchDenied(t *testing.T) {
+	s := newTestCommandService()
+	s.EnableAuthorization(&fakeAuthorizer{allow: false})
+
+	cmd := NewCommand(CommandTypeCacheWarmup, map[string]interface{}{})
+	result, err := s.SubmitBatch(userContext(&auth.User{ID: "user-1"}), []*Command{cmd}, BatchAtomic)
+	assert.ErrorIs(t, err, ErrBatchRejected)
+	require.Len(t, result.Items, 1)
+	assert.Equal(t, BatchItemFailed, result.Items[0].Status)
+	assert.Contains(t, result.Items[0].Error, "not allowed")
+}
@@ -0,0 +1,238 @@
+package command
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// MaxBatchSize is the largest number of commands accepted in one batch
+const MaxBatchSize = 100
+
+// BatchMode selects what happens to a batch when some of its commands fail
+type BatchMode string
+
+const (
+	// BatchAtomic stores either every command of the batch or none of them
+	BatchAtomic BatchMode = "atomic"
+	// BatchBestEffort stores the commands that succeed and reports the rest
+	BatchBestEffort BatchMode = "best_effort"
+)
+
+// Statuses reported for batch items that were not stored
+const (
+	BatchItemFailed   = "failed"
+	BatchItemRejected = "rejected"
+)
+
+var (
+	// ErrEmptyBatch is returned for batches without commands
+	ErrEmptyBatch = errors.New("batch contains no commands")
+
+	// ErrBatchTooLarge is returned for batches above MaxBatchSize
+	ErrBatchTooLarge = fmt.Errorf("batch exceeds %d commands", MaxBatchSize)
+
+	// ErrInvalidBatchMode is returned for an unknown BatchMode
+	ErrInvalidBatchMode = errors.New("invalid batch mode")
+
+	// ErrBatchRejected is returned with the per-item results when an atomic
+	// batch was not stored because some of its commands are invalid
+	ErrBatchRejected = errors.New("batch rejected")
+)
+
+// BatchItemResult is the outcome of one command of a batch
+type BatchItemResult struct {
+	Index     int    `json:"index"`
+	CommandID string `json:"command_id,omitempty"`
+	Status    string `json:"status"`
+	Location  string `json:"location,omitempty"`
+	// Duplicate is set when the idempotency key matched an earlier command,
+	// which is returned instead of storing a new one
+	Duplicate bool         `json:"duplicate,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty"`
+}
+
+// BatchResult holds the outcome of every command of a batch, in request order
+type BatchResult struct {
+	Mode     BatchMode         `json:"mode"`
+	Accepted int               `json:"accepted"`
+	Failed   int               `json:"failed"`
+	Items    []BatchItemResult `json:"items"`
+}
+
+func (r *BatchResult) accept(i int, res *CommandResult) {
+	r.Items[i] = BatchItemResult{
+		Index:     i,
+		CommandID: res.CommandID,
+		Status:    res.Status,
+		Location:  res.Location,
+		Duplicate: res.Replayed,
+	}
+	r.Accepted++
+}
+
+func (r *BatchResult) fail(i int, err error) {
+	item := BatchItemResult{Index: i, Status: BatchItemFailed, Error: err.Error()}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		item.Fields = validationErr.Fields
+	}
+	r.Items[i] = item
+	r.Failed++
+}
+
+// reject marks every command that would have been stored as rejected
+func (r *BatchResult) reject() {
+	for i := range r.Items {
+		item := &r.Items[i]
+		if item.Status == "" {
+			item.Index = i
+			item.Status = BatchItemRejected
+		}
+	}
+}
+
+// SubmitBatch accepts several commands in one transaction. Every command is
+// validated and checked against its idempotency key first. In atomic mode a
+// single invalid command rejects the whole batch with ErrBatchRejected and
+// nothing is stored; in best-effort mode the valid commands are stored and
+// the others reported as failed. The result is returned in both cases.
+func (s *CommandService) SubmitBatch(ctx context.Context, cmds []*Command, mode BatchMode) (*BatchResult, error) {
+	if mode == "" {
+		mode = BatchAtomic
+	}
+	if mode != BatchAtomic && mode != BatchBestEffort {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBatchMode, mode)
+	}
+	if len(cmds) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	if len(cmds) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+
+	result := &BatchResult{Mode: mode, Items: make([]BatchItemResult, len(cmds))}
+	pending := s.checkBatch(ctx, cmds, result)
+	if mode == BatchAtomic && result.Failed > 0 {
+		result.reject()
+		return result, ErrBatchRejected
+	}
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var stored []*Command
+	for i, cmd := range cmds {
+		if !pending[i] {
+			continue
+		}
+
+		scheduled := s.prepareCommand(cmd)
+		if mode == BatchAtomic {
+			if err := s.insertCommand(ctx, tx, cmd, scheduled); err != nil {
+				return nil, fmt.Errorf("command %d: %w", i, err)
+			}
+		} else if err := s.insertInSavepoint(ctx, tx, cmd, scheduled); err != nil {
+			s.log.Warn("Failed to store batch command",
+				zap.Int("index", i),
+				zap.String("type", cmd.Type),
+				zap.Error(err))
+			result.fail(i, err)
+			continue
+		}
+
+		result.accept(i, newCommandResult(cmd, scheduled))
+		stored = append(stored, cmd)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	for _, cmd := range stored {
+		if err := s.cacheCommandStatus(ctx, cmd); err != nil {
+			s.log.Warn("Failed to cache command status",
+				zap.String("command_id", cmd.ID),
+				zap.Error(err))
+		}
+		if cmd.Status == StatusScheduled {
+			s.scheduler.Notify(*cmd.ScheduledFor)
+		}
+	}
+
+	s.log.Info("Command batch submitted",
+		zap.String("mode", string(mode)),
+		zap.Int("size", len(cmds)),
+		zap.Int("stored", len(stored)),
+		zap.Int("failed", result.Failed))
+
+	return result, nil
+}
+
+// checkBatch validates the commands of a batch and resolves their
+// idempotency keys. It records failures and duplicates in the result and
+// returns which commands still have to be stored.
+func (s *CommandService) checkBatch(ctx context.Context, cmds []*Command, result *BatchResult) []bool {
+	pending := make([]bool, len(cmds))
+	keys := make(map[string]int)
+
+	for i, cmd := range cmds {
+		if cmd.Type == "" {
+			result.fail(i, errors.New("command type is required"))
+			continue
+		}
+		if err := s.checkCommand(ctx, cmd); err != nil {
+			result.fail(i, err)
+			continue
+		}
+
+		if cmd.IdempotencyKey != "" {
+			if first, ok := keys[cmd.IdempotencyKey]; ok {
+				result.fail(i, fmt.Errorf("idempotency key is already used by command %d of the batch", first))
+				continue
+			}
+			keys[cmd.IdempotencyKey] = i
+
+			existing, err := s.checkIdempotency(ctx, cmd)
+			if err != nil {
+				result.fail(i, err)
+				continue
+			}
+			if existing != nil {
+				result.accept(i, existing)
+				continue
+			}
+		}
+
+		pending[i] = true
+	}
+
+	return pending
+}
+
+// insertInSavepoint stores a command so that a failure only undoes its own
+// writes and leaves the rest of the transaction usable
+func (s *CommandService) insertInSavepoint(ctx context.Context, tx *sql.Tx, cmd *Command, scheduled bool) error {
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT batch_item"); err != nil {
+		return fmt.Errorf("failed to create savepoint: %w", err)
+	}
+
+	if err := s.insertCommand(ctx, tx, cmd, scheduled); err != nil {
+		if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_item"); rbErr != nil {
+			return fmt.Errorf("%v (rollback to savepoint failed: %w)", err, rbErr)
+		}
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT batch_item")
+	return err
+}
@@ -0,0 +1,64 @@
+package command
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestCommandService() *CommandService {
+	return &CommandService{
+		validator:   NewValidator(),
+		completions: NewCompletions(nil, nil, zap.NewNop()),
+		log:         zap.NewNop(),
+	}
+}
+
+func TestSubmitBatchArguments(t *testing.T) {
+	s := newTestCommandService()
+	cmd := NewCommand(CommandTypeCacheWarmup, map[string]interface{}{})
+
+	_, err := s.SubmitBatch(context.Background(), nil, BatchAtomic)
+	assert.ErrorIs(t, err, ErrEmptyBatch)
+
+	_, err = s.SubmitBatch(context.Background(), []*Command{cmd}, "partial")
+	assert.ErrorIs(t, err, ErrInvalidBatchMode)
+
+	cmds := make([]*Command, MaxBatchSize+1)
+	for i := range cmds {
+		cmds[i] = cmd
+	}
+	_, err = s.SubmitBatch(context.Background(), cmds, BatchBestEffort)
+	assert.ErrorIs(t, err, ErrBatchTooLarge)
+}
+
+func TestSubmitBatchAtomicRejection(t *testing.T) {
+	s := newTestCommandService()
+	cmds := []*Command{
+		NewCommand(CommandTypeCacheWarmup, map[string]interface{}{}),
+		NewCommand(CommandTypeEmailSend, map[string]interface{}{"to": "jane@example.com", "subject": "Hi"}),
+		NewCommand("", map[string]interface{}{}),
+	}
+
+	// Nothing is written, so the service needs no database
+	result, err := s.SubmitBatch(context.Background(), cmds, "")
+	assert.ErrorIs(t, err, ErrBatchRejected)
+	require.NotNil(t, result)
+
+	assert.Equal(t, BatchAtomic, result.Mode)
+	assert.Equal(t, 0, result.Accepted)
+	assert.Equal(t, 2, result.Failed)
+	require.Len(t, result.Items, 3)
+
+	assert.Equal(t, BatchItemResult{Index: 0, Status: BatchItemRejected}, result.Items[0])
+
+	assert.Equal(t, 1, result.Items[1].Index)
+	assert.Equal(t, BatchItemFailed, result.Items[1].Status)
+	assert.Equal(t, []FieldError{{Field: "/body", Rule: "required", Message: "is required"}}, result.Items[1].Fields)
+
+	assert.Equal(t, BatchItemFailed, result.Items[2].Status)
+	assert.Equal(t, "command type is required", result.Items[2].Error)
+}
@@ -0,0 +1,259 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Headers set on callback requests
+const (
+	CallbackSignatureHeader = "X-Callback-Signature"
+	CallbackTimestampHeader = "X-Callback-Timestamp"
+	CallbackCommandIDHeader = "X-Command-ID"
+)
+
+// ErrInvalidCallbackURL is returned for callback URLs that are not absolute
+// http(s) URLs or when callbacks are not enabled
+var ErrInvalidCallbackURL = errors.New("invalid callback URL")
+
+// errCallbackAddressBlocked is returned when a callback would be sent to a
+// loopback, private or link-local address
+var errCallbackAddressBlocked = errors.New("callback address is not public")
+
+// sharedAddressSpace is the carrier-grade NAT range, which is not routable on
+// the internet but not covered by netip.Addr.IsPrivate either
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// blockedCallbackAddr reports whether callbacks must not be sent to addr
+// because it is not a public unicast address
+func blockedCallbackAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return !addr.IsGlobalUnicast() || addr.IsPrivate() || sharedAddressSpace.Contains(addr)
+}
+
+// ValidateCallbackURL checks that a callback URL can be delivered to. An empty
+// URL is valid and means no callback was requested.
+func ValidateCallbackURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCallbackURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme must be http or https", ErrInvalidCallbackURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%w: host is required", ErrInvalidCallbackURL)
+	}
+	return nil
+}
+
+// SignCallback returns the signature of a callback body sent at the given
+// Unix time. Receivers recompute it with the shared secret over
+// "<timestamp>.<body>" and compare it to the X-Callback-Signature header.
+func SignCallback(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// CallbackPayload is the body POSTed to a command's callback URL once the
+// command has finished
+type CallbackPayload struct {
+	CommandID     string        `json:"command_id"`
+	Type          string        `json:"type"`
+	Status        Status        `json:"status"`
+	CorrelationID string        `json:"correlation_id,omitempty"`
+	Error         *ErrorDetails `json:"error,omitempty"`
+	CompletedAt   *time.Time    `json:"completed_at,omitempty"`
+	CancelledAt   *time.Time    `json:"cancelled_at,omitempty"`
+}
+
+// CallbackConfig holds configuration for callback delivery
+type CallbackConfig struct {
+	// Secret signs every callback, callbacks are disabled without it
+	Secret string
+
+	// Timeout bounds a single delivery attempt
+	Timeout time.Duration
+
+	// MaxAttempts is how often a callback is tried before it is dropped.
+	// Attempts are spaced by Backoff, doubling every time.
+	MaxAttempts int
+	Backoff     time.Duration
+
+	// AllowPrivateNetworks lets callbacks reach loopback, private and
+	// link-local addresses. Without it callback URLs are refused when their
+	// host resolves to such an address, and so are connections to one.
+	AllowPrivateNetworks bool
+}
+
+// DefaultCallbackConfig returns default callback configuration
+func DefaultCallbackConfig() CallbackConfig {
+	return CallbackConfig{
+		Timeout:     10 * time.Second,
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+	}
+}
+
+// CallbackSender delivers signed completion callbacks
+type CallbackSender struct {
+	config CallbackConfig
+	client *http.Client
+	log    *zap.Logger
+}
+
+// NewCallbackSender creates a callback sender. Missing timeouts and attempt
+// counts fall back to DefaultCallbackConfig.
+func NewCallbackSender(cfg CallbackConfig, log *zap.Logger) *CallbackSender {
+	defaults := DefaultCallbackConfig()
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaults.Timeout
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaults.MaxAttempts
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = defaults.Backoff
+	}
+
+	s := &CallbackSender{config: cfg, log: log}
+
+	// Hosts are checked again when connecting, as they may resolve
+	// differently than when the callback URL was accepted. Proxies are not
+	// used because the connection to them would be the one checked.
+	dialer := &net.Dialer{Timeout: cfg.Timeout, Control: s.checkDial}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	s.client = &http.Client{Timeout: cfg.Timeout, Transport: transport}
+
+	return s
+}
+
+// CheckURL rejects callback URLs that are invalid or, unless private networks
+// are allowed, whose host resolves to a loopback, private or link-local
+// address
+func (s *CallbackSender) CheckURL(ctx context.Context, raw string) error {
+	if err := ValidateCallbackURL(raw); err != nil || raw == "" {
+		return err
+	}
+	if s.config.AllowPrivateNetworks {
+		return nil
+	}
+
+	u, _ := url.Parse(raw)
+	host := u.Hostname()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("%w: cannot resolve %s", ErrInvalidCallbackURL, host)
+	}
+
+	for _, addr := range addrs {
+		if blockedCallbackAddr(addr) {
+			return fmt.Errorf("%w: %s resolves to a non-public address", ErrInvalidCallbackURL, host)
+		}
+	}
+	return nil
+}
+
+// checkDial refuses connections to non-public addresses unless private
+// networks are allowed
+func (s *CallbackSender) checkDial(network, address string, _ syscall.RawConn) error {
+	if s.config.AllowPrivateNetworks {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errCallbackAddressBlocked, address)
+	}
+	if blockedCallbackAddr(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", errCallbackAddressBlocked, address)
+	}
+	return nil
+}
+
+// callbackBody encodes the callback payload of a command
+func callbackBody(cmd *Command) ([]byte, error) {
+	body, err := json.Marshal(CallbackPayload{
+		CommandID:     cmd.ID,
+		Type:          cmd.Type,
+		Status:        cmd.Status,
+		CorrelationID: cmd.CorrelationID,
+		Error:         cmd.ErrorDetails,
+		CompletedAt:   cmd.CompletedAt,
+		CancelledAt:   cmd.CancelledAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode callback: %w", err)
+	}
+	return body, nil
+}
+
+// Send makes a single delivery attempt of the callback of a command
+func (s *CallbackSender) Send(ctx context.Context, cmd *Command) error {
+	body, err := callbackBody(cmd)
+	if err != nil {
+		return err
+	}
+	return s.post(ctx, cmd.CallbackURL, cmd.ID, body)
+}
+
+// post signs an encoded callback payload and sends it to target
+func (s *CallbackSender) post(ctx context.Context, target, commandID string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create callback request: %w", err)
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(CallbackCommandIDHeader, commandID)
+	req.Header.Set(CallbackTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(CallbackSignatureHeader, SignCallback(s.config.Secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if errors.Is(err, errCallbackAddressBlocked) {
+		return Permanent(fmt.Errorf("callback request failed: %w", err))
+	}
+	if err != nil {
+		return fmt.Errorf("callback request failed: %w", err)
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("callback returned %d", resp.StatusCode)
+	default:
+		// The receiver rejected the callback, sending it again will not help
+		return Permanent(fmt.Errorf("callback returned %d", resp.StatusCode))
+	}
+}
+
+// retryDelay returns how long to wait after the given failed attempt
+func (s *CallbackSender) retryDelay(attempt int) time.Duration {
+	return s.config.Backoff << (attempt - 1)
+}
@@ -0,0 +1,260 @@
+package command
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/linkmeAman/universal-middleware/internal/database"
+	"github.com/linkmeAman/universal-middleware/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// queueCallbackQuery queues the callback of a command that reached a terminal
+// status. A command is only called back once.
+const queueCallbackQuery = `
+	INSERT INTO command_callbacks (command_id, url, payload)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (command_id) DO NOTHING`
+
+// callbackArgs returns the arguments of queueCallbackQuery for a command, or
+// nil when the command has no callback or has not finished
+func callbackArgs(cmd *Command) ([]interface{}, error) {
+	if cmd.CallbackURL == "" || !cmd.Status.IsTerminal() {
+		return nil, nil
+	}
+
+	body, err := callbackBody(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{cmd.ID, cmd.CallbackURL, body}, nil
+}
+
+// storeCallback queues the callback of a finished command within the given
+// transaction
+func storeCallback(ctx context.Context, tx *sql.Tx, cmd *Command) error {
+	args, err := callbackArgs(cmd)
+	if err != nil || args == nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, queueCallbackQuery, args...); err != nil {
+		return fmt.Errorf("failed to queue callback: %w", err)
+	}
+	return nil
+}
+
+// insertCallback is storeCallback for the pgx backed repositories
+func insertCallback(ctx context.Context, db execer, cmd *Command) error {
+	args, err := callbackArgs(cmd)
+	if err != nil || args == nil {
+		return err
+	}
+	if _, err := db.Exec(ctx, queueCallbackQuery, args...); err != nil {
+		return fmt.Errorf("failed to queue callback: %w", err)
+	}
+	return nil
+}
+
+// CallbackQueueConfig holds configuration for the callback queue
+type CallbackQueueConfig struct {
+	// PollInterval is the longest the queue sleeps between scans. It bounds
+	// how late callbacks queued by another process, such as cancellations
+	// through the API gateway, are sent.
+	PollInterval time.Duration
+	BatchSize    int
+
+	// ClaimTimeout hides a claimed callback from other replicas. Callbacks
+	// claimed by a replica that stopped before sending them are picked up
+	// again once it has passed.
+	ClaimTimeout time.Duration
+}
+
+// DefaultCallbackQueueConfig returns default callback queue configuration
+func DefaultCallbackQueueConfig() CallbackQueueConfig {
+	return CallbackQueueConfig{
+		PollInterval: time.Second,
+		BatchSize:    50,
+		ClaimTimeout: time.Minute,
+	}
+}
+
+// CallbackQueue delivers the callbacks queued when commands finish.
+//
+// Callbacks are queued in the transaction storing the final status of their
+// command, so none is lost when a process stops, and are claimed with FOR
+// UPDATE SKIP LOCKED so each attempt is made by a single replica. Failed
+// attempts are retried with the backoff of the sender until it runs out of
+// attempts.
+type CallbackQueue struct {
+	db     database.DB
+	sender *CallbackSender
+	config CallbackQueueConfig
+	log    *logger.Logger
+	wake   chan struct{}
+}
+
+// NewCallbackQueue creates a callback queue delivering through sender
+func NewCallbackQueue(db database.DB, sender *CallbackSender, config CallbackQueueConfig, log *logger.Logger) *CallbackQueue {
+	return &CallbackQueue{
+		db:     db,
+		sender: sender,
+		config: config,
+		log:    log,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Start runs the delivery loop until the context is cancelled
+func (q *CallbackQueue) Start(ctx context.Context) {
+	q.log.Info("Starting callback queue",
+		zap.Duration("poll_interval", q.config.PollInterval),
+		zap.Int("batch_size", q.config.BatchSize))
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-q.wake:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+
+		sent, err := q.deliverDue(ctx)
+		if err != nil {
+			q.log.Error("Failed to deliver queued callbacks", zap.Error(err))
+		}
+
+		// A full batch means more callbacks are probably due already
+		delay := q.config.PollInterval
+		if err == nil && sent == q.config.BatchSize {
+			delay = 0
+		}
+		timer.Reset(delay)
+	}
+}
+
+// Notify wakes the queue up to deliver callbacks queued by this process. It
+// is safe to call on a nil *CallbackQueue.
+func (q *CallbackQueue) Notify() {
+	if q == nil {
+		return
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// queuedCallback is a claimed callback delivery
+type queuedCallback struct {
+	commandID string
+	url       string
+	payload   []byte
+	attempts  int
+}
+
+// deliverDue sends a batch of due callbacks and returns how many were claimed
+func (q *CallbackQueue) deliverDue(ctx context.Context) (int, error) {
+	due, err := q.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	for _, cb := range due {
+		wg.Add(1)
+		go func(cb queuedCallback) {
+			defer wg.Done()
+			q.settle(ctx, cb, q.sender.post(ctx, cb.url, cb.commandID, cb.payload))
+		}(cb)
+	}
+	wg.Wait()
+
+	return len(due), nil
+}
+
+// claim takes a batch of due callbacks, counting the attempt about to be made
+// and hiding them from other replicas for ClaimTimeout
+func (q *CallbackQueue) claim(ctx context.Context) ([]queuedCallback, error) {
+	query := `
+		UPDATE command_callbacks
+		SET attempts = attempts + 1,
+			next_attempt_at = NOW() + $1 * INTERVAL '1 millisecond'
+		WHERE command_id IN (
+			SELECT command_id FROM command_callbacks
+			WHERE next_attempt_at <= NOW()
+			ORDER BY next_attempt_at ASC
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING command_id, url, payload, attempts`
+
+	rows, err := q.db.Query(ctx, query, q.config.ClaimTimeout.Milliseconds(), q.config.BatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim callbacks: %w", err)
+	}
+	defer rows.Close()
+
+	var due []queuedCallback
+	for rows.Next() {
+		var cb queuedCallback
+		if err := rows.Scan(&cb.commandID, &cb.url, &cb.payload, &cb.attempts); err != nil {
+			return nil, fmt.Errorf("failed to scan callback: %w", err)
+		}
+		due = append(due, cb)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating callbacks: %w", err)
+	}
+	return due, nil
+}
+
+// settle removes a delivered or abandoned callback from the queue, or
+// schedules its next attempt
+func (q *CallbackQueue) settle(ctx context.Context, cb queuedCallback, sendErr error) {
+	// Shutting down, the claim runs out and another replica tries again
+	if ctx.Err() != nil {
+		return
+	}
+
+	fields := []zap.Field{
+		zap.String("command_id", cb.commandID),
+		zap.Int("attempt", cb.attempts),
+	}
+
+	var err error
+	switch {
+	case sendErr == nil:
+		q.log.Debug("Delivered command callback", fields...)
+		_, err = q.db.Exec(ctx, `DELETE FROM command_callbacks WHERE command_id = $1`, cb.commandID)
+
+	case !IsRetryableError(sendErr) || cb.attempts >= q.sender.config.MaxAttempts:
+		q.log.Warn("Failed to deliver command callback",
+			append(fields, zap.String("callback_url", cb.url), zap.Error(sendErr))...)
+		_, err = q.db.Exec(ctx, `DELETE FROM command_callbacks WHERE command_id = $1`, cb.commandID)
+
+	default:
+		_, err = q.db.Exec(ctx,
+			`UPDATE command_callbacks
+			SET next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond', last_error = $3
+			WHERE command_id = $1`,
+			cb.commandID, q.sender.retryDelay(cb.attempts).Milliseconds(), sendErr.Error(),
+		)
+	}
+
+	if err != nil {
+		q.log.Error("Failed to settle command callback", append(fields, zap.Error(err))...)
+	}
+}
@@ -0,0 +1,186 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/linkmeAman/universal-middleware/internal/database"
+	"github.com/linkmeAman/universal-middleware/test/testutil"
+)
+
+type execCall struct {
+	query string
+	args  []interface{}
+}
+
+// callbackDB is a database returning the given rows from queries and
+// recording every statement executed
+type callbackDB struct {
+	database.DB
+	rows []fakeRow
+
+	mu    sync.Mutex
+	execs []execCall
+}
+
+func (db *callbackDB) Exec(ctx context.Context, sql string, args ...interface{}) (database.CommandTag, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.execs = append(db.execs, execCall{query: sql, args: args})
+	return nil, nil
+}
+
+func (db *callbackDB) Query(ctx context.Context, sql string, args ...interface{}) (database.Rows, error) {
+	rows := db.rows
+	db.rows = nil
+	return &fakeRows{rows: rows}, nil
+}
+
+// fakeRows iterates over fake rows
+type fakeRows struct {
+	rows []fakeRow
+	next int
+}
+
+func (r *fakeRows) Close()                         {}
+func (r *fakeRows) Err() error                     { return nil }
+func (r *fakeRows) Next() bool                     { r.next++; return r.next <= len(r.rows) }
+func (r *fakeRows) Scan(dest ...interface{}) error { return r.rows[r.next-1].Scan(dest...) }
+
+func newTestCallbackQueue(t *testing.T, db *callbackDB) *CallbackQueue {
+	sender := NewCallbackSender(CallbackConfig{
+		Secret:               "s3cret",
+		MaxAttempts:          3,
+		Backoff:              time.Second,
+		AllowPrivateNetworks: true,
+	}, zap.NewNop())
+	return NewCallbackQueue(db, sender, DefaultCallbackQueueConfig(), testutil.NewTestLogger(t))
+}
+
+func TestCallbackArgs(t *testing.T) {
+	cmd := NewCommand(CommandTypeEmailSend, map[string]interface{}{})
+	cmd.CallbackURL = "https://hooks.example.com/done"
+
+	args, err := callbackArgs(cmd)
+	require.NoError(t, err)
+	assert.Nil(t, args, "pending commands are not called back")
+
+	cmd.Status = StatusFailed
+	cmd.SetError("HANDLER_ERROR", "smtp unavailable", "")
+	args, err = callbackArgs(cmd)
+	require.NoError(t, err)
+	require.Len(t, args, 3)
+	assert.Equal(t, cmd.ID, args[0])
+	assert.Equal(t, cmd.CallbackURL, args[1])
+
+	var payload CallbackPayload
+	require.NoError(t, json.Unmarshal(args[2].([]byte), &payload))
+	assert.Equal(t, StatusFailed, payload.Status)
+	assert.Equal(t, "HANDLER_ERROR", payload.Error.Code)
+
+	cmd.CallbackURL = ""
+	args, err = callbackArgs(cmd)
+	require.NoError(t, err)
+	assert.Nil(t, args)
+}
+
+func TestInsertTransitionQueuesCallback(t *testing.T) {
+	db := &callbackDB{}
+	cmd := NewCommand(CommandTypeEmailSend, map[string]interface{}{})
+	cmd.CallbackURL = "https://hooks.example.com/done"
+	cmd.Status = StatusCompleted
+
+	require.NoError(t, insertTransition(context.Background(), db, cmd, StatusProcessing, "worker-1"))
+	require.Len(t, db.execs, 3)
+	assert.Equal(t, queueCallbackQuery, db.execs[2].query)
+	assert.Equal(t, cmd.ID, db.execs[2].args[0])
+
+	db.execs = nil
+	cmd.Status = StatusRetrying
+	require.NoError(t, insertTransition(context.Background(), db, cmd, StatusProcessing, "worker-1"))
+	assert.Len(t, db.execs, 2)
+}
+
+func TestCallbackQueueDelivers(t *testing.T) {
+	srv, received := callbackServer(t)
+	cmd := NewCommand(CommandTypeEmailSend, map[string]interface{}{})
+	cmd.Status = StatusCompleted
+	body, err := callbackBody(cmd)
+	require.NoError(t, err)
+
+	db := &callbackDB{rows: []fakeRow{{cmd.ID, srv.URL, body, 1}}}
+	q := newTestCallbackQueue(t, db)
+
+	sent, err := q.deliverDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+
+	got := <-received
+	assert.Equal(t, body, got.body)
+	assert.Equal(t, cmd.ID, got.header.Get(CallbackCommandIDHeader))
+
+	require.Len(t, db.execs, 1)
+	assert.True(t, strings.HasPrefix(strings.TrimSpace(db.execs[0].query), "DELETE"))
+}
+
+func TestCallbackQueueSettle(t *testing.T) {
+	cb := queuedCallback{commandID: "cmd-1", url: "https://hooks.example.com/done", attempts: 2}
+
+	// Retryable failures are tried again after the backoff of the attempt
+	db := &callbackDB{}
+	q := newTestCallbackQueue(t, db)
+	q.settle(context.Background(), cb, assert.AnError)
+	require.Len(t, db.execs, 1)
+	assert.True(t, strings.HasPrefix(strings.TrimSpace(db.execs[0].query), "UPDATE"))
+	assert.Equal(t, (2 * time.Second).Milliseconds(), db.execs[0].args[1])
+
+	// Rejected callbacks and the last attempt are given up on
+	for name, settle := range map[string]func(){
+		"rejected": func() { q.settle(context.Background(), cb, Permanent(assert.AnError)) },
+		"last attempt": func() {
+			q.settle(context.Background(), queuedCallback{commandID: "cmd-1", attempts: 3}, assert.AnError)
+		},
+		"delivered": func() { q.settle(context.Background(), cb, nil) },
+	} {
+		db.execs = nil
+		settle()
+		require.Len(t, db.execs, 1, name)
+		assert.True(t, strings.HasPrefix(strings.TrimSpace(db.execs[0].query), "DELETE"), name)
+	}
+
+	// Callbacks interrupted by a shutdown are left to their claim running out
+	db.execs = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q.settle(ctx, cb, context.Canceled)
+	assert.Empty(t, db.execs)
+}
+
+func TestCompletionsWakeCallbackQueue(t *testing.T) {
+	q := newTestCallbackQueue(t, &callbackDB{})
+	completions := NewCompletions(nil, q, zap.NewNop())
+
+	cmd := NewCommand(CommandTypeEmailSend, map[string]interface{}{})
+	cmd.Status = StatusCompleted
+	completions.Notify(context.Background(), cmd)
+	assert.Empty(t, q.wake, "commands without a callback do not wake the queue")
+
+	cmd.CallbackURL = "https://hooks.example.com/done"
+	completions.Notify(context.Background(), cmd)
+	assert.Len(t, q.wake, 1)
+
+	// Waking is not blocked by a queue that is already awake
+	completions.Notify(context.Background(), cmd)
+	assert.Len(t, q.wake, 1)
+
+	var nilQueue *CallbackQueue
+	nilQueue.Notify()
+}
@@ -0,0 +1,151 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type receivedCallback struct {
+	header http.Header
+	body   []byte
+}
+
+// callbackServer answers callbacks with the given status codes in turn and
+// records what it received
+func callbackServer(t *testing.T, codes ...int) (*httptest.Server, chan receivedCallback) {
+	received := make(chan receivedCallback, 10)
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedCallback{header: r.Header.Clone(), body: body}
+		code := http.StatusNoContent
+		if calls < len(codes) {
+			code = codes[calls]
+		}
+		calls++
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, received
+}
+
+func TestValidateCallbackURL(t *testing.T) {
+	assert.NoError(t, ValidateCallbackURL(""))
+	assert.NoError(t, ValidateCallbackURL("https://hooks.example.com/commands?token=1"))
+	assert.NoError(t, ValidateCallbackURL("http://localhost:9000/done"))
+
+	for _, raw := range []string{"ftp://example.com/x", "/relative/path", "https://", "http://%zz"} {
+		assert.ErrorIs(t, ValidateCallbackURL(raw), ErrInvalidCallbackURL, raw)
+	}
+}
+
+func TestCallbackSenderSignsPayload(t *testing.T) {
+	srv, received := callbackServer(t)
+	sender := NewCallbackSender(CallbackConfig{Secret: "s3cret", AllowPrivateNetworks: true}, zap.NewNop())
+
+	cmd := NewCommand(CommandTypeEmailSend, map[string]interface{}{})
+	cmd.Status = StatusFailed
+	cmd.CallbackURL = srv.URL
+	cmd.SetError("HANDLER_ERROR", "smtp unavailable", "")
+
+	require.NoError(t, sender.Send(context.Background(), cmd))
+
+	got := <-received
+	assert.Equal(t, cmd.ID, got.header.Get(CallbackCommandIDHeader))
+	assert.Equal(t, "application/json", got.header.Get("Content-Type"))
+
+	timestamp, err := strconv.ParseInt(got.header.Get(CallbackTimestampHeader), 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, SignCallback("s3cret", timestamp, got.body), got.header.Get(CallbackSignatureHeader))
+	assert.NotEqual(t, SignCallback("other", timestamp, got.body), got.header.Get(CallbackSignatureHeader))
+
+	var payload CallbackPayload
+	require.NoError(t, json.Unmarshal(got.body, &payload))
+	assert.Equal(t, cmd.ID, payload.CommandID)
+	assert.Equal(t, StatusFailed, payload.Status)
+	require.NotNil(t, payload.Error)
+	assert.Equal(t, "HANDLER_ERROR", payload.Error.Code)
+}
+
+func TestCallbackSenderErrors(t *testing.T) {
+	srv, _ := callbackServer(t, http.StatusBadRequest, http.StatusServiceUnavailable)
+	sender := NewCallbackSender(CallbackConfig{Secret: "s3cret", AllowPrivateNetworks: true}, zap.NewNop())
+	cmd := NewCommand(CommandTypeEmailSend, map[string]interface{}{})
+	cmd.CallbackURL = srv.URL
+
+	err := sender.Send(context.Background(), cmd)
+	require.Error(t, err)
+	assert.False(t, IsRetryableError(err))
+
+	err = sender.Send(context.Background(), cmd)
+	require.Error(t, err)
+	assert.True(t, IsRetryableError(err))
+}
+
+func TestCallbackSenderChecksURL(t *testing.T) {
+	sender := NewCallbackSender(CallbackConfig{Secret: "s3cret"}, zap.NewNop())
+	ctx := context.Background()
+
+	assert.NoError(t, sender.CheckURL(ctx, ""))
+	assert.NoError(t, sender.CheckURL(ctx, "https://93.184.216.34/done"))
+
+	for _, raw := range []string{
+		"ftp://93.184.216.34/x",
+		"http://127.0.0.1:8080/done",
+		"http://localhost/done",
+		"http://10.0.0.5/done",
+		"http://192.168.1.1/done",
+		"http://100.64.0.1/done",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/done",
+		"http://[fe80::1]/done",
+		"http://[::ffff:127.0.0.1]/done",
+		"http://0.0.0.0/done",
+	} {
+		assert.ErrorIs(t, sender.CheckURL(ctx, raw), ErrInvalidCallbackURL, raw)
+	}
+
+	allowed := NewCallbackSender(CallbackConfig{Secret: "s3cret", AllowPrivateNetworks: true}, zap.NewNop())
+	assert.NoError(t, allowed.CheckURL(ctx, "http://127.0.0.1:8080/done"))
+}
+
+func TestCallbackSenderRefusesPrivateAddress(t *testing.T) {
+	// The URL may have been accepted while its host resolved elsewhere, the
+	// connection itself is checked too
+	srv, received := callbackServer(t)
+	sender := NewCallbackSender(CallbackConfig{Secret: "s3cret"}, zap.NewNop())
+	cmd := NewCommand(CommandTypeEmailSend, map[string]interface{}{})
+	cmd.CallbackURL = srv.URL
+
+	err := sender.Send(context.Background(), cmd)
+	require.Error(t, err)
+	assert.False(t, IsRetryableError(err))
+	assert.Empty(t, received)
+}
+
+func TestSubmitRejectsCallbackWhenDisabled(t *testing.T) {
+	s := newTestCommandService()
+
+	cmd := NewCommand(CommandTypeCacheWarmup, map[string]interface{}{})
+	cmd.CallbackURL = "https://hooks.example.com/done"
+	_, err := s.SubmitCommand(context.Background(), cmd)
+	assert.ErrorIs(t, err, ErrInvalidCallbackURL)
+
+	s.EnableCallbacks(NewCallbackSender(CallbackConfig{Secret: "s3cret"}, zap.NewNop()))
+	cmd.CallbackURL = "mailto:ops@example.com"
+	_, err = s.SubmitCommand(context.Background(), cmd)
+	assert.ErrorIs(t, err, ErrInvalidCallbackURL)
+
+	cmd.CallbackURL = "http://169.254.169.254/latest/meta-data"
+	_, err = s.SubmitCommand(context.Background(), cmd)
+	assert.ErrorIs(t, err, ErrInvalidCallbackURL)
+}
@@ -0,0 +1,261 @@
+package command
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+
+	"github.com/linkmeAman/universal-middleware/internal/auth"
+	"github.com/linkmeAman/universal-middleware/internal/events/schemas"
+)
+
+// CancelChannel is the Redis pub/sub channel carrying the IDs of running
+// commands whose handlers should be interrupted
+const CancelChannel = "cmd:cancel"
+
+var (
+	// ErrCommandNotCancellable is returned when a command has already finished
+	ErrCommandNotCancellable = errors.New("command cannot be cancelled")
+
+	// ErrCancelForbidden is returned when the caller neither submitted the
+	// command nor holds AdminScope
+	ErrCancelForbidden = errors.New("not allowed to cancel command")
+
+	// ErrCommandCancelled is the cancellation cause seen by handlers of a
+	// cancelled command and the error returned for it by Processor.Process
+	ErrCommandCancelled = errors.New("command cancelled")
+)
+
+// CancelCommand cancels a command that has not finished yet. Only the user
+// who submitted a command and callers with AdminScope may cancel it.
+//
+// Pending, scheduled and retrying commands are cancelled outright. A command
+// that is already processing keeps its status while its handler is signalled
+// through CancelChannel; the returned command then has CancelledAt set but is
+// not cancelled until the handler gives up. Cancelling an already cancelled
+// command returns it unchanged. Sensitive payload fields of the returned
+// command are redacted.
+func (s *CommandService) CancelCommand(ctx context.Context, commandID, cancelledBy, reason string) (*Command, error) {
+	cmd, err := s.cancelCommand(ctx, commandID, cancelledBy, reason)
+	return s.redact(cmd), err
+}
+
+func (s *CommandService) cancelCommand(ctx context.Context, commandID, cancelledBy, reason string) (*Command, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	cmd, err := s.lockCommand(ctx, tx, commandID)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeCancel(ctx, cmd); err != nil {
+		s.log.Warn("Command cancellation denied",
+			zap.String("command_id", cmd.ID),
+			zap.Error(err))
+		return nil, err
+	}
+
+	if cmd.Status == StatusCancelled {
+		return cmd, nil
+	}
+	if cmd.Status == StatusProcessing && cmd.CancelledAt != nil {
+		// Cancellation was requested before, repeat the signal in case the
+		// processor missed it
+		s.signalCancel(ctx, cmd.ID)
+		return cmd, nil
+	}
+	if cmd.Status.IsTerminal() {
+		return nil, fmt.Errorf("%w: command is %s", ErrCommandNotCancellable, cmd.Status)
+	}
+
+	now := time.Now()
+	previous := cmd.Status
+	inFlight := !cmd.Status.IsCancellable()
+
+	cmd.CancelledAt = &now
+	cmd.CancelledBy = cancelledBy
+	cmd.CancelReason = reason
+	cmd.UpdatedAt = now
+	if !inFlight {
+		cmd.Status = StatusCancelled
+	}
+
+	query := `
+		UPDATE commands
+		SET status = $2, cancelled_at = $3, cancelled_by = $4, cancel_reason = $5
+		WHERE id = $1
+	`
+	if _, err := tx.ExecContext(ctx, query,
+		cmd.ID, cmd.Status, cmd.CancelledAt, cmd.CancelledBy, cmd.CancelReason,
+	); err != nil {
+		return nil, fmt.Errorf("failed to update command: %w", err)
+	}
+
+	// Keep the outbox from dispatching a command that never started
+	if !inFlight {
+		if err := storeHistory(ctx, tx, cmd, previous); err != nil {
+			return nil, err
+		}
+		if err := storeCallback(ctx, tx, cmd); err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE outbox_messages SET status = 'cancelled' WHERE metadata->>'command_id' = $1 AND status IN ('pending', 'retrying')`,
+			cmd.ID,
+		); err != nil {
+			return nil, fmt.Errorf("failed to withdraw command from outbox: %w", err)
+		}
+	}
+
+	event := &schemas.CommandCancelledEvent{
+		Event:          newEvent(cmd, schemas.EventTypeCommandCancelled),
+		CommandID:      cmd.ID,
+		CommandType:    cmd.Type,
+		PreviousStatus: string(previous),
+		CancelledBy:    cancelledBy,
+		Reason:         reason,
+		InFlight:       inFlight,
+	}
+	if err := storeEvent(ctx, tx, cmd.ID, event.Event, event); err != nil {
+		return nil, fmt.Errorf("failed to store cancellation event: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if err := s.cacheCommandStatus(ctx, cmd); err != nil {
+		s.log.Warn("Failed to cache command status",
+			zap.String("command_id", cmd.ID),
+			zap.Error(err))
+	}
+
+	if inFlight {
+		s.signalCancel(ctx, cmd.ID)
+	} else {
+		s.completions.Notify(ctx, cmd)
+	}
+
+	s.log.Info("Command cancelled",
+		zap.String("command_id", cmd.ID),
+		zap.String("previous_status", string(previous)),
+		zap.String("cancelled_by", cancelledBy),
+		zap.Bool("in_flight", inFlight))
+
+	return cmd, nil
+}
+
+// authorizeCancel allows the user who submitted a command and admins to
+// cancel it
+func authorizeCancel(ctx context.Context, cmd *Command) error {
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return fmt.Errorf("%w: anonymous callers cannot cancel commands", ErrCancelForbidden)
+	}
+	for _, scope := range user.Scopes {
+		if scope == AdminScope {
+			return nil
+		}
+	}
+	if user.ID == "" || user.ID != cmd.UserID {
+		return fmt.Errorf("%w: %s did not submit command %s", ErrCancelForbidden, user.ID, cmd.ID)
+	}
+	return nil
+}
+
+// lockCommand loads a command row and locks it for the rest of the transaction
+func (s *CommandService) lockCommand(ctx context.Context, tx *sql.Tx, commandID string) (*Command, error) {
+	query := `
+		SELECT id, type, entity_id, payload, status, created_at, scheduled_for,
+			cancelled_at, cancelled_by, cancel_reason, callback_url, COALESCE(user_id, ''),
+			` + executionColumns + `
+		FROM commands
+		WHERE id = $1
+		FOR UPDATE
+	`
+
+	var cmd Command
+	var payloadJSON []byte
+	var scheduledFor, cancelledAt sql.NullTime
+	var cancelledBy, cancelReason, callbackURL sql.NullString
+	var execution executionFields
+
+	err := tx.QueryRowContext(ctx, query, commandID).Scan(append([]interface{}{
+		&cmd.ID,
+		&cmd.Type,
+		&cmd.EntityID,
+		&payloadJSON,
+		&cmd.Status,
+		&cmd.CreatedAt,
+		&scheduledFor,
+		&cancelledAt,
+		&cancelledBy,
+		&cancelReason,
+		&callbackURL,
+		&cmd.UserID,
+	}, execution.dest()...)...)
+	if err == sql.ErrNoRows {
+		return nil, ErrCommandNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load command: %w", err)
+	}
+
+	if err := json.Unmarshal(payloadJSON, &cmd.Payload); err != nil {
+		return nil, fmt.Errorf("failed to decode payload of command %s: %w", cmd.ID, err)
+	}
+	if scheduledFor.Valid {
+		cmd.ScheduledFor = &scheduledFor.Time
+	}
+	if cancelledAt.Valid {
+		cmd.CancelledAt = &cancelledAt.Time
+	}
+	cmd.CancelledBy = cancelledBy.String
+	cmd.CancelReason = cancelReason.String
+	cmd.CallbackURL = callbackURL.String
+	execution.apply(&cmd)
+
+	return &cmd, nil
+}
+
+// signalCancel asks processors to interrupt a running command
+func (s *CommandService) signalCancel(ctx context.Context, commandID string) {
+	if err := s.redisClient.Publish(ctx, CancelChannel, commandID).Err(); err != nil {
+		s.log.Warn("Failed to signal command cancellation",
+			zap.String("command_id", commandID),
+			zap.Error(err))
+	}
+}
+
+// WatchCancellations interrupts running commands announced on CancelChannel
+// until the context is cancelled
+func (p *Processor) WatchCancellations(ctx context.Context, rdb *redis.Client) {
+	sub := rdb.Subscribe(ctx, CancelChannel)
+	defer sub.Close()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			if p.Cancel(msg.Payload) {
+				p.log.Info("Cancelled running command", zap.String("command_id", msg.Payload))
+			}
+		}
+	}
+}
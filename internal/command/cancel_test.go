@@ -0,0 +1,26 @@
+package command
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/linkmeAman/universal-middleware/internal/auth"
+)
+
+func TestAuthorizeCancel(t *testing.T) {
+	cmd := NewCommand(CommandTypeEmailSend, map[string]interface{}{})
+	cmd.UserID = "user-1"
+
+	assert.NoError(t, authorizeCancel(userContext(&auth.User{ID: "user-1"}), cmd), "submitter")
+	assert.NoError(t, authorizeCancel(userContext(&auth.User{ID: "ops", Scopes: []string{AdminScope}}), cmd), "admin")
+
+	assert.ErrorIs(t, authorizeCancel(context.Background(), cmd), ErrCancelForbidden, "anonymous")
+	assert.ErrorIs(t, authorizeCancel(userContext(&auth.User{ID: "user-2"}), cmd), ErrCancelForbidden, "other user")
+
+	// Commands submitted anonymously can only be cancelled by admins
+	cmd.UserID = ""
+	assert.ErrorIs(t, authorizeCancel(userContext(&auth.User{}), cmd), ErrCancelForbidden)
+	assert.NoError(t, authorizeCancel(userContext(&auth.User{Scopes: []string{AdminScope}}), cmd))
+}
@@ -2,6 +2,7 @@ package command
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,13 +13,27 @@ type Status string
 
 const (
 	StatusPending    Status = "pending"
+	StatusScheduled  Status = "scheduled"
 	StatusProcessing Status = "processing"
 	StatusCompleted  Status = "completed"
 	StatusFailed     Status = "failed"
 	StatusRetrying   Status = "retrying"
 	StatusCancelled  Status = "cancelled"
+	// StatusDead marks a command that failed on every attempt it was allowed
+	StatusDead Status = "dead"
 )
 
+// IsTerminal reports whether the status is final and will not change anymore
+func (s Status) IsTerminal() bool {
+	return s == StatusCompleted || s == StatusFailed || s == StatusCancelled || s == StatusDead
+}
+
+// IsCancellable reports whether a command in this status can be cancelled
+// without interrupting a handler
+func (s Status) IsCancellable() bool {
+	return s == StatusPending || s == StatusScheduled || s == StatusRetrying
+}
+
 // Priority represents command processing priority
 type Priority int
 
@@ -29,10 +44,32 @@ const (
 	PriorityCritical Priority = 4
 )
 
+// String returns the lower-case name of the priority
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	case PriorityCritical:
+		return "critical"
+	default:
+		return fmt.Sprintf("priority(%d)", int(p))
+	}
+}
+
+// Valid reports whether p is one of the defined priorities
+func (p Priority) Valid() bool {
+	return p >= PriorityLow && p <= PriorityCritical
+}
+
 // Command represents a base command structure
 type Command struct {
 	ID             string                 `json:"id"`
 	Type           string                 `json:"type"`
+	SchemaVersion  string                 `json:"schemaVersion,omitempty"`
 	Priority       Priority               `json:"priority"`
 	Status         Status                 `json:"status"`
 	Payload        map[string]interface{} `json:"payload"`
@@ -49,9 +86,14 @@ type Command struct {
 	TimeoutAfter   time.Duration          `json:"timeoutAfter"`
 	CorrelationID  string                 `json:"correlationId,omitempty"`
 	UserID         string                 `json:"userId,omitempty"`
+	ClientID       string                 `json:"clientId,omitempty"`
 	IdempotencyKey string                 `json:"idempotencyKey,omitempty"`
+	CallbackURL    string                 `json:"callbackUrl,omitempty"`
 	EntityID       string                 `json:"entityId,omitempty"`
 	Error          string                 `json:"error,omitempty"`
+	CancelledAt    *time.Time             `json:"cancelledAt,omitempty"`
+	CancelledBy    string                 `json:"cancelledBy,omitempty"`
+	CancelReason   string                 `json:"cancelReason,omitempty"`
 }
 
 // ErrorDetails holds information about command failures
@@ -118,7 +160,7 @@ func (c *Command) HasTimedOut() bool {
 
 // ShouldProcess checks if the command should be processed now
 func (c *Command) ShouldProcess() bool {
-	if c.Status != StatusPending && c.Status != StatusRetrying {
+	if c.Status != StatusPending && c.Status != StatusScheduled && c.Status != StatusRetrying {
 		return false
 	}
 	if c.ScheduledFor != nil && time.Now().Before(*c.ScheduledFor) {
@@ -139,3 +181,16 @@ const (
 	CommandTypeCacheInvalidate = "cache.invalidate"
 	CommandTypeCacheWarmup     = "cache.warmup"
 )
+
+// commandTypes lists the common command types
+var commandTypes = []string{
+	CommandTypeUserCreate,
+	CommandTypeUserUpdate,
+	CommandTypeUserDelete,
+	CommandTypeEmailSend,
+	CommandTypePaymentProcess,
+	CommandTypeOrderCreate,
+	CommandTypeOrderCancel,
+	CommandTypeCacheInvalidate,
+	CommandTypeCacheWarmup,
+}
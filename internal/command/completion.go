@@ -0,0 +1,203 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+const (
+	// StatusChannelPrefix prefixes the Redis pub/sub channel on which the
+	// final state of a command is announced, followed by the command ID
+	StatusChannelPrefix = "cmd:done:"
+
+	// StatusKeyPrefix prefixes the Redis keys of the status cache, followed
+	// by the command ID
+	StatusKeyPrefix = "cmd:status:"
+
+	// StatusCacheDB is the Redis database holding the status cache
+	StatusCacheDB = 1
+
+	// MaxStatusWait bounds how long WaitForCommand blocks
+	MaxStatusWait = 60 * time.Second
+
+	// statusTTL and terminalStatusTTL are how long running and finished
+	// commands stay in the status cache
+	statusTTL         = 300 * time.Second
+	terminalStatusTTL = 3600 * time.Second
+)
+
+func statusChannel(commandID string) string {
+	return StatusChannelPrefix + commandID
+}
+
+// CacheStatus stores the current state of a command in the status cache read
+// by GetCommandStatus. Finished commands are kept longer.
+func CacheStatus(ctx context.Context, rdb *redis.Client, cmd *Command) error {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+
+	ttl := statusTTL
+	if cmd.Status.IsTerminal() {
+		ttl = terminalStatusTTL
+	}
+
+	return rdb.Set(ctx, StatusKeyPrefix+cmd.ID, data, ttl).Err()
+}
+
+// Completions announces commands that reached a terminal status on their
+// status channel and wakes the queue delivering their callbacks. Either part
+// may be nil.
+type Completions struct {
+	rdb       *redis.Client
+	callbacks *CallbackQueue
+	log       *zap.Logger
+}
+
+// NewCompletions creates a completion notifier
+func NewCompletions(rdb *redis.Client, callbacks *CallbackQueue, log *zap.Logger) *Completions {
+	return &Completions{
+		rdb:       rdb,
+		callbacks: callbacks,
+		log:       log,
+	}
+}
+
+// Notify announces a command once it reached a terminal status. It is safe to
+// call on a nil *Completions and for commands that have not finished.
+func (c *Completions) Notify(ctx context.Context, cmd *Command) {
+	if c == nil || !cmd.Status.IsTerminal() {
+		return
+	}
+
+	if c.rdb != nil {
+		data, err := json.Marshal(cmd)
+		if err == nil {
+			err = c.rdb.Publish(ctx, statusChannel(cmd.ID), data).Err()
+		}
+		if err != nil {
+			c.log.Warn("Failed to announce finished command",
+				zap.String("command_id", cmd.ID),
+				zap.Error(err))
+		}
+	}
+
+	// The callback was queued with the final status, sending it is up to
+	// the queue
+	if cmd.CallbackURL != "" {
+		c.callbacks.Notify()
+	}
+}
+
+// EnableCallbacks makes the service accept callback URLs, checking them with
+// the given sender. Callbacks are queued with the final status of their
+// command and sent by the CallbackQueue of the processors.
+func (s *CommandService) EnableCallbacks(sender *CallbackSender) {
+	s.callbacks = sender
+}
+
+// checkCallback rejects callback URLs that cannot or must not be delivered to
+func (s *CommandService) checkCallback(ctx context.Context, cmd *Command) error {
+	if cmd.CallbackURL == "" {
+		return nil
+	}
+	if s.callbacks == nil {
+		return fmt.Errorf("%w: callbacks are not enabled", ErrInvalidCallbackURL)
+	}
+	return s.callbacks.CheckURL(ctx, cmd.CallbackURL)
+}
+
+// WaitForCommand returns the command once it reached a terminal status, or
+// its current state when wait elapses first. The wait is capped at
+// MaxStatusWait and ends early when the context is done. Sensitive payload
+// fields are redacted.
+func (s *CommandService) WaitForCommand(ctx context.Context, commandID string, wait time.Duration) (*Command, error) {
+	cmd, err := s.waitForCommand(ctx, commandID, wait)
+	return s.redact(cmd), err
+}
+
+func (s *CommandService) waitForCommand(ctx context.Context, commandID string, wait time.Duration) (*Command, error) {
+	if wait > MaxStatusWait {
+		wait = MaxStatusWait
+	}
+
+	// Subscribe before reading the status so a command finishing in between
+	// is not missed
+	sub := s.redisClient.Subscribe(ctx, statusChannel(commandID))
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		return nil, fmt.Errorf("failed to subscribe to command status: %w", err)
+	}
+
+	// The cache may still hold a status from before the command finished
+	cmd, err := s.loadCommand(ctx, commandID)
+	if err != nil || cmd.Status.IsTerminal() || wait <= 0 {
+		return cmd, err
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case msg, ok := <-sub.Channel():
+		if ok {
+			var finished Command
+			if err := json.Unmarshal([]byte(msg.Payload), &finished); err == nil {
+				s.cacheStatus(ctx, &finished)
+				return &finished, nil
+			}
+		}
+	case <-timer.C:
+	case <-ctx.Done():
+		return cmd, nil
+	}
+
+	return s.loadCommand(ctx, commandID)
+}
+
+// WatchCompletions refreshes the status cache with commands announced as
+// finished until the context is cancelled
+func (s *CommandService) WatchCompletions(ctx context.Context) {
+	sub := s.redisClient.PSubscribe(ctx, StatusChannelPrefix+"*")
+	defer sub.Close()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			var cmd Command
+			if err := json.Unmarshal([]byte(msg.Payload), &cmd); err != nil {
+				s.log.Warn("Ignoring malformed completion",
+					zap.String("channel", msg.Channel),
+					zap.Error(err))
+				continue
+			}
+			if cmd.ID != strings.TrimPrefix(msg.Channel, StatusChannelPrefix) {
+				continue
+			}
+			s.cacheStatus(ctx, &cmd)
+		}
+	}
+}
+
+// cacheStatus updates the status cache, logging failures
+func (s *CommandService) cacheStatus(ctx context.Context, cmd *Command) {
+	if err := s.cacheCommandStatus(ctx, cmd); err != nil {
+		s.log.Warn("Failed to cache command status",
+			zap.String("command_id", cmd.ID),
+			zap.Error(err))
+	}
+}
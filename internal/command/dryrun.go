@@ -0,0 +1,127 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ValidateHook checks a command of one type before it is accepted, beyond
+// what its schema can express. A *ValidationError reports the offending
+// fields; any other error rejects the payload as ErrInvalidPayload.
+type ValidateHook func(ctx context.Context, cmd *Command) error
+
+// ValidatePayload returns a ValidateHook that decodes payloads into T the
+// way Register does, so submissions are checked against the Validate method
+// of a typed handler's payload before they are stored
+func ValidatePayload[T any]() ValidateHook {
+	return func(ctx context.Context, cmd *Command) error {
+		_, err := DecodePayload[T](cmd)
+		return err
+	}
+}
+
+// validateHooks holds the validate hooks of every command type
+type validateHooks struct {
+	mu     sync.RWMutex
+	byType map[string][]ValidateHook
+}
+
+func (h *validateHooks) add(cmdType string, hook ValidateHook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.byType == nil {
+		h.byType = make(map[string][]ValidateHook)
+	}
+	h.byType[cmdType] = append(h.byType[cmdType], hook)
+}
+
+// run calls the hooks of the command's type in registration order and
+// stops at the first error
+func (h *validateHooks) run(ctx context.Context, cmd *Command) error {
+	h.mu.RLock()
+	hooks := h.byType[cmd.Type]
+	h.mu.RUnlock()
+
+	for _, hook := range hooks {
+		err := hook(ctx, cmd)
+		if err == nil {
+			continue
+		}
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) || errors.Is(err, ErrInvalidPayload) {
+			return err
+		}
+		return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	return nil
+}
+
+// RegisterValidateHook adds a hook every submitted command of cmdType has
+// to pass, both when it is stored and in a dry run
+func (s *CommandService) RegisterValidateHook(cmdType string, hook ValidateHook) {
+	s.hooks.add(cmdType, hook)
+}
+
+// DryRunResult describes what submitting a command would have done
+type DryRunResult struct {
+	DryRun   bool   `json:"dry_run"`
+	Type     string `json:"type"`
+	EntityID string `json:"entity_id,omitempty"`
+	// Status is the status the command would have been accepted with, or
+	// "replayed" when its idempotency key matched an earlier submission
+	Status       string     `json:"status"`
+	ScheduledFor *time.Time `json:"scheduled_for,omitempty"`
+	// Topic is where the command would have been dispatched to
+	Topic string `json:"topic"`
+	// Replay is the response of the earlier submission that would have been
+	// returned instead of storing the command
+	Replay *CommandResult `json:"replay,omitempty"`
+}
+
+// DryRun runs every check SubmitCommand does, including the validate hooks
+// of the command type, and reports what the submission would have done.
+// Nothing is written to the commands or outbox_messages tables; rejected
+// commands fail with the same errors SubmitCommand returns.
+func (s *CommandService) DryRun(ctx context.Context, cmd *Command) (*DryRunResult, error) {
+	if err := s.checkCommand(ctx, cmd); err != nil {
+		return nil, err
+	}
+
+	result := &DryRunResult{
+		DryRun:   true,
+		Type:     cmd.Type,
+		EntityID: cmd.EntityID,
+		Status:   "accepted",
+		Topic:    CommandTopic,
+	}
+
+	if cmd.IdempotencyKey != "" {
+		existing, err := s.checkIdempotency(ctx, cmd)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			result.Status = "replayed"
+			result.Replay = existing
+			return result, nil
+		}
+	}
+
+	if cmd.ScheduledFor != nil && cmd.ScheduledFor.After(time.Now()) {
+		result.Status = string(StatusScheduled)
+		result.ScheduledFor = cmd.ScheduledFor
+	}
+
+	s.log.Debug("Command dry run passed",
+		zap.String("type", cmd.Type),
+		zap.String("entity_id", cmd.EntityID),
+		zap.String("status", result.Status))
+
+	return result, nil
+}
@@ -0,0 +1,96 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/linkmeAman/universal-middleware/internal/auth"
+)
+
+func TestDryRun(t *testing.T) {
+	s := newTestCommandService()
+	cmd := NewCommand(CommandTypeCacheWarmup, map[string]interface{}{})
+	cmd.EntityID = "cache-1"
+
+	result, err := s.DryRun(context.Background(), cmd)
+	require.NoError(t, err)
+	assert.Equal(t, &DryRunResult{
+		DryRun:   true,
+		Type:     CommandTypeCacheWarmup,
+		EntityID: "cache-1",
+		Status:   "accepted",
+		Topic:    CommandTopic,
+	}, result)
+}
+
+func TestDryRunScheduled(t *testing.T) {
+	s := newTestCommandService()
+	due := time.Now().Add(time.Hour)
+	cmd := NewCommand(CommandTypeCacheWarmup, map[string]interface{}{})
+	cmd.ScheduledFor = &due
+
+	result, err := s.DryRun(context.Background(), cmd)
+	require.NoError(t, err)
+	assert.Equal(t, string(StatusScheduled), result.Status)
+	assert.Equal(t, &due, result.ScheduledFor)
+}
+
+func TestDryRunRejected(t *testing.T) {
+	s := newTestCommandService()
+	s.EnableAuthorization(&fakeAuthorizer{allow: false})
+
+	_, err := s.DryRun(userContext(&auth.User{ID: "user-1"}), NewCommand(CommandTypeCacheWarmup, map[string]interface{}{}))
+	assert.ErrorIs(t, err, ErrCommandForbidden)
+}
+
+func TestDryRunUnsupportedType(t *testing.T) {
+	s := newTestCommandService()
+	s.AcceptTypes(CommandTypeCacheWarmup)
+
+	_, err := s.DryRun(context.Background(), NewCommand(CommandTypeCacheWarmup, map[string]interface{}{}))
+	require.NoError(t, err)
+
+	_, err = s.DryRun(context.Background(), NewCommand(CommandTypeEmailSend, map[string]interface{}{"to": "a@example.com", "subject": "hi"}))
+	assert.ErrorIs(t, err, ErrUnsupportedCommandType)
+}
+
+func TestValidateHooks(t *testing.T) {
+	s := newTestCommandService()
+	s.RegisterValidateHook(commandTypeInvoiceSend, ValidatePayload[invoicePayload]())
+
+	_, err := s.DryRun(context.Background(), NewCommand(commandTypeInvoiceSend, map[string]interface{}{"amount": 1}))
+	require.NoError(t, err)
+
+	_, err = s.DryRun(context.Background(), NewCommand(commandTypeInvoiceSend, map[string]interface{}{"amount": -1}))
+	assert.ErrorIs(t, err, ErrInvalidPayload)
+
+	// Hooks guard real submissions too, the command is rejected before
+	// anything is stored
+	_, err = s.SubmitCommand(context.Background(), NewCommand(commandTypeInvoiceSend, map[string]interface{}{"amount": -1}))
+	assert.ErrorIs(t, err, ErrInvalidPayload)
+}
+
+func TestValidateHookErrors(t *testing.T) {
+	s := newTestCommandService()
+	s.RegisterValidateHook(CommandTypeCacheWarmup, func(ctx context.Context, cmd *Command) error {
+		if cmd.EntityID == "" {
+			return &ValidationError{Fields: []FieldError{{Field: "/entity_id", Message: "is required"}}}
+		}
+		return errors.New("cache is read only")
+	})
+
+	_, err := s.DryRun(context.Background(), NewCommand(CommandTypeCacheWarmup, map[string]interface{}{}))
+	var validationErr *ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+
+	cmd := NewCommand(CommandTypeCacheWarmup, map[string]interface{}{})
+	cmd.EntityID = "cache-1"
+	_, err = s.DryRun(context.Background(), cmd)
+	assert.ErrorIs(t, err, ErrInvalidPayload)
+	assert.Contains(t, err.Error(), "cache is read only")
+}
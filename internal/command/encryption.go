@@ -0,0 +1,407 @@
+package command
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// ErrDecryptionFailed is returned for sealed payload fields that cannot be
+// opened, because they were tampered with or moved to another command
+var ErrDecryptionFailed = errors.New("failed to decrypt payload field")
+
+// Redacted replaces sensitive payload values in responses and logs
+const Redacted = "[REDACTED]"
+
+// sealedKey is the only key of the object a sealed field is replaced with:
+//
+//	{"$encrypted": {"kid": "<key ID>", "key": "<wrapped data key>", "data": "<ciphertext>"}}
+//
+// The ciphertext is the JSON encoding of the original value, so fields of
+// any type can be sealed.
+const sealedKey = "$encrypted"
+
+// SensitiveFields declares the payload fields of each command type, as JSON
+// Pointers, that are encrypted at rest and redacted from responses
+type SensitiveFields struct {
+	mu     sync.RWMutex
+	byType map[string][]string
+}
+
+// NewSensitiveFields creates an empty set of sensitive fields
+func NewSensitiveFields() *SensitiveFields {
+	return &SensitiveFields{byType: make(map[string][]string)}
+}
+
+// DefaultSensitiveFields returns the sensitive fields of the built-in
+// command types
+func DefaultSensitiveFields() *SensitiveFields {
+	f := NewSensitiveFields()
+	f.Add(CommandTypeUserCreate, "/password")
+	return f
+}
+
+// NewSensitiveFieldsFromConfig returns the sensitive fields of the built-in
+// command types together with the configured ones
+func NewSensitiveFieldsFromConfig(fields map[string][]string) *SensitiveFields {
+	f := DefaultSensitiveFields()
+	for cmdType, pointers := range fields {
+		f.Add(cmdType, pointers...)
+	}
+	return f
+}
+
+// Add declares fields of a command type sensitive
+func (f *SensitiveFields) Add(cmdType string, pointers ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.byType[cmdType] = append(f.byType[cmdType], pointers...)
+}
+
+// Fields returns the sensitive fields of a command type
+func (f *SensitiveFields) Fields(cmdType string) []string {
+	if f == nil {
+		return nil
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.byType[cmdType]
+}
+
+// Redact returns a copy of the payload of a command with its sensitive
+// fields, and any sealed value, replaced by Redacted
+func (f *SensitiveFields) Redact(cmd *Command) map[string]interface{} {
+	if cmd.Payload == nil {
+		return nil
+	}
+
+	payload := redactSealed(copyJSON(cmd.Payload)).(map[string]interface{})
+	for _, pointer := range f.Fields(cmd.Type) {
+		if container, token, ok := resolvePointer(payload, pointer); ok {
+			setMember(container, token, Redacted)
+		}
+	}
+	return payload
+}
+
+// LogField logs the redacted payload of a command
+func (f *SensitiveFields) LogField(cmd *Command) zap.Field {
+	return zap.Any("payload", f.Redact(cmd))
+}
+
+// SensitiveFields returns the payload fields the service encrypts and
+// redacts, per command type
+func (s *CommandService) SensitiveFields() *SensitiveFields {
+	return s.sensitive
+}
+
+// EnableEncryption makes the service seal the sensitive payload fields of
+// every command it stores with data keys wrapped by keys. Without it
+// sensitive fields are only redacted from responses.
+func (s *CommandService) EnableEncryption(keys KeyProvider) {
+	s.encryptor = NewFieldEncryptor(keys, s.sensitive)
+}
+
+// redact replaces the payload of a command about to be returned with its
+// redacted copy
+func (s *CommandService) redact(cmd *Command) *Command {
+	if cmd != nil {
+		cmd.Payload = s.sensitive.Redact(cmd)
+	}
+	return cmd
+}
+
+// FieldEncryptor seals the sensitive fields of command payloads with
+// envelope encryption: every command gets a fresh AES-256-GCM data key,
+// which is wrapped by a KeyProvider and stored with each sealed field.
+// Sealed fields are bound to their command ID and position in the payload.
+type FieldEncryptor struct {
+	fields *SensitiveFields
+	keys   KeyProvider
+}
+
+// NewFieldEncryptor creates an encryptor sealing fields with data keys
+// wrapped by keys
+func NewFieldEncryptor(keys KeyProvider, fields *SensitiveFields) *FieldEncryptor {
+	return &FieldEncryptor{fields: fields, keys: keys}
+}
+
+// Fields returns the sensitive fields the encryptor seals
+func (e *FieldEncryptor) Fields() *SensitiveFields {
+	return e.fields
+}
+
+// Encrypt replaces the plain values of the sensitive fields of a command's
+// payload with sealed ones. Missing and already sealed fields are left
+// alone, so encrypting twice is harmless.
+func (e *FieldEncryptor) Encrypt(ctx context.Context, cmd *Command) error {
+	type target struct {
+		container interface{}
+		token     string
+		pointer   string
+	}
+
+	var targets []target
+	for _, pointer := range e.fields.Fields(cmd.Type) {
+		container, token, ok := resolvePointer(cmd.Payload, pointer)
+		if !ok {
+			continue
+		}
+		if _, sealed := sealedValue(member(container, token)); sealed {
+			continue
+		}
+		targets = append(targets, target{container, token, canonicalPointer(pointer)})
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return fmt.Errorf("failed to generate data key: %w", err)
+	}
+	keyID, wrapped, err := e.keys.WrapKey(ctx, dataKey)
+	if err != nil {
+		return fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		plaintext, err := json.Marshal(member(t.container, t.token))
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", t.pointer, err)
+		}
+		data, err := seal(aead, plaintext, fieldBinding(cmd.ID, t.pointer))
+		if err != nil {
+			return err
+		}
+		setMember(t.container, t.token, map[string]interface{}{
+			sealedKey: map[string]interface{}{
+				"kid":  keyID,
+				"key":  base64.StdEncoding.EncodeToString(wrapped),
+				"data": base64.StdEncoding.EncodeToString(data),
+			},
+		})
+	}
+	return nil
+}
+
+// Decrypt returns a copy of the payload of a command with every sealed
+// field opened. The payload of the command itself is not changed.
+func (e *FieldEncryptor) Decrypt(ctx context.Context, cmd *Command) (map[string]interface{}, error) {
+	if cmd.Payload == nil {
+		return nil, nil
+	}
+
+	d := &decrypter{
+		keys:      e.keys,
+		commandID: cmd.ID,
+		dataKeys:  make(map[string][]byte),
+	}
+	payload, err := d.open(ctx, copyJSON(cmd.Payload), "")
+	if err != nil {
+		return nil, err
+	}
+	return payload.(map[string]interface{}), nil
+}
+
+// decrypter opens the sealed fields of one payload, unwrapping each data
+// key once
+type decrypter struct {
+	keys      KeyProvider
+	commandID string
+	dataKeys  map[string][]byte
+}
+
+func (d *decrypter) open(ctx context.Context, value interface{}, pointer string) (interface{}, error) {
+	if sealed, ok := sealedValue(value); ok {
+		return d.openField(ctx, sealed, pointer)
+	}
+
+	var err error
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, child := range v {
+			if v[name], err = d.open(ctx, child, pointer+"/"+escapePointer(name)); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			if v[i], err = d.open(ctx, child, pointer+"/"+strconv.Itoa(i)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return value, nil
+}
+
+func (d *decrypter) openField(ctx context.Context, sealed map[string]interface{}, pointer string) (interface{}, error) {
+	keyID, _ := sealed["kid"].(string)
+	encodedKey, _ := sealed["key"].(string)
+	encodedData, _ := sealed["data"].(string)
+
+	wrapped, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: invalid data key", ErrDecryptionFailed, pointer)
+	}
+	data, err := base64.StdEncoding.DecodeString(encodedData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: invalid ciphertext", ErrDecryptionFailed, pointer)
+	}
+
+	cacheKey := keyID + "/" + encodedKey
+	dataKey, ok := d.dataKeys[cacheKey]
+	if !ok {
+		if dataKey, err = d.keys.UnwrapKey(ctx, keyID, wrapped); err != nil {
+			return nil, fmt.Errorf("failed to unwrap data key of %s: %w", pointer, err)
+		}
+		d.dataKeys[cacheKey] = dataKey
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := open(aead, data, fieldBinding(d.commandID, pointer))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, pointer)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(plaintext, &value); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrDecryptionFailed, pointer, err)
+	}
+	return value, nil
+}
+
+// fieldBinding is the additional data a field is sealed with, so it cannot
+// be copied to another command or field
+func fieldBinding(commandID, pointer string) []byte {
+	return []byte(commandID + "\x00" + pointer)
+}
+
+// sealedValue returns the envelope of a sealed field
+func sealedValue(value interface{}) (map[string]interface{}, bool) {
+	m, ok := value.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return nil, false
+	}
+	sealed, ok := m[sealedKey].(map[string]interface{})
+	return sealed, ok
+}
+
+// redactSealed replaces every sealed value below value by Redacted
+func redactSealed(value interface{}) interface{} {
+	if _, ok := sealedValue(value); ok {
+		return Redacted
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, child := range v {
+			v[name] = redactSealed(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactSealed(child)
+		}
+	}
+	return value
+}
+
+// copyJSON deep copies decoded JSON
+func copyJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for name, child := range v {
+			c[name] = copyJSON(child)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, child := range v {
+			c[i] = copyJSON(child)
+		}
+		return c
+	default:
+		return value
+	}
+}
+
+// resolvePointer returns the object or array holding the value a JSON
+// Pointer points at, and the last token of the pointer. ok is false when
+// the value does not exist.
+func resolvePointer(root map[string]interface{}, pointer string) (container interface{}, token string, ok bool) {
+	if root == nil || !strings.HasPrefix(pointer, "/") {
+		return nil, "", false
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(tokens[i])
+	}
+
+	container = root
+	for i, token := range tokens {
+		value := member(container, token)
+		if value == nil {
+			return nil, "", false
+		}
+		if i == len(tokens)-1 {
+			return container, token, true
+		}
+		container = value
+	}
+	return nil, "", false
+}
+
+// canonicalPointer normalises the escaping of a JSON Pointer to the form
+// Decrypt reconstructs while walking a payload
+func canonicalPointer(pointer string) string {
+	tokens := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteString("/")
+		b.WriteString(escapePointer(strings.NewReplacer("~1", "/", "~0", "~").Replace(token)))
+	}
+	return b.String()
+}
+
+// member returns the member of an object or element of an array
+func member(container interface{}, token string) interface{} {
+	switch c := container.(type) {
+	case map[string]interface{}:
+		return c[token]
+	case []interface{}:
+		i, err := strconv.Atoi(token)
+		if err != nil || i < 0 || i >= len(c) {
+			return nil
+		}
+		return c[i]
+	}
+	return nil
+}
+
+// setMember replaces the member of an object or element of an array
+func setMember(container interface{}, token string, value interface{}) {
+	switch c := container.(type) {
+	case map[string]interface{}:
+		c[token] = value
+	case []interface{}:
+		if i, err := strconv.Atoi(token); err == nil && i >= 0 && i < len(c) {
+			c[i] = value
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKeyProvider(t *testing.T, primary string, ids ...string) *FileKeyProvider {
+	keys := make(map[string][]byte)
+	for _, id := range append(ids, primary) {
+		keys[id] = bytes.Repeat([]byte(id[:1]), 32)
+	}
+	p, err := NewStaticKeyProvider(primary, keys)
+	require.NoError(t, err)
+	return p
+}
+
+func newUserCreate() *Command {
+	return NewCommand(CommandTypeUserCreate, map[string]interface{}{
+		"email":    "a@example.com",
+		"username": "alice",
+		"password": "correct horse",
+	})
+}
+
+func TestFieldEncryptorRoundTrip(t *testing.T) {
+	enc := NewFieldEncryptor(testKeyProvider(t, "k1"), DefaultSensitiveFields())
+	cmd := newUserCreate()
+
+	require.NoError(t, enc.Encrypt(context.Background(), cmd))
+	_, sealed := sealedValue(cmd.Payload["password"])
+	assert.True(t, sealed)
+	assert.Equal(t, "a@example.com", cmd.Payload["email"])
+
+	stored, err := json.Marshal(cmd)
+	require.NoError(t, err)
+	assert.NotContains(t, string(stored), "correct horse")
+
+	// Sealed fields survive being stored and read back
+	var loaded Command
+	require.NoError(t, json.Unmarshal(stored, &loaded))
+	payload, err := enc.Decrypt(context.Background(), &loaded)
+	require.NoError(t, err)
+	assert.Equal(t, "correct horse", payload["password"])
+	_, sealed = sealedValue(loaded.Payload["password"])
+	assert.True(t, sealed, "Decrypt must not change the command")
+
+	// Encrypting again leaves sealed fields alone
+	before := cmd.Payload["password"]
+	require.NoError(t, enc.Encrypt(context.Background(), cmd))
+	assert.Equal(t, before, cmd.Payload["password"])
+}
+
+func TestFieldEncryptorNestedFields(t *testing.T) {
+	fields := NewSensitiveFields()
+	fields.Add("card.store", "/card/number", "/codes/1")
+	enc := NewFieldEncryptor(testKeyProvider(t, "k1"), fields)
+
+	cmd := NewCommand("card.store", map[string]interface{}{
+		"card":  map[string]interface{}{"number": "4111111111111111", "expiry": "12/30"},
+		"codes": []interface{}{"a", float64(42)},
+	})
+	require.NoError(t, enc.Encrypt(context.Background(), cmd))
+
+	payload, err := enc.Decrypt(context.Background(), cmd)
+	require.NoError(t, err)
+	assert.Equal(t, "4111111111111111", payload["card"].(map[string]interface{})["number"])
+	assert.Equal(t, []interface{}{"a", float64(42)}, payload["codes"])
+}
+
+func TestFieldEncryptorRejectsMovedFields(t *testing.T) {
+	enc := NewFieldEncryptor(testKeyProvider(t, "k1"), DefaultSensitiveFields())
+	cmd := newUserCreate()
+	require.NoError(t, enc.Encrypt(context.Background(), cmd))
+
+	other := newUserCreate()
+	other.Payload["password"] = cmd.Payload["password"]
+	_, err := enc.Decrypt(context.Background(), other)
+	assert.ErrorIs(t, err, ErrDecryptionFailed)
+}
+
+func TestFieldEncryptorKeyRotation(t *testing.T) {
+	cmd := newUserCreate()
+	require.NoError(t, NewFieldEncryptor(testKeyProvider(t, "k1"), DefaultSensitiveFields()).Encrypt(context.Background(), cmd))
+
+	rotated := NewFieldEncryptor(testKeyProvider(t, "k2", "k1"), DefaultSensitiveFields())
+	payload, err := rotated.Decrypt(context.Background(), cmd)
+	require.NoError(t, err)
+	assert.Equal(t, "correct horse", payload["password"])
+
+	retired := NewFieldEncryptor(testKeyProvider(t, "k2"), DefaultSensitiveFields())
+	_, err = retired.Decrypt(context.Background(), cmd)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestNewFileKeyProvider(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"primary": "2025-01", "keys": {"2025-01": "`+key+`"}}`), 0o600))
+
+	p, err := NewFileKeyProvider(path)
+	require.NoError(t, err)
+	id, wrapped, err := p.WrapKey(context.Background(), []byte("data key"))
+	require.NoError(t, err)
+	assert.Equal(t, "2025-01", id)
+
+	dataKey, err := p.UnwrapKey(context.Background(), id, wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data key"), dataKey)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"primary": "2025-02", "keys": {"2025-01": "`+key+`"}}`), 0o600))
+	_, err = NewFileKeyProvider(path)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestRedact(t *testing.T) {
+	fields := DefaultSensitiveFields()
+
+	plain := newUserCreate()
+	redacted := fields.Redact(plain)
+	assert.Equal(t, Redacted, redacted["password"])
+	assert.Equal(t, "alice", redacted["username"])
+	assert.Equal(t, "correct horse", plain.Payload["password"])
+
+	// Sealed values are redacted even where they are not declared
+	sealed := NewCommand("card.store", map[string]interface{}{
+		"number": map[string]interface{}{sealedKey: map[string]interface{}{"kid": "k1"}},
+	})
+	assert.Equal(t, map[string]interface{}{"number": Redacted}, fields.Redact(sealed))
+}
+
+func TestProcessorDecryptsForHandlers(t *testing.T) {
+	enc := NewFieldEncryptor(testKeyProvider(t, "k1"), DefaultSensitiveFields())
+	p := newTestProcessor(t, ProcessorConfig{MaxWorkers: 1, Encryptor: enc})
+
+	var seen string
+	RegisterFunc(p, CommandTypeUserCreate, func(ctx context.Context, cmd *Command, payload struct {
+		Password string `json:"password"`
+	}) error {
+		seen = payload.Password
+		return nil
+	})
+
+	cmd := newUserCreate()
+	require.NoError(t, p.Process(context.Background(), cmd))
+	assert.Equal(t, "correct horse", seen)
+	_, sealed := sealedValue(cmd.Payload["password"])
+	assert.True(t, sealed)
+}
@@ -0,0 +1,169 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+const (
+	// EntityLockPrefix prefixes the Redis keys of entity locks, followed by
+	// the entity ID
+	EntityLockPrefix = "cmd:entity-lock:"
+
+	defaultEntityLockTTL   = 30 * time.Second
+	entityLockPollInterval = 50 * time.Millisecond
+)
+
+// ErrEntityLockLost is the cancellation cause seen by handlers whose
+// processor could no longer renew the lock on the command's entity
+var ErrEntityLockLost = errors.New("entity lock lost")
+
+// EntityLocker serializes the commands of an entity across processors. A
+// lock that is not extended in time expires, so a crashed processor cannot
+// block an entity for good.
+type EntityLocker interface {
+	// Lock blocks until the entity is locked for owner or the context is done
+	Lock(ctx context.Context, entityID, owner string, ttl time.Duration) error
+	// Extend renews a lock held by owner or returns ErrEntityLockLost
+	Extend(ctx context.Context, entityID, owner string, ttl time.Duration) error
+	// Unlock releases a lock held by owner
+	Unlock(ctx context.Context, entityID, owner string) error
+}
+
+// Only the owner may extend or release a lock
+var (
+	extendEntityLockScript = redis.NewScript(`
+		if redis.call("GET", KEYS[1]) == ARGV[1] then
+			return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+		end
+		return 0`)
+
+	unlockEntityLockScript = redis.NewScript(`
+		if redis.call("GET", KEYS[1]) == ARGV[1] then
+			return redis.call("DEL", KEYS[1])
+		end
+		return 0`)
+)
+
+// RedisEntityLocker implements EntityLocker with expiring Redis keys
+type RedisEntityLocker struct {
+	rdb *redis.Client
+}
+
+// NewRedisEntityLocker creates an entity locker on the given Redis client
+func NewRedisEntityLocker(rdb *redis.Client) *RedisEntityLocker {
+	return &RedisEntityLocker{rdb: rdb}
+}
+
+// Lock polls until the lock key can be created
+func (l *RedisEntityLocker) Lock(ctx context.Context, entityID, owner string, ttl time.Duration) error {
+	ticker := time.NewTicker(entityLockPollInterval)
+	defer ticker.Stop()
+
+	for {
+		ok, err := l.rdb.SetNX(ctx, EntityLockPrefix+entityID, owner, ttl).Result()
+		if err != nil && ctx.Err() == nil {
+			return fmt.Errorf("failed to lock entity %s: %w", entityID, err)
+		}
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-ticker.C:
+		}
+	}
+}
+
+// Extend resets the expiry of a lock still held by owner
+func (l *RedisEntityLocker) Extend(ctx context.Context, entityID, owner string, ttl time.Duration) error {
+	extended, err := extendEntityLockScript.Run(ctx, l.rdb,
+		[]string{EntityLockPrefix + entityID}, owner, ttl.Milliseconds(),
+	).Int()
+	if err != nil {
+		return fmt.Errorf("failed to extend lock on entity %s: %w", entityID, err)
+	}
+	if extended == 0 {
+		return ErrEntityLockLost
+	}
+	return nil
+}
+
+// Unlock deletes the lock key if owner still holds it
+func (l *RedisEntityLocker) Unlock(ctx context.Context, entityID, owner string) error {
+	if err := unlockEntityLockScript.Run(ctx, l.rdb,
+		[]string{EntityLockPrefix + entityID}, owner,
+	).Err(); err != nil {
+		return fmt.Errorf("failed to unlock entity %s: %w", entityID, err)
+	}
+	return nil
+}
+
+// lockEntity takes the distributed lock on the command's entity and keeps
+// extending it until the returned unlock function is called. If the lock is
+// lost the returned context is cancelled with ErrEntityLockLost.
+func (p *Processor) lockEntity(ctx context.Context, cmd *Command) (context.Context, func(), error) {
+	owner := p.workerID + "/" + cmd.ID
+	if err := p.entityLocks.Lock(ctx, cmd.EntityID, owner, p.entityLockTTL); err != nil {
+		return ctx, nil, err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(p.entityLockTTL / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := p.entityLocks.Extend(ctx, cmd.EntityID, owner, p.entityLockTTL)
+			if errors.Is(err, ErrEntityLockLost) {
+				p.log.Warn("Entity lock lost",
+					zap.String("command_id", cmd.ID),
+					zap.String("entity_id", cmd.EntityID),
+				)
+				cancel(ErrEntityLockLost)
+				return
+			}
+			if err != nil {
+				p.log.Warn("Failed to extend entity lock",
+					zap.String("entity_id", cmd.EntityID),
+					zap.Error(err),
+				)
+			}
+		}
+	}()
+
+	return ctx, func() {
+		close(done)
+		<-stopped
+		cancel(nil)
+
+		unlockCtx, cancelUnlock := context.WithTimeout(context.WithoutCancel(ctx), releaseTimeout)
+		defer cancelUnlock()
+		if err := p.entityLocks.Unlock(unlockCtx, cmd.EntityID, owner); err != nil {
+			p.log.Warn("Failed to unlock entity",
+				zap.String("entity_id", cmd.EntityID),
+				zap.Error(err),
+			)
+		}
+	}, nil
+}
@@ -0,0 +1,206 @@
+package command
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatchQueueOrdersEntity(t *testing.T) {
+	q := newDispatchQueue(10, nil, time.Hour)
+	now := time.Now()
+
+	first := queueItemFor(PriorityNormal, now)
+	first.entity = "user-1"
+	urgent := queueItemFor(PriorityCritical, now)
+	urgent.entity = "user-1"
+	other := queueItemFor(PriorityLow, now)
+	other.entity = "user-2"
+	for _, item := range []*queueItem{first, urgent, other} {
+		require.NoError(t, q.push(item))
+	}
+
+	// The critical command waits for the earlier command of its entity
+	popped, err := q.pop(context.Background())
+	require.NoError(t, err)
+	assert.Same(t, first, popped)
+
+	popped, err = q.pop(context.Background())
+	require.NoError(t, err)
+	assert.Same(t, other, popped)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = q.pop(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	q.finish(first)
+	popped, err = q.pop(context.Background())
+	require.NoError(t, err)
+	assert.Same(t, urgent, popped)
+
+	q.finish(urgent)
+	q.finish(other)
+	assert.Empty(t, q.entities)
+}
+
+func TestDispatchQueueRemoveReleasesEntity(t *testing.T) {
+	q := newDispatchQueue(10, nil, time.Hour)
+	first := queueItemFor(PriorityNormal, time.Now())
+	first.entity = "user-1"
+	second := queueItemFor(PriorityNormal, time.Now())
+	second.entity = "user-1"
+	require.NoError(t, q.push(first))
+	require.NoError(t, q.push(second))
+
+	assert.True(t, q.remove(first))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	popped, err := q.pop(ctx)
+	require.NoError(t, err)
+	assert.Same(t, second, popped)
+}
+
+// entityHandler records the order commands run in and how many run at once
+type entityHandler struct {
+	mu      sync.Mutex
+	running map[string]int
+	overlap map[string]bool
+	order   []string
+	active  int
+	peak    int
+}
+
+func (h *entityHandler) HandleCommand(ctx context.Context, cmd *Command) error {
+	h.mu.Lock()
+	h.running[cmd.EntityID]++
+	if h.running[cmd.EntityID] > 1 {
+		h.overlap[cmd.EntityID] = true
+	}
+	h.active++
+	if h.active > h.peak {
+		h.peak = h.active
+	}
+	h.order = append(h.order, cmd.Payload["seq"].(string))
+	h.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	h.mu.Lock()
+	h.running[cmd.EntityID]--
+	h.active--
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *entityHandler) CanHandle(cmdType string) bool {
+	return true
+}
+
+func TestProcessorOrdersByEntity(t *testing.T) {
+	p := newTestProcessor(t, ProcessorConfig{MaxWorkers: 4, OrderByEntity: true})
+	handler := &entityHandler{running: map[string]int{}, overlap: map[string]bool{}}
+	p.handlers[CommandTypeUserUpdate] = []Handler{handler}
+
+	var wg sync.WaitGroup
+	submit := func(entity, seq string) {
+		cmd := NewCommand(CommandTypeUserUpdate, map[string]interface{}{"seq": seq})
+		cmd.EntityID = entity
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, p.Process(context.Background(), cmd))
+		}()
+		// Give the command time to be queued before the next one
+		time.Sleep(2 * time.Millisecond)
+	}
+	for _, seq := range []string{"a1", "b1", "a2", "b2", "a3"} {
+		submit("user-"+seq[:1], seq)
+	}
+	wg.Wait()
+
+	assert.Empty(t, handler.overlap)
+	assert.GreaterOrEqual(t, handler.peak, 2)
+
+	var a []string
+	for _, seq := range handler.order {
+		if seq[0] == 'a' {
+			a = append(a, seq)
+		}
+	}
+	assert.Equal(t, []string{"a1", "a2", "a3"}, a)
+}
+
+type memEntityLocker struct {
+	mu        sync.Mutex
+	locks     map[string]string
+	history   []string
+	extendErr error
+}
+
+func (l *memEntityLocker) Lock(ctx context.Context, entityID, owner string, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, held := l.locks[entityID]; held {
+		return context.DeadlineExceeded
+	}
+	l.locks[entityID] = owner
+	l.history = append(l.history, "lock "+entityID)
+	return nil
+}
+
+func (l *memEntityLocker) Extend(ctx context.Context, entityID, owner string, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.extendErr
+}
+
+func (l *memEntityLocker) Unlock(ctx context.Context, entityID, owner string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locks[entityID] == owner {
+		delete(l.locks, entityID)
+		l.history = append(l.history, "unlock "+entityID)
+	}
+	return nil
+}
+
+func TestProcessorLocksEntity(t *testing.T) {
+	locks := &memEntityLocker{locks: map[string]string{}}
+	p := newTestProcessor(t, ProcessorConfig{MaxWorkers: 1, EntityLocks: locks})
+	p.handlers[CommandTypeCacheWarmup] = []Handler{&recordingHandler{}}
+
+	cmd := NewCommand(CommandTypeCacheWarmup, map[string]interface{}{})
+	cmd.EntityID = "cache-1"
+	require.NoError(t, p.Process(context.Background(), cmd))
+
+	// Commands without an entity are not locked
+	require.NoError(t, p.Process(context.Background(), NewCommand(CommandTypeCacheWarmup, map[string]interface{}{})))
+
+	assert.Equal(t, []string{"lock cache-1", "unlock cache-1"}, locks.history)
+}
+
+func TestProcessorRetriesWhenEntityLockIsLost(t *testing.T) {
+	locks := &memEntityLocker{locks: map[string]string{}, extendErr: ErrEntityLockLost}
+	p := newTestProcessor(t, ProcessorConfig{
+		MaxWorkers:    1,
+		EntityLocks:   locks,
+		EntityLockTTL: 30 * time.Millisecond,
+	})
+	p.handlers[CommandTypeCacheWarmup] = []Handler{&blockingHandler{started: make(chan struct{})}}
+
+	cmd := NewCommand(CommandTypeCacheWarmup, map[string]interface{}{})
+	cmd.EntityID = "cache-1"
+	cmd.TimeoutAfter = time.Minute
+	cmd.MaxRetries = 1
+
+	assert.ErrorIs(t, p.Process(context.Background(), cmd), ErrEntityLockLost)
+	assert.Equal(t, StatusRetrying, cmd.Status)
+	require.NotNil(t, cmd.ErrorDetails)
+	assert.Equal(t, "ENTITY_LOCK_LOST", cmd.ErrorDetails.Code)
+}
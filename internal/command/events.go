@@ -0,0 +1,171 @@
+package command
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/linkmeAman/universal-middleware/internal/events/schemas"
+)
+
+// LifecycleTopic is the topic command lifecycle events are published to
+const LifecycleTopic = "command.events"
+
+// insertEventQuery queues a lifecycle event in the outbox
+const insertEventQuery = `
+	INSERT INTO outbox_messages (
+		id, aggregate_type, aggregate_id, event_type,
+		payload, topic, status, created_at, retry_count
+	) VALUES ($1, 'command', $2, $3, $4, $5, 'pending', $6, 0)
+`
+
+// newEvent returns the envelope for a lifecycle event about a command
+func newEvent(cmd *Command, eventType schemas.EventType) schemas.Event {
+	return schemas.Event{
+		ID:            uuid.New().String(),
+		Type:          eventType,
+		Source:        "command-service",
+		DataVersion:   "1.0",
+		Time:          time.Now().UTC(),
+		CorrelationID: cmd.CorrelationID,
+		CausationID:   cmd.ID,
+	}
+}
+
+// lifecycleEvent returns the event announcing that a command moved from
+// previous to its current status. An empty previous status means the command
+// has just been submitted.
+func lifecycleEvent(cmd *Command, previous Status, workerID string) (schemas.Event, interface{}) {
+	switch {
+	case previous == "":
+		event := &schemas.CommandReceivedEvent{
+			Event:       newEvent(cmd, schemas.EventTypeCommandReceived),
+			CommandID:   cmd.ID,
+			CommandType: cmd.Type,
+			UserID:      cmd.UserID,
+		}
+		return event.Event, event
+
+	case cmd.Status == StatusProcessing:
+		event := &schemas.CommandStartedEvent{
+			Event:       newEvent(cmd, schemas.EventTypeCommandStarted),
+			CommandID:   cmd.ID,
+			CommandType: cmd.Type,
+			WorkerID:    workerID,
+			Attempt:     cmd.RetryCount + 1,
+		}
+		return event.Event, event
+
+	case cmd.Status == StatusCompleted:
+		event := &schemas.CommandProcessedEvent{
+			Event:       newEvent(cmd, schemas.EventTypeCommandProcessed),
+			CommandID:   cmd.ID,
+			CommandType: cmd.Type,
+		}
+		if cmd.ProcessedAt != nil && cmd.CompletedAt != nil {
+			event.ProcessingMS = cmd.CompletedAt.Sub(*cmd.ProcessedAt).Milliseconds()
+		}
+		return event.Event, event
+
+	case cmd.Status == StatusFailed || cmd.Status == StatusDead || cmd.Status == StatusRetrying:
+		event := &schemas.CommandFailedEvent{
+			Event:       newEvent(cmd, schemas.EventTypeCommandFailed),
+			CommandID:   cmd.ID,
+			CommandType: cmd.Type,
+			Status:      string(cmd.Status),
+			Attempt:     cmd.RetryCount + 1,
+		}
+		if cmd.Status == StatusRetrying {
+			// The retry count already includes the attempt that failed
+			event.Attempt = cmd.RetryCount
+			event.NextAttemptAt = cmd.ScheduledFor
+		}
+		if cmd.ErrorDetails != nil {
+			event.Error = cmd.ErrorDetails.Message
+			event.ErrorCode = cmd.ErrorDetails.Code
+		}
+		return event.Event, event
+
+	case cmd.Status == StatusCancelled:
+		event := &schemas.CommandCancelledEvent{
+			Event:          newEvent(cmd, schemas.EventTypeCommandCancelled),
+			CommandID:      cmd.ID,
+			CommandType:    cmd.Type,
+			PreviousStatus: string(previous),
+			CancelledBy:    cmd.CancelledBy,
+			Reason:         cmd.CancelReason,
+		}
+		return event.Event, event
+
+	default:
+		event := &schemas.CommandStatusChangedEvent{
+			Event:          newEvent(cmd, schemas.EventTypeCommandStatusChanged),
+			CommandID:      cmd.ID,
+			CommandType:    cmd.Type,
+			PreviousStatus: string(previous),
+			Status:         string(cmd.Status),
+		}
+		return event.Event, event
+	}
+}
+
+// storeEvent saves a lifecycle event to the outbox within the given transaction
+func storeEvent(ctx context.Context, tx *sql.Tx, aggregateID string, event schemas.Event, payload interface{}) error {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, insertEventQuery,
+		event.ID, aggregateID, string(event.Type), payloadJSON, LifecycleTopic, event.Time,
+	)
+	return err
+}
+
+// storeTransition records a status change in the command history and saves
+// its lifecycle event, and the callback of a finished command, within the
+// given transaction
+func storeTransition(ctx context.Context, tx *sql.Tx, cmd *Command, previous Status) error {
+	if err := storeHistory(ctx, tx, cmd, previous); err != nil {
+		return err
+	}
+
+	event, payload := lifecycleEvent(cmd, previous, "")
+	if err := storeEvent(ctx, tx, cmd.ID, event, payload); err != nil {
+		return fmt.Errorf("failed to store %s event: %w", event.Type, err)
+	}
+	return storeCallback(ctx, tx, cmd)
+}
+
+// storeHistory appends a status change to the command history within the
+// given transaction
+func storeHistory(ctx context.Context, tx *sql.Tx, cmd *Command, previous Status) error {
+	if _, err := tx.ExecContext(ctx, insertTransitionQuery, newTransition(cmd, previous, "").args()...); err != nil {
+		return fmt.Errorf("failed to record transition: %w", err)
+	}
+	return nil
+}
+
+// insertTransition is storeTransition for the pgx backed repositories
+func insertTransition(ctx context.Context, db execer, cmd *Command, previous Status, workerID string) error {
+	if _, err := db.Exec(ctx, insertTransitionQuery, newTransition(cmd, previous, workerID).args()...); err != nil {
+		return fmt.Errorf("failed to record transition: %w", err)
+	}
+
+	event, payload := lifecycleEvent(cmd, previous, workerID)
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", event.Type, err)
+	}
+
+	if _, err := db.Exec(ctx, insertEventQuery,
+		event.ID, cmd.ID, string(event.Type), payloadJSON, LifecycleTopic, event.Time,
+	); err != nil {
+		return fmt.Errorf("failed to store %s event: %w", event.Type, err)
+	}
+	return insertCallback(ctx, db, cmd)
+}
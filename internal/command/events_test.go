@@ -0,0 +1,104 @@
+package command
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/linkmeAman/universal-middleware/internal/events/schemas"
+)
+
+func TestLifecycleEvent(t *testing.T) {
+	started := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	completed := started.Add(1500 * time.Millisecond)
+	next := started.Add(time.Minute)
+
+	newCmd := func(status Status) *Command {
+		cmd := NewCommand(CommandTypeEmailSend, map[string]interface{}{})
+		cmd.Status = status
+		cmd.CorrelationID = "corr-1"
+		cmd.UserID = "user-1"
+		return cmd
+	}
+
+	t.Run("received", func(t *testing.T) {
+		cmd := newCmd(StatusPending)
+		event, payload := lifecycleEvent(cmd, "", "")
+		assert.Equal(t, schemas.EventTypeCommandReceived, event.Type)
+		assert.Equal(t, "corr-1", event.CorrelationID)
+		assert.Equal(t, cmd.ID, event.CausationID)
+
+		received, ok := payload.(*schemas.CommandReceivedEvent)
+		require.True(t, ok)
+		assert.Equal(t, "user-1", received.UserID)
+		assert.Equal(t, event, received.Event)
+	})
+
+	t.Run("released", func(t *testing.T) {
+		_, payload := lifecycleEvent(newCmd(StatusPending), StatusScheduled, "")
+		changed, ok := payload.(*schemas.CommandStatusChangedEvent)
+		require.True(t, ok)
+		assert.Equal(t, schemas.EventTypeCommandStatusChanged, changed.Type)
+		assert.Equal(t, "scheduled", changed.PreviousStatus)
+		assert.Equal(t, "pending", changed.Status)
+	})
+
+	t.Run("started", func(t *testing.T) {
+		cmd := newCmd(StatusProcessing)
+		cmd.RetryCount = 2
+		_, payload := lifecycleEvent(cmd, StatusPending, "worker-1")
+		startedEvent, ok := payload.(*schemas.CommandStartedEvent)
+		require.True(t, ok)
+		assert.Equal(t, "worker-1", startedEvent.WorkerID)
+		assert.Equal(t, 3, startedEvent.Attempt)
+	})
+
+	t.Run("completed", func(t *testing.T) {
+		cmd := newCmd(StatusCompleted)
+		cmd.ProcessedAt = &started
+		cmd.CompletedAt = &completed
+		_, payload := lifecycleEvent(cmd, StatusProcessing, "worker-1")
+		processed, ok := payload.(*schemas.CommandProcessedEvent)
+		require.True(t, ok)
+		assert.Equal(t, schemas.EventTypeCommandProcessed, processed.Type)
+		assert.Equal(t, int64(1500), processed.ProcessingMS)
+	})
+
+	t.Run("retrying", func(t *testing.T) {
+		cmd := newCmd(StatusRetrying)
+		cmd.RetryCount = 1
+		cmd.ScheduledFor = &next
+		cmd.SetError("HANDLER_ERROR", "smtp unavailable", "")
+		_, payload := lifecycleEvent(cmd, StatusProcessing, "worker-1")
+		failed, ok := payload.(*schemas.CommandFailedEvent)
+		require.True(t, ok)
+		assert.Equal(t, "retrying", failed.Status)
+		assert.Equal(t, 1, failed.Attempt)
+		assert.Equal(t, &next, failed.NextAttemptAt)
+		assert.Equal(t, "HANDLER_ERROR", failed.ErrorCode)
+		assert.Equal(t, "smtp unavailable", failed.Error)
+	})
+
+	t.Run("dead", func(t *testing.T) {
+		cmd := newCmd(StatusDead)
+		cmd.RetryCount = 3
+		_, payload := lifecycleEvent(cmd, StatusProcessing, "worker-1")
+		failed, ok := payload.(*schemas.CommandFailedEvent)
+		require.True(t, ok)
+		assert.Equal(t, "dead", failed.Status)
+		assert.Equal(t, 4, failed.Attempt)
+		assert.Nil(t, failed.NextAttemptAt)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		cmd := newCmd(StatusCancelled)
+		cmd.CancelledBy = "admin"
+		_, payload := lifecycleEvent(cmd, StatusScheduled, "")
+		cancelled, ok := payload.(*schemas.CommandCancelledEvent)
+		require.True(t, ok)
+		assert.Equal(t, "scheduled", cancelled.PreviousStatus)
+		assert.Equal(t, "admin", cancelled.CancelledBy)
+	})
+}
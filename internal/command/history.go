@@ -0,0 +1,112 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Transition is one status change in the history of a command
+type Transition struct {
+	CommandID  string    `json:"commandId"`
+	FromStatus Status    `json:"fromStatus,omitempty"`
+	ToStatus   Status    `json:"toStatus"`
+	WorkerID   string    `json:"workerId,omitempty"`
+	Attempt    int       `json:"attempt,omitempty"`
+	ErrorCode  string    `json:"errorCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// insertTransitionQuery appends a status change to the command history
+const insertTransitionQuery = `
+	INSERT INTO command_transitions (
+		command_id, from_status, to_status, worker_id, attempt,
+		error_code, error, created_at
+	) VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, ''), NULLIF($5, 0), NULLIF($6, ''), NULLIF($7, ''), $8)
+`
+
+// newTransition returns the history entry for a command that moved from
+// previous to its current status. Only changes into or out of processing
+// belong to an attempt.
+func newTransition(cmd *Command, previous Status, workerID string) *Transition {
+	t := &Transition{
+		CommandID:  cmd.ID,
+		FromStatus: previous,
+		ToStatus:   cmd.Status,
+		WorkerID:   workerID,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	switch {
+	case cmd.Status == StatusRetrying && previous == StatusProcessing:
+		// The retry count already includes the attempt that failed
+		t.Attempt = cmd.RetryCount
+	case cmd.Status == StatusProcessing || previous == StatusProcessing:
+		t.Attempt = cmd.RetryCount + 1
+	}
+
+	if cmd.ErrorDetails != nil {
+		t.ErrorCode = cmd.ErrorDetails.Code
+		t.Error = cmd.ErrorDetails.Message
+	}
+
+	return t
+}
+
+// args returns the arguments of insertTransitionQuery
+func (t *Transition) args() []interface{} {
+	return []interface{}{
+		t.CommandID, string(t.FromStatus), t.ToStatus, t.WorkerID, t.Attempt,
+		t.ErrorCode, t.Error, t.CreatedAt,
+	}
+}
+
+// History returns the status changes of a command, oldest first
+func (s *CommandService) History(ctx context.Context, commandID string) ([]*Transition, error) {
+	query := `
+		SELECT command_id, COALESCE(from_status, ''), to_status, COALESCE(worker_id, ''),
+			COALESCE(attempt, 0), COALESCE(error_code, ''), COALESCE(error, ''), created_at
+		FROM command_transitions
+		WHERE command_id = $1
+		ORDER BY id ASC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, commandID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query command history: %w", err)
+	}
+	defer rows.Close()
+
+	var history []*Transition
+	for rows.Next() {
+		t := &Transition{}
+		if err := rows.Scan(
+			&t.CommandID, &t.FromStatus, &t.ToStatus, &t.WorkerID,
+			&t.Attempt, &t.ErrorCode, &t.Error, &t.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan transition: %w", err)
+		}
+		history = append(history, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating command history: %w", err)
+	}
+
+	if len(history) == 0 {
+		// Commands stored before the history was recorded have none
+		var exists bool
+		err := s.db.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM commands WHERE id = $1)`, commandID,
+		).Scan(&exists)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load command: %w", err)
+		}
+		if !exists {
+			return nil, ErrCommandNotFound
+		}
+	}
+
+	return history, nil
+}
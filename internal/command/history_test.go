@@ -0,0 +1,59 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTransition(t *testing.T) {
+	newCmd := func(status Status, retryCount int) *Command {
+		cmd := NewCommand(CommandTypeEmailSend, map[string]interface{}{})
+		cmd.Status = status
+		cmd.RetryCount = retryCount
+		return cmd
+	}
+
+	t.Run("submitted", func(t *testing.T) {
+		cmd := newCmd(StatusPending, 0)
+		tr := newTransition(cmd, "", "")
+		assert.Equal(t, cmd.ID, tr.CommandID)
+		assert.Empty(t, tr.FromStatus)
+		assert.Equal(t, StatusPending, tr.ToStatus)
+		assert.Zero(t, tr.Attempt)
+		assert.False(t, tr.CreatedAt.IsZero())
+	})
+
+	t.Run("started", func(t *testing.T) {
+		tr := newTransition(newCmd(StatusProcessing, 2), StatusPending, "worker-1")
+		assert.Equal(t, "worker-1", tr.WorkerID)
+		assert.Equal(t, 3, tr.Attempt)
+	})
+
+	t.Run("retrying", func(t *testing.T) {
+		cmd := newCmd(StatusRetrying, 1)
+		cmd.ErrorDetails = &ErrorDetails{Code: "HANDLER_ERROR", Message: "smtp unavailable"}
+		tr := newTransition(cmd, StatusProcessing, "worker-1")
+		assert.Equal(t, StatusProcessing, tr.FromStatus)
+		assert.Equal(t, 1, tr.Attempt)
+		assert.Equal(t, "HANDLER_ERROR", tr.ErrorCode)
+		assert.Equal(t, "smtp unavailable", tr.Error)
+	})
+
+	t.Run("dead", func(t *testing.T) {
+		tr := newTransition(newCmd(StatusDead, 3), StatusProcessing, "worker-1")
+		assert.Equal(t, 4, tr.Attempt)
+	})
+
+	t.Run("released", func(t *testing.T) {
+		tr := newTransition(newCmd(StatusPending, 1), StatusRetrying, "")
+		assert.Zero(t, tr.Attempt)
+	})
+
+	t.Run("args", func(t *testing.T) {
+		tr := newTransition(newCmd(StatusPending, 0), "", "")
+		args := tr.args()
+		assert.Len(t, args, 8)
+		assert.Equal(t, "", args[1])
+	})
+}
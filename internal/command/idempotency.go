@@ -0,0 +1,262 @@
+package command
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const defaultIdempotencyTTL = 24 * time.Hour
+
+var (
+	// ErrIdempotencyKeyMismatch is returned when an idempotency key is reused
+	// for a request with a different type, entity or payload
+	ErrIdempotencyKeyMismatch = errors.New("idempotency key was used for a different request")
+
+	// ErrIdempotencyKeyInUse is returned when another request with the same
+	// idempotency key was stored while this one was being submitted
+	ErrIdempotencyKeyInUse = errors.New("idempotency key is in use by a concurrent request")
+)
+
+// IdempotencyConfig holds configuration for idempotency keys
+type IdempotencyConfig struct {
+	// TTL is how long a key is honoured, ClientTTLs overrides it for the
+	// commands of a client
+	TTL        time.Duration
+	ClientTTLs map[string]time.Duration
+
+	// SweepInterval is how often expired keys are purged, at most
+	// SweepBatchSize per statement
+	SweepInterval  time.Duration
+	SweepBatchSize int
+
+	// FingerprintKey keys the request hashes stored with idempotency keys.
+	// It must be shared by every replica and kept across restarts, or
+	// retries are rejected as mismatches.
+	FingerprintKey []byte
+}
+
+// DefaultIdempotencyConfig returns default idempotency configuration
+func DefaultIdempotencyConfig() IdempotencyConfig {
+	return IdempotencyConfig{
+		TTL:            defaultIdempotencyTTL,
+		SweepInterval:  10 * time.Minute,
+		SweepBatchSize: 1000,
+		FingerprintKey: randomFingerprintKey(),
+	}
+}
+
+// randomFingerprintKey returns a key only valid for the lifetime of the
+// process, for services not given one
+func randomFingerprintKey() []byte {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return key
+}
+
+// ConfigureIdempotency replaces the idempotency configuration. Missing
+// values fall back to DefaultIdempotencyConfig.
+func (s *CommandService) ConfigureIdempotency(cfg IdempotencyConfig) {
+	defaults := DefaultIdempotencyConfig()
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaults.TTL
+	}
+	if cfg.SweepInterval <= 0 {
+		cfg.SweepInterval = defaults.SweepInterval
+	}
+	if cfg.SweepBatchSize <= 0 {
+		cfg.SweepBatchSize = defaults.SweepBatchSize
+	}
+	if len(cfg.FingerprintKey) == 0 {
+		cfg.FingerprintKey = defaults.FingerprintKey
+	}
+	s.idempotency = cfg
+}
+
+// idempotencyTTL returns how long the idempotency key of a command is kept
+func (s *CommandService) idempotencyTTL(clientID string) time.Duration {
+	if ttl, ok := s.idempotency.ClientTTLs[clientID]; ok && clientID != "" && ttl > 0 {
+		return ttl
+	}
+	if s.idempotency.TTL > 0 {
+		return s.idempotency.TTL
+	}
+	return defaultIdempotencyTTL
+}
+
+// fingerprint identifies the request a command was submitted with, so a
+// reused idempotency key can be told apart from a retry of the same request.
+// The hash is stored in the clear, so it is an HMAC under a server-side key:
+// guessable payload values such as passwords cannot be matched against it.
+func fingerprint(cmd *Command, key []byte) (string, error) {
+	// Maps are encoded with sorted keys, equal payloads hash the same
+	data, err := json.Marshal(struct {
+		Type     string                 `json:"type"`
+		EntityID string                 `json:"entity_id"`
+		Payload  map[string]interface{} `json:"payload"`
+	}{cmd.Type, cmd.EntityID, cmd.Payload})
+	if err != nil {
+		return "", fmt.Errorf("failed to fingerprint command: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// checkIdempotency looks up the live idempotency key of a command. It
+// returns the response of the original submission, nil if the key is new,
+// or ErrIdempotencyKeyMismatch if it was used for a different request.
+func (s *CommandService) checkIdempotency(ctx context.Context, cmd *Command) (*CommandResult, error) {
+	query := `
+		SELECT i.command_id, COALESCE(i.fingerprint, ''), i.response, c.status
+		FROM idempotency_keys i
+		JOIN commands c ON c.id = i.command_id
+		WHERE i.key = $1 AND i.expires_at > NOW()
+	`
+
+	var commandID, stored string
+	var status Status
+	var response []byte
+	err := s.db.QueryRowContext(ctx, query, cmd.IdempotencyKey).Scan(&commandID, &stored, &response, &status)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check idempotency key: %w", err)
+	}
+
+	// Keys stored before fingerprints were recorded match any request
+	if stored != "" {
+		current, err := fingerprint(cmd, s.idempotency.FingerprintKey)
+		if err != nil {
+			return nil, err
+		}
+		if current != stored {
+			return nil, fmt.Errorf("%w: key %q belongs to command %s", ErrIdempotencyKeyMismatch, cmd.IdempotencyKey, commandID)
+		}
+	}
+
+	var result CommandResult
+	if len(response) == 0 || json.Unmarshal(response, &result) != nil {
+		result = CommandResult{
+			CommandID: commandID,
+			Status:    string(status),
+			Location:  commandLocation(commandID),
+		}
+	}
+	result.Replayed = true
+	return &result, nil
+}
+
+// storeIdempotencyKey saves the idempotency key of a command together with
+// the fingerprint and response of its submission. An expired key is taken
+// over; a live one stored concurrently fails with ErrIdempotencyKeyInUse.
+func (s *CommandService) storeIdempotencyKey(ctx context.Context, tx *sql.Tx, cmd *Command, hash string, result *CommandResult) error {
+	response, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to encode response: %w", err)
+	}
+
+	query := `
+		INSERT INTO idempotency_keys (key, command_id, fingerprint, response, client_id, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7)
+		ON CONFLICT (key) DO UPDATE
+		SET command_id = EXCLUDED.command_id,
+			fingerprint = EXCLUDED.fingerprint,
+			response = EXCLUDED.response,
+			client_id = EXCLUDED.client_id,
+			created_at = EXCLUDED.created_at,
+			expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= NOW()
+	`
+
+	res, err := tx.ExecContext(ctx, query,
+		cmd.IdempotencyKey,
+		cmd.ID,
+		hash,
+		response,
+		cmd.ClientID,
+		cmd.CreatedAt,
+		cmd.CreatedAt.Add(s.idempotencyTTL(cmd.ClientID)),
+	)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrIdempotencyKeyInUse
+	}
+	return nil
+}
+
+// SweepIdempotencyKeys deletes expired idempotency keys and returns how many
+// were removed
+func (s *CommandService) SweepIdempotencyKeys(ctx context.Context) (int64, error) {
+	batchSize := s.idempotency.SweepBatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultIdempotencyConfig().SweepBatchSize
+	}
+
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE key IN (
+			SELECT key FROM idempotency_keys
+			WHERE expires_at <= NOW()
+			LIMIT $1
+		)
+	`
+
+	var total int64
+	for {
+		res, err := s.db.ExecContext(ctx, query, batchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+// StartIdempotencySweeper purges expired idempotency keys every
+// SweepInterval until the context is cancelled
+func (s *CommandService) StartIdempotencySweeper(ctx context.Context) {
+	interval := s.idempotency.SweepInterval
+	if interval <= 0 {
+		interval = DefaultIdempotencyConfig().SweepInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.SweepIdempotencyKeys(ctx)
+			if err != nil {
+				s.log.Error("Failed to sweep idempotency keys", zap.Error(err))
+				continue
+			}
+			if n > 0 {
+				s.log.Info("Swept expired idempotency keys", zap.Int64("count", n))
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package command
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testFingerprintKey = []byte("fingerprint-key")
+
+func TestFingerprint(t *testing.T) {
+	var payload map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{"to":"a@example.com","subject":"hi","tags":["x","y"]}`), &payload))
+	cmd := NewCommand(CommandTypeEmailSend, payload)
+	cmd.EntityID = "user-1"
+
+	base, err := fingerprint(cmd, testFingerprintKey)
+	require.NoError(t, err)
+	assert.Len(t, base, 64)
+
+	// Field order and request metadata do not matter
+	var reordered map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{"tags":["x","y"],"subject":"hi","to":"a@example.com"}`), &reordered))
+	retry := NewCommand(CommandTypeEmailSend, reordered)
+	retry.EntityID = "user-1"
+	retry.CallbackURL = "https://hooks.example.com/done"
+	got, err := fingerprint(retry, testFingerprintKey)
+	require.NoError(t, err)
+	assert.Equal(t, base, got)
+
+	changes := map[string]func(c *Command){
+		"type":    func(c *Command) { c.Type = CommandTypeUserUpdate },
+		"entity":  func(c *Command) { c.EntityID = "user-2" },
+		"payload": func(c *Command) { c.Payload = map[string]interface{}{"to": "b@example.com"} },
+	}
+	for name, change := range changes {
+		other := NewCommand(CommandTypeEmailSend, payload)
+		other.EntityID = "user-1"
+		change(other)
+		got, err := fingerprint(other, testFingerprintKey)
+		require.NoError(t, err)
+		assert.NotEqual(t, base, got, name)
+	}
+}
+
+func TestFingerprintIsKeyed(t *testing.T) {
+	cmd := NewCommand(CommandTypeUserCreate, map[string]interface{}{"email": "a@example.com", "password": "hunter2"})
+	base, err := fingerprint(cmd, testFingerprintKey)
+	require.NoError(t, err)
+
+	// Sensitive fields take part in the comparison
+	other := NewCommand(CommandTypeUserCreate, map[string]interface{}{"email": "a@example.com", "password": "letmein"})
+	got, err := fingerprint(other, testFingerprintKey)
+	require.NoError(t, err)
+	assert.NotEqual(t, base, got)
+
+	// Without the key the stored hash cannot be matched against guesses
+	got, err = fingerprint(cmd, []byte("another key"))
+	require.NoError(t, err)
+	assert.NotEqual(t, base, got)
+}
+
+func TestConfigureIdempotencyFingerprintKey(t *testing.T) {
+	s := newTestCommandService()
+	s.ConfigureIdempotency(IdempotencyConfig{})
+	assert.Len(t, s.idempotency.FingerprintKey, 32, "a random key is used when none is configured")
+
+	s.ConfigureIdempotency(IdempotencyConfig{FingerprintKey: testFingerprintKey})
+	assert.Equal(t, testFingerprintKey, s.idempotency.FingerprintKey)
+}
+
+func TestIdempotencyTTL(t *testing.T) {
+	s := newTestCommandService()
+	assert.Equal(t, defaultIdempotencyTTL, s.idempotencyTTL(""))
+
+	s.ConfigureIdempotency(IdempotencyConfig{
+		TTL:        time.Hour,
+		ClientTTLs: map[string]time.Duration{"billing": 72 * time.Hour},
+	})
+	assert.Equal(t, time.Hour, s.idempotencyTTL(""))
+	assert.Equal(t, time.Hour, s.idempotencyTTL("reports"))
+	assert.Equal(t, 72*time.Hour, s.idempotencyTTL("billing"))
+
+	defaults := DefaultIdempotencyConfig()
+	assert.Equal(t, defaults.SweepInterval, s.idempotency.SweepInterval)
+	assert.Equal(t, defaults.SweepBatchSize, s.idempotency.SweepBatchSize)
+}
@@ -0,0 +1,138 @@
+package command
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrUnknownKey is returned for data keys wrapped under a key the provider
+// does not hold
+var ErrUnknownKey = errors.New("unknown key encryption key")
+
+// KeyProvider wraps the data keys sensitive payload fields are encrypted
+// with under key encryption keys it keeps to itself. Wrapped keys are stored
+// next to the data they protect together with the ID of the key that
+// wrapped them, so keys can be rotated while older data stays readable.
+type KeyProvider interface {
+	// WrapKey encrypts a data key under the current key encryption key
+	WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+	// UnwrapKey decrypts a data key wrapped under the key keyID
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// FileKeyProvider is a KeyProvider holding its key encryption keys in a
+// local JSON file:
+//
+//	{"primary": "2025-01", "keys": {"2025-01": "<base64 AES-256 key>", "2024-07": "..."}}
+//
+// New data keys are wrapped under the primary key, the others are kept to
+// unwrap what was written before a rotation.
+type FileKeyProvider struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewFileKeyProvider loads the key encryption keys of a key file
+func NewFileKeyProvider(path string) (*FileKeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	var file struct {
+		Primary string            `json:"primary"`
+		Keys    map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse key file %s: %w", path, err)
+	}
+
+	keys := make(map[string][]byte, len(file.Keys))
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q of %s is not base64: %w", id, path, err)
+		}
+		keys[id] = key
+	}
+	return NewStaticKeyProvider(file.Primary, keys)
+}
+
+// NewStaticKeyProvider creates a FileKeyProvider from keys already in memory.
+// Every key must be 32 bytes long.
+func NewStaticKeyProvider(primary string, keys map[string][]byte) (*FileKeyProvider, error) {
+	p := &FileKeyProvider{
+		primary: primary,
+		keys:    make(map[string]cipher.AEAD, len(keys)),
+	}
+	for id, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes, got %d", id, len(key))
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		p.keys[id] = aead
+	}
+	if _, ok := p.keys[primary]; !ok {
+		return nil, fmt.Errorf("%w: primary key %q", ErrUnknownKey, primary)
+	}
+	return p, nil
+}
+
+// WrapKey encrypts a data key under the primary key
+func (p *FileKeyProvider) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	wrapped, err := seal(p.keys[p.primary], dataKey, []byte(p.primary))
+	if err != nil {
+		return "", nil, err
+	}
+	return p.primary, wrapped, nil
+}
+
+// UnwrapKey decrypts a data key wrapped under keyID
+func (p *FileKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+	return open(aead, wrapped, []byte(keyID))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a random nonce, which is prepended to the
+// ciphertext
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+// open decrypts what seal returned
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrDecryptionFailed
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additional)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
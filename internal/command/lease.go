@@ -0,0 +1,274 @@
+package command
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/linkmeAman/universal-middleware/internal/database"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// ErrLeaseHeld is returned when another worker holds a live lease on a command
+	ErrLeaseHeld = errors.New("command is leased by another worker")
+
+	// ErrLeaseLost is returned when a worker no longer owns the lease it is
+	// renewing or releasing, usually because the reaper expired it
+	ErrLeaseLost = errors.New("command lease lost")
+
+	// ErrCommandFinished is returned when a redelivered command has already
+	// finished or was cancelled, so it must not run again
+	ErrCommandFinished = errors.New("command has already finished")
+
+	// ErrCommandNotDue is returned when a redelivered command is scheduled or
+	// backing off before a retry; the scheduler dispatches it again when due
+	ErrCommandNotDue = errors.New("command is not due yet")
+
+	// ErrCommandTimeout is the cancellation cause seen by handlers that ran
+	// past the command's TimeoutAfter
+	ErrCommandTimeout = errors.New("command timed out")
+)
+
+// LeaseStore persists leases on running commands. A worker acquires a lease
+// before running a command and keeps renewing it; a lease that is not renewed
+// in time marks the command as abandoned for the Reaper.
+type LeaseStore interface {
+	// Acquire marks the command as processing and leased by owner for ttl
+	Acquire(ctx context.Context, cmd *Command, owner string, ttl time.Duration) error
+	// Renew extends a lease held by owner
+	Renew(ctx context.Context, commandID, owner string, ttl time.Duration) error
+	// Release stores the final state of the command and drops the lease
+	Release(ctx context.Context, cmd *Command, owner string) error
+}
+
+// LeaseRepository stores command leases on the commands table
+type LeaseRepository struct {
+	db     database.DB
+	tracer trace.Tracer
+}
+
+// NewLeaseRepository creates a new lease repository
+func NewLeaseRepository(db database.DB) *LeaseRepository {
+	return &LeaseRepository{
+		db:     db,
+		tracer: otel.GetTracerProvider().Tracer("command-lease-repository"),
+	}
+}
+
+// Acquire inserts or claims the command row and queues a command.started
+// event. Commands that were not submitted through the API gateway have no row
+// yet and are created here. Only pending commands and retrying commands whose
+// backoff has elapsed are claimed: redelivered messages of finished or
+// cancelled commands fail with ErrCommandFinished, of commands that are not
+// due yet with ErrCommandNotDue.
+func (r *LeaseRepository) Acquire(ctx context.Context, cmd *Command, owner string, ttl time.Duration) error {
+	ctx, span := r.tracer.Start(ctx, "command.lease.acquire",
+		trace.WithAttributes(
+			attribute.String("command.id", cmd.ID),
+			attribute.String("lease.owner", owner),
+		),
+	)
+	defer span.End()
+
+	payloadJSON, err := json.Marshal(cmd.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+
+	query := `
+		INSERT INTO commands (
+			id, type, entity_id, payload, status, created_at, processed_at,
+			retry_count, max_retries, lease_owner, lease_expires_at, heartbeat_at,
+			callback_url, priority, schema_version, timeout_ms, retry_backoff_ms
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, NOW(),
+			$7, $8, $9, NOW() + $10 * INTERVAL '1 millisecond', NOW(),
+			NULLIF($11, ''), $12, NULLIF($13, ''), $14, $15
+		)
+		ON CONFLICT (id) DO UPDATE
+		SET status = EXCLUDED.status,
+			processed_at = EXCLUDED.processed_at,
+			lease_owner = EXCLUDED.lease_owner,
+			lease_expires_at = EXCLUDED.lease_expires_at,
+			heartbeat_at = EXCLUDED.heartbeat_at
+		WHERE (commands.lease_owner IS NULL
+				OR commands.lease_owner = EXCLUDED.lease_owner
+				OR commands.lease_expires_at < NOW())
+			AND (commands.status = $16
+				OR (commands.status = $17 AND COALESCE(commands.scheduled_for, NOW()) <= NOW()))
+			AND commands.cancelled_at IS NULL`
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the row, if there is one, to learn the status the command leaves
+	previous := string(StatusPending)
+	var cancelled, due bool
+	err = tx.QueryRow(ctx,
+		`SELECT status, cancelled_at IS NOT NULL, COALESCE(scheduled_for, NOW()) <= NOW()
+		FROM commands WHERE id = $1 FOR UPDATE`,
+		cmd.ID,
+	).Scan(&previous, &cancelled, &due)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("failed to load command: %w", err)
+	}
+
+	result, err := tx.Exec(ctx, query,
+		cmd.ID, cmd.Type, cmd.EntityID, payloadJSON, StatusProcessing, cmd.CreatedAt,
+		cmd.RetryCount, cmd.MaxRetries, owner, ttl.Milliseconds(),
+		cmd.CallbackURL, int(cmd.Priority), cmd.SchemaVersion,
+		cmd.TimeoutAfter.Milliseconds(), cmd.RetryBackoff.Milliseconds(),
+		StatusPending, StatusRetrying,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to acquire lease: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return refuseLease(Status(previous), cancelled, due)
+	}
+
+	started := *cmd
+	started.Status = StatusProcessing
+	if err := insertTransition(ctx, tx, &started, Status(previous), owner); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// refuseLease explains why a command in the given state was not leased
+func refuseLease(status Status, cancelled, due bool) error {
+	switch {
+	case cancelled || status.IsTerminal():
+		return ErrCommandFinished
+	case status == StatusScheduled || (status == StatusRetrying && !due):
+		return ErrCommandNotDue
+	default:
+		return ErrLeaseHeld
+	}
+}
+
+// Renew extends the lease and records a heartbeat
+func (r *LeaseRepository) Renew(ctx context.Context, commandID, owner string, ttl time.Duration) error {
+	query := `
+		UPDATE commands
+		SET lease_expires_at = NOW() + $3 * INTERVAL '1 millisecond', heartbeat_at = NOW()
+		WHERE id = $1 AND lease_owner = $2 AND status = $4`
+
+	result, err := r.db.Exec(ctx, query, commandID, owner, ttl.Milliseconds(), StatusProcessing)
+	if err != nil {
+		return fmt.Errorf("failed to renew lease: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrLeaseLost
+	}
+
+	return nil
+}
+
+// Release writes the command outcome and clears the lease. The lifecycle
+// event of the outcome is queued in the same transaction.
+func (r *LeaseRepository) Release(ctx context.Context, cmd *Command, owner string) error {
+	ctx, span := r.tracer.Start(ctx, "command.lease.release",
+		trace.WithAttributes(
+			attribute.String("command.id", cmd.ID),
+			attribute.String("command.status", string(cmd.Status)),
+		),
+	)
+	defer span.End()
+
+	var errorMsg, errorCode sql.NullString
+	if cmd.ErrorDetails != nil {
+		errorMsg = sql.NullString{String: cmd.ErrorDetails.Message, Valid: true}
+		errorCode = nullString(cmd.ErrorDetails.Code)
+	}
+
+	query := `
+		UPDATE commands
+		SET status = $3, error = $4, error_code = $5, retry_count = $6, scheduled_for = $7,
+			lease_owner = NULL, lease_expires_at = NULL
+		WHERE id = $1 AND lease_owner = $2`
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, query,
+		cmd.ID, owner, cmd.Status, errorMsg, errorCode, cmd.RetryCount, cmd.ScheduledFor,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrLeaseLost
+	}
+
+	if err := insertTransition(ctx, tx, cmd, StatusProcessing, owner); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Reject stores the failure of a command that was refused before any worker
+// leased it, such as one whose payload does not validate. Commands that are
+// already running or finished are left alone.
+func (r *LeaseRepository) Reject(ctx context.Context, cmd *Command) error {
+	var errorMsg, errorCode sql.NullString
+	if cmd.ErrorDetails != nil {
+		errorMsg = sql.NullString{String: cmd.ErrorDetails.Message, Valid: true}
+		errorCode = nullString(cmd.ErrorDetails.Code)
+	}
+
+	query := `
+		UPDATE commands
+		SET status = $2, error = $3, error_code = $4
+		WHERE id = $1 AND status = $5 AND lease_owner IS NULL`
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, query, cmd.ID, cmd.Status, errorMsg, errorCode, StatusPending)
+	if err != nil {
+		return fmt.Errorf("failed to reject command: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return nil
+	}
+
+	if err := insertTransition(ctx, tx, cmd, StatusPending, ""); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
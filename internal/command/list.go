@@ -0,0 +1,200 @@
+package command
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AdminScope is the token scope required to inspect and operate on the
+// commands of all users
+const AdminScope = "commands:admin"
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+// ErrInvalidCursor is returned when a listing cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListFilter selects the commands returned by ListCommands. Empty fields
+// match every command.
+type ListFilter struct {
+	Status        Status
+	Type          string
+	EntityID      string
+	UserID        string
+	CorrelationID string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+	Limit  int
+}
+
+// CommandPage is one page of a command listing
+type CommandPage struct {
+	Commands   []*Command `json:"commands"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// listCursor is the position after the last command of a page. Commands are
+// listed newest first, ties on created_at are broken by ID.
+type listCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"id"`
+}
+
+func encodeCursor(c listCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" || c.CreatedAt.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// listLimit returns the page size of a listing
+func (f ListFilter) listLimit() int {
+	switch {
+	case f.Limit <= 0:
+		return defaultListLimit
+	case f.Limit > maxListLimit:
+		return maxListLimit
+	default:
+		return f.Limit
+	}
+}
+
+// buildListQuery returns the listing query of a filter. Only the filters that
+// are set become conditions, so status and entity listings are served by the
+// (status, created_at) and (entity_id, created_at) indexes. One row more than
+// the page size is fetched to tell whether another page follows.
+func buildListQuery(f ListFilter, cursor *listCursor) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	where := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.Status != "" {
+		where("status = $%d", f.Status)
+	}
+	if f.Type != "" {
+		where("type = $%d", f.Type)
+	}
+	if f.EntityID != "" {
+		where("entity_id = $%d", f.EntityID)
+	}
+	if f.UserID != "" {
+		where("user_id = $%d", f.UserID)
+	}
+	if f.CorrelationID != "" {
+		where("correlation_id = $%d", f.CorrelationID)
+	}
+	if f.CreatedAfter != nil {
+		where("created_at >= $%d", *f.CreatedAfter)
+	}
+	if f.CreatedBefore != nil {
+		where("created_at < $%d", *f.CreatedBefore)
+	}
+	if cursor != nil {
+		args = append(args, cursor.CreatedAt, cursor.ID)
+		conds = append(conds, fmt.Sprintf("(created_at, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	query := `
+		SELECT id, type, entity_id, payload, status, created_at, scheduled_for, processed_at,
+			retry_count, max_retries, error, user_id, correlation_id, callback_url,
+			` + executionColumns + `
+		FROM commands`
+	if len(conds) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(conds, " AND ")
+	}
+
+	args = append(args, f.listLimit()+1)
+	query += fmt.Sprintf("\n\t\tORDER BY created_at DESC, id DESC\n\t\tLIMIT $%d", len(args))
+
+	return query, args
+}
+
+// ListCommands returns the commands matching a filter, newest first
+func (s *CommandService) ListCommands(ctx context.Context, f ListFilter) (*CommandPage, error) {
+	var cursor *listCursor
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		cursor = c
+	}
+
+	query, args := buildListQuery(f, cursor)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commands: %w", err)
+	}
+	defer rows.Close()
+
+	page := &CommandPage{Commands: []*Command{}}
+	for rows.Next() {
+		cmd := &Command{}
+		var payloadJSON []byte
+		var scheduledFor, processedAt sql.NullTime
+		var errorMsg, userID, correlationID, callbackURL sql.NullString
+		var retryCount sql.NullInt64
+		var execution executionFields
+
+		if err := rows.Scan(append([]interface{}{
+			&cmd.ID, &cmd.Type, &cmd.EntityID, &payloadJSON, &cmd.Status, &cmd.CreatedAt,
+			&scheduledFor, &processedAt, &retryCount, &cmd.MaxRetries, &errorMsg,
+			&userID, &correlationID, &callbackURL,
+		}, execution.dest()...)...); err != nil {
+			return nil, fmt.Errorf("failed to scan command: %w", err)
+		}
+		if err := json.Unmarshal(payloadJSON, &cmd.Payload); err != nil {
+			return nil, fmt.Errorf("failed to decode payload of command %s: %w", cmd.ID, err)
+		}
+		if scheduledFor.Valid {
+			cmd.ScheduledFor = &scheduledFor.Time
+		}
+		if processedAt.Valid {
+			cmd.ProcessedAt = &processedAt.Time
+		}
+		cmd.RetryCount = int(retryCount.Int64)
+		cmd.Error = errorMsg.String
+		cmd.UserID = userID.String
+		cmd.CorrelationID = correlationID.String
+		cmd.CallbackURL = callbackURL.String
+		execution.apply(cmd)
+		page.Commands = append(page.Commands, s.redact(cmd))
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating commands: %w", err)
+	}
+
+	if limit := f.listLimit(); len(page.Commands) > limit {
+		page.Commands = page.Commands[:limit]
+		last := page.Commands[limit-1]
+		page.NextCursor = encodeCursor(listCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	return page, nil
+}
@@ -0,0 +1,60 @@
+package command
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListCursor(t *testing.T) {
+	c := listCursor{CreatedAt: time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC), ID: "cmd-1"}
+
+	decoded, err := decodeCursor(encodeCursor(c))
+	require.NoError(t, err)
+	assert.True(t, c.CreatedAt.Equal(decoded.CreatedAt))
+	assert.Equal(t, "cmd-1", decoded.ID)
+
+	for _, bad := range []string{"not base64!", "e30", encodeCursor(listCursor{ID: "cmd-1"})} {
+		_, err := decodeCursor(bad)
+		assert.ErrorIs(t, err, ErrInvalidCursor, bad)
+	}
+}
+
+func TestBuildListQuery(t *testing.T) {
+	t.Run("unfiltered", func(t *testing.T) {
+		query, args := buildListQuery(ListFilter{}, nil)
+		assert.NotContains(t, query, "WHERE")
+		assert.Contains(t, query, "ORDER BY created_at DESC, id DESC")
+		assert.Equal(t, []interface{}{defaultListLimit + 1}, args)
+	})
+
+	t.Run("filtered page", func(t *testing.T) {
+		after := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		cursor := &listCursor{CreatedAt: after.Add(time.Hour), ID: "cmd-9"}
+
+		query, args := buildListQuery(ListFilter{
+			Status:       StatusFailed,
+			EntityID:     "user-1",
+			CreatedAfter: &after,
+			Limit:        10,
+		}, cursor)
+
+		assert.Contains(t, query, "WHERE status = $1 AND entity_id = $2 AND created_at >= $3 AND (created_at, id) < ($4, $5)")
+		assert.Contains(t, query, "LIMIT $6")
+		assert.Equal(t, []interface{}{StatusFailed, "user-1", after, cursor.CreatedAt, "cmd-9", 11}, args)
+	})
+
+	t.Run("limit is capped", func(t *testing.T) {
+		_, args := buildListQuery(ListFilter{Limit: 10000}, nil)
+		assert.Equal(t, []interface{}{maxListLimit + 1}, args)
+	})
+}
+
+func TestListCommandsRejectsInvalidCursor(t *testing.T) {
+	s := newTestCommandService()
+	_, err := s.ListCommands(context.Background(), ListFilter{Cursor: "garbage"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
@@ -0,0 +1,150 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/linkmeAman/universal-middleware/pkg/logger"
+	"github.com/linkmeAman/universal-middleware/pkg/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// ErrHandlerPanic is returned for a handler that panicked while
+// RecoveryMiddleware was installed
+var ErrHandlerPanic = errors.New("command handler panicked")
+
+// HandlerFunc runs a handler on a command
+type HandlerFunc func(ctx context.Context, cmd *Command) error
+
+// Middleware wraps every handler a Processor runs. The name of the wrapped
+// handler is available through HandlerName.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+type handlerNameKey struct{}
+
+// HandlerName returns the name of the handler a middleware is wrapping
+func HandlerName(ctx context.Context) string {
+	name, _ := ctx.Value(handlerNameKey{}).(string)
+	return name
+}
+
+// handlerName names a handler in logs, metrics and error details. Handlers
+// implementing fmt.Stringer choose their own name.
+func handlerName(handler Handler) string {
+	if s, ok := handler.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", handler)
+}
+
+// chain composes middleware around a handler, the first middleware is the
+// outermost
+func chain(handler HandlerFunc, middleware []Middleware) HandlerFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// outcome labels the result of a handler
+func outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// LoggingMiddleware logs every handler run with its duration and error
+func LoggingMiddleware(log *logger.Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, cmd *Command) error {
+			start := time.Now()
+			err := next(ctx, cmd)
+
+			fields := []zap.Field{
+				zap.String("command_id", cmd.ID),
+				zap.String("command_type", cmd.Type),
+				zap.String("handler", HandlerName(ctx)),
+				zap.Int("attempt", cmd.RetryCount+1),
+				zap.Duration("duration", time.Since(start)),
+			}
+			if err != nil {
+				log.Warn("Command handler returned an error", append(fields, zap.Error(err))...)
+			} else {
+				log.Debug("Command handler finished", fields...)
+			}
+			return err
+		}
+	}
+}
+
+// TracingMiddleware runs every handler in its own span
+func TracingMiddleware() Middleware {
+	tracer := otel.GetTracerProvider().Tracer("command-handler")
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, cmd *Command) error {
+			ctx, span := tracer.Start(ctx, "command.handle",
+				trace.WithAttributes(
+					attribute.String("command.id", cmd.ID),
+					attribute.String("command.type", cmd.Type),
+					attribute.String("command.handler", HandlerName(ctx)),
+					attribute.Int("command.attempt", cmd.RetryCount+1),
+				),
+			)
+			defer span.End()
+
+			err := next(ctx, cmd)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			return err
+		}
+	}
+}
+
+// MetricsMiddleware records how long handlers take per command type and
+// outcome
+func MetricsMiddleware(m *metrics.Metrics) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, cmd *Command) error {
+			start := time.Now()
+			err := next(ctx, cmd)
+			m.CommandHandlerDuration.WithLabelValues(cmd.Type, HandlerName(ctx), outcome(err)).
+				Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
+
+// RecoveryMiddleware turns a panicking handler into a permanent
+// ErrHandlerPanic failure of its command. Panics are counted when m is set.
+func RecoveryMiddleware(log *logger.Logger, m *metrics.Metrics) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, cmd *Command) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error("Command handler panicked",
+						zap.String("command_id", cmd.ID),
+						zap.String("command_type", cmd.Type),
+						zap.String("handler", HandlerName(ctx)),
+						zap.Any("panic", r),
+						zap.ByteString("stack", debug.Stack()),
+					)
+					if m != nil {
+						m.CommandHandlerPanics.WithLabelValues(cmd.Type, HandlerName(ctx)).Inc()
+					}
+					err = Permanent(fmt.Errorf("%w: %v", ErrHandlerPanic, r))
+				}
+			}()
+			return next(ctx, cmd)
+		}
+	}
+}
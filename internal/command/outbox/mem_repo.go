@@ -32,11 +32,19 @@ func (r *InMemoryRepository) Save(ctx context.Context, msg *Message) error {
 	return nil
 }
 
-// GetPendingMessages retrieves pending messages for processing
+// InTx calls fn with the repository itself. Changes made before fn fails
+// are kept.
+func (r *InMemoryRepository) InTx(ctx context.Context, fn func(tx Store) error) error {
+	return fn(r)
+}
+
+// GetPendingMessages retrieves pending messages and retrying messages that
+// are due for processing
 func (r *InMemoryRepository) GetPendingMessages(ctx context.Context, limit int) ([]*Message, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	now := time.Now()
 	var out []*Message
 	count := 0
 	for _, id := range r.order {
@@ -44,7 +52,11 @@ func (r *InMemoryRepository) GetPendingMessages(ctx context.Context, limit int)
 			break
 		}
 		m := r.messages[id]
-		if m != nil && m.Status == StatusPending {
+		if m == nil {
+			continue
+		}
+		due := m.Status == StatusRetrying && m.NextAttemptAt != nil && !m.NextAttemptAt.After(now)
+		if m.Status == StatusPending || due {
 			out = append(out, m)
 			count++
 		}
@@ -52,6 +64,25 @@ func (r *InMemoryRepository) GetPendingMessages(ctx context.Context, limit int)
 	return out, nil
 }
 
+// ClaimOrdered returns up to limit messages such that every aggregate's
+// messages are published strictly in the order they were saved, holding
+// back messages behind one that is not due
+func (r *InMemoryRepository) ClaimOrdered(ctx context.Context, limit int) ([]*Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unpublished []*Message
+	for _, id := range r.order {
+		m := r.messages[id]
+		if m != nil && (m.Status == StatusPending || m.Status == StatusRetrying) {
+			unpublished = append(unpublished, m)
+		}
+	}
+
+	claimed := claimRuns(unpublished, time.Now())
+	return claimed[:min(limit, len(claimed))], nil
+}
+
 // MarkAsPublished marks a message as successfully published
 func (r *InMemoryRepository) MarkAsPublished(ctx context.Context, messageID string) error {
 	r.mu.Lock()
@@ -65,14 +96,45 @@ func (r *InMemoryRepository) MarkAsPublished(ctx context.Context, messageID stri
 	return nil
 }
 
-// MarkAsFailed marks a message as failed with error details
-func (r *InMemoryRepository) MarkAsFailed(ctx context.Context, messageID string, errorMsg string) error {
+// MarkBatchPublished marks the messages of a published batch
+func (r *InMemoryRepository) MarkBatchPublished(ctx context.Context, messageIDs []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, id := range messageIDs {
+		if m, ok := r.messages[id]; ok {
+			m.Status = StatusPublished
+			m.PublishedAt = &now
+			m.NextAttemptAt = nil
+		}
+	}
+	return nil
+}
+
+// ScheduleRetry records a failed publish attempt and schedules the next one
+func (r *InMemoryRepository) ScheduleRetry(ctx context.Context, messageID string, errorMsg string, nextAttemptAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.messages[messageID]; ok {
+		m.RetryCount++
+		m.ErrorMessage = errorMsg
+		m.Status = StatusRetrying
+		m.NextAttemptAt = &nextAttemptAt
+		return nil
+	}
+	return nil
+}
+
+// MarkAsDead records the last failed publish attempt of a message that
+// will not be retried
+func (r *InMemoryRepository) MarkAsDead(ctx context.Context, messageID string, errorMsg string) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	if m, ok := r.messages[messageID]; ok {
 		m.RetryCount++
 		m.ErrorMessage = errorMsg
-		m.Status = StatusFailed
+		m.Status = StatusDead
+		m.NextAttemptAt = nil
 		return nil
 	}
 	return nil
@@ -2,54 +2,134 @@ package outbox
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/linkmeAman/universal-middleware/internal/events/publisher"
 	"github.com/linkmeAman/universal-middleware/pkg/logger"
+	"github.com/linkmeAman/universal-middleware/pkg/metrics"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+// NotifyChannel is the channel the outbox_messages insert trigger notifies
+const NotifyChannel = "outbox_messages"
+
+// Notifier delivers Postgres notifications, *postgres.DB implements it
+type Notifier interface {
+	// Listen calls notify for every notification on channel until the
+	// context is done or the connection fails
+	Listen(ctx context.Context, channel string, notify func(payload string)) error
+}
+
+// Publisher sends a batch of messages to Kafka in one round trip,
+// *publisher.Producer implements it
+type Publisher interface {
+	// PublishMessages returns a *publisher.BatchError when only some of the
+	// messages were not published
+	PublishMessages(ctx context.Context, messages []publisher.Message) error
+}
+
+// Store holds the outbox messages the processor publishes. *Repository and
+// *InMemoryRepository implement it.
+type Store interface {
+	// InTx calls fn with a store whose claimed messages stay locked, and
+	// whose changes are committed, when fn returns nil
+	InTx(ctx context.Context, fn func(tx Store) error) error
+	GetPendingMessages(ctx context.Context, limit int) ([]*Message, error)
+	ClaimOrdered(ctx context.Context, limit int) ([]*Message, error)
+	MarkBatchPublished(ctx context.Context, messageIDs []string) error
+	ScheduleRetry(ctx context.Context, messageID string, errorMsg string, nextAttemptAt time.Time) error
+	MarkAsDead(ctx context.Context, messageID string, errorMsg string) error
+	CleanupPublishedMessages(ctx context.Context, olderThan time.Duration) (int64, error)
+}
+
 // ProcessorConfig holds configuration for the outbox processor
 type ProcessorConfig struct {
-	BatchSize       int
+	BatchSize int
+	// MaxBatchSize bounds adaptive draining: while batches come back full
+	// the next one is twice as large, up to MaxBatchSize
+	MaxBatchSize    int
 	PollingInterval time.Duration
+	// RetryDelay is the delay before the first retry of a message that
+	// failed to publish. It doubles with every further retry, up to
+	// MaxRetryDelay; after MaxRetries retries the message is dead.
 	RetryDelay      time.Duration
+	MaxRetryDelay   time.Duration
 	MaxRetries      int
 	CleanupInterval time.Duration
 	RetentionPeriod time.Duration
+
+	// Notifier, when set, wakes the processor on every notification on
+	// NotifyChannel. Polling then only runs every FallbackInterval to pick
+	// up messages whose notification was missed.
+	Notifier         Notifier
+	NotifyChannel    string
+	FallbackInterval time.Duration
+
+	// Metrics receives publish latency, batch size and wakeup metrics when set
+	Metrics *metrics.Metrics
+
+	// OrderByAggregate publishes the messages of an aggregate strictly in
+	// the order they were written, also across relay replicas, keyed by
+	// aggregate ID so they land on one partition. A message that fails
+	// holds back the aggregate's later messages until it is published or
+	// dead; later messages of the aggregate published in the same batch are
+	// not marked and are published again after it. Messages without an
+	// aggregate ID are not ordered. Every relay sharing the outbox must use
+	// the same mode.
+	OrderByAggregate bool
 }
 
 // DefaultConfig returns default processor configuration
 func DefaultConfig() ProcessorConfig {
 	return ProcessorConfig{
-		BatchSize:       100,
-		PollingInterval: 1 * time.Second,
-		RetryDelay:      5 * time.Second,
-		MaxRetries:      3,
-		CleanupInterval: 1 * time.Hour,
-		RetentionPeriod: 7 * 24 * time.Hour, // 7 days
+		BatchSize:        100,
+		MaxBatchSize:     1000,
+		PollingInterval:  1 * time.Second,
+		RetryDelay:       5 * time.Second,
+		MaxRetryDelay:    5 * time.Minute,
+		MaxRetries:       3,
+		CleanupInterval:  1 * time.Hour,
+		RetentionPeriod:  7 * 24 * time.Hour, // 7 days
+		NotifyChannel:    NotifyChannel,
+		FallbackInterval: 30 * time.Second,
 	}
 }
 
 // Processor handles outbox message processing and publishing
 type Processor struct {
 	config    ProcessorConfig
-	repo      *Repository
-	publisher *publisher.Producer
+	repo      Store
+	publisher Publisher
+	metrics   *metrics.Metrics
 	log       *logger.Logger
 	tracer    trace.Tracer
 }
 
 // NewProcessor creates a new outbox processor
-func NewProcessor(config ProcessorConfig, repo *Repository, pub *publisher.Producer, log *logger.Logger) *Processor {
+func NewProcessor(config ProcessorConfig, repo Store, pub Publisher, log *logger.Logger) *Processor {
+	if config.MaxBatchSize < config.BatchSize {
+		config.MaxBatchSize = config.BatchSize
+	}
+	if config.NotifyChannel == "" {
+		config.NotifyChannel = NotifyChannel
+	}
+	if config.FallbackInterval <= 0 {
+		config.FallbackInterval = DefaultConfig().FallbackInterval
+	}
+	if config.MaxRetryDelay < config.RetryDelay {
+		config.MaxRetryDelay = config.RetryDelay
+	}
+
 	return &Processor{
 		config:    config,
 		repo:      repo,
 		publisher: pub,
+		metrics:   config.Metrics,
 		log:       log,
 		tracer:    otel.GetTracerProvider().Tracer("outbox-processor"),
 	}
@@ -59,11 +139,14 @@ func NewProcessor(config ProcessorConfig, repo *Repository, pub *publisher.Produ
 func (p *Processor) Start(ctx context.Context) error {
 	p.log.Info("Starting outbox processor",
 		zap.Int("batch_size", p.config.BatchSize),
-		zap.Duration("polling_interval", p.config.PollingInterval),
+		zap.Int("max_batch_size", p.config.MaxBatchSize),
+		zap.Duration("polling_interval", p.pollingInterval()),
+		zap.Bool("notify", p.config.Notifier != nil),
+		zap.Bool("order_by_aggregate", p.config.OrderByAggregate),
 	)
 
 	// Try processing a test batch to verify everything works
-	if err := p.processBatch(ctx); err != nil {
+	if _, _, err := p.processBatch(ctx, p.config.BatchSize); err != nil {
 		return fmt.Errorf("failed to process initial batch: %w", err)
 	}
 
@@ -76,101 +159,289 @@ func (p *Processor) Start(ctx context.Context) error {
 	return nil
 }
 
+// pollingInterval is how often the outbox is polled. While notifications
+// wake the processor polling is a safety net, and picks up retries as they
+// fall due, which no notification announces.
+func (p *Processor) pollingInterval() time.Duration {
+	if p.config.Notifier != nil {
+		if p.config.RetryDelay > 0 {
+			return min(p.config.FallbackInterval, p.config.RetryDelay)
+		}
+		return p.config.FallbackInterval
+	}
+	return p.config.PollingInterval
+}
+
 func (p *Processor) processMessages(ctx context.Context) {
-	ticker := time.NewTicker(p.config.PollingInterval)
+	wake := make(chan struct{}, 1)
+	if p.config.Notifier != nil {
+		go p.listen(ctx, wake)
+	}
+
+	ticker := time.NewTicker(p.pollingInterval())
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-wake:
+			p.drain(ctx, "notify")
 		case <-ticker.C:
-			if err := p.processBatch(ctx); err != nil {
-				p.log.Error("Failed to process message batch",
-					zap.Error(err),
-				)
-			}
+			p.drain(ctx, "poll")
 		}
 	}
 }
 
-func (p *Processor) processBatch(ctx context.Context) error {
-	ctx, span := p.tracer.Start(ctx, "outbox.process_batch")
-	defer span.End()
-
-	messages, err := p.repo.GetPendingMessages(ctx, p.config.BatchSize)
-	if err != nil {
-		return fmt.Errorf("failed to get pending messages: %w", err)
+// listen wakes the processor for every notification and reconnects after
+// RetryDelay when the listening connection fails. Every (re)connect also
+// wakes it, for messages written while nobody was listening.
+func (p *Processor) listen(ctx context.Context, wake chan<- struct{}) {
+	// Notifications arriving during a drain are folded into one wakeup
+	signal := func(string) {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
 	}
 
-	if len(messages) == 0 {
-		return nil
+	for {
+		signal("")
+		err := p.config.Notifier.Listen(ctx, p.config.NotifyChannel, signal)
+		if ctx.Err() != nil {
+			return
+		}
+		p.log.Warn("Outbox notification listener stopped, reconnecting",
+			zap.String("channel", p.config.NotifyChannel),
+			zap.Duration("retry_delay", p.config.RetryDelay),
+			zap.Error(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.config.RetryDelay):
+		}
 	}
+}
 
-	span.SetAttributes(attribute.Int("batch.size", len(messages)))
+// drain publishes pending messages until the outbox is empty. While
+// batches come back full the batch size doubles, up to MaxBatchSize, so a
+// backlog is worked off in few round trips.
+func (p *Processor) drain(ctx context.Context, reason string) {
+	if p.metrics != nil {
+		p.metrics.OutboxWakeups.WithLabelValues(reason).Inc()
+	}
 
-	for _, msg := range messages {
-		if err := p.processMessage(ctx, msg); err != nil {
-			p.log.Error("Failed to process message",
-				zap.String("message_id", msg.ID),
+	size := p.config.BatchSize
+	for ctx.Err() == nil {
+		fetched, published, err := p.processBatch(ctx, size)
+		if err != nil {
+			p.log.Error("Failed to process message batch",
 				zap.Error(err),
 			)
+			return
+		}
 
-			if msg.RetryCount >= p.config.MaxRetries {
-				p.log.Error("Message exceeded max retries",
-					zap.String("message_id", msg.ID),
-					zap.Int("retry_count", msg.RetryCount),
-				)
-				if err := p.repo.MarkAsFailed(ctx, msg.ID, "Exceeded max retries"); err != nil {
-					p.log.Error("Failed to mark message as failed",
-						zap.String("message_id", msg.ID),
-						zap.Error(err),
-					)
-				}
-				continue
-			}
+		// Stop once the outbox is drained, or when nothing could be
+		// published so a broken broker is not hammered
+		if fetched < size || published == 0 {
+			return
+		}
+		size = min(size*2, p.config.MaxBatchSize)
+	}
+}
 
-			if err := p.repo.MarkAsFailed(ctx, msg.ID, err.Error()); err != nil {
-				p.log.Error("Failed to mark message as failed",
-					zap.String("message_id", msg.ID),
-					zap.Error(err),
-				)
+// processBatch publishes up to limit pending messages and reports how many
+// were fetched and how many of those were published
+func (p *Processor) processBatch(ctx context.Context, limit int) (fetched, published int, err error) {
+	ctx, span := p.tracer.Start(ctx, "outbox.process_batch",
+		trace.WithAttributes(
+			attribute.Int("batch.limit", limit),
+			attribute.Bool("batch.ordered", p.config.OrderByAggregate),
+		),
+	)
+	defer span.End()
+
+	// Claimed messages, and with ordering their aggregates, stay locked
+	// while they are published and marked, keeping other relays off them
+	err = p.repo.InTx(ctx, func(tx Store) error {
+		var messages []*Message
+		var err error
+		if p.config.OrderByAggregate {
+			messages, err = tx.ClaimOrdered(ctx, limit)
+		} else {
+			messages, err = tx.GetPendingMessages(ctx, limit)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to claim messages: %w", err)
+		}
+		fetched = len(messages)
+		published = p.publishMessages(ctx, tx, messages)
+		return nil
+	})
+	span.SetAttributes(attribute.Int("batch.size", fetched))
+	return fetched, published, err
+}
+
+// publishMessages publishes messages in one round trip, marks the
+// published ones in one statement through repo and schedules retries of the
+// others. When ordering by aggregate, messages behind a failed one of their
+// aggregate are left unpublished, to be sent again after it. It returns how
+// many were published.
+func (p *Processor) publishMessages(ctx context.Context, repo Store, messages []*Message) int {
+	if p.metrics != nil {
+		p.metrics.OutboxBatchSize.Observe(float64(len(messages)))
+	}
+	if len(messages) == 0 {
+		return 0
+	}
+
+	batch := make([]publisher.Message, len(messages))
+	for i, msg := range messages {
+		batch[i] = publisher.Message{
+			Topic: msg.Topic,
+			Key:   p.partitionKey(msg),
+			Value: msg.Payload,
+		}
+	}
+
+	start := time.Now()
+	failures := batchFailures(len(messages), p.publisher.PublishMessages(ctx, batch))
+	duration := time.Since(start)
+
+	published := make([]string, 0, len(messages))
+	heldBack := make(map[orderKey]bool)
+	for i, msg := range messages {
+		key := orderKeyOf(msg)
+		if err, failed := failures[i]; failed {
+			p.observePublish(msg, duration, false)
+			p.handleFailure(ctx, repo, msg, fmt.Errorf("failed to publish message: %w", err))
+			if p.config.OrderByAggregate {
+				heldBack[key] = true
 			}
 			continue
 		}
-
-		if err := p.repo.MarkAsPublished(ctx, msg.ID); err != nil {
-			p.log.Error("Failed to mark message as published",
+		if heldBack[key] {
+			p.log.Debug("Holding back message behind a failed one of its aggregate",
 				zap.String("message_id", msg.ID),
+				zap.String("aggregate_id", msg.AggregateID),
+			)
+			continue
+		}
+		p.observePublish(msg, duration, true)
+		published = append(published, msg.ID)
+	}
+
+	if len(published) > 0 {
+		if err := repo.MarkBatchPublished(ctx, published); err != nil {
+			p.log.Error("Failed to mark messages as published",
+				zap.Int("count", len(published)),
 				zap.Error(err),
 			)
 		}
 	}
 
-	return nil
+	p.log.Debug("Published message batch",
+		zap.Int("batch_size", len(messages)),
+		zap.Int("published", len(published)),
+		zap.Duration("duration", duration),
+	)
+
+	return len(published)
 }
 
-func (p *Processor) processMessage(ctx context.Context, msg *Message) error {
-	ctx, span := p.tracer.Start(ctx, "outbox.process_message",
-		trace.WithAttributes(
-			attribute.String("message.id", msg.ID),
-			attribute.String("message.type", msg.EventType),
-		),
-	)
-	defer span.End()
+// batchFailures maps the error of publishing a batch of n messages to the
+// messages that were not published, by index. Errors other than a
+// *publisher.BatchError fail the whole batch.
+func batchFailures(n int, err error) map[int]error {
+	if err == nil {
+		return nil
+	}
+
+	var batchErr *publisher.BatchError
+	if errors.As(err, &batchErr) {
+		return batchErr.Failed
+	}
+
+	failed := make(map[int]error, n)
+	for i := range n {
+		failed[i] = err
+	}
+	return failed
+}
 
-	// Publish message
-	err := p.publisher.Publish(ctx, msg.Topic, msg.ID, msg.Payload)
-	if err != nil {
-		return fmt.Errorf("failed to publish message: %w", err)
+func (p *Processor) observePublish(msg *Message, duration time.Duration, ok bool) {
+	if p.metrics == nil {
+		return
 	}
+	if !ok {
+		p.metrics.OutboxPublishDuration.WithLabelValues(msg.Topic, "error").Observe(duration.Seconds())
+		return
+	}
+	p.metrics.OutboxPublishDuration.WithLabelValues(msg.Topic, "success").Observe(duration.Seconds())
+	p.metrics.OutboxPublishLatency.WithLabelValues(msg.Topic).Observe(time.Since(msg.CreatedAt).Seconds())
+}
 
-	p.log.Debug("Successfully published message",
+// handleFailure schedules the next attempt of a message that failed to
+// publish, or marks it dead once it has been retried MaxRetries times
+func (p *Processor) handleFailure(ctx context.Context, repo Store, msg *Message, err error) {
+	if msg.RetryCount >= p.config.MaxRetries {
+		p.log.Error("Message exceeded max retries, marking dead",
+			zap.String("message_id", msg.ID),
+			zap.String("topic", msg.Topic),
+			zap.Int("retry_count", msg.RetryCount),
+			zap.Error(err),
+		)
+		if err := repo.MarkAsDead(ctx, msg.ID, err.Error()); err != nil {
+			p.log.Error("Failed to mark message as dead",
+				zap.String("message_id", msg.ID),
+				zap.Error(err),
+			)
+			return
+		}
+		if p.metrics != nil {
+			p.metrics.OutboxDeadMessages.WithLabelValues(msg.Topic).Inc()
+		}
+		return
+	}
+
+	delay := p.retryDelay(msg.RetryCount + 1)
+	p.log.Warn("Failed to publish message, retrying",
 		zap.String("message_id", msg.ID),
 		zap.String("topic", msg.Topic),
+		zap.Int("retry", msg.RetryCount+1),
+		zap.Duration("delay", delay),
+		zap.Error(err),
 	)
+	if err := repo.ScheduleRetry(ctx, msg.ID, err.Error(), time.Now().Add(delay)); err != nil {
+		p.log.Error("Failed to schedule message retry",
+			zap.String("message_id", msg.ID),
+			zap.Error(err),
+		)
+		return
+	}
+	if p.metrics != nil {
+		p.metrics.OutboxRetries.WithLabelValues(msg.Topic).Inc()
+	}
+}
 
-	return nil
+// retryDelay returns the delay before the given retry, counting from 1
+func (p *Processor) retryDelay(retry int) time.Duration {
+	delay := p.config.RetryDelay
+	for i := 1; i < retry && delay < p.config.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.config.MaxRetryDelay)
+}
+
+// partitionKey is the Kafka key of a message. When ordering by aggregate it
+// is the aggregate ID, so an aggregate's messages share a partition.
+func (p *Processor) partitionKey(msg *Message) string {
+	if p.config.OrderByAggregate && msg.AggregateID != "" {
+		return msg.AggregateID
+	}
+	return msg.ID
 }
 
 func (p *Processor) runCleanup(ctx context.Context) {
@@ -0,0 +1,365 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/linkmeAman/universal-middleware/internal/events/publisher"
+	"github.com/linkmeAman/universal-middleware/test/testutil"
+)
+
+func TestRetryDelay(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RetryDelay = time.Second
+	cfg.MaxRetryDelay = 10 * time.Second
+	p := NewProcessor(cfg, nil, nil, testutil.NewTestLogger(t))
+
+	assert.Equal(t, time.Second, p.retryDelay(1))
+	assert.Equal(t, 2*time.Second, p.retryDelay(2))
+	assert.Equal(t, 8*time.Second, p.retryDelay(4))
+	assert.Equal(t, 10*time.Second, p.retryDelay(5))
+	assert.Equal(t, 10*time.Second, p.retryDelay(50))
+}
+
+func TestPollingIntervalWithNotifier(t *testing.T) {
+	cfg := DefaultConfig()
+	assert.Equal(t, cfg.PollingInterval, NewProcessor(cfg, nil, nil, testutil.NewTestLogger(t)).pollingInterval())
+
+	// Retries fall due without a notification, so they bound the interval
+	cfg.Notifier = notifierFunc(nil)
+	assert.Equal(t, cfg.RetryDelay, NewProcessor(cfg, nil, nil, testutil.NewTestLogger(t)).pollingInterval())
+}
+
+func TestPartitionKey(t *testing.T) {
+	msg := &Message{ID: "msg-1", AggregateType: "command", AggregateID: "cmd-1"}
+
+	cfg := DefaultConfig()
+	assert.Equal(t, "msg-1", NewProcessor(cfg, nil, nil, testutil.NewTestLogger(t)).partitionKey(msg))
+
+	cfg.OrderByAggregate = true
+	p := NewProcessor(cfg, nil, nil, testutil.NewTestLogger(t))
+	assert.Equal(t, "cmd-1", p.partitionKey(msg))
+	assert.Equal(t, "msg-2", p.partitionKey(&Message{ID: "msg-2"}))
+}
+
+func TestBatchFailures(t *testing.T) {
+	assert.Empty(t, batchFailures(3, nil))
+
+	// A partial failure only fails the messages it names
+	partial := &publisher.BatchError{Failed: map[int]error{1: errors.New("message too large")}}
+	failed := batchFailures(3, fmt.Errorf("publish: %w", partial))
+	assert.Len(t, failed, 1)
+	assert.EqualError(t, failed[1], "message too large")
+
+	// Anything else, like an aborted transaction, fails the whole batch
+	failed = batchFailures(3, errors.New("transaction aborted"))
+	assert.Len(t, failed, 3)
+	for i := range 3 {
+		assert.EqualError(t, failed[i], "transaction aborted")
+	}
+}
+
+func TestInMemoryRepositoryRetries(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryRepository()
+	msg, err := CreateMessage("command", "cmd-1", "command.received", map[string]string{})
+	require.NoError(t, err)
+	require.NoError(t, repo.Save(ctx, msg))
+
+	require.NoError(t, repo.ScheduleRetry(ctx, msg.ID, "broker down", time.Now().Add(time.Hour)))
+	pending, err := repo.GetPendingMessages(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, pending, "retry is not due yet")
+
+	require.NoError(t, repo.ScheduleRetry(ctx, msg.ID, "broker down", time.Now().Add(-time.Second)))
+	pending, err = repo.GetPendingMessages(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, StatusRetrying, pending[0].Status)
+	assert.Equal(t, 2, pending[0].RetryCount)
+
+	require.NoError(t, repo.MarkAsDead(ctx, msg.ID, "broker down"))
+	pending, err = repo.GetPendingMessages(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	assert.Equal(t, StatusDead, msg.Status)
+	assert.Nil(t, msg.NextAttemptAt)
+}
+
+func TestClaimRuns(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Minute)
+	message := func(id, aggregateID string, status Status, next *time.Time) *Message {
+		return &Message{ID: id, AggregateType: "command", AggregateID: aggregateID, Status: status, NextAttemptAt: next}
+	}
+
+	claimed := claimRuns([]*Message{
+		message("a1", "cmd-a", StatusPending, nil),
+		message("b1", "cmd-b", StatusRetrying, &later),
+		message("a2", "cmd-a", StatusPending, nil),
+		message("b2", "cmd-b", StatusPending, nil),
+		message("n1", "", StatusRetrying, &later),
+		message("n2", "", StatusPending, nil),
+	}, now)
+
+	// cmd-b waits for its retry, messages without an aggregate are not
+	// held back by each other
+	var ids []string
+	for _, msg := range claimed {
+		ids = append(ids, msg.ID)
+	}
+	assert.Equal(t, []string{"a1", "a2", "n2"}, ids)
+}
+
+// failingPublisher fails the messages whose value is in fail
+type failingPublisher struct {
+	mu        sync.Mutex
+	fail      map[string]bool
+	published [][]publisher.Message
+}
+
+func (f *failingPublisher) PublishMessages(ctx context.Context, messages []publisher.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published = append(f.published, messages)
+
+	failed := make(map[int]error)
+	for i, msg := range messages {
+		if f.fail[string(msg.Value)] {
+			failed[i] = errors.New("message too large")
+		}
+	}
+	if len(failed) > 0 {
+		return &publisher.BatchError{Failed: failed}
+	}
+	return nil
+}
+
+// saveMessages saves one message per aggregate ID, with the aggregate ID
+// and a sequence number as payload
+func saveMessages(t *testing.T, repo *InMemoryRepository, aggregateIDs ...string) []*Message {
+	var messages []*Message
+	for i, aggregateID := range aggregateIDs {
+		msg, err := CreateMessage("command", aggregateID, "command.event", fmt.Sprintf("%s-%d", aggregateID, i))
+		require.NoError(t, err)
+		require.NoError(t, repo.Save(context.Background(), msg))
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func TestPublishMessagesPartialFailure(t *testing.T) {
+	for _, ordered := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ordered=%v", ordered), func(t *testing.T) {
+			repo := NewInMemoryRepository()
+			messages := saveMessages(t, repo, "cmd-a", "cmd-a", "cmd-b")
+			pub := &failingPublisher{fail: map[string]bool{`"cmd-a-0"`: true}}
+
+			cfg := DefaultConfig()
+			cfg.OrderByAggregate = ordered
+			p := NewProcessor(cfg, repo, pub, testutil.NewTestLogger(t))
+
+			fetched, published, err := p.processBatch(context.Background(), 10)
+			require.NoError(t, err)
+			assert.Equal(t, 3, fetched)
+
+			assert.Equal(t, StatusRetrying, messages[0].Status)
+			assert.Equal(t, StatusPublished, messages[2].Status)
+			if !ordered {
+				assert.Equal(t, 2, published)
+				assert.Equal(t, StatusPublished, messages[1].Status)
+				return
+			}
+
+			// The aggregate's later message waits for the failed one
+			assert.Equal(t, 1, published)
+			assert.Equal(t, StatusPending, messages[1].Status)
+			assert.Zero(t, messages[1].RetryCount)
+			claimed, err := repo.ClaimOrdered(context.Background(), 10)
+			require.NoError(t, err)
+			assert.Empty(t, claimed)
+		})
+	}
+}
+
+func TestProcessBatchOrderedBlocksAggregate(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryRepository()
+	messages := saveMessages(t, repo, "cmd-a", "cmd-b", "cmd-a", "cmd-b")
+	pub := &failingPublisher{fail: map[string]bool{`"cmd-a-0"`: true}}
+
+	cfg := DefaultConfig()
+	cfg.OrderByAggregate = true
+	cfg.RetryDelay = time.Hour
+	p := NewProcessor(cfg, repo, pub, testutil.NewTestLogger(t))
+
+	_, published, err := p.processBatch(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 2, published)
+
+	// cmd-a is blocked until its retry is due
+	fetched, _, err := p.processBatch(ctx, 10)
+	require.NoError(t, err)
+	assert.Zero(t, fetched)
+
+	delete(pub.fail, `"cmd-a-0"`)
+	due := time.Now().Add(-time.Second)
+	messages[0].NextAttemptAt = &due
+
+	_, published, err = p.processBatch(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 2, published)
+	last := pub.published[len(pub.published)-1]
+	require.Len(t, last, 2)
+	assert.JSONEq(t, `"cmd-a-0"`, string(last[0].Value))
+	assert.JSONEq(t, `"cmd-a-2"`, string(last[1].Value))
+	assert.Equal(t, "cmd-a", last[0].Key)
+	for _, msg := range messages {
+		assert.Equal(t, StatusPublished, msg.Status)
+	}
+}
+
+func TestProcessBatchOrderedSkipsDeadMessage(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryRepository()
+	messages := saveMessages(t, repo, "cmd-a", "cmd-a")
+	pub := &failingPublisher{fail: map[string]bool{`"cmd-a-0"`: true}}
+
+	cfg := DefaultConfig()
+	cfg.OrderByAggregate = true
+	cfg.MaxRetries = 0
+	p := NewProcessor(cfg, repo, pub, testutil.NewTestLogger(t))
+
+	_, published, err := p.processBatch(ctx, 10)
+	require.NoError(t, err)
+	assert.Zero(t, published)
+	assert.Equal(t, StatusDead, messages[0].Status)
+
+	// A dead message no longer holds back its aggregate
+	_, published, err = p.processBatch(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, published)
+	assert.Equal(t, StatusPublished, messages[1].Status)
+}
+
+type notifierFunc func(ctx context.Context, channel string, notify func(string)) error
+
+func (f notifierFunc) Listen(ctx context.Context, channel string, notify func(string)) error {
+	return f(ctx, channel, notify)
+}
+
+func TestListenReconnects(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var attempts atomic.Int32
+	calls := make(chan struct{}, 10)
+	cfg := DefaultConfig()
+	cfg.RetryDelay = time.Millisecond
+	cfg.Notifier = notifierFunc(func(ctx context.Context, channel string, notify func(string)) error {
+		calls <- struct{}{}
+		if attempts.Add(1) < 3 {
+			return errors.New("connection reset")
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	p := NewProcessor(cfg, nil, nil, testutil.NewTestLogger(t))
+
+	wake := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		p.listen(ctx, wake)
+		close(done)
+	}()
+
+	// Every connect wakes the processor for messages written meanwhile
+	for range 3 {
+		select {
+		case <-calls:
+		case <-time.After(time.Second):
+			t.Fatal("listener did not reconnect")
+		}
+	}
+	<-wake
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("listener did not stop")
+	}
+}
+
+func TestListenFoldsNotifications(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listening := make(chan struct{})
+	cfg := DefaultConfig()
+	cfg.Notifier = notifierFunc(func(ctx context.Context, channel string, notify func(string)) error {
+		assert.Equal(t, NotifyChannel, channel)
+		for range 5 {
+			notify("{}")
+		}
+		close(listening)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	p := NewProcessor(cfg, nil, nil, testutil.NewTestLogger(t))
+
+	wake := make(chan struct{}, 1)
+	go p.listen(ctx, wake)
+	<-listening
+
+	// Notifications arriving during a drain wake the processor once
+	assert.Len(t, wake, 1)
+}
+
+func TestDrainDoublesBatchSize(t *testing.T) {
+	repo := NewInMemoryRepository()
+	aggregateIDs := make([]string, 20)
+	for i := range aggregateIDs {
+		aggregateIDs[i] = fmt.Sprintf("cmd-%d", i)
+	}
+	saveMessages(t, repo, aggregateIDs...)
+	pub := &failingPublisher{}
+
+	cfg := DefaultConfig()
+	cfg.BatchSize = 2
+	cfg.MaxBatchSize = 8
+	p := NewProcessor(cfg, repo, pub, testutil.NewTestLogger(t))
+	p.drain(context.Background(), "poll")
+
+	// Full batches double the next one up to MaxBatchSize, a short one ends
+	// the drain
+	var sizes []int
+	for _, batch := range pub.published {
+		sizes = append(sizes, len(batch))
+	}
+	assert.Equal(t, []int{2, 4, 8, 6}, sizes)
+}
+
+func TestDrainStopsWhenNothingPublished(t *testing.T) {
+	repo := NewInMemoryRepository()
+	saveMessages(t, repo, "cmd-a", "cmd-b", "cmd-c", "cmd-d")
+	pub := &failingPublisher{fail: map[string]bool{
+		`"cmd-a-0"`: true, `"cmd-b-1"`: true, `"cmd-c-2"`: true, `"cmd-d-3"`: true,
+	}}
+
+	cfg := DefaultConfig()
+	cfg.BatchSize = 2
+	p := NewProcessor(cfg, repo, pub, testutil.NewTestLogger(t))
+	p.drain(context.Background(), "poll")
+
+	// A broken broker is not hammered with the rest of the outbox
+	assert.Len(t, pub.published, 1)
+}
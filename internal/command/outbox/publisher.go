@@ -0,0 +1,35 @@
+package outbox
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/linkmeAman/universal-middleware/internal/events/publisher"
+	"github.com/linkmeAman/universal-middleware/pkg/logger"
+)
+
+// NewTransactionalPublisher creates a producer publishing every outbox batch
+// in a Kafka transaction, so consumers reading committed messages see a
+// batch completely or not at all. The producer can only be used by the
+// outbox processor.
+//
+// Kafka fences off every producer but the latest one sharing a
+// transactional ID, so the ID must be unique per relay: the name of the
+// relaying service and the host name are appended to transactionalID.
+func NewTransactionalPublisher(brokers []string, transactionalID, relay string, log *logger.Logger) (*publisher.Producer, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get host name: %w", err)
+	}
+
+	return publisher.NewProducer(publisher.ProducerConfig{
+		Brokers:           brokers,
+		RequiredAcks:      sarama.WaitForAll,
+		MaxRetries:        3,
+		RetryBackoff:      100 * time.Millisecond,
+		ConnectionTimeout: 10 * time.Second,
+		TransactionalID:   transactionalID + "-" + relay + "-" + hostname,
+	}, log)
+}
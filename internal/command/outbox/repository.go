@@ -21,7 +21,11 @@ type Status string
 const (
 	StatusPending   Status = "pending"
 	StatusPublished Status = "published"
-	StatusFailed    Status = "failed"
+	// StatusRetrying messages failed to publish and are due again at
+	// their NextAttemptAt
+	StatusRetrying Status = "retrying"
+	// StatusDead messages exhausted their retries and are not published
+	StatusDead Status = "dead"
 )
 
 // Message represents an outbox message
@@ -36,12 +40,20 @@ type Message struct {
 	CreatedAt     time.Time       `json:"createdAt"`
 	PublishedAt   *time.Time      `json:"publishedAt,omitempty"`
 	RetryCount    int             `json:"retryCount"`
+	NextAttemptAt *time.Time      `json:"nextAttemptAt,omitempty"`
 	ErrorMessage  string          `json:"errorMessage,omitempty"`
 }
 
+// querier runs statements on the pool or inside a transaction
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (database.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (database.Rows, error)
+}
+
 // Repository handles outbox message persistence
 type Repository struct {
 	db     database.DB
+	q      querier
 	log    *logger.Logger
 	tracer trace.Tracer
 }
@@ -50,11 +62,32 @@ type Repository struct {
 func NewRepository(db database.DB, log *logger.Logger) *Repository {
 	return &Repository{
 		db:     db,
+		q:      db,
 		log:    log,
 		tracer: otel.GetTracerProvider().Tracer("outbox-repository"),
 	}
 }
 
+// InTx calls fn with a repository running its statements in one
+// transaction, which is committed when fn returns nil and rolled back
+// otherwise. Rows claimed through it stay locked until then.
+func (r *Repository) InTx(ctx context.Context, fn func(tx Store) error) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(&Repository{db: r.db, q: tx, log: r.log, tracer: r.tracer}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
 // Save stores a new message in the outbox
 func (r *Repository) Save(ctx context.Context, msg *Message) error {
 	ctx, span := r.tracer.Start(ctx, "outbox.save",
@@ -72,7 +105,7 @@ func (r *Repository) Save(ctx context.Context, msg *Message) error {
 			retry_count
 		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
 
-	_, err := r.db.Exec(ctx, query,
+	_, err := r.q.Exec(ctx, query,
 		msg.ID, msg.AggregateType, msg.AggregateID, msg.EventType,
 		msg.Payload, msg.Topic, msg.Status, msg.CreatedAt,
 		msg.RetryCount,
@@ -89,7 +122,9 @@ func (r *Repository) Save(ctx context.Context, msg *Message) error {
 	return nil
 }
 
-// GetPendingMessages retrieves pending messages for processing
+// GetPendingMessages retrieves pending messages and retrying messages that
+// are due for processing. Called inside InTx they stay locked, and are
+// skipped by other relays, until the transaction ends.
 func (r *Repository) GetPendingMessages(ctx context.Context, limit int) ([]*Message, error) {
 	ctx, span := r.tracer.Start(ctx, "outbox.get_pending",
 		trace.WithAttributes(
@@ -101,17 +136,143 @@ func (r *Repository) GetPendingMessages(ctx context.Context, limit int) ([]*Mess
 	query := `
 		SELECT id, aggregate_type, aggregate_id, event_type,
 			   payload, topic, status, created_at, published_at,
-			   retry_count, error_message
+			   retry_count, next_attempt_at, COALESCE(error_message, '')
 		FROM outbox_messages
 		WHERE status = $1
+		   OR (status = $2 AND next_attempt_at <= NOW())
 		ORDER BY created_at ASC
-		LIMIT $2
+		LIMIT $3
 		FOR UPDATE SKIP LOCKED`
 
-	rows, err := r.db.Query(ctx, query, StatusPending, limit)
+	rows, err := r.q.Query(ctx, query, StatusPending, StatusRetrying, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query pending messages: %w", err)
 	}
+	return scanMessages(rows)
+}
+
+// ClaimOrdered claims up to limit messages such that every aggregate's
+// messages are published strictly in the order they were written. For each
+// aggregate whose oldest unpublished message is due, that message is
+// locked, together with the messages queued behind it, returned in
+// sequence. Messages behind a retrying one are held back until it is
+// published or dead. Messages without an aggregate ID are not ordered, each
+// is claimed on its own. Call it inside InTx: the lock on the oldest message
+// keeps other relays off the whole aggregate until the transaction ends.
+func (r *Repository) ClaimOrdered(ctx context.Context, limit int) ([]*Message, error) {
+	ctx, span := r.tracer.Start(ctx, "outbox.claim_ordered",
+		trace.WithAttributes(
+			attribute.Int("limit", limit),
+		),
+	)
+	defer span.End()
+
+	heads := `
+		SELECT m.id, m.aggregate_type, m.aggregate_id
+		FROM outbox_messages m
+		WHERE (m.status = $1 OR (m.status = $2 AND m.next_attempt_at <= NOW()))
+		  AND (m.aggregate_id = '' OR NOT EXISTS (
+			SELECT 1 FROM outbox_messages p
+			WHERE p.aggregate_type = m.aggregate_type
+			  AND p.aggregate_id = m.aggregate_id
+			  AND p.status IN ($1, $2)
+			  AND p.sequence < m.sequence
+		  ))
+		ORDER BY m.sequence ASC
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED`
+
+	rows, err := r.q.Query(ctx, heads, StatusPending, StatusRetrying, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim aggregates: %w", err)
+	}
+	var types, ids, unordered []string
+	for rows.Next() {
+		var id, aggregateType, aggregateID string
+		if err := rows.Scan(&id, &aggregateType, &aggregateID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan aggregate: %w", err)
+		}
+		if aggregateID == "" {
+			unordered = append(unordered, id)
+			continue
+		}
+		types = append(types, aggregateType)
+		ids = append(ids, aggregateID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating aggregates: %w", err)
+	}
+	if len(ids) == 0 && len(unordered) == 0 {
+		return nil, nil
+	}
+	span.SetAttributes(
+		attribute.Int("aggregates", len(ids)),
+		attribute.Int("unordered", len(unordered)),
+	)
+
+	query := `
+		SELECT id, aggregate_type, aggregate_id, event_type,
+			   payload, topic, status, created_at, published_at,
+			   retry_count, next_attempt_at, COALESCE(error_message, '')
+		FROM outbox_messages
+		WHERE status IN ($1, $2)
+		  AND ((aggregate_id <> '' AND (aggregate_type, aggregate_id) IN (
+				SELECT * FROM unnest($3::text[], $4::text[])
+			))
+			OR id = ANY($5::uuid[]))
+		ORDER BY sequence ASC
+		LIMIT $6`
+
+	rows, err = r.q.Query(ctx, query, StatusPending, StatusRetrying, types, ids, unordered, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query claimed messages: %w", err)
+	}
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	return claimRuns(messages, time.Now()), nil
+}
+
+// claimRuns cuts every aggregate's run of messages, in sequence, at the
+// first message that is not due at now. Messages without an aggregate ID
+// are runs of their own.
+func claimRuns(messages []*Message, now time.Time) []*Message {
+	blocked := make(map[orderKey]bool)
+	claimed := messages[:0]
+	for _, msg := range messages {
+		key := orderKeyOf(msg)
+		if blocked[key] {
+			continue
+		}
+		if msg.Status == StatusRetrying && msg.NextAttemptAt != nil && msg.NextAttemptAt.After(now) {
+			blocked[key] = true
+			continue
+		}
+		claimed = append(claimed, msg)
+	}
+	return claimed
+}
+
+// orderKey identifies the messages that are published in sequence
+type orderKey struct {
+	aggregateType string
+	aggregateID   string
+	messageID     string
+}
+
+// orderKeyOf keys a message by its aggregate, or by its own ID when it has
+// no aggregate ID and is not ordered
+func orderKeyOf(msg *Message) orderKey {
+	if msg.AggregateID == "" {
+		return orderKey{messageID: msg.ID}
+	}
+	return orderKey{aggregateType: msg.AggregateType, aggregateID: msg.AggregateID}
+}
+
+func scanMessages(rows database.Rows) ([]*Message, error) {
 	defer rows.Close()
 
 	var messages []*Message
@@ -120,7 +281,7 @@ func (r *Repository) GetPendingMessages(ctx context.Context, limit int) ([]*Mess
 		err := rows.Scan(
 			&msg.ID, &msg.AggregateType, &msg.AggregateID, &msg.EventType,
 			&msg.Payload, &msg.Topic, &msg.Status, &msg.CreatedAt, &msg.PublishedAt,
-			&msg.RetryCount, &msg.ErrorMessage,
+			&msg.RetryCount, &msg.NextAttemptAt, &msg.ErrorMessage,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan message: %w", err)
@@ -128,7 +289,7 @@ func (r *Repository) GetPendingMessages(ctx context.Context, limit int) ([]*Mess
 		messages = append(messages, msg)
 	}
 
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating messages: %w", err)
 	}
 
@@ -150,7 +311,7 @@ func (r *Repository) MarkAsPublished(ctx context.Context, messageID string) erro
 		SET status = $1, published_at = $2
 		WHERE id = $3`
 
-	result, err := r.db.Exec(ctx, query, StatusPublished, now, messageID)
+	result, err := r.q.Exec(ctx, query, StatusPublished, now, messageID)
 	if err != nil {
 		return fmt.Errorf("failed to mark message as published: %w", err)
 	}
@@ -162,9 +323,63 @@ func (r *Repository) MarkAsPublished(ctx context.Context, messageID string) erro
 	return nil
 }
 
-// MarkAsFailed marks a message as failed with error details
-func (r *Repository) MarkAsFailed(ctx context.Context, messageID string, errorMsg string) error {
-	ctx, span := r.tracer.Start(ctx, "outbox.mark_failed",
+// MarkBatchPublished marks the messages of a published batch in one statement
+func (r *Repository) MarkBatchPublished(ctx context.Context, messageIDs []string) error {
+	ctx, span := r.tracer.Start(ctx, "outbox.mark_batch_published",
+		trace.WithAttributes(
+			attribute.Int("batch.size", len(messageIDs)),
+		),
+	)
+	defer span.End()
+
+	query := `
+		UPDATE outbox_messages 
+		SET status = $1, published_at = NOW(), next_attempt_at = NULL
+		WHERE id = ANY($2)`
+
+	result, err := r.q.Exec(ctx, query, StatusPublished, messageIDs)
+	if err != nil {
+		return fmt.Errorf("failed to mark messages as published: %w", err)
+	}
+
+	if result.RowsAffected() != int64(len(messageIDs)) {
+		return fmt.Errorf("marked %d of %d messages as published", result.RowsAffected(), len(messageIDs))
+	}
+
+	return nil
+}
+
+// ScheduleRetry records a failed publish attempt and schedules the next one
+func (r *Repository) ScheduleRetry(ctx context.Context, messageID string, errorMsg string, nextAttemptAt time.Time) error {
+	ctx, span := r.tracer.Start(ctx, "outbox.schedule_retry",
+		trace.WithAttributes(
+			attribute.String("message.id", messageID),
+			attribute.String("error", errorMsg),
+		),
+	)
+	defer span.End()
+
+	query := `
+		UPDATE outbox_messages 
+		SET status = $1, error_message = $2, retry_count = retry_count + 1, next_attempt_at = $3
+		WHERE id = $4`
+
+	result, err := r.q.Exec(ctx, query, StatusRetrying, errorMsg, nextAttemptAt, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to schedule message retry: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("no message found with ID: %s", messageID)
+	}
+
+	return nil
+}
+
+// MarkAsDead records the last failed publish attempt of a message that
+// will not be retried
+func (r *Repository) MarkAsDead(ctx context.Context, messageID string, errorMsg string) error {
+	ctx, span := r.tracer.Start(ctx, "outbox.mark_dead",
 		trace.WithAttributes(
 			attribute.String("message.id", messageID),
 			attribute.String("error", errorMsg),
@@ -174,12 +389,12 @@ func (r *Repository) MarkAsFailed(ctx context.Context, messageID string, errorMs
 
 	query := `
 		UPDATE outbox_messages 
-		SET status = $1, error_message = $2, retry_count = retry_count + 1
+		SET status = $1, error_message = $2, retry_count = retry_count + 1, next_attempt_at = NULL
 		WHERE id = $3`
 
-	result, err := r.db.Exec(ctx, query, StatusFailed, errorMsg, messageID)
+	result, err := r.q.Exec(ctx, query, StatusDead, errorMsg, messageID)
 	if err != nil {
-		return fmt.Errorf("failed to mark message as failed: %w", err)
+		return fmt.Errorf("failed to mark message as dead: %w", err)
 	}
 
 	if result.RowsAffected() == 0 {
@@ -204,7 +419,7 @@ func (r *Repository) CleanupPublishedMessages(ctx context.Context, olderThan tim
 		WHERE status = $1 
 		AND published_at < $2`
 
-	result, err := r.db.Exec(ctx, query, StatusPublished, cutoff)
+	result, err := r.q.Exec(ctx, query, StatusPublished, cutoff)
 	if err != nil {
 		return 0, fmt.Errorf("failed to cleanup messages: %w", err)
 	}
@@ -2,11 +2,15 @@ package command
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/linkmeAman/universal-middleware/pkg/logger"
+	"github.com/linkmeAman/universal-middleware/pkg/metrics"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
@@ -21,14 +25,35 @@ type Handler interface {
 
 // Processor manages command processing
 type Processor struct {
-	handlers   map[string][]Handler
-	validator  CommandValidator
-	log        *logger.Logger
-	tracer     trace.Tracer
-	workerPool chan struct{}
-	mu         sync.RWMutex
-	ctx        context.Context
-	cancel     context.CancelFunc
+	handlers  map[string][]Handler
+	validator CommandValidator
+	log       *logger.Logger
+	tracer    trace.Tracer
+	queue     *dispatchQueue
+	metrics   *metrics.Metrics
+	mu        sync.RWMutex
+
+	defaultTimeout    time.Duration
+	retryPolicies     *RetryPolicies
+	attempts          AttemptStore
+	leases            LeaseStore
+	completions       *Completions
+	orderByEntity     bool
+	entityLocks       EntityLocker
+	entityLockTTL     time.Duration
+	leaseTTL          time.Duration
+	heartbeatInterval time.Duration
+	workerID          string
+	middleware        []Middleware
+	encryptor         *FieldEncryptor
+	sensitive         *SensitiveFields
+
+	// running holds the cancel functions of queued and running commands
+	running   map[string]context.CancelCauseFunc
+	runningMu sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // ProcessorConfig holds configuration for the command processor
@@ -36,35 +61,179 @@ type ProcessorConfig struct {
 	MaxWorkers     int
 	QueueSize      int
 	DefaultTimeout time.Duration
+
+	// PriorityWeights sets the relative share of workers each priority gets
+	// while several priorities are queued. Missing priorities keep their
+	// DefaultPriorityWeights value.
+	PriorityWeights map[Priority]int
+
+	// MaxQueueWait is how long a command can wait before it is dispatched
+	// ahead of higher priorities
+	MaxQueueWait time.Duration
+
+	// Metrics receives queue depth, wait time and rejection metrics when set
+	Metrics *metrics.Metrics
+
+	// RetryPolicies sets the backoff between attempts per command type,
+	// defaults to DefaultBackoffPolicy for every type
+	RetryPolicies *RetryPolicies
+
+	// Attempts, when set, receives a record of every attempt
+	Attempts AttemptStore
+
+	// Schemas validates command payloads, defaults to DefaultSchemaRegistry
+	Schemas *SchemaRegistry
+
+	// Leases, when set, records which worker runs a command so the Reaper can
+	// recover commands whose worker died. The lease is renewed every
+	// HeartbeatInterval and expires LeaseTTL after the last renewal.
+	Leases            LeaseStore
+	LeaseTTL          time.Duration
+	HeartbeatInterval time.Duration
+
+	// WorkerID identifies this processor as lease owner, defaults to the host
+	// name with a random suffix
+	WorkerID string
+
+	// Completions, when set, announces finished commands to long-polling
+	// callers and delivers their callbacks
+	Completions *Completions
+
+	// OrderByEntity runs commands with the same EntityID one at a time and in
+	// the order they were submitted. Commands of different entities still
+	// run in parallel.
+	OrderByEntity bool
+
+	// EntityLocks, when set, also keeps commands of the same entity from
+	// running at the same time on different processors. Locks are extended
+	// while the command runs and expire EntityLockTTL after the last renewal.
+	EntityLocks   EntityLocker
+	EntityLockTTL time.Duration
+
+	// Middleware is composed around every handler, the first entry is the
+	// outermost. More can be added with Use.
+	Middleware []Middleware
+
+	// Encryptor, when set, seals the sensitive payload fields of commands
+	// before they are stored. Handlers always see the plain payload.
+	Encryptor *FieldEncryptor
 }
 
-// NewProcessor creates a new command processor
+const (
+	defaultMaxWorkers   = 10
+	defaultQueueSize    = 1000
+	defaultMaxQueueWait = 30 * time.Second
+	defaultLeaseTTL     = 30 * time.Second
+
+	// releaseTimeout bounds writing the outcome of a command whose own
+	// context may already be cancelled
+	releaseTimeout = 5 * time.Second
+)
+
+// NewProcessor creates a new command processor and starts its workers
 func NewProcessor(cfg ProcessorConfig, log *logger.Logger) *Processor {
+	if cfg.MaxWorkers <= 0 {
+		cfg.MaxWorkers = defaultMaxWorkers
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	if cfg.MaxQueueWait <= 0 {
+		cfg.MaxQueueWait = defaultMaxQueueWait
+	}
+	if cfg.LeaseTTL <= 0 {
+		cfg.LeaseTTL = defaultLeaseTTL
+	}
+	if cfg.HeartbeatInterval <= 0 || cfg.HeartbeatInterval >= cfg.LeaseTTL {
+		cfg.HeartbeatInterval = cfg.LeaseTTL / 3
+	}
+	if cfg.EntityLockTTL <= 0 {
+		cfg.EntityLockTTL = defaultEntityLockTTL
+	}
+	if cfg.RetryPolicies == nil {
+		cfg.RetryPolicies = NewRetryPolicies(DefaultBackoffPolicy())
+	}
+	if cfg.Schemas == nil {
+		cfg.Schemas = DefaultSchemaRegistry()
+	}
+	if cfg.WorkerID == "" {
+		host, _ := os.Hostname()
+		cfg.WorkerID = fmt.Sprintf("%s-%s", host, uuid.New().String()[:8])
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
-	return &Processor{
-		handlers:   make(map[string][]Handler),
-		validator:  NewValidator(),
-		log:        log,
-		tracer:     otel.GetTracerProvider().Tracer("command-processor"),
-		workerPool: make(chan struct{}, cfg.MaxWorkers),
-		ctx:        ctx,
-		cancel:     cancel,
+	p := &Processor{
+		handlers:  make(map[string][]Handler),
+		validator: NewSchemaValidator(cfg.Schemas),
+		log:       log,
+		tracer:    otel.GetTracerProvider().Tracer("command-processor"),
+		queue:     newDispatchQueue(cfg.QueueSize, cfg.PriorityWeights, cfg.MaxQueueWait),
+		metrics:   cfg.Metrics,
+		running:   make(map[string]context.CancelCauseFunc),
+
+		defaultTimeout:    cfg.DefaultTimeout,
+		retryPolicies:     cfg.RetryPolicies,
+		attempts:          cfg.Attempts,
+		leases:            cfg.Leases,
+		completions:       cfg.Completions,
+		orderByEntity:     cfg.OrderByEntity,
+		entityLocks:       cfg.EntityLocks,
+		entityLockTTL:     cfg.EntityLockTTL,
+		leaseTTL:          cfg.LeaseTTL,
+		heartbeatInterval: cfg.HeartbeatInterval,
+		workerID:          cfg.WorkerID,
+		middleware:        cfg.Middleware,
+		encryptor:         cfg.Encryptor,
+		sensitive:         DefaultSensitiveFields(),
+
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	if cfg.Encryptor != nil {
+		p.sensitive = cfg.Encryptor.Fields()
+	}
+
+	for i := 0; i < cfg.MaxWorkers; i++ {
+		go p.worker()
 	}
+
+	return p
 }
 
-// RegisterHandler registers a handler for specific command types
+// RegisterHandler registers a handler for every common command type it can
+// handle. Handlers of other types are registered with RegisterHandlerFor.
 func (p *Processor) RegisterHandler(handler Handler) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	for cmdType := range p.handlers {
+	for _, cmdType := range commandTypes {
 		if handler.CanHandle(cmdType) {
 			p.handlers[cmdType] = append(p.handlers[cmdType], handler)
 		}
 	}
 }
 
-// Process handles a command
+// RegisterHandlerFor registers a handler for a command type
+func (p *Processor) RegisterHandlerFor(cmdType string, handler Handler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.handlers[cmdType] = append(p.handlers[cmdType], handler)
+}
+
+// Use adds middleware composed around every handler after the middleware
+// already installed
+func (p *Processor) Use(middleware ...Middleware) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.middleware = append(p.middleware, middleware...)
+}
+
+// Process validates a command, queues it by priority and waits for a worker
+// to run it. It returns ErrQueueFull without queueing when the queue is at
+// capacity.
 func (p *Processor) Process(ctx context.Context, cmd *Command) error {
 	ctx, span := p.tracer.Start(ctx, "process_command",
 		trace.WithAttributes(
@@ -75,11 +244,26 @@ func (p *Processor) Process(ctx context.Context, cmd *Command) error {
 	)
 	defer span.End()
 
+	// Commands are stored sealed, only their validation and handlers see the
+	// plain payload
+	plain, err := p.sealCommand(ctx, cmd)
+	if err != nil {
+		p.log.Error("Command payload encryption failed",
+			zap.String("command_id", cmd.ID),
+			zap.String("command_type", cmd.Type),
+			zap.Error(err),
+		)
+		cmd.SetError("ENCRYPTION_ERROR", err.Error(), "")
+		cmd.Status = StatusFailed
+		return err
+	}
+
 	// Validate command
-	if err := p.validator.ValidateCommand(ctx, cmd); err != nil {
+	if err := p.validator.ValidateCommand(ctx, plain); err != nil {
 		p.log.Error("Command validation failed",
 			zap.String("command_id", cmd.ID),
 			zap.String("command_type", cmd.Type),
+			p.sensitive.LogField(plain),
 			zap.Error(err),
 		)
 		cmd.SetError("VALIDATION_ERROR", err.Error(), "")
@@ -87,22 +271,242 @@ func (p *Processor) Process(ctx context.Context, cmd *Command) error {
 		return fmt.Errorf("command validation failed: %w", err)
 	}
 
-	// Acquire worker from pool
+	if p.ctx.Err() != nil {
+		return ErrProcessorStopped
+	}
+
+	// Register the command so Cancel can interrupt it while queued or running
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	p.track(cmd.ID, cancel)
+	defer p.untrack(cmd.ID)
+
+	item := &queueItem{
+		ctx:        ctx,
+		cmd:        cmd,
+		lane:       laneFor(cmd.Priority),
+		enqueuedAt: time.Now(),
+		done:       make(chan error, 1),
+	}
+	if p.orderByEntity {
+		item.entity = cmd.EntityID
+	}
+
+	if err := p.queue.push(item); err != nil {
+		p.log.Warn("Command rejected",
+			zap.String("command_id", cmd.ID),
+			zap.String("command_type", cmd.Type),
+			zap.String("priority", item.lane.String()),
+			zap.Error(err),
+		)
+		if p.metrics != nil {
+			p.metrics.CommandQueueRejected.WithLabelValues(item.lane.String()).Inc()
+		}
+		return err
+	}
+	p.observeQueueDepth(item.lane)
+
+	// Wait for a worker to run the command. Once a worker has picked it up the
+	// result is always awaited, so the command is never left half-processed.
 	select {
-	case p.workerPool <- struct{}{}:
-		defer func() { <-p.workerPool }()
+	case err := <-item.done:
+		return err
 	case <-ctx.Done():
-		return ctx.Err()
+		if p.queue.remove(item) {
+			p.observeQueueDepth(item.lane)
+			return p.abandon(ctx, cmd)
+		}
+	case <-p.ctx.Done():
+		if p.queue.remove(item) {
+			p.observeQueueDepth(item.lane)
+			return ErrProcessorStopped
+		}
+	}
+	return <-item.done
+}
+
+// worker runs queued commands until the processor is stopped
+func (p *Processor) worker() {
+	for {
+		item, err := p.queue.pop(p.ctx)
+		if err != nil {
+			return
+		}
+
+		wait := time.Since(item.enqueuedAt)
+		p.observeQueueDepth(item.lane)
+		if p.metrics != nil {
+			p.metrics.CommandQueueWait.WithLabelValues(item.lane.String()).Observe(wait.Seconds())
+		}
+
+		if item.ctx.Err() != nil {
+			p.queue.finish(item)
+			item.done <- p.abandon(item.ctx, item.cmd)
+			continue
+		}
+
+		trace.SpanFromContext(item.ctx).SetAttributes(
+			attribute.Int64("command.queue_wait_ms", wait.Milliseconds()),
+		)
+		err = p.execute(item.ctx, item.cmd)
+		p.queue.finish(item)
+		item.done <- err
+	}
+}
+
+// execute runs a command under its deadline, holding the lock on its entity
+// when entity locks are configured and, when a lease store is configured,
+// under a lease that is renewed until the handlers return
+func (p *Processor) execute(ctx context.Context, cmd *Command) error {
+	timeout := cmd.TimeoutAfter
+	if timeout <= 0 {
+		timeout = p.defaultTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, ErrCommandTimeout)
+		defer cancel()
+	}
+
+	// Wait for commands of the same entity running on other processors
+	if p.entityLocks != nil && cmd.EntityID != "" {
+		var unlock func()
+		var err error
+		ctx, unlock, err = p.lockEntity(ctx, cmd)
+		if err != nil {
+			if ctx.Err() != nil {
+				return p.abandon(ctx, cmd)
+			}
+			p.log.Warn("Failed to lock command entity",
+				zap.String("command_id", cmd.ID),
+				zap.String("entity_id", cmd.EntityID),
+				zap.Error(err),
+			)
+			return err
+		}
+		defer unlock()
 	}
 
 	// Update command status
 	cmd.Status = StatusProcessing
 	cmd.ProcessedAt = p.now()
 	cmd.UpdatedAt = *cmd.ProcessedAt
+	attempt := cmd.RetryCount + 1
+
+	if p.leases == nil {
+		err := p.runHandlers(ctx, cmd)
+		p.recordAttempt(ctx, cmd, attempt)
+		p.completions.Notify(context.WithoutCancel(ctx), cmd)
+		return err
+	}
+
+	if err := p.leases.Acquire(ctx, cmd, p.workerID, p.leaseTTL); err != nil {
+		p.log.Warn("Failed to acquire command lease",
+			zap.String("command_id", cmd.ID),
+			zap.String("worker_id", p.workerID),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	leaseCtx, stopHeartbeat := p.heartbeat(ctx, cmd.ID)
+	err := p.runHandlers(leaseCtx, cmd)
+	stopHeartbeat()
+
+	// The reaper has taken the command over, its outcome is no longer ours to write
+	if errors.Is(err, ErrLeaseLost) {
+		return err
+	}
+
+	releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), releaseTimeout)
+	defer cancel()
+	p.recordAttempt(releaseCtx, cmd, attempt)
+	if relErr := p.leases.Release(releaseCtx, cmd, p.workerID); relErr != nil {
+		p.log.Error("Failed to release command lease",
+			zap.String("command_id", cmd.ID),
+			zap.String("status", string(cmd.Status)),
+			zap.Error(relErr),
+		)
+	} else {
+		p.completions.Notify(releaseCtx, cmd)
+	}
+
+	return err
+}
+
+// heartbeat renews the lease of a running command until the returned stop
+// function is called. If the lease is lost the returned context is cancelled
+// with ErrLeaseLost so the handlers stop working on a command they no longer own.
+func (p *Processor) heartbeat(ctx context.Context, commandID string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(p.heartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := p.leases.Renew(ctx, commandID, p.workerID, p.leaseTTL)
+			if errors.Is(err, ErrLeaseLost) {
+				p.log.Warn("Command lease lost",
+					zap.String("command_id", commandID),
+					zap.String("worker_id", p.workerID),
+				)
+				cancel(ErrLeaseLost)
+				return
+			}
+			if err != nil {
+				// Keep trying, the lease only expires after LeaseTTL
+				p.log.Warn("Failed to renew command lease",
+					zap.String("command_id", commandID),
+					zap.Error(err),
+				)
+			}
+		}
+	}()
+
+	return ctx, func() {
+		close(done)
+		<-stopped
+		cancel(nil)
+	}
+}
+
+// sealCommand seals the sensitive fields of a command that are still plain
+// and returns a copy of it with every sealed field opened
+func (p *Processor) sealCommand(ctx context.Context, cmd *Command) (*Command, error) {
+	if p.encryptor == nil {
+		return cmd, nil
+	}
+	if err := p.encryptor.Encrypt(ctx, cmd); err != nil {
+		return nil, err
+	}
+	payload, err := p.encryptor.Decrypt(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	plain := *cmd
+	plain.Payload = payload
+	return &plain, nil
+}
 
+// runHandlers runs the registered handlers for a command
+func (p *Processor) runHandlers(ctx context.Context, cmd *Command) error {
 	// Get handlers for command type
 	p.mu.RLock()
 	handlers := p.handlers[cmd.Type]
+	middleware := p.middleware
 	p.mu.RUnlock()
 
 	if len(handlers) == 0 {
@@ -112,25 +516,70 @@ func (p *Processor) Process(ctx context.Context, cmd *Command) error {
 		return err
 	}
 
+	// Handlers get the plain payload, the sealed one is restored before the
+	// outcome is stored
+	if p.encryptor != nil {
+		payload, err := p.encryptor.Decrypt(ctx, cmd)
+		if err != nil {
+			// A tampered field or a lost key will not heal with retries
+			if errors.Is(err, ErrDecryptionFailed) || errors.Is(err, ErrUnknownKey) {
+				err = Permanent(err)
+			}
+			p.fail(cmd, err, "DECRYPTION_FAILED", "")
+			return err
+		}
+		sealed := cmd.Payload
+		cmd.Payload = payload
+		defer func() { cmd.Payload = sealed }()
+	}
+
 	// Execute handlers
 	var lastErr error
 	for _, handler := range handlers {
-		if err := handler.HandleCommand(ctx, cmd); err != nil {
-			p.log.Error("Handler failed",
-				zap.String("command_id", cmd.ID),
-				zap.String("command_type", cmd.Type),
-				zap.String("handler", fmt.Sprintf("%T", handler)),
-				zap.Error(err),
-			)
-			lastErr = err
-			cmd.SetError("HANDLER_ERROR", err.Error(), fmt.Sprintf("Handler: %T", handler))
-
-			if cmd.IsRetryable() {
-				cmd.Status = StatusRetrying
-				cmd.RetryCount++
-				cmd.ScheduledFor = p.calculateNextRetry(cmd)
-			} else {
-				cmd.Status = StatusFailed
+		handlerName := handlerName(handler)
+		run := chain(handler.HandleCommand, middleware)
+		if err := run(context.WithValue(ctx, handlerNameKey{}, handlerName), cmd); err != nil {
+
+			switch cause := context.Cause(ctx); {
+			case errors.Is(cause, ErrCommandCancelled):
+				p.log.Info("Handler stopped by cancellation",
+					zap.String("command_id", cmd.ID),
+					zap.String("command_type", cmd.Type),
+					zap.String("handler", handlerName),
+				)
+				return p.abandon(ctx, cmd)
+			case errors.Is(cause, ErrLeaseLost):
+				return ErrLeaseLost
+			case errors.Is(cause, ErrEntityLockLost):
+				// Another processor may be running a command of the entity,
+				// try again once it is done
+				p.fail(cmd, ErrEntityLockLost, "ENTITY_LOCK_LOST", fmt.Sprintf("Handler: %s", handlerName))
+				return ErrEntityLockLost
+			case errors.Is(cause, ErrCommandTimeout):
+				p.log.Error("Handler timed out",
+					zap.String("command_id", cmd.ID),
+					zap.String("command_type", cmd.Type),
+					zap.String("handler", handlerName),
+				)
+				lastErr = ErrCommandTimeout
+				p.fail(cmd, ErrCommandTimeout, "TIMEOUT", fmt.Sprintf("Handler: %s", handlerName))
+			default:
+				p.log.Error("Handler failed",
+					zap.String("command_id", cmd.ID),
+					zap.String("command_type", cmd.Type),
+					zap.String("handler", handlerName),
+					p.sensitive.LogField(cmd),
+					zap.Error(err),
+				)
+				code := "HANDLER_ERROR"
+				switch {
+				case errors.Is(err, ErrInvalidPayload):
+					code = "INVALID_PAYLOAD"
+				case errors.Is(err, ErrHandlerPanic):
+					code = "HANDLER_PANIC"
+				}
+				lastErr = err
+				p.fail(cmd, err, code, fmt.Sprintf("Handler: %s", handlerName))
 			}
 			break
 		}
@@ -145,22 +594,113 @@ func (p *Processor) Process(ctx context.Context, cmd *Command) error {
 	return lastErr
 }
 
-// Stop gracefully stops the processor
+// fail records a failed attempt. Retryable errors schedule the next attempt
+// according to the command type's backoff policy until MaxRetries is used
+// up, after which the command is dead. Other errors fail it right away.
+func (p *Processor) fail(cmd *Command, err error, code, details string) {
+	cmd.SetError(code, err.Error(), details)
+	cmd.UpdatedAt = time.Now()
+
+	switch {
+	case !IsRetryableError(err):
+		cmd.Status = StatusFailed
+	case cmd.RetryCount >= cmd.MaxRetries:
+		cmd.Status = StatusDead
+	default:
+		cmd.Status = StatusRetrying
+		cmd.RetryCount++
+		next := p.retryPolicies.NextAttempt(cmd, cmd.RetryCount)
+		cmd.ScheduledFor = &next
+	}
+}
+
+// recordAttempt stores the outcome of an attempt if an attempt store is set
+func (p *Processor) recordAttempt(ctx context.Context, cmd *Command, number int) {
+	if p.attempts == nil {
+		return
+	}
+
+	attempt := &Attempt{
+		CommandID:  cmd.ID,
+		Number:     number,
+		WorkerID:   p.workerID,
+		Status:     cmd.Status,
+		StartedAt:  *cmd.ProcessedAt,
+		FinishedAt: time.Now(),
+	}
+	if cmd.ErrorDetails != nil {
+		attempt.ErrorCode = cmd.ErrorDetails.Code
+		attempt.Error = cmd.ErrorDetails.Message
+	}
+	if cmd.Status == StatusRetrying {
+		attempt.NextAttemptAt = cmd.ScheduledFor
+	}
+
+	if err := p.attempts.RecordAttempt(context.WithoutCancel(ctx), attempt); err != nil {
+		p.log.Warn("Failed to record command attempt",
+			zap.String("command_id", cmd.ID),
+			zap.Int("attempt", number),
+			zap.Error(err),
+		)
+	}
+}
+
+// Cancel interrupts a queued or running command and reports whether the
+// command was known to this processor. Queued commands are dropped, running
+// handlers see their context cancelled with ErrCommandCancelled as cause.
+func (p *Processor) Cancel(commandID string) bool {
+	p.runningMu.Lock()
+	cancel, ok := p.running[commandID]
+	p.runningMu.Unlock()
+
+	if ok {
+		cancel(ErrCommandCancelled)
+	}
+	return ok
+}
+
+func (p *Processor) track(commandID string, cancel context.CancelCauseFunc) {
+	p.runningMu.Lock()
+	p.running[commandID] = cancel
+	p.runningMu.Unlock()
+}
+
+func (p *Processor) untrack(commandID string) {
+	p.runningMu.Lock()
+	delete(p.running, commandID)
+	p.runningMu.Unlock()
+}
+
+// abandon records why a command stopped because its context ended
+func (p *Processor) abandon(ctx context.Context, cmd *Command) error {
+	if errors.Is(context.Cause(ctx), ErrCommandCancelled) {
+		now := time.Now()
+		cmd.Status = StatusCancelled
+		cmd.CancelledAt = &now
+		cmd.UpdatedAt = now
+		return ErrCommandCancelled
+	}
+	return ctx.Err()
+}
+
+// Stop gracefully stops the processor. Commands already running finish,
+// queued commands fail with ErrProcessorStopped.
 func (p *Processor) Stop() {
 	p.cancel()
 }
 
+func (p *Processor) observeQueueDepth(lane Priority) {
+	if p.metrics == nil {
+		return
+	}
+	p.metrics.CommandQueueDepth.WithLabelValues(lane.String()).Set(float64(p.queue.depth(lane)))
+}
+
 func (p *Processor) now() *time.Time {
 	now := time.Now()
 	return &now
 }
 
-func (p *Processor) calculateNextRetry(cmd *Command) *time.Time {
-	backoff := cmd.RetryBackoff * time.Duration(cmd.RetryCount)
-	next := time.Now().Add(backoff)
-	return &next
-}
-
 // Status returns nil if the processor is healthy and running, or an error if there are issues
 func (p *Processor) Status() error {
 	select {
@@ -0,0 +1,230 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/linkmeAman/universal-middleware/test/testutil"
+)
+
+type blockingHandler struct {
+	started chan struct{}
+}
+
+func (h *blockingHandler) HandleCommand(ctx context.Context, cmd *Command) error {
+	close(h.started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (h *blockingHandler) CanHandle(cmdType string) bool {
+	return cmdType == CommandTypeCacheWarmup
+}
+
+func newTestProcessor(t *testing.T, cfg ProcessorConfig) *Processor {
+	p := NewProcessor(cfg, testutil.NewTestLogger(t))
+	p.SetValidator(NewMockValidator())
+	t.Cleanup(p.Stop)
+	return p
+}
+
+func TestProcessorCancelRunningCommand(t *testing.T) {
+	p := newTestProcessor(t, ProcessorConfig{MaxWorkers: 1})
+	handler := &blockingHandler{started: make(chan struct{})}
+	p.handlers[CommandTypeCacheWarmup] = []Handler{handler}
+
+	cmd := NewCommand(CommandTypeCacheWarmup, map[string]interface{}{})
+	result := make(chan error, 1)
+	go func() { result <- p.Process(context.Background(), cmd) }()
+
+	select {
+	case <-handler.started:
+	case <-time.After(time.Second):
+		t.Fatal("handler was not started")
+	}
+
+	assert.True(t, p.Cancel(cmd.ID))
+	select {
+	case err := <-result:
+		assert.ErrorIs(t, err, ErrCommandCancelled)
+	case <-time.After(time.Second):
+		t.Fatal("cancelled command did not return")
+	}
+	assert.Equal(t, StatusCancelled, cmd.Status)
+	assert.NotNil(t, cmd.CancelledAt)
+	assert.False(t, p.Cancel(cmd.ID))
+}
+
+func TestProcessorCancelQueuedCommand(t *testing.T) {
+	p := newTestProcessor(t, ProcessorConfig{MaxWorkers: 1})
+	handler := &blockingHandler{started: make(chan struct{})}
+	p.handlers[CommandTypeCacheWarmup] = []Handler{handler}
+
+	running := NewCommand(CommandTypeCacheWarmup, map[string]interface{}{})
+	go p.Process(context.Background(), running)
+	<-handler.started
+
+	queued := NewCommand(CommandTypeCacheWarmup, map[string]interface{}{})
+	result := make(chan error, 1)
+	go func() { result <- p.Process(context.Background(), queued) }()
+
+	require.Eventually(t, func() bool { return p.queue.depth(PriorityNormal) == 1 }, time.Second, 10*time.Millisecond)
+	assert.True(t, p.Cancel(queued.ID))
+	assert.ErrorIs(t, <-result, ErrCommandCancelled)
+	assert.Equal(t, StatusCancelled, queued.Status)
+	assert.Equal(t, 0, p.queue.depth(PriorityNormal))
+
+	p.Cancel(running.ID)
+}
+
+type fakeLeaseStore struct {
+	mu       sync.Mutex
+	renewErr error
+	acquired []string
+	released []*Command
+}
+
+func (s *fakeLeaseStore) Acquire(ctx context.Context, cmd *Command, owner string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acquired = append(s.acquired, cmd.ID)
+	return nil
+}
+
+func (s *fakeLeaseStore) Renew(ctx context.Context, commandID, owner string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.renewErr
+}
+
+func (s *fakeLeaseStore) Release(ctx context.Context, cmd *Command, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.released = append(s.released, cmd)
+	return nil
+}
+
+func TestProcessorTimeout(t *testing.T) {
+	leases := &fakeLeaseStore{}
+	p := newTestProcessor(t, ProcessorConfig{MaxWorkers: 1, Leases: leases})
+	p.handlers[CommandTypeCacheWarmup] = []Handler{&blockingHandler{started: make(chan struct{})}}
+
+	cmd := NewCommand(CommandTypeCacheWarmup, map[string]interface{}{})
+	cmd.TimeoutAfter = 20 * time.Millisecond
+	cmd.MaxRetries = 1
+
+	err := p.Process(context.Background(), cmd)
+	assert.ErrorIs(t, err, ErrCommandTimeout)
+	assert.Equal(t, StatusRetrying, cmd.Status)
+	assert.Equal(t, 1, cmd.RetryCount)
+	require.NotNil(t, cmd.ErrorDetails)
+	assert.Equal(t, "TIMEOUT", cmd.ErrorDetails.Code)
+
+	// The outcome is written back when the lease is released
+	require.Len(t, leases.released, 1)
+	assert.Same(t, cmd, leases.released[0])
+}
+
+func TestProcessorStopsHandlerWhenLeaseIsLost(t *testing.T) {
+	leases := &fakeLeaseStore{renewErr: ErrLeaseLost}
+	p := newTestProcessor(t, ProcessorConfig{
+		MaxWorkers:        1,
+		Leases:            leases,
+		LeaseTTL:          60 * time.Millisecond,
+		HeartbeatInterval: 10 * time.Millisecond,
+	})
+	p.handlers[CommandTypeCacheWarmup] = []Handler{&blockingHandler{started: make(chan struct{})}}
+
+	cmd := NewCommand(CommandTypeCacheWarmup, map[string]interface{}{})
+	cmd.TimeoutAfter = time.Minute
+
+	assert.ErrorIs(t, p.Process(context.Background(), cmd), ErrLeaseLost)
+	assert.Len(t, leases.acquired, 1)
+	assert.Empty(t, leases.released)
+}
+
+type failingHandler struct {
+	err error
+}
+
+func (h *failingHandler) HandleCommand(ctx context.Context, cmd *Command) error {
+	return h.err
+}
+
+func (h *failingHandler) CanHandle(cmdType string) bool {
+	return true
+}
+
+type fakeAttemptStore struct {
+	mu       sync.Mutex
+	attempts []*Attempt
+}
+
+func (s *fakeAttemptStore) RecordAttempt(ctx context.Context, attempt *Attempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts = append(s.attempts, attempt)
+	return nil
+}
+
+func TestProcessorRetryOutcome(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		retryCount int
+		want       Status
+	}{
+		{"retryable", errors.New("temporary"), 0, StatusRetrying},
+		{"exhausted", errors.New("temporary"), 2, StatusDead},
+		{"permanent", Permanent(errors.New("invalid card")), 0, StatusFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := &fakeAttemptStore{}
+			p := newTestProcessor(t, ProcessorConfig{
+				MaxWorkers:    1,
+				Attempts:      attempts,
+				RetryPolicies: NewRetryPolicies(BackoffPolicy{Strategy: BackoffFixed, Initial: time.Minute}),
+			})
+			p.handlers[CommandTypeCacheWarmup] = []Handler{&failingHandler{err: tt.err}}
+
+			cmd := NewCommand(CommandTypeCacheWarmup, map[string]interface{}{})
+			cmd.MaxRetries = 2
+			cmd.RetryCount = tt.retryCount
+
+			assert.Error(t, p.Process(context.Background(), cmd))
+			assert.Equal(t, tt.want, cmd.Status)
+
+			require.Len(t, attempts.attempts, 1)
+			attempt := attempts.attempts[0]
+			assert.Equal(t, tt.retryCount+1, attempt.Number)
+			assert.Equal(t, tt.want, attempt.Status)
+			assert.Equal(t, "HANDLER_ERROR", attempt.ErrorCode)
+
+			if tt.want == StatusRetrying {
+				require.NotNil(t, cmd.ScheduledFor)
+				assert.WithinDuration(t, time.Now().Add(time.Minute), *cmd.ScheduledFor, time.Second)
+				assert.Equal(t, cmd.ScheduledFor, attempt.NextAttemptAt)
+			} else {
+				assert.Nil(t, attempt.NextAttemptAt)
+			}
+		})
+	}
+}
+
+func TestRefuseLease(t *testing.T) {
+	assert.ErrorIs(t, refuseLease(StatusCompleted, false, true), ErrCommandFinished)
+	assert.ErrorIs(t, refuseLease(StatusDead, false, true), ErrCommandFinished)
+	assert.ErrorIs(t, refuseLease(StatusPending, true, true), ErrCommandFinished)
+	assert.ErrorIs(t, refuseLease(StatusScheduled, false, false), ErrCommandNotDue)
+	assert.ErrorIs(t, refuseLease(StatusRetrying, false, false), ErrCommandNotDue)
+	assert.ErrorIs(t, refuseLease(StatusRetrying, false, true), ErrLeaseHeld)
+	assert.ErrorIs(t, refuseLease(StatusProcessing, false, true), ErrLeaseHeld)
+}
@@ -0,0 +1,294 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned when the dispatch queue has no room for another
+	// command. Callers should back off and resubmit later.
+	ErrQueueFull = errors.New("command queue is full")
+
+	// ErrProcessorStopped is returned for commands submitted to, or still queued
+	// in, a processor that has been stopped
+	ErrProcessorStopped = errors.New("processor is stopped")
+)
+
+// priorities lists the queue lanes from most to least urgent
+var priorities = []Priority{PriorityCritical, PriorityHigh, PriorityNormal, PriorityLow}
+
+// DefaultPriorityWeights returns the relative share of dispatch slots each
+// priority receives while several priorities have work queued
+func DefaultPriorityWeights() map[Priority]int {
+	return map[Priority]int{
+		PriorityCritical: 8,
+		PriorityHigh:     4,
+		PriorityNormal:   2,
+		PriorityLow:      1,
+	}
+}
+
+// queueItem is a command waiting for a worker
+type queueItem struct {
+	ctx        context.Context
+	cmd        *Command
+	lane       Priority
+	enqueuedAt time.Time
+	done       chan error
+
+	// entity is set when commands of the same entity must run one at a time
+	entity string
+}
+
+// dispatchQueue is a bounded queue with one FIFO lane per priority.
+//
+// Lanes are served by smooth weighted round robin, so every priority with
+// queued work gets its share of workers in proportion to its weight. A lane
+// whose head has waited longer than maxWait is served ahead of the rotation,
+// which keeps a steady stream of critical work from starving low priorities.
+//
+// Items with an entity key run one at a time and in arrival order per entity:
+// an item is only dispatched once every earlier item of its entity has
+// finished, whatever their priorities. Items of other entities pass it.
+type dispatchQueue struct {
+	mu       sync.Mutex
+	lanes    map[Priority][]*queueItem
+	weights  map[Priority]int
+	current  map[Priority]int
+	size     int
+	capacity int
+	maxWait  time.Duration
+
+	// entities holds the queued and running items of each entity in arrival
+	// order. Only the first one may be dispatched or run.
+	entities map[string][]*queueItem
+
+	// ready holds at least one token per dispatchable item
+	ready chan struct{}
+}
+
+func newDispatchQueue(capacity int, weights map[Priority]int, maxWait time.Duration) *dispatchQueue {
+	q := &dispatchQueue{
+		lanes:    make(map[Priority][]*queueItem, len(priorities)),
+		weights:  DefaultPriorityWeights(),
+		current:  make(map[Priority]int, len(priorities)),
+		capacity: capacity,
+		maxWait:  maxWait,
+		entities: make(map[string][]*queueItem),
+		ready:    make(chan struct{}, capacity),
+	}
+	for p, w := range weights {
+		if _, ok := q.weights[p]; ok && w > 0 {
+			q.weights[p] = w
+		}
+	}
+	return q
+}
+
+// laneFor maps a command priority to its queue lane
+func laneFor(p Priority) Priority {
+	if p < PriorityLow || p > PriorityCritical {
+		return PriorityNormal
+	}
+	return p
+}
+
+// push appends an item to its lane or returns ErrQueueFull
+func (q *dispatchQueue) push(item *queueItem) error {
+	q.mu.Lock()
+	if q.size >= q.capacity {
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+	q.lanes[item.lane] = append(q.lanes[item.lane], item)
+	q.size++
+	if item.entity != "" {
+		q.entities[item.entity] = append(q.entities[item.entity], item)
+	}
+	q.mu.Unlock()
+
+	q.wake()
+	return nil
+}
+
+// wake adds a dispatch token. Tokens left behind by removed items may fill
+// the channel, in which case there are already enough tokens for every
+// queued item.
+func (q *dispatchQueue) wake() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// finish marks a dispatched item as done so the next item of its entity can
+// be dispatched. Items without an entity need not be finished.
+func (q *dispatchQueue) finish(item *queueItem) {
+	if item.entity == "" {
+		return
+	}
+
+	q.mu.Lock()
+	waiting := q.forget(item)
+	q.mu.Unlock()
+
+	if waiting {
+		q.wake()
+	}
+}
+
+// forget drops an item from its entity and reports whether other items of
+// the entity are still waiting. The caller must hold q.mu.
+func (q *dispatchQueue) forget(item *queueItem) bool {
+	items := q.entities[item.entity]
+	for i, queued := range items {
+		if queued == item {
+			items = append(items[:i], items[i+1:]...)
+			break
+		}
+	}
+	if len(items) == 0 {
+		delete(q.entities, item.entity)
+		return false
+	}
+	q.entities[item.entity] = items
+	return true
+}
+
+// dispatchable returns the index of the first item of a lane that may run
+// now, or -1. The caller must hold q.mu.
+func (q *dispatchQueue) dispatchable(lane []*queueItem) int {
+	for i, item := range lane {
+		if item.entity == "" || q.entities[item.entity][0] == item {
+			return i
+		}
+	}
+	return -1
+}
+
+// pop blocks until an item is available or the context is done
+func (q *dispatchQueue) pop(ctx context.Context) (*queueItem, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-q.ready:
+		}
+
+		q.mu.Lock()
+		item := q.next(time.Now())
+		q.mu.Unlock()
+
+		if item != nil {
+			return item, nil
+		}
+	}
+}
+
+// next dequeues the item that should run next. The caller must hold q.mu.
+func (q *dispatchQueue) next(now time.Time) *queueItem {
+	if q.size == 0 {
+		return nil
+	}
+
+	// Items waiting for an earlier item of their entity are skipped, a lane
+	// without dispatchable items counts as empty
+	heads := make(map[Priority]int, len(priorities))
+	for _, p := range priorities {
+		if i := q.dispatchable(q.lanes[p]); i >= 0 {
+			heads[p] = i
+		}
+	}
+	if len(heads) == 0 {
+		return nil
+	}
+
+	// Serve the longest-waiting head once it has exceeded maxWait
+	var starved Priority
+	var oldest time.Time
+	for _, p := range priorities {
+		i, ok := heads[p]
+		if !ok {
+			continue
+		}
+		head := q.lanes[p][i]
+		if now.Sub(head.enqueuedAt) < q.maxWait {
+			continue
+		}
+		if starved == 0 || head.enqueuedAt.Before(oldest) {
+			starved = p
+			oldest = head.enqueuedAt
+		}
+	}
+	if starved != 0 {
+		return q.dequeue(starved, heads[starved])
+	}
+
+	// Smooth weighted round robin across non-empty lanes
+	var best Priority
+	total := 0
+	for _, p := range priorities {
+		if _, ok := heads[p]; !ok {
+			continue
+		}
+		q.current[p] += q.weights[p]
+		total += q.weights[p]
+		if best == 0 || q.current[p] > q.current[best] {
+			best = p
+		}
+	}
+	q.current[best] -= total
+
+	return q.dequeue(best, heads[best])
+}
+
+// dequeue removes the item at index i of a lane. Items of an entity stay
+// registered with it until they finish. The caller must hold q.mu.
+func (q *dispatchQueue) dequeue(p Priority, i int) *queueItem {
+	lane := q.lanes[p]
+	item := lane[i]
+	if i == 0 {
+		lane[0] = nil
+		q.lanes[p] = lane[1:]
+	} else {
+		q.lanes[p] = append(lane[:i], lane[i+1:]...)
+	}
+	q.size--
+	if len(q.lanes[p]) == 0 {
+		// Idle lanes do not accumulate credit
+		q.current[p] = 0
+	}
+	return item
+}
+
+// remove drops a queued item and reports whether it was still queued
+func (q *dispatchQueue) remove(item *queueItem) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	lane := q.lanes[item.lane]
+	for i, queued := range lane {
+		if queued == item {
+			q.lanes[item.lane] = append(lane[:i], lane[i+1:]...)
+			q.size--
+			if len(q.lanes[item.lane]) == 0 {
+				q.current[item.lane] = 0
+			}
+			if item.entity != "" {
+				q.forget(item)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// depth returns the number of items queued in a lane
+func (q *dispatchQueue) depth(p Priority) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.lanes[p])
+}
@@ -0,0 +1,86 @@
+package command
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func queueItemFor(p Priority, enqueuedAt time.Time) *queueItem {
+	return &queueItem{
+		ctx:        context.Background(),
+		cmd:        &Command{Priority: p},
+		lane:       laneFor(p),
+		enqueuedAt: enqueuedAt,
+		done:       make(chan error, 1),
+	}
+}
+
+func TestDispatchQueueRejectsWhenFull(t *testing.T) {
+	q := newDispatchQueue(2, nil, time.Minute)
+	now := time.Now()
+
+	require.NoError(t, q.push(queueItemFor(PriorityLow, now)))
+	require.NoError(t, q.push(queueItemFor(PriorityCritical, now)))
+	assert.ErrorIs(t, q.push(queueItemFor(PriorityCritical, now)), ErrQueueFull)
+
+	item, err := q.pop(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, PriorityCritical, item.lane)
+	assert.NoError(t, q.push(queueItemFor(PriorityNormal, now)))
+}
+
+func TestDispatchQueueWeightedShares(t *testing.T) {
+	q := newDispatchQueue(100, nil, time.Hour)
+	now := time.Now()
+
+	for i := 0; i < 30; i++ {
+		require.NoError(t, q.push(queueItemFor(PriorityCritical, now)))
+		require.NoError(t, q.push(queueItemFor(PriorityLow, now)))
+	}
+
+	// With weights 8:1 a full rotation serves eight critical commands per low one
+	counts := make(map[Priority]int)
+	for i := 0; i < 18; i++ {
+		item, err := q.pop(context.Background())
+		require.NoError(t, err)
+		counts[item.lane]++
+	}
+	assert.Equal(t, 16, counts[PriorityCritical])
+	assert.Equal(t, 2, counts[PriorityLow])
+}
+
+func TestDispatchQueueServesStarvedLane(t *testing.T) {
+	q := newDispatchQueue(10, map[Priority]int{PriorityLow: 1, PriorityCritical: 100}, time.Second)
+	now := time.Now()
+
+	require.NoError(t, q.push(queueItemFor(PriorityLow, now.Add(-2*time.Second))))
+	require.NoError(t, q.push(queueItemFor(PriorityCritical, now)))
+
+	item, err := q.pop(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, PriorityLow, item.lane)
+}
+
+func TestDispatchQueueRemove(t *testing.T) {
+	q := newDispatchQueue(1, nil, time.Minute)
+	item := queueItemFor(PriorityHigh, time.Now())
+
+	require.NoError(t, q.push(item))
+	assert.True(t, q.remove(item))
+	assert.False(t, q.remove(item))
+	assert.Equal(t, 0, q.depth(PriorityHigh))
+
+	// The freed slot is available again and stale wake-ups are skipped
+	next := queueItemFor(PriorityNormal, time.Now())
+	require.NoError(t, q.push(next))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	popped, err := q.pop(ctx)
+	require.NoError(t, err)
+	assert.Same(t, next, popped)
+}
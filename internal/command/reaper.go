@@ -0,0 +1,220 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/linkmeAman/universal-middleware/internal/database"
+	"github.com/linkmeAman/universal-middleware/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// ReaperConfig holds configuration for the expired lease reaper
+type ReaperConfig struct {
+	Interval  time.Duration
+	BatchSize int
+
+	// Completions, when set, announces the commands the reaper finishes
+	Completions *Completions
+}
+
+// DefaultReaperConfig returns default reaper configuration
+func DefaultReaperConfig() ReaperConfig {
+	return ReaperConfig{
+		Interval:  15 * time.Second,
+		BatchSize: 100,
+	}
+}
+
+// Reaper recovers commands stuck in processing because the worker running
+// them stopped renewing its lease. Commands cancelled while running are
+// cancelled, those with retries left move to retrying and are released again
+// by the scheduler, the rest are dead.
+type Reaper struct {
+	db       database.DB
+	config   ReaperConfig
+	policies *RetryPolicies
+	log      *logger.Logger
+}
+
+// NewReaper creates a new reaper scheduling retries with the given policies
+func NewReaper(db database.DB, config ReaperConfig, policies *RetryPolicies, log *logger.Logger) *Reaper {
+	return &Reaper{
+		db:       db,
+		config:   config,
+		policies: policies,
+		log:      log,
+	}
+}
+
+// Start runs the reaper loop until the context is cancelled
+func (r *Reaper) Start(ctx context.Context) {
+	r.log.Info("Starting command reaper",
+		zap.Duration("interval", r.config.Interval),
+		zap.Int("batch_size", r.config.BatchSize))
+
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				reaped, err := r.reapExpired(ctx)
+				if err != nil {
+					r.log.Error("Failed to reap expired commands", zap.Error(err))
+					break
+				}
+				if reaped < r.config.BatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// reapExpired handles one batch of commands with expired leases and returns its size
+func (r *Reaper) reapExpired(ctx context.Context) (int, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		SELECT id, type, retry_count, max_retries, lease_owner, processed_at,
+			COALESCE(callback_url, ''), cancelled_at, COALESCE(cancelled_by, ''),
+			COALESCE(cancel_reason, '')
+		FROM commands
+		WHERE status = $1 AND lease_expires_at < NOW()
+		ORDER BY lease_expires_at ASC
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.Query(ctx, query, StatusProcessing, r.config.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query expired leases: %w", err)
+	}
+
+	type expired struct {
+		id          string
+		cmdType     string
+		retryCount  int
+		maxRetries  int
+		owner       string
+		processedAt *time.Time
+		callbackURL string
+		cancelledAt *time.Time
+		cancelledBy string
+		reason      string
+	}
+
+	var commands []expired
+	for rows.Next() {
+		var c expired
+		if err := rows.Scan(&c.id, &c.cmdType, &c.retryCount, &c.maxRetries, &c.owner, &c.processedAt,
+			&c.callbackURL, &c.cancelledAt, &c.cancelledBy, &c.reason); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan command: %w", err)
+		}
+		commands = append(commands, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating expired leases: %w", err)
+	}
+
+	if len(commands) == 0 {
+		return 0, nil
+	}
+
+	var finished []*Command
+	for _, c := range commands {
+		errorMsg := fmt.Sprintf("lease held by %s expired", c.owner)
+
+		retryCount := c.retryCount
+		var scheduledFor *time.Time
+		var status Status
+		switch {
+		case c.cancelledAt != nil:
+			// Cancelled while running and its worker never got to stop it,
+			// running it again would only have it refused as finished
+			status = StatusCancelled
+		case c.retryCount < c.maxRetries:
+			status = StatusRetrying
+			retryCount++
+			next := r.policies.NextAttempt(&Command{Type: c.cmdType}, retryCount)
+			scheduledFor = &next
+		default:
+			status = StatusDead
+		}
+
+		update := `
+			UPDATE commands
+			SET status = $2, error = $3, error_code = 'LEASE_EXPIRED', retry_count = $4,
+				scheduled_for = COALESCE($5, scheduled_for),
+				lease_owner = NULL, lease_expires_at = NULL
+			WHERE id = $1`
+
+		if _, err := tx.Exec(ctx, update, c.id, status, errorMsg, retryCount, scheduledFor); err != nil {
+			return 0, fmt.Errorf("failed to reap command %s: %w", c.id, err)
+		}
+
+		now := time.Now()
+		attempt := &Attempt{
+			CommandID:     c.id,
+			Number:        c.retryCount + 1,
+			WorkerID:      c.owner,
+			Status:        status,
+			ErrorCode:     "LEASE_EXPIRED",
+			Error:         errorMsg,
+			StartedAt:     now,
+			FinishedAt:    now,
+			NextAttemptAt: scheduledFor,
+		}
+		if c.processedAt != nil {
+			attempt.StartedAt = *c.processedAt
+		}
+		if err := insertAttempt(ctx, tx, attempt); err != nil {
+			return 0, err
+		}
+
+		cmd := &Command{
+			ID:           c.id,
+			Type:         c.cmdType,
+			Status:       status,
+			RetryCount:   retryCount,
+			ScheduledFor: scheduledFor,
+			ErrorDetails: &ErrorDetails{Code: "LEASE_EXPIRED", Message: errorMsg},
+			CallbackURL:  c.callbackURL,
+			CancelledAt:  c.cancelledAt,
+			CancelledBy:  c.cancelledBy,
+			CancelReason: c.reason,
+		}
+		if err := insertTransition(ctx, tx, cmd, StatusProcessing, c.owner); err != nil {
+			return 0, err
+		}
+		if status.IsTerminal() {
+			finished = append(finished, cmd)
+		}
+
+		r.log.Warn("Reaped command with expired lease",
+			zap.String("command_id", c.id),
+			zap.String("lease_owner", c.owner),
+			zap.String("status", string(status)),
+			zap.Int("retry_count", retryCount))
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	for _, cmd := range finished {
+		r.config.Completions.Notify(ctx, cmd)
+	}
+
+	return len(commands), nil
+}
@@ -0,0 +1,80 @@
+package command
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/linkmeAman/universal-middleware/internal/database"
+	"github.com/linkmeAman/universal-middleware/test/testutil"
+)
+
+// txDB is a callbackDB whose transactions run against itself
+type txDB struct {
+	callbackDB
+	committed bool
+}
+
+func (db *txDB) Begin(ctx context.Context) (database.Tx, error) { return db, nil }
+func (db *txDB) Commit(ctx context.Context) error               { db.committed = true; return nil }
+func (db *txDB) Rollback(ctx context.Context) error             { return nil }
+
+// expiredRow is a row of the expired lease query
+func expiredRow(id string, retryCount, maxRetries int, callbackURL string, cancelledAt *time.Time) fakeRow {
+	started := time.Now().Add(-time.Minute)
+	return fakeRow{id, CommandTypeEmailSend, retryCount, maxRetries, "worker-1", &started,
+		callbackURL, cancelledAt, "admin", "no longer needed"}
+}
+
+func TestReaperCancelsCommandCancelledInFlight(t *testing.T) {
+	cancelledAt := time.Now().Add(-30 * time.Second)
+	db := &txDB{callbackDB: callbackDB{rows: []fakeRow{
+		expiredRow("cmd-1", 0, 3, "https://hooks.example.com/done", &cancelledAt),
+	}}}
+	callbacks := newTestCallbackQueue(t, &callbackDB{})
+	config := DefaultReaperConfig()
+	config.Completions = NewCompletions(nil, callbacks, zap.NewNop())
+	r := NewReaper(db, config, nil, testutil.NewTestLogger(t))
+
+	reaped, err := r.reapExpired(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, reaped)
+	assert.True(t, db.committed)
+
+	// The command is finished instead of retried, so the scheduler does not
+	// release it to be refused by every worker
+	require.NotEmpty(t, db.execs)
+	assert.Equal(t, StatusCancelled, db.execs[0].args[1])
+	assert.Equal(t, 0, db.execs[0].args[3], "a cancelled command uses up no retry")
+	assert.Nil(t, db.execs[0].args[4])
+
+	var queued bool
+	for _, exec := range db.execs {
+		if exec.query == queueCallbackQuery {
+			queued = true
+		}
+	}
+	assert.True(t, queued, "the callback of the cancelled command is queued")
+	assert.Len(t, callbacks.wake, 1, "waiters and callbacks are told the command finished")
+}
+
+func TestReaperRetriesExpiredLease(t *testing.T) {
+	db := &txDB{callbackDB: callbackDB{rows: []fakeRow{
+		expiredRow("cmd-1", 0, 3, "https://hooks.example.com/done", nil),
+	}}}
+	callbacks := newTestCallbackQueue(t, &callbackDB{})
+	config := DefaultReaperConfig()
+	config.Completions = NewCompletions(nil, callbacks, zap.NewNop())
+	r := NewReaper(db, config, NewRetryPolicies(DefaultBackoffPolicy()), testutil.NewTestLogger(t))
+
+	_, err := r.reapExpired(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, StatusRetrying, db.execs[0].args[1])
+	assert.Equal(t, 1, db.execs[0].args[3])
+	assert.NotNil(t, db.execs[0].args[4])
+	assert.Empty(t, callbacks.wake)
+}
@@ -0,0 +1,346 @@
+package command
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultReplayLimit = 100
+	maxReplayLimit     = 1000
+)
+
+// ErrEmptyReplayFilter is returned when a replay would select every failed
+// command
+var ErrEmptyReplayFilter = errors.New("replay filter selects no type, error code, time window or commands")
+
+// ReplayFilter selects the failed and dead commands to replay. Empty fields
+// match every command, but at least one must be set.
+type ReplayFilter struct {
+	Type          string     `json:"type,omitempty"`
+	ErrorCode     string     `json:"error_code,omitempty"`
+	CreatedAfter  *time.Time `json:"created_after,omitempty"`
+	CreatedBefore *time.Time `json:"created_before,omitempty"`
+	CommandIDs    []string   `json:"command_ids,omitempty"`
+	Limit         int        `json:"limit,omitempty"`
+}
+
+func (f ReplayFilter) empty() bool {
+	return f.Type == "" && f.ErrorCode == "" && f.CreatedAfter == nil &&
+		f.CreatedBefore == nil && len(f.CommandIDs) == 0
+}
+
+func (f ReplayFilter) replayLimit() int {
+	switch {
+	case f.Limit <= 0:
+		return defaultReplayLimit
+	case f.Limit > maxReplayLimit:
+		return maxReplayLimit
+	default:
+		return f.Limit
+	}
+}
+
+// ReplayRequest asks for failed commands to be run again
+type ReplayRequest struct {
+	Filter ReplayFilter
+
+	// PayloadPatch is merged into the payload of every replayed command, a
+	// null value removes the field
+	PayloadPatch map[string]interface{}
+
+	// DryRun reports the commands that would be replayed without touching them
+	DryRun bool
+
+	// RequestedBy and Reason are kept in the audit record of the replay
+	RequestedBy string
+	Reason      string
+}
+
+// ReplayedCommand is a command selected by a replay
+type ReplayedCommand struct {
+	ID             string `json:"command_id"`
+	Type           string `json:"type"`
+	PreviousStatus Status `json:"previous_status"`
+	ErrorCode      string `json:"error_code,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// ReplayResult describes a replay
+type ReplayResult struct {
+	ReplayID string             `json:"replay_id,omitempty"`
+	DryRun   bool               `json:"dry_run"`
+	Commands []*ReplayedCommand `json:"commands"`
+}
+
+// buildReplayQuery returns the query selecting and locking the commands of
+// a replay, oldest first. Commands locked by a concurrent replay are skipped.
+func buildReplayQuery(f ReplayFilter) (string, []interface{}) {
+	args := []interface{}{StatusFailed, StatusDead}
+	conds := []string{"status IN ($1, $2)"}
+	where := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.Type != "" {
+		where("type = $%d", f.Type)
+	}
+	if f.ErrorCode != "" {
+		where("error_code = $%d", f.ErrorCode)
+	}
+	if f.CreatedAfter != nil {
+		where("created_at >= $%d", *f.CreatedAfter)
+	}
+	if f.CreatedBefore != nil {
+		where("created_at < $%d", *f.CreatedBefore)
+	}
+	if len(f.CommandIDs) > 0 {
+		placeholders := make([]string, len(f.CommandIDs))
+		for i, id := range f.CommandIDs {
+			args = append(args, id)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		conds = append(conds, "id IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	args = append(args, f.replayLimit())
+	query := fmt.Sprintf(`
+		SELECT `+replayColumns+`
+		FROM commands
+		WHERE %s
+		ORDER BY created_at ASC
+		LIMIT $%d
+		FOR UPDATE SKIP LOCKED`, strings.Join(conds, " AND "), len(args))
+
+	return query, args
+}
+
+// replayColumns are the columns scanReplayCommand reads
+const replayColumns = `id, type, entity_id, payload, status, created_at, max_retries,
+			error, error_code, callback_url, user_id, correlation_id, ` + executionColumns
+
+// scanReplayCommand rebuilds a command to replay from a row of
+// replayColumns, together with what it is replayed from
+func scanReplayCommand(row rowScanner) (*Command, *ReplayedCommand, error) {
+	cmd := &Command{}
+	var payloadJSON []byte
+	var errorMsg, errorCode, callbackURL, userID, correlationID sql.NullString
+	var execution executionFields
+	if err := row.Scan(append([]interface{}{
+		&cmd.ID, &cmd.Type, &cmd.EntityID, &payloadJSON, &cmd.Status, &cmd.CreatedAt,
+		&cmd.MaxRetries, &errorMsg, &errorCode, &callbackURL, &userID, &correlationID,
+	}, execution.dest()...)...); err != nil {
+		return nil, nil, fmt.Errorf("failed to scan command: %w", err)
+	}
+	if err := json.Unmarshal(payloadJSON, &cmd.Payload); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode payload of command %s: %w", cmd.ID, err)
+	}
+	cmd.CallbackURL = callbackURL.String
+	cmd.UserID = userID.String
+	cmd.CorrelationID = correlationID.String
+	execution.apply(cmd)
+
+	return cmd, &ReplayedCommand{
+		ID:             cmd.ID,
+		Type:           cmd.Type,
+		PreviousStatus: cmd.Status,
+		ErrorCode:      errorCode.String,
+		Error:          errorMsg.String,
+	}, nil
+}
+
+// patchPayload returns payload with patch merged in
+func patchPayload(payload, patch map[string]interface{}) map[string]interface{} {
+	patched := make(map[string]interface{}, len(payload)+len(patch))
+	for k, v := range payload {
+		patched[k] = v
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(patched, k)
+			continue
+		}
+		patched[k] = v
+	}
+	return patched
+}
+
+// patchCommand merges a patch into the payload of a command and validates
+// the result. Sealed fields are opened for the patch and sealed again.
+func (s *CommandService) patchCommand(ctx context.Context, cmd *Command, patch map[string]interface{}) error {
+	payload := cmd.Payload
+	if s.encryptor != nil {
+		var err error
+		if payload, err = s.encryptor.Decrypt(ctx, cmd); err != nil {
+			return err
+		}
+	}
+
+	cmd.Payload = patchPayload(payload, patch)
+	if err := s.validator.schemas.Validate(cmd.Type, cmd.SchemaVersion, cmd.Payload); err != nil {
+		return err
+	}
+	if s.encryptor != nil {
+		return s.encryptor.Encrypt(ctx, cmd)
+	}
+	return nil
+}
+
+// ReplayCommands requeues failed and dead commands through the outbox. Their
+// retry counters and errors are reset and, if a patch is given, their
+// payloads are patched and validated again. Every replay is recorded with
+// the commands it requeued and who requested it.
+func (s *CommandService) ReplayCommands(ctx context.Context, req ReplayRequest) (*ReplayResult, error) {
+	if req.Filter.empty() {
+		return nil, ErrEmptyReplayFilter
+	}
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	cmds, replayed, err := s.selectReplay(ctx, tx, req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReplayResult{DryRun: req.DryRun, Commands: replayed}
+	for _, cmd := range cmds {
+		if len(req.PayloadPatch) > 0 {
+			if err := s.patchCommand(ctx, cmd, req.PayloadPatch); err != nil {
+				return nil, fmt.Errorf("command %s: %w", cmd.ID, err)
+			}
+		}
+	}
+	if req.DryRun || len(cmds) == 0 {
+		return result, nil
+	}
+
+	now := time.Now()
+	ids := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		previous := cmd.Status
+		cmd.Status = StatusPending
+		cmd.RetryCount = 0
+		cmd.UpdatedAt = now
+		cmd.Error = ""
+		cmd.ErrorDetails = nil
+		ids[i] = cmd.ID
+
+		payloadJSON, err := json.Marshal(cmd.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode payload of command %s: %w", cmd.ID, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE commands
+			SET status = $2, payload = $3, retry_count = 0, error = NULL, error_code = NULL,
+				scheduled_for = NULL, processed_at = NULL
+			WHERE id = $1`,
+			cmd.ID, cmd.Status, payloadJSON,
+		); err != nil {
+			return nil, fmt.Errorf("failed to requeue command %s: %w", cmd.ID, err)
+		}
+
+		if err := s.storeInOutbox(ctx, tx, cmd); err != nil {
+			return nil, fmt.Errorf("failed to store command %s in outbox: %w", cmd.ID, err)
+		}
+
+		if err := storeTransition(ctx, tx, cmd, previous); err != nil {
+			return nil, fmt.Errorf("command %s: %w", cmd.ID, err)
+		}
+	}
+
+	result.ReplayID = uuid.New().String()
+	if err := storeReplay(ctx, tx, result.ReplayID, req, ids, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	for _, cmd := range cmds {
+		if err := s.cacheCommandStatus(ctx, cmd); err != nil {
+			s.log.Warn("Failed to cache command status",
+				zap.String("command_id", cmd.ID),
+				zap.Error(err))
+		}
+	}
+
+	s.log.Info("Replayed failed commands",
+		zap.String("replay_id", result.ReplayID),
+		zap.String("requested_by", req.RequestedBy),
+		zap.Int("count", len(cmds)))
+
+	return result, nil
+}
+
+// selectReplay loads and locks the commands matching a replay filter
+func (s *CommandService) selectReplay(ctx context.Context, tx *sql.Tx, f ReplayFilter) ([]*Command, []*ReplayedCommand, error) {
+	query, args := buildReplayQuery(f)
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to select commands to replay: %w", err)
+	}
+	defer rows.Close()
+
+	cmds := []*Command{}
+	replayed := []*ReplayedCommand{}
+	for rows.Next() {
+		cmd, from, err := scanReplayCommand(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		cmds = append(cmds, cmd)
+		replayed = append(replayed, from)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating commands to replay: %w", err)
+	}
+
+	return cmds, replayed, nil
+}
+
+// storeReplay saves the audit record of a replay
+func storeReplay(ctx context.Context, tx *sql.Tx, id string, req ReplayRequest, commandIDs []string, at time.Time) error {
+	filterJSON, err := json.Marshal(req.Filter)
+	if err != nil {
+		return fmt.Errorf("failed to encode replay filter: %w", err)
+	}
+	idsJSON, err := json.Marshal(commandIDs)
+	if err != nil {
+		return fmt.Errorf("failed to encode replayed commands: %w", err)
+	}
+	var patchJSON []byte
+	if len(req.PayloadPatch) > 0 {
+		if patchJSON, err = json.Marshal(req.PayloadPatch); err != nil {
+			return fmt.Errorf("failed to encode payload patch: %w", err)
+		}
+	}
+
+	query := `
+		INSERT INTO command_replays (id, requested_by, reason, filter, payload_patch, command_ids, created_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7)
+	`
+	if _, err := tx.ExecContext(ctx, query,
+		id, req.RequestedBy, req.Reason, filterJSON, patchJSON, idsJSON, at,
+	); err != nil {
+		return fmt.Errorf("failed to record replay: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package command
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildReplayQuery(t *testing.T) {
+	after := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	query, args := buildReplayQuery(ReplayFilter{
+		Type:         CommandTypeEmailSend,
+		ErrorCode:    "HANDLER_ERROR",
+		CreatedAfter: &after,
+		CommandIDs:   []string{"cmd-1", "cmd-2"},
+	})
+
+	assert.Contains(t, query, "WHERE status IN ($1, $2) AND type = $3 AND error_code = $4 AND created_at >= $5 AND id IN ($6, $7)")
+	assert.Contains(t, query, "LIMIT $8")
+	assert.Contains(t, query, "FOR UPDATE SKIP LOCKED")
+	assert.Equal(t, []interface{}{
+		StatusFailed, StatusDead, CommandTypeEmailSend, "HANDLER_ERROR", after, "cmd-1", "cmd-2", defaultReplayLimit,
+	}, args)
+
+	_, args = buildReplayQuery(ReplayFilter{Type: CommandTypeEmailSend, Limit: 5000})
+	assert.Equal(t, maxReplayLimit, args[len(args)-1])
+}
+
+func TestPatchPayload(t *testing.T) {
+	payload := map[string]interface{}{"to": "a@example.com", "subject": "hi", "template": "v1"}
+
+	patched := patchPayload(payload, map[string]interface{}{"template": "v2", "subject": nil, "locale": "de"})
+	assert.Equal(t, map[string]interface{}{"to": "a@example.com", "template": "v2", "locale": "de"}, patched)
+
+	// The original payload is left alone
+	assert.Equal(t, "v1", payload["template"])
+	assert.Equal(t, "hi", payload["subject"])
+}
+
+func TestReplayCommandsRequiresFilter(t *testing.T) {
+	s := newTestCommandService()
+	_, err := s.ReplayCommands(context.Background(), ReplayRequest{Filter: ReplayFilter{Limit: 10}, DryRun: true})
+	assert.ErrorIs(t, err, ErrEmptyReplayFilter)
+}
+
+func TestReplayedCommandPassesValidation(t *testing.T) {
+	registry := NewSchemaRegistry()
+	require.NoError(t, registry.Load(fstest.MapFS{
+		"schemas/invoice.send.json":    {Data: []byte(`{"type": "object", "required": ["to"]}`)},
+		"schemas/invoice.send.v2.json": {Data: []byte(`{"type": "object", "required": ["recipient"]}`)},
+	}, "schemas"))
+	s := newTestCommandService()
+	s.validator = NewSchemaValidator(registry)
+
+	payloadJSON, err := json.Marshal(map[string]interface{}{"to": "jane@example.com"})
+	require.NoError(t, err)
+	row := fakeRow{
+		"cmd-1", "invoice.send", "invoice-1", payloadJSON, StatusDead, time.Now(), 3,
+		sql.NullString{String: "broker down", Valid: true}, sql.NullString{String: "HANDLER_ERROR", Valid: true},
+		sql.NullString{}, sql.NullString{String: "user-1", Valid: true}, sql.NullString{},
+		int64(PriorityCritical), "v1", int64(60000), int64(2000),
+	}
+
+	cmd, from, err := scanReplayCommand(row)
+	require.NoError(t, err)
+	assert.Equal(t, StatusDead, from.PreviousStatus)
+	assert.Equal(t, "HANDLER_ERROR", from.ErrorCode)
+
+	// Patches are validated against the version the command was submitted
+	// with, not the latest one
+	require.NoError(t, s.patchCommand(context.Background(), cmd, map[string]interface{}{"subject": "Invoice"}))
+
+	cmd.Status = StatusPending
+	data, err := cmd.Marshal()
+	require.NoError(t, err)
+	var delivered Command
+	require.NoError(t, delivered.Unmarshal(data))
+	require.NoError(t, s.validator.ValidateCommand(context.Background(), &delivered))
+
+	assert.Equal(t, PriorityCritical, delivered.Priority)
+	assert.Equal(t, "v1", delivered.SchemaVersion)
+	assert.Equal(t, time.Minute, delivered.TimeoutAfter)
+	assert.Equal(t, "Invoice", delivered.Payload["subject"])
+}
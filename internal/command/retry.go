@@ -0,0 +1,182 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/linkmeAman/universal-middleware/pkg/config"
+)
+
+// BackoffStrategy selects how the delay grows between attempts
+type BackoffStrategy string
+
+const (
+	BackoffFixed       BackoffStrategy = "fixed"
+	BackoffLinear      BackoffStrategy = "linear"
+	BackoffExponential BackoffStrategy = "exponential"
+)
+
+// defaultRetryDelay is used when neither the policy nor the command sets one
+const defaultRetryDelay = time.Second
+
+// BackoffPolicy computes the delay before a retry
+type BackoffPolicy struct {
+	Strategy BackoffStrategy
+	// Initial is the delay before the first retry. When zero the command's
+	// RetryBackoff is used instead.
+	Initial time.Duration
+	// Max caps the delay, zero means uncapped
+	Max time.Duration
+	// Multiplier is the growth factor of exponential backoff, defaults to 2
+	Multiplier float64
+	// Jitter is the fraction of the delay, between 0 and 1, that is randomly
+	// taken off so retries of many commands failing together spread out
+	Jitter float64
+}
+
+// DefaultBackoffPolicy returns the policy used for command types without one
+func DefaultBackoffPolicy() BackoffPolicy {
+	return BackoffPolicy{
+		Strategy:   BackoffExponential,
+		Max:        10 * time.Minute,
+		Multiplier: 2,
+		Jitter:     0.2,
+	}
+}
+
+// Delay returns the delay before the given retry, counting from 1
+func (b BackoffPolicy) Delay(attempt int, initial time.Duration) time.Duration {
+	if b.Initial > 0 {
+		initial = b.Initial
+	}
+	if initial <= 0 {
+		initial = defaultRetryDelay
+	}
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	var delay float64
+	switch b.Strategy {
+	case BackoffFixed:
+		delay = float64(initial)
+	case BackoffLinear:
+		delay = float64(initial) * float64(attempt)
+	default:
+		multiplier := b.Multiplier
+		if multiplier <= 1 {
+			multiplier = 2
+		}
+		delay = float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	}
+
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		delay -= delay * math.Min(b.Jitter, 1) * rand.Float64()
+	}
+
+	return time.Duration(delay)
+}
+
+// RetryPolicies holds the backoff policy of every command type
+type RetryPolicies struct {
+	mu            sync.RWMutex
+	defaultPolicy BackoffPolicy
+	byType        map[string]BackoffPolicy
+}
+
+// NewRetryPolicies creates a policy set falling back to the given default
+func NewRetryPolicies(defaultPolicy BackoffPolicy) *RetryPolicies {
+	return &RetryPolicies{
+		defaultPolicy: defaultPolicy,
+		byType:        make(map[string]BackoffPolicy),
+	}
+}
+
+// NewRetryPoliciesFromConfig builds the per command type backoff policies
+// from config. An entry without a command type replaces the default policy.
+func NewRetryPoliciesFromConfig(configs []config.RetryPolicyConfig) *RetryPolicies {
+	toPolicy := func(c config.RetryPolicyConfig) BackoffPolicy {
+		return BackoffPolicy{
+			Strategy:   BackoffStrategy(c.Strategy),
+			Initial:    c.Initial,
+			Max:        c.Max,
+			Multiplier: c.Multiplier,
+			Jitter:     c.Jitter,
+		}
+	}
+
+	defaultPolicy := DefaultBackoffPolicy()
+	for _, c := range configs {
+		if c.CommandType == "" {
+			defaultPolicy = toPolicy(c)
+		}
+	}
+
+	policies := NewRetryPolicies(defaultPolicy)
+	for _, c := range configs {
+		if c.CommandType != "" {
+			policies.Set(c.CommandType, toPolicy(c))
+		}
+	}
+	return policies
+}
+
+// Set configures the backoff policy of a command type
+func (r *RetryPolicies) Set(cmdType string, policy BackoffPolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byType[cmdType] = policy
+}
+
+// For returns the backoff policy of a command type
+func (r *RetryPolicies) For(cmdType string) BackoffPolicy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if policy, ok := r.byType[cmdType]; ok {
+		return policy
+	}
+	return r.defaultPolicy
+}
+
+// NextAttempt returns when the given retry of a command should run
+func (r *RetryPolicies) NextAttempt(cmd *Command, attempt int) time.Time {
+	return time.Now().Add(r.For(cmd.Type).Delay(attempt, cmd.RetryBackoff))
+}
+
+// PermanentError marks a handler error that retrying cannot fix
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent wraps an error so the command fails without being retried
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsRetryableError reports whether a failed attempt is worth retrying.
+// Handlers opt out with Permanent; cancellation is never retried.
+func IsRetryableError(err error) bool {
+	var permanent *PermanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+	return !errors.Is(err, ErrCommandCancelled) && !errors.Is(err, context.Canceled)
+}
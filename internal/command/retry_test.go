@@ -0,0 +1,61 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  BackoffPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{"fixed", BackoffPolicy{Strategy: BackoffFixed, Initial: time.Second}, 3, time.Second},
+		{"linear", BackoffPolicy{Strategy: BackoffLinear, Initial: time.Second}, 3, 3 * time.Second},
+		{"exponential", BackoffPolicy{Strategy: BackoffExponential, Initial: time.Second, Multiplier: 3}, 3, 9 * time.Second},
+		{"exponential default multiplier", BackoffPolicy{Strategy: BackoffExponential, Initial: time.Second}, 4, 8 * time.Second},
+		{"capped", BackoffPolicy{Strategy: BackoffExponential, Initial: time.Second, Max: 5 * time.Second}, 10, 5 * time.Second},
+		{"command backoff when initial unset", BackoffPolicy{Strategy: BackoffLinear}, 2, 4 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.policy.Delay(tt.attempt, 2*time.Second))
+		})
+	}
+}
+
+func TestBackoffPolicyJitter(t *testing.T) {
+	policy := BackoffPolicy{Strategy: BackoffFixed, Initial: 10 * time.Second, Jitter: 0.5}
+
+	for i := 0; i < 100; i++ {
+		delay := policy.Delay(1, 0)
+		assert.GreaterOrEqual(t, delay, 5*time.Second)
+		assert.LessOrEqual(t, delay, 10*time.Second)
+	}
+}
+
+func TestRetryPoliciesPerType(t *testing.T) {
+	policies := NewRetryPolicies(BackoffPolicy{Strategy: BackoffFixed, Initial: time.Second})
+	policies.Set(CommandTypePaymentProcess, BackoffPolicy{Strategy: BackoffFixed, Initial: time.Minute})
+
+	assert.Equal(t, time.Minute, policies.For(CommandTypePaymentProcess).Initial)
+	assert.Equal(t, time.Second, policies.For(CommandTypeEmailSend).Initial)
+}
+
+func TestIsRetryableError(t *testing.T) {
+	assert.True(t, IsRetryableError(errors.New("connection reset")))
+	assert.True(t, IsRetryableError(ErrCommandTimeout))
+	assert.False(t, IsRetryableError(Permanent(errors.New("card declined"))))
+	assert.False(t, IsRetryableError(fmt.Errorf("charge: %w", Permanent(errors.New("card declined")))))
+	assert.False(t, IsRetryableError(ErrCommandCancelled))
+	assert.False(t, IsRetryableError(context.Canceled))
+	assert.Nil(t, Permanent(nil))
+}
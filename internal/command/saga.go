@@ -0,0 +1,501 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/linkmeAman/universal-middleware/internal/events/schemas"
+	"github.com/linkmeAman/universal-middleware/pkg/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrSagaNotFound is returned for sagas that are not stored or not registered
+	ErrSagaNotFound = errors.New("saga not found")
+
+	// ErrSagaRolledBack is returned when a step failed and the completed
+	// steps before it were compensated
+	ErrSagaRolledBack = errors.New("saga rolled back")
+
+	// ErrSagaCompensationFailed is returned when a compensation could not be
+	// run. The saga is left failed and needs manual attention.
+	ErrSagaCompensationFailed = errors.New("saga compensation failed")
+
+	// errSagaStore marks failures to store saga state, which interrupt a
+	// saga rather than fail its step
+	errSagaStore = errors.New("failed to store saga")
+)
+
+// SagaStatus represents the state of a saga
+type SagaStatus string
+
+const (
+	SagaRunning      SagaStatus = "running"
+	SagaCompleted    SagaStatus = "completed"
+	SagaCompensating SagaStatus = "compensating"
+	SagaCompensated  SagaStatus = "compensated"
+	SagaFailed       SagaStatus = "failed"
+)
+
+// IsFinished reports whether the saga will not make further progress
+func (s SagaStatus) IsFinished() bool {
+	return s == SagaCompleted || s == SagaCompensated || s == SagaFailed
+}
+
+// SagaStepStatus represents the state of one step of a saga
+type SagaStepStatus string
+
+const (
+	SagaStepPending      SagaStepStatus = "pending"
+	SagaStepRunning      SagaStepStatus = "running"
+	SagaStepCompleted    SagaStepStatus = "completed"
+	SagaStepFailed       SagaStepStatus = "failed"
+	SagaStepCompensating SagaStepStatus = "compensating"
+	SagaStepCompensated  SagaStepStatus = "compensated"
+)
+
+// SagaStep declares one step of a saga
+type SagaStep struct {
+	Name string
+	// Action builds the command performing the step
+	Action func(saga *Saga) *Command
+	// Compensation builds the command undoing the step once it completed.
+	// Steps without side effects to undo leave it nil.
+	Compensation func(saga *Saga) *Command
+	// MaxRetries is how often the action, and later its compensation, is
+	// retried on retryable errors before giving up
+	MaxRetries int
+}
+
+// SagaDefinition declares a multi-step operation as ordered steps
+type SagaDefinition struct {
+	Name  string
+	Steps []SagaStep
+}
+
+// SagaStepState is the persisted progress of one step. Attempts counts the
+// commands run for the step, across resumes.
+type SagaStepState struct {
+	Name           string                 `json:"name"`
+	Status         SagaStepStatus         `json:"status"`
+	CommandType    string                 `json:"commandType,omitempty"`
+	CommandID      string                 `json:"commandId,omitempty"`
+	CompensationID string                 `json:"compensationId,omitempty"`
+	Attempts       int                    `json:"attempts,omitempty"`
+	Result         map[string]interface{} `json:"result,omitempty"`
+	Error          string                 `json:"error,omitempty"`
+	StartedAt      *time.Time             `json:"startedAt,omitempty"`
+	FinishedAt     *time.Time             `json:"finishedAt,omitempty"`
+}
+
+// Saga is a running or finished instance of a saga definition
+type Saga struct {
+	ID            string                 `json:"id"`
+	Name          string                 `json:"name"`
+	Status        SagaStatus             `json:"status"`
+	Data          map[string]interface{} `json:"data"`
+	Steps         []*SagaStepState       `json:"steps"`
+	CurrentStep   int                    `json:"currentStep"`
+	CorrelationID string                 `json:"correlationId,omitempty"`
+	Error         string                 `json:"error,omitempty"`
+	CreatedAt     time.Time              `json:"createdAt"`
+	UpdatedAt     time.Time              `json:"updatedAt"`
+}
+
+// Step returns the state of the named step, or nil if there is none
+func (s *Saga) Step(name string) *SagaStepState {
+	for _, step := range s.Steps {
+		if step.Name == name {
+			return step
+		}
+	}
+	return nil
+}
+
+// SagaStore persists sagas. Rollback events are saved together with the
+// saga state they belong to so they are emitted exactly when it is stored.
+type SagaStore interface {
+	Save(ctx context.Context, saga *Saga, events ...*schemas.CommandRollbackedEvent) error
+	Get(ctx context.Context, id string) (*Saga, error)
+	// ListUnfinished returns running and compensating sagas, oldest first
+	ListUnfinished(ctx context.Context, limit int) ([]*Saga, error)
+}
+
+// SagaOrchestrator drives sagas through the command processor. Steps run one
+// after another; when one fails, the completed steps before it are
+// compensated in reverse order. Saga state is stored after every transition
+// so an interrupted saga can be resumed where it stopped.
+//
+// Retries of step commands are handled here rather than by the processor so
+// a saga never continues while one of its steps is waiting in the scheduler.
+// Every attempt of a step, including the one after a resume, is a command of
+// its own; handlers recognise repeated attempts by the saga_id and saga_step
+// metadata.
+type SagaOrchestrator struct {
+	processor   *Processor
+	store       SagaStore
+	log         *logger.Logger
+	tracer      trace.Tracer
+	mu          sync.RWMutex
+	definitions map[string]*SagaDefinition
+}
+
+// NewSagaOrchestrator creates a new saga orchestrator
+func NewSagaOrchestrator(processor *Processor, store SagaStore, log *logger.Logger) *SagaOrchestrator {
+	return &SagaOrchestrator{
+		processor:   processor,
+		store:       store,
+		log:         log,
+		tracer:      otel.GetTracerProvider().Tracer("saga-orchestrator"),
+		definitions: make(map[string]*SagaDefinition),
+	}
+}
+
+// Register adds a saga definition
+func (o *SagaOrchestrator) Register(def *SagaDefinition) error {
+	if def.Name == "" {
+		return fmt.Errorf("saga name is required")
+	}
+	if len(def.Steps) == 0 {
+		return fmt.Errorf("saga %s has no steps", def.Name)
+	}
+	seen := make(map[string]bool, len(def.Steps))
+	for i, step := range def.Steps {
+		if step.Name == "" || step.Action == nil {
+			return fmt.Errorf("step %d of saga %s needs a name and an action", i, def.Name)
+		}
+		if seen[step.Name] {
+			return fmt.Errorf("saga %s has duplicate step %s", def.Name, step.Name)
+		}
+		seen[step.Name] = true
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.definitions[def.Name] = def
+	return nil
+}
+
+// Start creates a saga of the named definition and runs it to the end. The
+// returned saga reflects the final state even when an error is returned.
+func (o *SagaOrchestrator) Start(ctx context.Context, name string, data map[string]interface{}) (*Saga, error) {
+	def, err := o.definition(name)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	saga := &Saga{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Status:    SagaRunning,
+		Data:      data,
+		Steps:     make([]*SagaStepState, len(def.Steps)),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if saga.Data == nil {
+		saga.Data = make(map[string]interface{})
+	}
+	saga.CorrelationID = saga.ID
+	for i, step := range def.Steps {
+		saga.Steps[i] = &SagaStepState{Name: step.Name, Status: SagaStepPending}
+	}
+
+	if err := o.store.Save(ctx, saga); err != nil {
+		return nil, fmt.Errorf("failed to store saga: %w", err)
+	}
+
+	return saga, o.run(ctx, def, saga)
+}
+
+// Resume continues a stored saga that was interrupted
+func (o *SagaOrchestrator) Resume(ctx context.Context, id string) (*Saga, error) {
+	saga, err := o.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if saga.Status.IsFinished() {
+		return saga, nil
+	}
+
+	def, err := o.definition(saga.Name)
+	if err != nil {
+		return saga, err
+	}
+	if len(def.Steps) != len(saga.Steps) {
+		return saga, fmt.Errorf("saga %s has %d steps but definition %s has %d",
+			saga.ID, len(saga.Steps), def.Name, len(def.Steps))
+	}
+
+	return saga, o.run(ctx, def, saga)
+}
+
+// Recover resumes the sagas left unfinished by a previous run. It is meant to
+// be called once at startup, before new sagas are started.
+func (o *SagaOrchestrator) Recover(ctx context.Context, limit int) (int, error) {
+	sagas, err := o.store.ListUnfinished(ctx, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list unfinished sagas: %w", err)
+	}
+
+	for _, saga := range sagas {
+		if _, err := o.Resume(ctx, saga.ID); err != nil {
+			o.log.Warn("Resumed saga did not complete",
+				zap.String("saga_id", saga.ID),
+				zap.String("saga", saga.Name),
+				zap.Error(err),
+			)
+		}
+	}
+
+	return len(sagas), nil
+}
+
+func (o *SagaOrchestrator) definition(name string) (*SagaDefinition, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	def, ok := o.definitions[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: no definition named %s", ErrSagaNotFound, name)
+	}
+	return def, nil
+}
+
+// run drives a saga from its current state until it finishes or the context
+// is done. An interrupted saga keeps its status and can be resumed.
+func (o *SagaOrchestrator) run(ctx context.Context, def *SagaDefinition, saga *Saga) error {
+	ctx, span := o.tracer.Start(ctx, "saga.run",
+		trace.WithAttributes(
+			attribute.String("saga.id", saga.ID),
+			attribute.String("saga.name", saga.Name),
+		),
+	)
+	defer span.End()
+
+	if saga.Status == SagaRunning {
+		for saga.Status == SagaRunning && saga.CurrentStep < len(def.Steps) {
+			if err := o.runStep(ctx, def, saga); err != nil && saga.Status != SagaCompensating {
+				return err
+			}
+		}
+
+		if saga.Status == SagaRunning {
+			saga.Status = SagaCompleted
+			saga.UpdatedAt = time.Now()
+			if err := o.store.Save(ctx, saga); err != nil {
+				return fmt.Errorf("failed to store saga: %w", err)
+			}
+			o.log.Info("Saga completed",
+				zap.String("saga_id", saga.ID),
+				zap.String("saga", saga.Name),
+			)
+			return nil
+		}
+	}
+
+	return o.compensate(ctx, def, saga)
+}
+
+// runStep runs the current step and advances the saga. A failed step moves
+// the saga to compensating unless the failure came from the context, in
+// which case the saga stays running.
+func (o *SagaOrchestrator) runStep(ctx context.Context, def *SagaDefinition, saga *Saga) error {
+	step := def.Steps[saga.CurrentStep]
+	state := saga.Steps[saga.CurrentStep]
+
+	state.Status = SagaStepRunning
+	state.StartedAt = o.processor.now()
+	cmd, err := o.execute(ctx, saga, step, step.Action, func(cmd *Command) error {
+		state.CommandID = cmd.ID
+		state.CommandType = cmd.Type
+		state.Attempts++
+		saga.UpdatedAt = time.Now()
+		return o.save(ctx, saga)
+	})
+	if err != nil && interrupted(ctx, err) {
+		return err
+	}
+
+	state.FinishedAt = o.processor.now()
+	saga.UpdatedAt = *state.FinishedAt
+	if err != nil {
+		o.log.Error("Saga step failed",
+			zap.String("saga_id", saga.ID),
+			zap.String("saga", saga.Name),
+			zap.String("step", step.Name),
+			zap.String("command_id", cmd.ID),
+			zap.Error(err),
+		)
+		state.Status = SagaStepFailed
+		state.Error = err.Error()
+		saga.Status = SagaCompensating
+		saga.Error = fmt.Sprintf("step %s failed: %v", step.Name, err)
+	} else {
+		state.Status = SagaStepCompleted
+		state.Result = cmd.Metadata
+		saga.CurrentStep++
+	}
+
+	if saveErr := o.save(ctx, saga); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// save stores the saga, marking failures with errSagaStore
+func (o *SagaOrchestrator) save(ctx context.Context, saga *Saga) error {
+	if err := o.store.Save(ctx, saga); err != nil {
+		return fmt.Errorf("%w: %w", errSagaStore, err)
+	}
+	return nil
+}
+
+// interrupted reports whether a step failed because the saga could not go
+// on, rather than because its command failed
+func interrupted(ctx context.Context, err error) bool {
+	return ctx.Err() != nil || errors.Is(err, ErrProcessorStopped) || errors.Is(err, errSagaStore)
+}
+
+// compensate undoes the completed steps before the current one, last first
+func (o *SagaOrchestrator) compensate(ctx context.Context, def *SagaDefinition, saga *Saga) error {
+	for i := saga.CurrentStep - 1; i >= 0; i-- {
+		step := def.Steps[i]
+		state := saga.Steps[i]
+		if state.Status == SagaStepCompensated || step.Compensation == nil {
+			continue
+		}
+
+		state.Status = SagaStepCompensating
+		cmd, err := o.execute(ctx, saga, step, step.Compensation, func(cmd *Command) error {
+			state.CompensationID = cmd.ID
+			saga.UpdatedAt = time.Now()
+			return o.save(ctx, saga)
+		})
+		if err != nil {
+			if interrupted(ctx, err) {
+				return err
+			}
+
+			o.log.Error("Saga compensation failed",
+				zap.String("saga_id", saga.ID),
+				zap.String("saga", saga.Name),
+				zap.String("step", step.Name),
+				zap.String("command_id", cmd.ID),
+				zap.Error(err),
+			)
+			state.Error = err.Error()
+			saga.Status = SagaFailed
+			saga.Error = fmt.Sprintf("compensation of step %s failed: %v", step.Name, err)
+			saga.UpdatedAt = time.Now()
+			if saveErr := o.store.Save(ctx, saga); saveErr != nil {
+				return fmt.Errorf("failed to store saga: %w", saveErr)
+			}
+			return fmt.Errorf("%w: step %s: %v", ErrSagaCompensationFailed, step.Name, err)
+		}
+
+		state.Status = SagaStepCompensated
+		saga.UpdatedAt = time.Now()
+		event := &schemas.CommandRollbackedEvent{
+			Event: schemas.Event{
+				ID:            uuid.New().String(),
+				Type:          schemas.EventTypeCommandRollbacked,
+				Source:        "command-service",
+				DataVersion:   "1.0",
+				Time:          saga.UpdatedAt.UTC(),
+				CorrelationID: saga.CorrelationID,
+				CausationID:   cmd.ID,
+			},
+			CommandID:      state.CommandID,
+			CommandType:    state.CommandType,
+			CompensationID: cmd.ID,
+			SagaID:         saga.ID,
+			SagaName:       saga.Name,
+			Step:           step.Name,
+			Reason:         saga.Error,
+		}
+		if err := o.store.Save(ctx, saga, event); err != nil {
+			return fmt.Errorf("failed to store saga: %w", err)
+		}
+
+		o.log.Info("Saga step compensated",
+			zap.String("saga_id", saga.ID),
+			zap.String("step", step.Name),
+			zap.String("compensation_id", cmd.ID),
+		)
+	}
+
+	saga.Status = SagaCompensated
+	saga.UpdatedAt = time.Now()
+	if err := o.store.Save(ctx, saga); err != nil {
+		return fmt.Errorf("failed to store saga: %w", err)
+	}
+
+	o.log.Info("Saga rolled back",
+		zap.String("saga_id", saga.ID),
+		zap.String("saga", saga.Name),
+		zap.String("reason", saga.Error),
+	)
+	return fmt.Errorf("%w: %s", ErrSagaRolledBack, saga.Error)
+}
+
+// execute runs a step command built by build through the processor. Every
+// attempt is a new command, retried with the command type's backoff policy
+// up to the step's MaxRetries. record is called with each attempt before it
+// runs. The last attempt is returned.
+func (o *SagaOrchestrator) execute(ctx context.Context, saga *Saga, step SagaStep, build func(*Saga) *Command, record func(*Command) error) (*Command, error) {
+	for attempt := 1; ; attempt++ {
+		cmd := build(saga)
+		cmd.ID = uuid.New().String()
+		if cmd.CorrelationID == "" {
+			cmd.CorrelationID = saga.CorrelationID
+		}
+		if cmd.Metadata == nil {
+			cmd.Metadata = make(map[string]interface{})
+		}
+		cmd.Metadata["saga_id"] = saga.ID
+		cmd.Metadata["saga_step"] = step.Name
+		cmd.Metadata["saga_attempt"] = attempt
+		// Without retries of its own a failing command goes dead instead of
+		// being handed to the scheduler
+		cmd.MaxRetries = 0
+
+		if err := record(cmd); err != nil {
+			return cmd, err
+		}
+
+		err := o.processor.Process(ctx, cmd)
+		if err == nil {
+			return cmd, nil
+		}
+		// Only retryable handler failures end dead, anything else is final
+		if cmd.Status != StatusDead || attempt > step.MaxRetries {
+			return cmd, err
+		}
+
+		delay := o.processor.retryPolicies.For(cmd.Type).Delay(attempt, cmd.RetryBackoff)
+		o.log.Warn("Retrying saga step",
+			zap.String("saga_id", saga.ID),
+			zap.String("step", step.Name),
+			zap.String("command_id", cmd.ID),
+			zap.Int("attempt", attempt),
+			zap.Duration("delay", delay),
+			zap.Error(err),
+		)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return cmd, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
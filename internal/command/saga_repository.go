@@ -0,0 +1,165 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/linkmeAman/universal-middleware/internal/database"
+	"github.com/linkmeAman/universal-middleware/internal/events/schemas"
+)
+
+// SagaRepository stores sagas in the sagas table and their rollback events
+// in the outbox
+type SagaRepository struct {
+	db database.DB
+}
+
+// NewSagaRepository creates a new saga repository
+func NewSagaRepository(db database.DB) *SagaRepository {
+	return &SagaRepository{db: db}
+}
+
+// Save upserts the saga and queues its events in the same transaction
+func (r *SagaRepository) Save(ctx context.Context, saga *Saga, events ...*schemas.CommandRollbackedEvent) error {
+	dataJSON, err := json.Marshal(saga.Data)
+	if err != nil {
+		return fmt.Errorf("failed to encode saga data: %w", err)
+	}
+	stepsJSON, err := json.Marshal(saga.Steps)
+	if err != nil {
+		return fmt.Errorf("failed to encode saga steps: %w", err)
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		INSERT INTO sagas (
+			id, name, status, data, steps, current_step,
+			correlation_id, error, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (id) DO UPDATE
+		SET status = EXCLUDED.status,
+			data = EXCLUDED.data,
+			steps = EXCLUDED.steps,
+			current_step = EXCLUDED.current_step,
+			error = EXCLUDED.error,
+			updated_at = EXCLUDED.updated_at`
+
+	if _, err := tx.Exec(ctx, query,
+		saga.ID, saga.Name, saga.Status, dataJSON, stepsJSON, saga.CurrentStep,
+		nullString(saga.CorrelationID), nullString(saga.Error), saga.CreatedAt, saga.UpdatedAt,
+	); err != nil {
+		return fmt.Errorf("failed to save saga: %w", err)
+	}
+
+	for _, event := range events {
+		payloadJSON, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+
+		outbox := `
+			INSERT INTO outbox_messages (
+				id, aggregate_type, aggregate_id, event_type,
+				payload, topic, status, created_at, retry_count
+			) VALUES ($1, 'saga', $2, $3, $4, $5, 'pending', $6, 0)`
+
+		if _, err := tx.Exec(ctx, outbox,
+			event.ID, saga.ID, string(event.Type), payloadJSON, LifecycleTopic, event.Time,
+		); err != nil {
+			return fmt.Errorf("failed to store saga event: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Get loads a saga by ID
+func (r *SagaRepository) Get(ctx context.Context, id string) (*Saga, error) {
+	query := `
+		SELECT id, name, status, data, steps, current_step,
+			   correlation_id, error, created_at, updated_at
+		FROM sagas
+		WHERE id = $1`
+
+	saga, err := scanSaga(r.db.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrSagaNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load saga: %w", err)
+	}
+
+	return saga, nil
+}
+
+// ListUnfinished returns running and compensating sagas, oldest first
+func (r *SagaRepository) ListUnfinished(ctx context.Context, limit int) ([]*Saga, error) {
+	query := `
+		SELECT id, name, status, data, steps, current_step,
+			   correlation_id, error, created_at, updated_at
+		FROM sagas
+		WHERE status IN ($1, $2)
+		ORDER BY created_at ASC
+		LIMIT $3`
+
+	rows, err := r.db.Query(ctx, query, SagaRunning, SagaCompensating, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query unfinished sagas: %w", err)
+	}
+	defer rows.Close()
+
+	var sagas []*Saga
+	for rows.Next() {
+		saga, err := scanSaga(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan saga: %w", err)
+		}
+		sagas = append(sagas, saga)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sagas: %w", err)
+	}
+
+	return sagas, nil
+}
+
+func scanSaga(row database.Row) (*Saga, error) {
+	saga := &Saga{}
+	var dataJSON, stepsJSON []byte
+	var correlationID, errorMsg *string
+
+	if err := row.Scan(
+		&saga.ID, &saga.Name, &saga.Status, &dataJSON, &stepsJSON, &saga.CurrentStep,
+		&correlationID, &errorMsg, &saga.CreatedAt, &saga.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(dataJSON, &saga.Data); err != nil {
+		return nil, fmt.Errorf("failed to decode data of saga %s: %w", saga.ID, err)
+	}
+	if err := json.Unmarshal(stepsJSON, &saga.Steps); err != nil {
+		return nil, fmt.Errorf("failed to decode steps of saga %s: %w", saga.ID, err)
+	}
+	if correlationID != nil {
+		saga.CorrelationID = *correlationID
+	}
+	if errorMsg != nil {
+		saga.Error = *errorMsg
+	}
+
+	return saga, nil
+}
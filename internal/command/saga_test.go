@@ -0,0 +1,247 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/linkmeAman/universal-middleware/internal/events/schemas"
+	"github.com/linkmeAman/universal-middleware/test/testutil"
+)
+
+type memSagaStore struct {
+	mu     sync.Mutex
+	sagas  map[string][]byte
+	events []*schemas.CommandRollbackedEvent
+}
+
+func newMemSagaStore() *memSagaStore {
+	return &memSagaStore{sagas: make(map[string][]byte)}
+}
+
+func (s *memSagaStore) Save(ctx context.Context, saga *Saga, events ...*schemas.CommandRollbackedEvent) error {
+	data, err := json.Marshal(saga)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sagas[saga.ID] = data
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *memSagaStore) Get(ctx context.Context, id string) (*Saga, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.sagas[id]
+	if !ok {
+		return nil, ErrSagaNotFound
+	}
+	saga := &Saga{}
+	return saga, json.Unmarshal(data, saga)
+}
+
+func (s *memSagaStore) ListUnfinished(ctx context.Context, limit int) ([]*Saga, error) {
+	s.mu.Lock()
+	var ids []string
+	for id, data := range s.sagas {
+		saga := &Saga{}
+		if err := json.Unmarshal(data, saga); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		if !saga.Status.IsFinished() {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.Unlock()
+
+	var sagas []*Saga
+	for _, id := range ids {
+		saga, _ := s.Get(ctx, id)
+		sagas = append(sagas, saga)
+	}
+	return sagas, nil
+}
+
+// recordingHandler records the commands it runs and fails the types in fail
+type recordingHandler struct {
+	mu   sync.Mutex
+	ran  []string
+	ids  []string
+	fail map[string]error
+}
+
+func (h *recordingHandler) HandleCommand(ctx context.Context, cmd *Command) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ran = append(h.ran, cmd.Type)
+	h.ids = append(h.ids, cmd.ID)
+	if err, ok := h.fail[cmd.Type]; ok {
+		return err
+	}
+	cmd.Metadata["handled_by"] = "recording"
+	return nil
+}
+
+func (h *recordingHandler) CanHandle(cmdType string) bool {
+	return true
+}
+
+func orderSaga(retries int) *SagaDefinition {
+	step := func(cmdType string) func(*Saga) *Command {
+		return func(s *Saga) *Command {
+			return NewCommand(cmdType, map[string]interface{}{"order_id": s.Data["order_id"]})
+		}
+	}
+	return &SagaDefinition{
+		Name: "order.checkout",
+		Steps: []SagaStep{
+			{Name: "order", Action: step(CommandTypeOrderCreate), Compensation: step(CommandTypeOrderCancel)},
+			{Name: "payment", Action: step(CommandTypePaymentProcess), Compensation: step("payment.refund"), MaxRetries: retries},
+			{Name: "email", Action: step(CommandTypeEmailSend)},
+		},
+	}
+}
+
+func newTestOrchestrator(t *testing.T, handler *recordingHandler, store SagaStore, retries int) *SagaOrchestrator {
+	p := newTestProcessor(t, ProcessorConfig{
+		MaxWorkers:    1,
+		RetryPolicies: NewRetryPolicies(BackoffPolicy{Strategy: BackoffFixed, Initial: time.Millisecond}),
+	})
+	for _, cmdType := range []string{CommandTypeOrderCreate, CommandTypeOrderCancel, CommandTypePaymentProcess, "payment.refund", CommandTypeEmailSend} {
+		p.handlers[cmdType] = []Handler{handler}
+	}
+
+	o := NewSagaOrchestrator(p, store, testutil.NewTestLogger(t))
+	require.NoError(t, o.Register(orderSaga(retries)))
+	return o
+}
+
+func TestSagaCompletes(t *testing.T) {
+	handler := &recordingHandler{}
+	store := newMemSagaStore()
+	o := newTestOrchestrator(t, handler, store, 0)
+
+	saga, err := o.Start(context.Background(), "order.checkout", map[string]interface{}{"order_id": "o-1"})
+	require.NoError(t, err)
+
+	assert.Equal(t, SagaCompleted, saga.Status)
+	assert.Equal(t, []string{CommandTypeOrderCreate, CommandTypePaymentProcess, CommandTypeEmailSend}, handler.ran)
+	assert.Equal(t, "recording", saga.Step("payment").Result["handled_by"])
+	assert.Equal(t, saga.ID, saga.Step("payment").Result["saga_id"])
+	assert.Empty(t, store.events)
+
+	stored, err := store.Get(context.Background(), saga.ID)
+	require.NoError(t, err)
+	assert.Equal(t, SagaCompleted, stored.Status)
+	assert.Equal(t, 3, stored.CurrentStep)
+}
+
+func TestSagaCompensatesInReverse(t *testing.T) {
+	handler := &recordingHandler{fail: map[string]error{
+		CommandTypeEmailSend: Permanent(errors.New("mailbox does not exist")),
+	}}
+	store := newMemSagaStore()
+	o := newTestOrchestrator(t, handler, store, 0)
+
+	saga, err := o.Start(context.Background(), "order.checkout", map[string]interface{}{"order_id": "o-1"})
+	assert.ErrorIs(t, err, ErrSagaRolledBack)
+
+	assert.Equal(t, SagaCompensated, saga.Status)
+	assert.Equal(t, []string{
+		CommandTypeOrderCreate, CommandTypePaymentProcess, CommandTypeEmailSend,
+		"payment.refund", CommandTypeOrderCancel,
+	}, handler.ran)
+	assert.Equal(t, SagaStepFailed, saga.Step("email").Status)
+	assert.Equal(t, SagaStepCompensated, saga.Step("payment").Status)
+	assert.Equal(t, SagaStepCompensated, saga.Step("order").Status)
+
+	require.Len(t, store.events, 2)
+	assert.Equal(t, schemas.EventTypeCommandRollbacked, store.events[0].Type)
+	assert.Equal(t, "payment", store.events[0].Step)
+	assert.Equal(t, CommandTypePaymentProcess, store.events[0].CommandType)
+	assert.Equal(t, saga.Step("payment").CommandID, store.events[0].CommandID)
+	assert.Equal(t, "order", store.events[1].Step)
+}
+
+func TestSagaRetriesStep(t *testing.T) {
+	handler := &recordingHandler{fail: map[string]error{
+		CommandTypePaymentProcess: errors.New("gateway unavailable"),
+	}}
+	o := newTestOrchestrator(t, handler, newMemSagaStore(), 2)
+
+	saga, err := o.Start(context.Background(), "order.checkout", nil)
+	assert.ErrorIs(t, err, ErrSagaRolledBack)
+
+	assert.Equal(t, []string{
+		CommandTypeOrderCreate,
+		CommandTypePaymentProcess, CommandTypePaymentProcess, CommandTypePaymentProcess,
+		CommandTypeOrderCancel,
+	}, handler.ran)
+	assert.Equal(t, SagaCompensated, saga.Status)
+
+	// Every attempt is a command of its own, the last one is recorded
+	payment := handler.ids[1:4]
+	assert.NotEqual(t, payment[0], payment[1])
+	assert.NotEqual(t, payment[1], payment[2])
+	assert.Equal(t, payment[2], saga.Step("payment").CommandID)
+	assert.Equal(t, 3, saga.Step("payment").Attempts)
+}
+
+func TestSagaCompensationFailure(t *testing.T) {
+	handler := &recordingHandler{fail: map[string]error{
+		CommandTypeEmailSend: Permanent(errors.New("mailbox does not exist")),
+		"payment.refund":     Permanent(errors.New("payment already settled")),
+	}}
+	store := newMemSagaStore()
+	o := newTestOrchestrator(t, handler, store, 0)
+
+	saga, err := o.Start(context.Background(), "order.checkout", nil)
+	assert.ErrorIs(t, err, ErrSagaCompensationFailed)
+
+	assert.Equal(t, SagaFailed, saga.Status)
+	assert.NotContains(t, handler.ran, CommandTypeOrderCancel)
+	assert.Empty(t, store.events)
+}
+
+func TestSagaResume(t *testing.T) {
+	handler := &recordingHandler{}
+	store := newMemSagaStore()
+	o := newTestOrchestrator(t, handler, store, 0)
+
+	// A saga interrupted after its first step, with the second one started
+	saga := &Saga{
+		ID:          "saga-1",
+		Name:        "order.checkout",
+		Status:      SagaRunning,
+		Data:        map[string]interface{}{},
+		CurrentStep: 1,
+		Steps: []*SagaStepState{
+			{Name: "order", Status: SagaStepCompleted, CommandID: "cmd-order"},
+			{Name: "payment", Status: SagaStepRunning, CommandID: "cmd-payment"},
+			{Name: "email", Status: SagaStepPending},
+		},
+	}
+	require.NoError(t, store.Save(context.Background(), saga))
+
+	n, err := o.Recover(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	resumed, err := store.Get(context.Background(), "saga-1")
+	require.NoError(t, err)
+	assert.Equal(t, SagaCompleted, resumed.Status)
+	assert.Equal(t, []string{CommandTypePaymentProcess, CommandTypeEmailSend}, handler.ran)
+	// The interrupted step runs again as a new attempt
+	assert.Equal(t, handler.ids[0], resumed.Step("payment").CommandID)
+	assert.NotEqual(t, "cmd-payment", resumed.Step("payment").CommandID)
+	assert.Equal(t, 1, resumed.Step("payment").Attempts)
+}
@@ -0,0 +1,257 @@
+package command
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SchedulerConfig holds configuration for the delayed command scheduler
+type SchedulerConfig struct {
+	// PollInterval is the longest the scheduler sleeps between scans. It bounds
+	// how late a command submitted through another replica can be released.
+	PollInterval time.Duration
+	BatchSize    int
+}
+
+// DefaultSchedulerConfig returns default scheduler configuration
+func DefaultSchedulerConfig() SchedulerConfig {
+	return SchedulerConfig{
+		PollInterval: 5 * time.Second,
+		BatchSize:    100,
+	}
+}
+
+// Scheduler releases future-dated commands once their ScheduledFor time has
+// come, as well as retrying commands once their backoff has elapsed.
+//
+// Due commands are claimed with FOR UPDATE SKIP LOCKED and moved to the outbox
+// in the same transaction, so every command is released exactly once even when
+// several replicas run a scheduler against the same database.
+type Scheduler struct {
+	svc    *CommandService
+	config SchedulerConfig
+	log    *zap.Logger
+
+	mu       sync.Mutex
+	nextWake time.Time
+	wake     chan struct{}
+}
+
+// NewScheduler creates a scheduler releasing commands through the command service
+func NewScheduler(svc *CommandService, config SchedulerConfig) *Scheduler {
+	return &Scheduler{
+		svc:    svc,
+		config: config,
+		log:    svc.log,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Start runs the scheduler loop until the context is cancelled
+func (s *Scheduler) Start(ctx context.Context) {
+	s.log.Info("Starting command scheduler",
+		zap.Duration("poll_interval", s.config.PollInterval),
+		zap.Int("batch_size", s.config.BatchSize))
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-s.wake:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+
+		released, err := s.releaseDue(ctx)
+		if err != nil {
+			s.log.Error("Failed to release scheduled commands", zap.Error(err))
+		}
+
+		// A full batch means more commands are probably due already
+		delay := time.Duration(0)
+		if err != nil || released < s.config.BatchSize {
+			delay = s.nextDelay(ctx)
+		}
+
+		s.mu.Lock()
+		s.nextWake = time.Now().Add(delay)
+		s.mu.Unlock()
+
+		timer.Reset(delay)
+	}
+}
+
+// Notify tells the scheduler about a command scheduled for the given time,
+// waking it up early if the command is due before its next planned scan
+func (s *Scheduler) Notify(at time.Time) {
+	s.mu.Lock()
+	earlier := s.nextWake.IsZero() || at.Before(s.nextWake)
+	s.mu.Unlock()
+
+	if !earlier {
+		return
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dueCommandColumns are the columns scanDueCommand reads
+const dueCommandColumns = `id, type, status, entity_id, payload, created_at, scheduled_for, retry_count,
+			max_retries, COALESCE(callback_url, ''), COALESCE(user_id, ''), COALESCE(correlation_id, ''),
+			` + executionColumns
+
+// scanDueCommand rebuilds a due command from a row of dueCommandColumns
+func scanDueCommand(row rowScanner) (*Command, error) {
+	cmd := &Command{}
+	var payloadJSON []byte
+	var scheduledFor sql.NullTime
+	var execution executionFields
+	if err := row.Scan(append([]interface{}{
+		&cmd.ID,
+		&cmd.Type,
+		&cmd.Status,
+		&cmd.EntityID,
+		&payloadJSON,
+		&cmd.CreatedAt,
+		&scheduledFor,
+		&cmd.RetryCount,
+		&cmd.MaxRetries,
+		&cmd.CallbackURL,
+		&cmd.UserID,
+		&cmd.CorrelationID,
+	}, execution.dest()...)...); err != nil {
+		return nil, fmt.Errorf("failed to scan command: %w", err)
+	}
+	if err := json.Unmarshal(payloadJSON, &cmd.Payload); err != nil {
+		return nil, fmt.Errorf("failed to decode payload of command %s: %w", cmd.ID, err)
+	}
+	if scheduledFor.Valid {
+		cmd.ScheduledFor = &scheduledFor.Time
+	}
+	execution.apply(cmd)
+	return cmd, nil
+}
+
+// releaseDue moves a batch of due commands to the outbox and returns how many were released
+func (s *Scheduler) releaseDue(ctx context.Context) (int, error) {
+	tx, err := s.svc.db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT ` + dueCommandColumns + `
+		FROM commands
+		WHERE status IN ($1, $2) AND scheduled_for <= NOW()
+		ORDER BY scheduled_for ASC
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	`
+
+	rows, err := tx.QueryContext(ctx, query, StatusScheduled, StatusRetrying, s.config.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query due commands: %w", err)
+	}
+
+	var due []*Command
+	for rows.Next() {
+		cmd, err := scanDueCommand(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, cmd)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating due commands: %w", err)
+	}
+
+	if len(due) == 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+	for _, cmd := range due {
+		previous := cmd.Status
+		cmd.Status = StatusPending
+		cmd.UpdatedAt = now
+
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE commands SET status = $1 WHERE id = $2`,
+			cmd.Status, cmd.ID,
+		); err != nil {
+			return 0, fmt.Errorf("failed to release command %s: %w", cmd.ID, err)
+		}
+
+		if err := s.svc.storeInOutbox(ctx, tx, cmd); err != nil {
+			return 0, fmt.Errorf("failed to store command %s in outbox: %w", cmd.ID, err)
+		}
+
+		if err := storeTransition(ctx, tx, cmd, previous); err != nil {
+			return 0, fmt.Errorf("command %s: %w", cmd.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	for _, cmd := range due {
+		if err := s.svc.cacheCommandStatus(ctx, cmd); err != nil {
+			s.log.Warn("Failed to cache command status",
+				zap.String("command_id", cmd.ID),
+				zap.Error(err))
+		}
+	}
+
+	s.log.Info("Released scheduled commands", zap.Int("count", len(due)))
+
+	return len(due), nil
+}
+
+// nextDelay returns how long to sleep until the earliest scheduled command is due
+func (s *Scheduler) nextDelay(ctx context.Context) time.Duration {
+	var next sql.NullTime
+	err := s.svc.db.QueryRowContext(ctx,
+		`SELECT MIN(scheduled_for) FROM commands WHERE status IN ($1, $2)`,
+		StatusScheduled, StatusRetrying,
+	).Scan(&next)
+	if err != nil {
+		s.log.Warn("Failed to look up next scheduled command", zap.Error(err))
+		return s.config.PollInterval
+	}
+
+	if !next.Valid {
+		return s.config.PollInterval
+	}
+
+	delay := time.Until(next.Time)
+	if delay < 0 {
+		return 0
+	}
+	if delay > s.config.PollInterval {
+		return s.config.PollInterval
+	}
+	return delay
+}
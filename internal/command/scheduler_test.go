@@ -0,0 +1,75 @@
+package command
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRow is a database row holding the values of its columns
+type fakeRow []interface{}
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	if len(dest) != len(r) {
+		return fmt.Errorf("scanning %d columns into %d destinations", len(r), len(dest))
+	}
+	for i, value := range r {
+		target := reflect.ValueOf(dest[i]).Elem()
+		v := reflect.ValueOf(value)
+		if !v.Type().AssignableTo(target.Type()) {
+			return fmt.Errorf("column %d: cannot scan %T into %s", i, value, target.Type())
+		}
+		target.Set(v)
+	}
+	return nil
+}
+
+// dueRow is the row of dueCommandColumns storeCommand leaves for cmd
+func dueRow(cmd *Command) fakeRow {
+	payloadJSON, _ := json.Marshal(cmd.Payload)
+	scheduledFor := sql.NullTime{}
+	if cmd.ScheduledFor != nil {
+		scheduledFor = sql.NullTime{Time: *cmd.ScheduledFor, Valid: true}
+	}
+	return fakeRow{
+		cmd.ID, cmd.Type, cmd.Status, cmd.EntityID, payloadJSON, cmd.CreatedAt, scheduledFor,
+		cmd.RetryCount, cmd.MaxRetries, cmd.CallbackURL, cmd.UserID, cmd.CorrelationID,
+		int64(cmd.Priority), cmd.SchemaVersion, cmd.TimeoutAfter.Milliseconds(), cmd.RetryBackoff.Milliseconds(),
+	}
+}
+
+func TestReleasedCommandPassesValidation(t *testing.T) {
+	due := time.Now().Add(-time.Minute)
+	submitted := NewCommand(CommandTypeCacheWarmup, map[string]interface{}{})
+	submitted.Priority = PriorityHigh
+	submitted.Status = StatusScheduled
+	submitted.ScheduledFor = &due
+	submitted.TimeoutAfter = 10 * time.Second
+	submitted.RetryBackoff = time.Second
+	submitted.UserID = "user-1"
+	submitted.CorrelationID = "corr-1"
+
+	released, err := scanDueCommand(dueRow(submitted))
+	require.NoError(t, err)
+	released.Status = StatusPending
+
+	// The processor decodes the outbox message and validates it again
+	data, err := released.Marshal()
+	require.NoError(t, err)
+	var delivered Command
+	require.NoError(t, delivered.Unmarshal(data))
+	require.NoError(t, NewValidator().ValidateCommand(context.Background(), &delivered))
+
+	assert.Equal(t, PriorityHigh, delivered.Priority)
+	assert.Equal(t, 10*time.Second, delivered.TimeoutAfter)
+	assert.Equal(t, time.Second, delivered.RetryBackoff)
+	assert.Equal(t, "user-1", delivered.UserID)
+	assert.Equal(t, "corr-1", delivered.CorrelationID)
+}
@@ -0,0 +1,416 @@
+package command
+
+import (
+	"fmt"
+	"math"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+)
+
+// FormatChecker reports whether a string is valid for a schema "format"
+type FormatChecker func(value string) bool
+
+// defaultFormats returns the formats every schema registry understands
+func defaultFormats() map[string]FormatChecker {
+	validate := validator.New()
+	return map[string]FormatChecker{
+		"email": func(s string) bool {
+			addr, err := mail.ParseAddress(s)
+			return err == nil && addr.Address == s
+		},
+		"uuid": func(s string) bool {
+			_, err := uuid.Parse(s)
+			return err == nil
+		},
+		"date-time": func(s string) bool {
+			_, err := time.Parse(time.RFC3339, s)
+			return err == nil
+		},
+		"iso4217": func(s string) bool {
+			return validate.Var(s, "iso4217") == nil
+		},
+	}
+}
+
+// Schema is a compiled JSON Schema. The supported subset covers the
+// keywords needed to describe command payloads: type, enum, const,
+// properties, required, additionalProperties, items, minItems, maxItems,
+// uniqueItems, minLength, maxLength, pattern, format, minimum, maximum,
+// exclusiveMinimum and exclusiveMaximum. Annotations such as title and
+// description are accepted and ignored; any other keyword is rejected when
+// the schema is compiled rather than silently not enforced.
+type Schema struct {
+	types            []string
+	enum             []interface{}
+	constValue       interface{}
+	hasConst         bool
+	properties       map[string]*Schema
+	required         []string
+	additional       *Schema
+	noAdditional     bool
+	items            *Schema
+	minItems         *int
+	maxItems         *int
+	uniqueItems      bool
+	minLength        *int
+	maxLength        *int
+	pattern          *regexp.Regexp
+	format           string
+	checkFormat      FormatChecker
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+}
+
+// annotations are keywords that carry no validation rule
+var annotations = map[string]bool{
+	"$schema":     true,
+	"$id":         true,
+	"$comment":    true,
+	"title":       true,
+	"description": true,
+	"examples":    true,
+	"default":     true,
+}
+
+// compileSchema compiles a decoded JSON Schema document
+func compileSchema(doc interface{}, path string, formats map[string]FormatChecker) (*Schema, error) {
+	def, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object", schemaPath(path))
+	}
+
+	s := &Schema{}
+	for keyword, value := range def {
+		var err error
+		switch keyword {
+		case "type":
+			s.types, err = schemaTypes(value)
+		case "enum":
+			values, ok := value.([]interface{})
+			if !ok || len(values) == 0 {
+				err = fmt.Errorf("must be a non-empty array")
+			}
+			s.enum = values
+		case "const":
+			s.constValue, s.hasConst = value, true
+		case "properties":
+			props, ok := value.(map[string]interface{})
+			if !ok {
+				err = fmt.Errorf("must be an object")
+				break
+			}
+			s.properties = make(map[string]*Schema, len(props))
+			for name, prop := range props {
+				if s.properties[name], err = compileSchema(prop, path+"/properties/"+name, formats); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			s.required, err = stringList(value)
+		case "additionalProperties":
+			if allowed, ok := value.(bool); ok {
+				s.noAdditional = !allowed
+				break
+			}
+			if s.additional, err = compileSchema(value, path+"/additionalProperties", formats); err != nil {
+				return nil, err
+			}
+		case "items":
+			if s.items, err = compileSchema(value, path+"/items", formats); err != nil {
+				return nil, err
+			}
+		case "minItems":
+			s.minItems, err = schemaCount(value)
+		case "maxItems":
+			s.maxItems, err = schemaCount(value)
+		case "uniqueItems":
+			s.uniqueItems, ok = value.(bool)
+			if !ok {
+				err = fmt.Errorf("must be a boolean")
+			}
+		case "minLength":
+			s.minLength, err = schemaCount(value)
+		case "maxLength":
+			s.maxLength, err = schemaCount(value)
+		case "pattern":
+			pattern, ok := value.(string)
+			if !ok {
+				err = fmt.Errorf("must be a string")
+				break
+			}
+			s.pattern, err = regexp.Compile(pattern)
+		case "format":
+			s.format, ok = value.(string)
+			if !ok {
+				err = fmt.Errorf("must be a string")
+				break
+			}
+			if s.checkFormat = formats[s.format]; s.checkFormat == nil {
+				err = fmt.Errorf("unknown format %q", s.format)
+			}
+		case "minimum":
+			s.minimum, err = schemaNumber(value)
+		case "maximum":
+			s.maximum, err = schemaNumber(value)
+		case "exclusiveMinimum":
+			s.exclusiveMinimum, err = schemaNumber(value)
+		case "exclusiveMaximum":
+			s.exclusiveMaximum, err = schemaNumber(value)
+		default:
+			if !annotations[keyword] {
+				err = fmt.Errorf("unsupported keyword")
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %w", schemaPath(path), keyword, err)
+		}
+	}
+
+	return s, nil
+}
+
+func schemaPath(path string) string {
+	if path == "" {
+		return "#"
+	}
+	return "#" + path
+}
+
+func schemaTypes(value interface{}) ([]string, error) {
+	var types []string
+	switch v := value.(type) {
+	case string:
+		types = []string{v}
+	case []interface{}:
+		var err error
+		if types, err = stringList(v); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("must be a string or an array of strings")
+	}
+
+	for _, t := range types {
+		switch t {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return nil, fmt.Errorf("unknown type %q", t)
+		}
+	}
+	return types, nil
+}
+
+func stringList(value interface{}) ([]string, error) {
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be an array of strings")
+	}
+	list := make([]string, 0, len(values))
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be an array of strings")
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+func schemaCount(value interface{}) (*int, error) {
+	n, ok := value.(float64)
+	if !ok || n < 0 || n != math.Trunc(n) {
+		return nil, fmt.Errorf("must be a non-negative integer")
+	}
+	count := int(n)
+	return &count, nil
+}
+
+func schemaNumber(value interface{}) (*float64, error) {
+	n, ok := value.(float64)
+	if !ok {
+		return nil, fmt.Errorf("must be a number")
+	}
+	return &n, nil
+}
+
+// FieldError describes one payload field that failed validation
+type FieldError struct {
+	// Field is the JSON Pointer of the offending value, e.g. /items/0/quantity.
+	// It is empty when the payload as a whole is invalid.
+	Field string `json:"field"`
+	// Rule is the schema keyword that was violated
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// validate checks a decoded JSON value and returns the violations found
+func (s *Schema) validate(value interface{}, path string, errs []FieldError) []FieldError {
+	fail := func(rule, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: path, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.types) > 0 && !matchesType(value, s.types) {
+		fail("type", "must be %s", joinTypes(s.types))
+		return errs
+	}
+	if s.hasConst && !reflect.DeepEqual(value, s.constValue) {
+		fail("const", "must be %v", s.constValue)
+	}
+	if len(s.enum) > 0 && !containsValue(s.enum, value) {
+		fail("enum", "must be one of %v", s.enum)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				errs = append(errs, FieldError{Field: path + "/" + escapePointer(name), Rule: "required", Message: "is required"})
+			}
+		}
+		for _, name := range sortedKeys(v) {
+			field := path + "/" + escapePointer(name)
+			if prop, ok := s.properties[name]; ok {
+				errs = prop.validate(v[name], field, errs)
+				continue
+			}
+			if s.noAdditional {
+				errs = append(errs, FieldError{Field: field, Rule: "additionalProperties", Message: "is not allowed"})
+			} else if s.additional != nil {
+				errs = s.additional.validate(v[name], field, errs)
+			}
+		}
+
+	case []interface{}:
+		if s.minItems != nil && len(v) < *s.minItems {
+			fail("minItems", "must contain at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			fail("maxItems", "must contain at most %d items", *s.maxItems)
+		}
+		if s.uniqueItems {
+			for i := 1; i < len(v); i++ {
+				if containsValue(v[:i], v[i]) {
+					fail("uniqueItems", "must not contain duplicate items")
+					break
+				}
+			}
+		}
+		if s.items != nil {
+			for i, item := range v {
+				errs = s.items.validate(item, path+"/"+strconv.Itoa(i), errs)
+			}
+		}
+
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.minLength != nil && length < *s.minLength {
+			fail("minLength", "must be at least %d characters", *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			fail("maxLength", "must be at most %d characters", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("pattern", "must match %s", s.pattern)
+		}
+		if s.checkFormat != nil && !s.checkFormat(v) {
+			fail("format", "must be a valid %s", s.format)
+		}
+
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			fail("minimum", "must be at least %v", *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			fail("maximum", "must be at most %v", *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && v <= *s.exclusiveMinimum {
+			fail("exclusiveMinimum", "must be greater than %v", *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && v >= *s.exclusiveMaximum {
+			fail("exclusiveMaximum", "must be less than %v", *s.exclusiveMaximum)
+		}
+	}
+
+	return errs
+}
+
+func matchesType(value interface{}, types []string) bool {
+	for _, t := range types {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case float64:
+			if t == "number" || (t == "integer" && v == math.Trunc(v)) {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case nil:
+			if t == "null" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func joinTypes(types []string) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		switch t {
+		case "object", "array", "integer":
+			names[i] = "an " + t
+		case "null":
+			names[i] = t
+		default:
+			names[i] = "a " + t
+		}
+	}
+	return strings.Join(names, " or ")
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// escapePointer escapes a property name for use in a JSON Pointer
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,206 @@
+package command
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed schemas/*.json
+var embeddedSchemas embed.FS
+
+// defaultSchemaVersion is the version of schema files named without one
+const defaultSchemaVersion = "v1"
+
+var (
+	// ErrUnknownSchemaVersion is returned for commands asking for a payload
+	// schema version that is not registered for their type
+	ErrUnknownSchemaVersion = errors.New("unknown command schema version")
+
+	versionPattern = regexp.MustCompile(`^v[0-9]+$`)
+)
+
+// ValidationError reports every payload field of a command that does not
+// match the schema registered for its type
+type ValidationError struct {
+	CommandType string       `json:"commandType"`
+	Version     string       `json:"version"`
+	Fields      []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid %s payload", e.CommandType)
+	for i, f := range e.Fields {
+		if i == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString("; ")
+		}
+		if f.Field != "" {
+			b.WriteString(f.Field)
+			b.WriteString(" ")
+		}
+		b.WriteString(f.Message)
+	}
+	return b.String()
+}
+
+// SchemaRegistry holds the JSON Schemas of command payloads by command type
+// and version
+type SchemaRegistry struct {
+	mu      sync.RWMutex
+	schemas map[string]map[string]*Schema
+	formats map[string]FormatChecker
+}
+
+// NewSchemaRegistry creates an empty schema registry
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{
+		schemas: make(map[string]map[string]*Schema),
+		formats: defaultFormats(),
+	}
+}
+
+// DefaultSchemaRegistry returns a registry holding the schemas of the
+// built-in command types
+func DefaultSchemaRegistry() *SchemaRegistry {
+	r := NewSchemaRegistry()
+	if err := r.Load(embeddedSchemas, "schemas"); err != nil {
+		panic(fmt.Sprintf("invalid embedded command schema: %v", err))
+	}
+	return r
+}
+
+// RegisterFormat adds a custom string format. Formats must be registered
+// before the schemas using them.
+func (r *SchemaRegistry) RegisterFormat(name string, check FormatChecker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.formats[name] = check
+}
+
+// Register compiles a schema and registers it for a command type and
+// version, replacing any schema registered before
+func (r *SchemaRegistry) Register(cmdType, version string, schema []byte) error {
+	if version == "" {
+		version = defaultSchemaVersion
+	}
+	if !versionPattern.MatchString(version) {
+		return fmt.Errorf("invalid schema version %q for %s, expected v<number>", version, cmdType)
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(schema, &doc); err != nil {
+		return fmt.Errorf("failed to decode schema for %s %s: %w", cmdType, version, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	compiled, err := compileSchema(doc, "", r.formats)
+	if err != nil {
+		return fmt.Errorf("invalid schema for %s %s: %w", cmdType, version, err)
+	}
+
+	if r.schemas[cmdType] == nil {
+		r.schemas[cmdType] = make(map[string]*Schema)
+	}
+	r.schemas[cmdType][version] = compiled
+	return nil
+}
+
+// Load registers every *.json file in dir. Files are named after the command
+// type with an optional version, e.g. order.create.json or order.create.v2.json.
+func (r *SchemaRegistry) Load(fsys fs.FS, dir string) error {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list schemas: %w", err)
+	}
+
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return fmt.Errorf("failed to read schema %s: %w", file, err)
+		}
+
+		cmdType := strings.TrimSuffix(path.Base(file), ".json")
+		version := defaultSchemaVersion
+		if i := strings.LastIndex(cmdType, "."); i > 0 && versionPattern.MatchString(cmdType[i+1:]) {
+			cmdType, version = cmdType[:i], cmdType[i+1:]
+		}
+
+		if err := r.Register(cmdType, version, data); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	return nil
+}
+
+// Versions returns the registered schema versions of a command type, oldest first
+func (r *SchemaRegistry) Versions(cmdType string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	versions := make([]string, 0, len(r.schemas[cmdType]))
+	for v := range r.schemas[cmdType] {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versionNumber(versions[i]) < versionNumber(versions[j])
+	})
+	return versions
+}
+
+// Validate checks a payload against the schema of the command type. An
+// empty version selects the latest one. Command types without a schema are
+// accepted as they are.
+func (r *SchemaRegistry) Validate(cmdType, version string, payload map[string]interface{}) error {
+	versions := r.Versions(cmdType)
+	if len(versions) == 0 {
+		if version != "" {
+			return fmt.Errorf("%w: %s has no schemas", ErrUnknownSchemaVersion, cmdType)
+		}
+		return nil
+	}
+	if version == "" {
+		version = versions[len(versions)-1]
+	}
+
+	r.mu.RLock()
+	schema, ok := r.schemas[cmdType][version]
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s %s", ErrUnknownSchemaVersion, cmdType, version)
+	}
+
+	// Round trip through JSON so payloads built in Go carry the same types
+	// as decoded ones
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to decode payload: %w", err)
+	}
+
+	if fields := schema.validate(doc, "", nil); len(fields) > 0 {
+		return &ValidationError{CommandType: cmdType, Version: version, Fields: fields}
+	}
+	return nil
+}
+
+func versionNumber(version string) int {
+	n, _ := strconv.Atoi(strings.TrimPrefix(version, "v"))
+	return n
+}
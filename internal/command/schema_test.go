@@ -0,0 +1,169 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/linkmeAman/universal-middleware/test/testutil"
+)
+
+func TestDefaultSchemas(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmdType string
+		payload map[string]interface{}
+		want    []FieldError
+	}{
+		{
+			name:    "valid user",
+			cmdType: CommandTypeUserCreate,
+			payload: map[string]interface{}{"email": "jane@example.com", "username": "jane", "password": "s3cret-pass"},
+		},
+		{
+			name:    "invalid user",
+			cmdType: CommandTypeUserCreate,
+			payload: map[string]interface{}{"email": "not-an-email", "username": "j", "password": 12345678},
+			want: []FieldError{
+				{Field: "/email", Rule: "format", Message: "must be a valid email"},
+				{Field: "/password", Rule: "type", Message: "must be a string"},
+				{Field: "/username", Rule: "pattern", Message: "must match ^[A-Za-z0-9]{3,30}$"},
+			},
+		},
+		{
+			name:    "missing fields",
+			cmdType: CommandTypeEmailSend,
+			payload: map[string]interface{}{"to": "jane@example.com"},
+			want: []FieldError{
+				{Field: "/subject", Rule: "required", Message: "is required"},
+				{Field: "/body", Rule: "required", Message: "is required"},
+			},
+		},
+		{
+			name:    "invalid currency",
+			cmdType: CommandTypePaymentProcess,
+			payload: map[string]interface{}{"amount": 0, "currency": "XYZ", "paymentMethod": "card"},
+			want: []FieldError{
+				{Field: "/amount", Rule: "exclusiveMinimum", Message: "must be greater than 0"},
+				{Field: "/currency", Rule: "format", Message: "must be a valid iso4217"},
+			},
+		},
+		{
+			name:    "invalid order item",
+			cmdType: CommandTypeOrderCreate,
+			payload: map[string]interface{}{
+				"userId": "u-1",
+				"items": []map[string]interface{}{
+					{"productId": "p-1", "quantity": 2},
+					{"quantity": -1},
+				},
+			},
+			want: []FieldError{
+				{Field: "/items/1/productId", Rule: "required", Message: "is required"},
+				{Field: "/items/1/quantity", Rule: "exclusiveMinimum", Message: "must be greater than 0"},
+			},
+		},
+		{
+			name:    "type without schema",
+			cmdType: CommandTypeCacheWarmup,
+			payload: map[string]interface{}{"anything": true},
+		},
+	}
+
+	registry := DefaultSchemaRegistry()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := registry.Validate(tt.cmdType, "", tt.payload)
+			if tt.want == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			var validationErr *ValidationError
+			require.ErrorAs(t, err, &validationErr)
+			assert.Equal(t, tt.cmdType, validationErr.CommandType)
+			assert.Equal(t, "v1", validationErr.Version)
+			assert.Equal(t, tt.want, validationErr.Fields)
+		})
+	}
+}
+
+func TestSchemaRegistryVersions(t *testing.T) {
+	registry := NewSchemaRegistry()
+	require.NoError(t, registry.Load(fstest.MapFS{
+		"schemas/invoice.send.json":     {Data: []byte(`{"type": "object", "required": ["to"]}`)},
+		"schemas/invoice.send.v2.json":  {Data: []byte(`{"type": "object", "required": ["to", "locale"]}`)},
+		"schemas/invoice.send.v10.json": {Data: []byte(`{"type": "object", "required": ["recipient"]}`)},
+	}, "schemas"))
+
+	assert.Equal(t, []string{"v1", "v2", "v10"}, registry.Versions("invoice.send"))
+
+	payload := map[string]interface{}{"to": "jane@example.com"}
+	assert.NoError(t, registry.Validate("invoice.send", "v1", payload))
+	assert.Error(t, registry.Validate("invoice.send", "v2", payload))
+
+	var validationErr *ValidationError
+	require.ErrorAs(t, registry.Validate("invoice.send", "", payload), &validationErr)
+	assert.Equal(t, "v10", validationErr.Version)
+
+	assert.ErrorIs(t, registry.Validate("invoice.send", "v3", payload), ErrUnknownSchemaVersion)
+	assert.ErrorIs(t, registry.Validate("invoice.archive", "v1", payload), ErrUnknownSchemaVersion)
+}
+
+func TestSchemaRegistryRejectsInvalidSchemas(t *testing.T) {
+	tests := map[string]string{
+		"unsupported keyword": `{"type": "object", "oneOf": [{"required": ["a"]}]}`,
+		"unknown type":        `{"type": "map"}`,
+		"unknown format":      `{"properties": {"at": {"type": "string", "format": "hostname"}}}`,
+		"invalid pattern":     `{"type": "string", "pattern": "("}`,
+		"negative length":     `{"type": "string", "minLength": -1}`,
+		"not json":            `{"type":`,
+	}
+
+	for name, schema := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, NewSchemaRegistry().Register("invoice.send", "v1", []byte(schema)))
+		})
+	}
+
+	assert.Error(t, NewSchemaRegistry().Register("invoice.send", "2", []byte(`{}`)))
+}
+
+func TestSchemaRegistryCustomFormat(t *testing.T) {
+	registry := NewSchemaRegistry()
+	registry.RegisterFormat("sku", func(s string) bool { return len(s) == 8 })
+	require.NoError(t, registry.Register("stock.adjust", "", []byte(`{
+		"type": "object",
+		"additionalProperties": false,
+		"properties": {"sku": {"type": "string", "format": "sku"}, "delta": {"type": "integer"}}
+	}`)))
+
+	assert.NoError(t, registry.Validate("stock.adjust", "", map[string]interface{}{"sku": "ABCD1234", "delta": -3}))
+
+	var validationErr *ValidationError
+	require.ErrorAs(t, registry.Validate("stock.adjust", "", map[string]interface{}{
+		"sku": "ABC", "delta": 1.5, "note": "x",
+	}), &validationErr)
+	assert.Equal(t, []FieldError{
+		{Field: "/delta", Rule: "type", Message: "must be an integer"},
+		{Field: "/note", Rule: "additionalProperties", Message: "is not allowed"},
+		{Field: "/sku", Rule: "format", Message: "must be a valid sku"},
+	}, validationErr.Fields)
+}
+
+func TestProcessorRejectsInvalidPayload(t *testing.T) {
+	p := NewProcessor(ProcessorConfig{MaxWorkers: 1}, testutil.NewTestLogger(t))
+	t.Cleanup(p.Stop)
+
+	cmd := NewCommand(CommandTypeEmailSend, map[string]interface{}{"to": "nobody"})
+	err := p.Process(context.Background(), cmd)
+
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, StatusFailed, cmd.Status)
+	assert.Len(t, validationErr.Fields, 3)
+}
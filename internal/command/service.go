@@ -4,19 +4,43 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
+
+	"github.com/linkmeAman/universal-middleware/internal/auth"
 )
 
+// ErrCommandNotFound is returned when a command does not exist
+var ErrCommandNotFound = errors.New("command not found")
+
+// ErrUnsupportedCommandType is returned for commands of a type no processor
+// has a handler for
+var ErrUnsupportedCommandType = errors.New("unsupported command type")
+
+// CommandTopic is the topic accepted commands are dispatched to processors
+// on. Every message carries the whole command as JSON.
+const CommandTopic = "entity.commands"
+
 // CommandService handles async write operations with Redis buffering
 type CommandService struct {
 	db          *sql.DB
 	redisClient *redis.Client
 	outbox      *OutboxProcessor
+	scheduler   *Scheduler
+	validator   *Validator
+	accepted    map[string]bool
+	completions *Completions
+	callbacks   *CallbackSender
+	idempotency IdempotencyConfig
+	authorizer  auth.OPAAuthorizer
+	hooks       validateHooks
+	sensitive   *SensitiveFields
+	encryptor   *FieldEncryptor
 	log         *zap.Logger
 }
 
@@ -38,6 +62,27 @@ type CommandResult struct {
 	CommandID string `json:"command_id"`
 	Status    string `json:"status"`
 	Location  string `json:"location"`
+
+	// Replayed is set when the idempotency key matched an earlier
+	// submission whose response is returned instead
+	Replayed bool `json:"-"`
+}
+
+// newCommandResult returns the response to the submission of a command
+func newCommandResult(cmd *Command, scheduled bool) *CommandResult {
+	status := "accepted"
+	if scheduled {
+		status = string(StatusScheduled)
+	}
+	return &CommandResult{
+		CommandID: cmd.ID,
+		Status:    status,
+		Location:  commandLocation(cmd.ID),
+	}
+}
+
+func commandLocation(commandID string) string {
+	return fmt.Sprintf("/api/v1/commands/%s", commandID)
 }
 
 // NewCommandService creates a new command service
@@ -49,12 +94,16 @@ func NewCommandService(
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     redisAddr,
 		Password: "",
-		DB:       1, // Use different DB for command tracking
+		DB:       StatusCacheDB, // Use different DB for command tracking
 	})
 
 	svc := &CommandService{
 		db:          db,
 		redisClient: rdb,
+		validator:   NewValidator(),
+		completions: NewCompletions(rdb, nil, log),
+		idempotency: DefaultIdempotencyConfig(),
+		sensitive:   DefaultSensitiveFields(),
 		log:         log,
 	}
 
@@ -65,29 +114,45 @@ func NewCommandService(
 	}
 	svc.outbox = outbox
 
+	// Initialize scheduler for delayed commands
+	svc.scheduler = NewScheduler(svc, DefaultSchedulerConfig())
+
 	return svc, nil
 }
 
-// SubmitCommand accepts a write command and returns immediately
+// StartScheduler runs the delayed command scheduler until the context is cancelled
+func (s *CommandService) StartScheduler(ctx context.Context) {
+	s.scheduler.Start(ctx)
+}
+
+// Schemas returns the registry submitted payloads are validated against
+func (s *CommandService) Schemas() *SchemaRegistry {
+	return s.validator.Schemas()
+}
+
+// SubmitCommand accepts a write command and returns immediately. Payloads
+// that do not match the schema of their type are rejected with a
+// *ValidationError before anything is stored.
 func (s *CommandService) SubmitCommand(ctx context.Context, cmd *Command) (*CommandResult, error) {
-	// Check idempotency
+	if err := s.checkCommand(ctx, cmd); err != nil {
+		return nil, err
+	}
+
+	// Check idempotency, a retried request gets the original response
 	if cmd.IdempotencyKey != "" {
-		if existing, err := s.checkIdempotency(ctx, cmd.IdempotencyKey); err == nil {
+		existing, err := s.checkIdempotency(ctx, cmd)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
 			s.log.Info("Idempotent request detected",
-				zap.String("command_id", existing.ID),
+				zap.String("command_id", existing.CommandID),
 				zap.String("idempotency_key", cmd.IdempotencyKey))
-			return &CommandResult{
-				CommandID: existing.ID,
-				Status:    string(existing.Status),
-				Location:  fmt.Sprintf("/api/v1/commands/%s", existing.ID),
-			}, nil
+			return existing, nil
 		}
 	}
 
-	// Generate command ID
-	cmd.ID = uuid.New().String()
-	cmd.Status = "pending"
-	cmd.CreatedAt = time.Now()
+	scheduled := s.prepareCommand(cmd)
 
 	// Start transaction
 	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{
@@ -98,19 +163,11 @@ func (s *CommandService) SubmitCommand(ctx context.Context, cmd *Command) (*Comm
 	}
 	defer tx.Rollback()
 
-	// 1. Store command in outbox (transactional)
-	if err := s.storeInOutbox(ctx, tx, cmd); err != nil {
-		return nil, fmt.Errorf("failed to store in outbox: %w", err)
-	}
-
-	// 2. Store idempotency key (if provided)
-	if cmd.IdempotencyKey != "" {
-		if err := s.storeIdempotencyKey(ctx, tx, cmd); err != nil {
-			return nil, fmt.Errorf("failed to store idempotency key: %w", err)
-		}
+	if err := s.insertCommand(ctx, tx, cmd, scheduled); err != nil {
+		return nil, err
 	}
 
-	// 3. Cache command status in Redis for fast status checks
+	// Cache command status in Redis for fast status checks
 	if err := s.cacheCommandStatus(ctx, cmd); err != nil {
 		// Log but don't fail - DB is source of truth
 		s.log.Warn("Failed to cache command status", zap.Error(err))
@@ -121,53 +178,68 @@ func (s *CommandService) SubmitCommand(ctx context.Context, cmd *Command) (*Comm
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	if scheduled {
+		s.scheduler.Notify(*cmd.ScheduledFor)
+	}
+
+	result := newCommandResult(cmd, scheduled)
 	s.log.Info("Command submitted",
 		zap.String("command_id", cmd.ID),
-		zap.String("type", cmd.Type))
+		zap.String("type", cmd.Type),
+		zap.String("status", result.Status))
 
-	return &CommandResult{
-		CommandID: cmd.ID,
-		Status:    "accepted",
-		Location:  fmt.Sprintf("/api/v1/commands/%s", cmd.ID),
-	}, nil
+	return result, nil
 }
 
-// GetCommandStatus retrieves command status (cache-first)
+// GetCommandStatus retrieves command status (cache-first). Sensitive
+// payload fields are redacted.
 func (s *CommandService) GetCommandStatus(ctx context.Context, commandID string) (*Command, error) {
 	// Try cache first
-	cacheKey := fmt.Sprintf("cmd:status:%s", commandID)
-	if cached, err := s.redisClient.Get(ctx, cacheKey).Result(); err == nil {
+	if cached, err := s.redisClient.Get(ctx, StatusKeyPrefix+commandID).Result(); err == nil {
 		var cmd Command
 		if err := json.Unmarshal([]byte(cached), &cmd); err == nil {
-			return &cmd, nil
+			return s.redact(&cmd), nil
 		}
 	}
 
 	// Cache miss - query database
+	cmd, err := s.loadCommand(ctx, commandID)
+	return s.redact(cmd), err
+}
+
+// loadCommand reads a command from the database and refreshes its cache entry
+func (s *CommandService) loadCommand(ctx context.Context, commandID string) (*Command, error) {
 	var cmd Command
 	query := `
-		SELECT id, type, entity_id, payload, status, created_at, processed_at, error
+		SELECT id, type, entity_id, payload, status, created_at, scheduled_for, processed_at, error,
+			cancelled_at, cancelled_by, cancel_reason, callback_url, ` + executionColumns + `
 		FROM commands
 		WHERE id = $1
 	`
 
 	var payloadJSON []byte
-	var processedAt sql.NullTime
-	var errorMsg sql.NullString
+	var scheduledFor, processedAt, cancelledAt sql.NullTime
+	var errorMsg, cancelledBy, cancelReason, callbackURL sql.NullString
+	var execution executionFields
 
-	err := s.db.QueryRowContext(ctx, query, commandID).Scan(
+	err := s.db.QueryRowContext(ctx, query, commandID).Scan(append([]interface{}{
 		&cmd.ID,
 		&cmd.Type,
 		&cmd.EntityID,
 		&payloadJSON,
 		&cmd.Status,
 		&cmd.CreatedAt,
+		&scheduledFor,
 		&processedAt,
 		&errorMsg,
-	)
+		&cancelledAt,
+		&cancelledBy,
+		&cancelReason,
+		&callbackURL,
+	}, execution.dest()...)...)
 
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("command not found")
+		return nil, ErrCommandNotFound
 	}
 	if err != nil {
 		return nil, err
@@ -176,99 +248,241 @@ func (s *CommandService) GetCommandStatus(ctx context.Context, commandID string)
 	// Parse JSON payload
 	json.Unmarshal(payloadJSON, &cmd.Payload)
 
+	if scheduledFor.Valid {
+		cmd.ScheduledFor = &scheduledFor.Time
+	}
 	if processedAt.Valid {
 		cmd.ProcessedAt = &processedAt.Time
 	}
 	if errorMsg.Valid {
 		cmd.Error = errorMsg.String
 	}
+	if cancelledAt.Valid {
+		cmd.CancelledAt = &cancelledAt.Time
+	}
+	cmd.CancelledBy = cancelledBy.String
+	cmd.CancelReason = cancelReason.String
+	cmd.CallbackURL = callbackURL.String
+	execution.apply(&cmd)
 
 	// Update cache
-	if data, err := json.Marshal(cmd); err == nil {
-		ttl := 300 * time.Second
-		if cmd.Status == "completed" || cmd.Status == "failed" {
-			ttl = 3600 * time.Second // Cache completed commands longer
+	s.cacheCommandStatus(ctx, &cmd)
+
+	return &cmd, nil
+}
+
+// AcceptTypes restricts submissions to the given command types, those the
+// processors have handlers for. Without it every type is accepted.
+func (s *CommandService) AcceptTypes(types ...string) {
+	s.accepted = make(map[string]bool, len(types))
+	for _, t := range types {
+		s.accepted[t] = true
+	}
+}
+
+// checkCommand runs every check a command must pass before it is accepted:
+// its type, schema, callback URL, authorization and the validate hooks of
+// its type
+func (s *CommandService) checkCommand(ctx context.Context, cmd *Command) error {
+	if s.accepted != nil && !s.accepted[cmd.Type] {
+		return fmt.Errorf("%w: %s", ErrUnsupportedCommandType, cmd.Type)
+	}
+	if err := s.validator.schemas.Validate(cmd.Type, cmd.SchemaVersion, cmd.Payload); err != nil {
+		return err
+	}
+	if err := s.checkCallback(ctx, cmd); err != nil {
+		return err
+	}
+	if err := s.authorize(ctx, cmd); err != nil {
+		return err
+	}
+	return s.hooks.run(ctx, cmd)
+}
+
+// prepareCommand assigns a new command its ID and initial status and reports
+// whether it is held back until ScheduledFor
+func (s *CommandService) prepareCommand(cmd *Command) bool {
+	cmd.ID = uuid.New().String()
+	cmd.Status = StatusPending
+	cmd.CreatedAt = time.Now()
+	cmd.UpdatedAt = cmd.CreatedAt
+
+	// Hold back commands that are due in the future
+	scheduled := cmd.ScheduledFor != nil && cmd.ScheduledFor.After(cmd.CreatedAt)
+	if scheduled {
+		cmd.Status = StatusScheduled
+	}
+	return scheduled
+}
+
+// insertCommand writes a prepared command, its outbox row and its
+// idempotency key within the given transaction. Sensitive payload fields
+// are sealed first when encryption is enabled.
+func (s *CommandService) insertCommand(ctx context.Context, tx *sql.Tx, cmd *Command, scheduled bool) error {
+	// Retries are matched against the plain payload, sealing is not
+	// deterministic
+	var hash string
+	if cmd.IdempotencyKey != "" {
+		var err error
+		if hash, err = fingerprint(cmd, s.idempotency.FingerprintKey); err != nil {
+			return err
+		}
+	}
+	if s.encryptor != nil {
+		if err := s.encryptor.Encrypt(ctx, cmd); err != nil {
+			return fmt.Errorf("failed to encrypt payload: %w", err)
 		}
-		s.redisClient.Set(ctx, cacheKey, data, ttl)
 	}
 
-	return &cmd, nil
+	// 1. Store command record (transactional)
+	if err := s.storeCommand(ctx, tx, cmd); err != nil {
+		return fmt.Errorf("failed to store command: %w", err)
+	}
+
+	// 2. Store command in outbox (transactional), scheduled commands
+	// are released to the outbox by the scheduler once they are due
+	if !scheduled {
+		if err := s.storeInOutbox(ctx, tx, cmd); err != nil {
+			return fmt.Errorf("failed to store in outbox: %w", err)
+		}
+	}
+
+	// 3. Store idempotency key (if provided)
+	if cmd.IdempotencyKey != "" {
+		if err := s.storeIdempotencyKey(ctx, tx, cmd, hash, newCommandResult(cmd, scheduled)); err != nil {
+			return fmt.Errorf("failed to store idempotency key: %w", err)
+		}
+	}
+
+	// 4. Start the command history and announce the command on the
+	// lifecycle topic (transactional)
+	return storeTransition(ctx, tx, cmd, "")
 }
 
-// storeInOutbox saves command to outbox table for processing
-func (s *CommandService) storeInOutbox(ctx context.Context, tx *sql.Tx, cmd *Command) error {
+// storeCommand saves the command record that status lookups are served from
+func (s *CommandService) storeCommand(ctx context.Context, tx *sql.Tx, cmd *Command) error {
 	payloadJSON, err := json.Marshal(cmd.Payload)
 	if err != nil {
 		return err
 	}
 
 	query := `
-		INSERT INTO outbox_messages (
-			id, aggregate_type, aggregate_id, event_type,
-			payload, topic, status, created_at, retry_count
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 0)
+		INSERT INTO commands (
+			id, type, entity_id, payload, idempotency_key,
+			status, created_at, scheduled_for, retry_count, max_retries, callback_url,
+			user_id, correlation_id, priority, schema_version, timeout_ms, retry_backoff_ms
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 0, $9, $10, NULLIF($11, ''), NULLIF($12, ''),
+			$13, NULLIF($14, ''), $15, $16)
 	`
 
+	var idempotencyKey, callbackURL sql.NullString
+	if cmd.IdempotencyKey != "" {
+		idempotencyKey = sql.NullString{String: cmd.IdempotencyKey, Valid: true}
+	}
+	if cmd.CallbackURL != "" {
+		callbackURL = sql.NullString{String: cmd.CallbackURL, Valid: true}
+	}
+
 	_, err = tx.ExecContext(ctx, query,
 		cmd.ID,
-		"command",
-		cmd.EntityID,
 		cmd.Type,
+		cmd.EntityID,
 		payloadJSON,
-		"entity.commands",
-		"pending",
+		idempotencyKey,
+		cmd.Status,
 		cmd.CreatedAt,
+		cmd.ScheduledFor,
+		cmd.MaxRetries,
+		callbackURL,
+		cmd.UserID,
+		cmd.CorrelationID,
+		int(cmd.Priority),
+		cmd.SchemaVersion,
+		cmd.TimeoutAfter.Milliseconds(),
+		cmd.RetryBackoff.Milliseconds(),
 	)
 
 	return err
 }
 
-// storeIdempotencyKey saves idempotency key to prevent duplicates
-func (s *CommandService) storeIdempotencyKey(ctx context.Context, tx *sql.Tx, cmd *Command) error {
-	query := `
-		INSERT INTO idempotency_keys (key, command_id, created_at)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (key) DO NOTHING
-	`
+// executionColumns are the columns of commands describing how a command is
+// run. Every query rebuilding a command selects them, in this order, so the
+// command is dispatched again as it was submitted.
+const executionColumns = `priority, COALESCE(schema_version, ''), timeout_ms, retry_backoff_ms`
 
-	_, err := tx.ExecContext(ctx, query, cmd.IdempotencyKey, cmd.ID, cmd.CreatedAt)
-	return err
+// rowScanner is a row of *sql.Rows, *sql.Row or database.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// executionFields receives executionColumns
+type executionFields struct {
+	priority      int64
+	schemaVersion string
+	timeoutMs     int64
+	backoffMs     int64
+}
+
+// dest returns the scan destinations of executionColumns
+func (e *executionFields) dest() []interface{} {
+	return []interface{}{&e.priority, &e.schemaVersion, &e.timeoutMs, &e.backoffMs}
+}
+
+// apply copies the scanned fields into cmd
+func (e *executionFields) apply(cmd *Command) {
+	cmd.Priority = Priority(e.priority)
+	cmd.SchemaVersion = e.schemaVersion
+	cmd.TimeoutAfter = time.Duration(e.timeoutMs) * time.Millisecond
+	cmd.RetryBackoff = time.Duration(e.backoffMs) * time.Millisecond
 }
 
-// checkIdempotency checks if a command with this idempotency key exists
-func (s *CommandService) checkIdempotency(ctx context.Context, key string) (*Command, error) {
+// storeInOutbox saves command to outbox table for processing. A command is
+// dispatched once per attempt, so every message gets its own ID and refers
+// to the command through its metadata. The message payload is the command
+// itself so processors can rebuild it without reading the commands table.
+func (s *CommandService) storeInOutbox(ctx context.Context, tx *sql.Tx, cmd *Command) error {
+	payloadJSON, err := cmd.Marshal()
+	if err != nil {
+		return err
+	}
+
+	metadata := map[string]interface{}{
+		"command_id":  cmd.ID,
+		"retry_count": cmd.RetryCount,
+	}
+	if cmd.CallbackURL != "" {
+		metadata["callback_url"] = cmd.CallbackURL
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
 	query := `
-		SELECT c.id, c.type, c.entity_id, c.status, c.created_at
-		FROM commands c
-		JOIN idempotency_keys i ON i.command_id = c.id
-		WHERE i.key = $1
+		INSERT INTO outbox_messages (
+			id, aggregate_type, aggregate_id, event_type,
+			payload, topic, status, created_at, retry_count, metadata
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 0, $9)
 	`
 
-	var cmd Command
-	err := s.db.QueryRowContext(ctx, query, key).Scan(
-		&cmd.ID,
-		&cmd.Type,
-		&cmd.EntityID,
-		&cmd.Status,
-		&cmd.CreatedAt,
+	_, err = tx.ExecContext(ctx, query,
+		uuid.New().String(),
+		"command",
+		cmd.EntityID,
+		cmd.Type,
+		payloadJSON,
+		CommandTopic,
+		"pending",
+		time.Now(),
+		metadataJSON,
 	)
 
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("not found")
-	}
-
-	return &cmd, err
+	return err
 }
 
 // cacheCommandStatus stores command status in Redis for fast lookups
 func (s *CommandService) cacheCommandStatus(ctx context.Context, cmd *Command) error {
-	data, err := json.Marshal(cmd)
-	if err != nil {
-		return err
-	}
-
-	key := fmt.Sprintf("cmd:status:%s", cmd.ID)
-	return s.redisClient.Set(ctx, key, data, 300*time.Second).Err()
+	return CacheStatus(ctx, s.redisClient, cmd)
 }
 
 // OutboxProcessor processes commands from the outbox
@@ -0,0 +1,93 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidPayload is returned when a payload cannot be decoded into the
+// type its handler expects or does not pass its Validate method
+var ErrInvalidPayload = errors.New("invalid command payload")
+
+// PayloadValidator is implemented by payload types that check themselves
+// after decoding
+type PayloadValidator interface {
+	Validate() error
+}
+
+// TypedHandler handles commands whose payload decodes into T
+type TypedHandler[T any] interface {
+	Handle(ctx context.Context, cmd *Command, payload T) error
+}
+
+// TypedHandlerFunc adapts a function to a TypedHandler
+type TypedHandlerFunc[T any] func(ctx context.Context, cmd *Command, payload T) error
+
+// Handle calls f
+func (f TypedHandlerFunc[T]) Handle(ctx context.Context, cmd *Command, payload T) error {
+	return f(ctx, cmd, payload)
+}
+
+// Register registers a typed handler for a command type. The payload of
+// every command is decoded into T and validated before the handler runs;
+// payloads that do not fit fail the command permanently with
+// ErrInvalidPayload.
+func Register[T any](p *Processor, cmdType string, handler TypedHandler[T]) {
+	p.RegisterHandlerFor(cmdType, &typedHandler[T]{cmdType: cmdType, handler: handler})
+}
+
+// RegisterFunc is Register for a plain function
+func RegisterFunc[T any](p *Processor, cmdType string, fn func(ctx context.Context, cmd *Command, payload T) error) {
+	Register[T](p, cmdType, TypedHandlerFunc[T](fn))
+}
+
+// DecodePayload decodes the payload of a command into T and runs its
+// Validate method when it has one
+func DecodePayload[T any](cmd *Command) (T, error) {
+	var payload T
+
+	data, err := json.Marshal(cmd.Payload)
+	if err != nil {
+		return payload, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return payload, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	validator, ok := any(&payload).(PayloadValidator)
+	if !ok {
+		validator, ok = any(payload).(PayloadValidator)
+	}
+	if ok {
+		if err := validator.Validate(); err != nil {
+			return payload, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+		}
+	}
+
+	return payload, nil
+}
+
+// typedHandler adapts a TypedHandler to Handler
+type typedHandler[T any] struct {
+	cmdType string
+	handler TypedHandler[T]
+}
+
+func (h *typedHandler[T]) HandleCommand(ctx context.Context, cmd *Command) error {
+	payload, err := DecodePayload[T](cmd)
+	if err != nil {
+		return Permanent(err)
+	}
+	return h.handler.Handle(ctx, cmd, payload)
+}
+
+func (h *typedHandler[T]) CanHandle(cmdType string) bool {
+	return cmdType == h.cmdType
+}
+
+// String names the wrapped handler rather than the adapter
+func (h *typedHandler[T]) String() string {
+	return fmt.Sprintf("%T", h.handler)
+}
@@ -0,0 +1,121 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/linkmeAman/universal-middleware/test/testutil"
+)
+
+type invoicePayload struct {
+	InvoiceID string   `json:"invoice_id"`
+	Amount    float64  `json:"amount"`
+	Lines     []string `json:"lines"`
+}
+
+func (p *invoicePayload) Validate() error {
+	if p.Amount <= 0 {
+		return errors.New("amount must be positive")
+	}
+	return nil
+}
+
+const commandTypeInvoiceSend = "invoice.send"
+
+type invoiceHandler struct {
+	calls *[]string
+}
+
+func (h *invoiceHandler) Handle(ctx context.Context, cmd *Command, payload invoicePayload) error {
+	*h.calls = append(*h.calls, "handler")
+	return nil
+}
+
+func TestRegisterTypedHandler(t *testing.T) {
+	p := newTestProcessor(t, ProcessorConfig{MaxWorkers: 1})
+
+	var got invoicePayload
+	RegisterFunc(p, commandTypeInvoiceSend, func(ctx context.Context, cmd *Command, payload invoicePayload) error {
+		got = payload
+		return nil
+	})
+
+	cmd := NewCommand(commandTypeInvoiceSend, map[string]interface{}{
+		"invoice_id": "inv-1",
+		"amount":     12.5,
+		"lines":      []interface{}{"a", "b"},
+	})
+	require.NoError(t, p.Process(context.Background(), cmd))
+	assert.Equal(t, StatusCompleted, cmd.Status)
+	assert.Equal(t, invoicePayload{InvoiceID: "inv-1", Amount: 12.5, Lines: []string{"a", "b"}}, got)
+}
+
+func TestRegisterTypedHandlerRejectsInvalidPayload(t *testing.T) {
+	payloads := map[string]map[string]interface{}{
+		"wrong type":      {"invoice_id": 7, "amount": 1},
+		"failed validate": {"invoice_id": "inv-1", "amount": -1},
+	}
+
+	for name, payload := range payloads {
+		t.Run(name, func(t *testing.T) {
+			p := newTestProcessor(t, ProcessorConfig{MaxWorkers: 1})
+			called := false
+			RegisterFunc(p, commandTypeInvoiceSend, func(ctx context.Context, cmd *Command, payload invoicePayload) error {
+				called = true
+				return nil
+			})
+
+			cmd := NewCommand(commandTypeInvoiceSend, payload)
+			err := p.Process(context.Background(), cmd)
+			assert.ErrorIs(t, err, ErrInvalidPayload)
+			assert.False(t, called)
+			assert.Equal(t, StatusFailed, cmd.Status)
+			require.NotNil(t, cmd.ErrorDetails)
+			assert.Equal(t, "INVALID_PAYLOAD", cmd.ErrorDetails.Code)
+		})
+	}
+}
+
+func TestProcessorMiddleware(t *testing.T) {
+	var calls []string
+	record := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(ctx context.Context, cmd *Command) error {
+				calls = append(calls, name+":"+HandlerName(ctx))
+				return next(ctx, cmd)
+			}
+		}
+	}
+
+	p := newTestProcessor(t, ProcessorConfig{MaxWorkers: 1, Middleware: []Middleware{record("outer")}})
+	p.Use(record("inner"))
+	Register[invoicePayload](p, commandTypeInvoiceSend, &invoiceHandler{calls: &calls})
+
+	cmd := NewCommand(commandTypeInvoiceSend, map[string]interface{}{"amount": 1})
+	require.NoError(t, p.Process(context.Background(), cmd))
+
+	name := "*command.invoiceHandler"
+	assert.Equal(t, []string{"outer:" + name, "inner:" + name, "handler"}, calls)
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	p := newTestProcessor(t, ProcessorConfig{MaxWorkers: 1})
+	p.Use(RecoveryMiddleware(testutil.NewTestLogger(t), nil))
+	RegisterFunc(p, commandTypeInvoiceSend, func(ctx context.Context, cmd *Command, payload invoicePayload) error {
+		var lines map[string]string
+		lines["first"] = "boom"
+		return nil
+	})
+
+	cmd := NewCommand(commandTypeInvoiceSend, map[string]interface{}{"amount": 1})
+	err := p.Process(context.Background(), cmd)
+	assert.ErrorIs(t, err, ErrHandlerPanic)
+	assert.False(t, IsRetryableError(err))
+	assert.Equal(t, StatusFailed, cmd.Status)
+	require.NotNil(t, cmd.ErrorDetails)
+	assert.Equal(t, "HANDLER_PANIC", cmd.ErrorDetails.Code)
+}
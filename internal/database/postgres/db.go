@@ -9,6 +9,7 @@ import (
 	"github.com/linkmeAman/universal-middleware/pkg/logger"
 	"github.com/linkmeAman/universal-middleware/pkg/metrics"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -129,6 +130,32 @@ func (db *DB) BeginTx(ctx context.Context, opts database.TxOptions) (database.Tx
 	return &Tx{tx: tx, db: db}, nil
 }
 
+// Listen holds a connection of the pool subscribed to a notification
+// channel and calls notify with the payload of every notification. It
+// returns when the context is done or the connection fails; the connection
+// is not reused afterwards.
+func (db *DB) Listen(ctx context.Context, channel string, notify func(payload string)) error {
+	conn, err := db.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire listen connection: %w", err)
+	}
+	// A listening connection must not go back to the pool
+	pgConn := conn.Hijack()
+	defer pgConn.Close(context.Background())
+
+	if _, err := pgConn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", channel, err)
+	}
+
+	for {
+		notification, err := pgConn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		notify(notification.Payload)
+	}
+}
+
 // Close closes the database connection pool
 func (db *DB) Close() {
 	db.pool.Close()
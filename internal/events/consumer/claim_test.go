@@ -0,0 +1,74 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/linkmeAman/universal-middleware/test/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+)
+
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	marked []int64
+}
+
+func (s *fakeSession) Claims() map[string][]int32 {
+	return map[string][]int32{"commands": {0}}
+}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.marked = append(s.marked, msg.Offset)
+}
+
+func (s *fakeSession) Context() context.Context {
+	return s.ctx
+}
+
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+// failingHandler fails the message at offset fail
+type failingHandler struct {
+	fail    int64
+	handled []int64
+}
+
+func (h *failingHandler) Handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	h.handled = append(h.handled, msg.Offset)
+	if msg.Offset == h.fail {
+		return errors.New("not run")
+	}
+	return nil
+}
+
+func TestConsumeClaimStopsAtFailedMessage(t *testing.T) {
+	handler := &failingHandler{fail: 1}
+	c := &Consumer{
+		handler: handler,
+		log:     testutil.NewTestLogger(t),
+		tracer:  otel.GetTracerProvider().Tracer("test"),
+	}
+
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, 3)}
+	for offset := range int64(3) {
+		claim.messages <- &sarama.ConsumerMessage{Topic: "commands", Offset: offset}
+	}
+	close(claim.messages)
+	session := &fakeSession{ctx: context.Background()}
+
+	assert.Error(t, c.ConsumeClaim(session, claim))
+	// The failed message and everything after it are delivered again
+	assert.Equal(t, []int64{0, 1}, handler.handled)
+	assert.Equal(t, []int64{0}, session.marked)
+}
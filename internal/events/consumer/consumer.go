@@ -115,10 +115,12 @@ func (c *Consumer) Cleanup(sarama.ConsumerGroupSession) error {
 	return nil
 }
 
-// ConsumeClaim handles message consumption
+// ConsumeClaim handles message consumption. A message the handler fails is
+// not marked; the claim stops so the session ends, and the message is
+// delivered again from the last committed offset once the group rejoins.
 func (c *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	for msg := range claim.Messages() {
-		ctx := c.extractContext(msg)
+		ctx := c.extractContext(session.Context(), msg)
 		ctx, span := c.tracer.Start(ctx, "kafka.consume",
 			trace.WithAttributes(
 				attribute.String("messaging.system", "kafka"),
@@ -142,18 +144,18 @@ func (c *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim saram
 			)
 			span.RecordError(err)
 			span.SetStatus(codes.Error, err.Error())
-		} else {
-			session.MarkMessage(msg, "")
+			span.End()
+			return fmt.Errorf("message at offset %d of %s/%d not handled: %w", msg.Offset, msg.Topic, msg.Partition, err)
 		}
 
+		session.MarkMessage(msg, "")
 		span.End()
 	}
 	return nil
 }
 
 // extractContext extracts tracing context from message headers
-func (c *Consumer) extractContext(msg *sarama.ConsumerMessage) context.Context {
-	ctx := context.Background()
+func (c *Consumer) extractContext(ctx context.Context, msg *sarama.ConsumerMessage) context.Context {
 	propagator := otel.GetTextMapPropagator()
 	// Convert headers to a map-like carrier
 	carrier := propagation.HeaderCarrier{}
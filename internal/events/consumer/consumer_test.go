@@ -1,4 +1,4 @@
-package consumer_test
+package consumer
 
 import (
 	"context"
@@ -6,11 +6,10 @@ import (
 	"time"
 
 	"github.com/IBM/sarama"
-	"github.com/IBM/sarama/mocks"
-	"github.com/linkmeAman/universal-middleware/internal/events/consumer"
-	"github.com/linkmeAman/universal-middleware/pkg/logger"
+	"github.com/linkmeAman/universal-middleware/test/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
 )
 
 // MockHandler implements the Handler interface for testing
@@ -24,16 +23,9 @@ func (h *MockHandler) Handle(ctx context.Context, msg *sarama.ConsumerMessage) e
 }
 
 func TestConsumer(t *testing.T) {
-	// Create mock consumer group
-	config := sarama.NewConfig()
-	mockConsumer := mocks.NewConsumerGroup()
-
-	// Create test logger
-	log := logger.NewTestLogger()
-
 	// Create consumer config
-	cfg := consumer.ConsumerConfig{
-		Brokers:          []string{"localhost:9092"},
+	cfg := ConsumerConfig{
+		Brokers:          []string{"127.0.0.1:1"},
 		GroupID:          "test-group",
 		Topics:           []string{"test-topic"},
 		InitialOffset:    sarama.OffsetOldest,
@@ -44,67 +36,51 @@ func TestConsumer(t *testing.T) {
 		RebalanceTimeout: 60 * time.Second,
 	}
 
-	// Create mock handler
-	handler := &MockHandler{}
-
 	t.Run("successful consumption", func(t *testing.T) {
+		// Create mock handler
+		handler := &MockHandler{}
+		c := &Consumer{
+			handler: handler,
+			log:     testutil.NewTestLogger(t),
+			tracer:  otel.GetTracerProvider().Tracer("test"),
+		}
+
 		// Create test messages
 		testMessages := []*sarama.ConsumerMessage{
 			{
-				Topic: "test-topic",
-				Key:   []byte("key1"),
-				Value: []byte("value1"),
+				Topic:  "commands",
+				Key:    []byte("key1"),
+				Value:  []byte("value1"),
+				Offset: 0,
 			},
 			{
-				Topic: "test-topic",
-				Key:   []byte("key2"),
-				Value: []byte("value2"),
+				Topic:  "commands",
+				Key:    []byte("key2"),
+				Value:  []byte("value2"),
+				Offset: 1,
 			},
 		}
+		claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, len(testMessages))}
+		for _, msg := range testMessages {
+			claim.messages <- msg
+		}
+		close(claim.messages)
+		session := &fakeSession{ctx: context.Background()}
 
-		// Set up mock expectations
-		mockConsumer.ExpectConsumePartition("test-topic", 0, sarama.OffsetOldest).YieldMessage(testMessages...)
-
-		// Create consumer
-		consumer, err := consumer.NewConsumer(cfg, handler, log)
-		require.NoError(t, err)
-
-		// Start consuming
-		err = consumer.Start()
-		require.NoError(t, err)
-
-		// Wait for messages to be processed
-		time.Sleep(100 * time.Millisecond)
-
-		// Stop consumer
-		err = consumer.Stop()
-		require.NoError(t, err)
+		require.NoError(t, c.ConsumeClaim(session, claim))
 
-		// Verify messages were handled
+		// Verify messages were handled and marked
 		assert.Len(t, handler.messages, len(testMessages))
 		for i, msg := range handler.messages {
 			assert.Equal(t, testMessages[i].Key, msg.Key)
 			assert.Equal(t, testMessages[i].Value, msg.Value)
 		}
+		assert.Equal(t, []int64{0, 1}, session.marked)
 	})
 
 	t.Run("consumer error handling", func(t *testing.T) {
-		// Set up mock to return error
-		mockConsumer.ExpectError(sarama.ErrOutOfBrokers)
-
-		// Create consumer
-		consumer, err := consumer.NewConsumer(cfg, handler, log)
-		require.NoError(t, err)
-
-		// Start consuming
-		err = consumer.Start()
-		require.NoError(t, err)
-
-		// Wait for error to be processed
-		time.Sleep(100 * time.Millisecond)
-
-		// Stop consumer
-		err = consumer.Stop()
-		require.NoError(t, err)
+		// No broker listens on the configured address
+		_, err := NewConsumer(cfg, &MockHandler{}, testutil.NewTestLogger(t))
+		assert.Error(t, err)
 	})
 }
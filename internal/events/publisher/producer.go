@@ -2,6 +2,7 @@ package publisher
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -21,13 +22,18 @@ type ProducerConfig struct {
 	MaxRetries        int
 	RetryBackoff      time.Duration
 	ConnectionTimeout time.Duration
+	// TransactionalID makes PublishMessages publish each batch in a Kafka
+	// transaction. It must be unique per producer instance; a transactional
+	// producer can only send through PublishMessages.
+	TransactionalID string
 }
 
 // Producer handles Kafka message production
 type Producer struct {
-	producer sarama.SyncProducer
-	log      *logger.Logger
-	tracer   trace.Tracer
+	producer      sarama.SyncProducer
+	transactional bool
+	log           *logger.Logger
+	tracer        trace.Tracer
 }
 
 // NewProducer creates a new Kafka producer instance
@@ -50,15 +56,21 @@ func NewProducer(cfg ProducerConfig, log *logger.Logger) (*Producer, error) {
 	config.Net.MaxOpenRequests = 1
 	config.Producer.Return.Successes = true
 
+	if cfg.TransactionalID != "" {
+		config.Producer.Transaction.ID = cfg.TransactionalID
+		config.Producer.RequiredAcks = sarama.WaitForAll
+	}
+
 	producer, err := sarama.NewSyncProducer(cfg.Brokers, config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Kafka producer: %w", err)
 	}
 
 	return &Producer{
-		producer: producer,
-		log:      log,
-		tracer:   trace.NewNoopTracerProvider().Tracer("kafka-producer"),
+		producer:      producer,
+		transactional: cfg.TransactionalID != "",
+		log:           log,
+		tracer:        trace.NewNoopTracerProvider().Tracer("kafka-producer"),
 	}, nil
 }
 
@@ -174,6 +186,110 @@ func (p *Producer) PublishBatch(ctx context.Context, topic string, messages []Me
 	return nil
 }
 
+// BatchError reports the messages of a batch that were not published, by
+// their index in the batch. The other messages were published.
+type BatchError struct {
+	Failed map[int]error
+}
+
+func (e *BatchError) Error() string {
+	for _, err := range e.Failed {
+		return fmt.Sprintf("failed to publish %d messages of batch: %v", len(e.Failed), err)
+	}
+	return "failed to publish batch"
+}
+
+// PublishMessages sends messages to their topics in one round trip. When
+// only some of them fail it returns a *BatchError naming those. A
+// transactional producer publishes the batch in a Kafka transaction, so
+// either every message is published or, on error, none.
+func (p *Producer) PublishMessages(ctx context.Context, messages []Message) error {
+	ctx, span := p.tracer.Start(ctx, "kafka.publishMessages",
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.Int("messaging.batch_size", len(messages)),
+			attribute.Bool("messaging.kafka.transactional", p.transactional),
+		),
+	)
+	defer span.End()
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	batch := make([]*sarama.ProducerMessage, len(messages))
+	for i, msg := range messages {
+		headers := make([]sarama.RecordHeader, 0)
+		if span := trace.SpanFromContext(ctx); span.IsRecording() {
+			headers = append(headers, sarama.RecordHeader{
+				Key:   []byte("trace_id"),
+				Value: []byte(span.SpanContext().TraceID().String()),
+			})
+		}
+
+		batch[i] = &sarama.ProducerMessage{
+			Topic:    msg.Topic,
+			Key:      sarama.StringEncoder(msg.Key),
+			Value:    sarama.ByteEncoder(msg.Value),
+			Headers:  headers,
+			Metadata: i,
+		}
+	}
+
+	err := p.send(batch)
+	if err != nil {
+		p.log.Error("Failed to publish messages",
+			zap.Int("batch_size", len(messages)),
+			zap.Bool("transactional", p.transactional),
+			zap.Error(err),
+		)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		var producerErrs sarama.ProducerErrors
+		if !p.transactional && errors.As(err, &producerErrs) {
+			failed := make(map[int]error, len(producerErrs))
+			for _, producerErr := range producerErrs {
+				if i, ok := producerErr.Msg.Metadata.(int); ok {
+					failed[i] = producerErr.Err
+				}
+			}
+			return &BatchError{Failed: failed}
+		}
+		return fmt.Errorf("failed to publish messages: %w", err)
+	}
+
+	p.log.Debug("Messages published successfully",
+		zap.Int("batch_size", len(messages)),
+	)
+
+	return nil
+}
+
+// send sends a batch, wrapped in a transaction on a transactional producer
+func (p *Producer) send(batch []*sarama.ProducerMessage) error {
+	if !p.transactional {
+		return p.producer.SendMessages(batch)
+	}
+
+	if err := p.producer.BeginTxn(); err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := p.producer.SendMessages(batch); err != nil {
+		if abortErr := p.producer.AbortTxn(); abortErr != nil {
+			p.log.Error("Failed to abort transaction", zap.Error(abortErr))
+		}
+		return err
+	}
+	if err := p.producer.CommitTxn(); err != nil {
+		if abortErr := p.producer.AbortTxn(); abortErr != nil {
+			p.log.Error("Failed to abort transaction", zap.Error(abortErr))
+		}
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
 // Close closes the Kafka producer
 func (p *Producer) Close() error {
 	if err := p.producer.Close(); err != nil {
@@ -185,6 +301,9 @@ func (p *Producer) Close() error {
 
 // Message represents a Kafka message
 type Message struct {
+	// Topic is where PublishMessages sends the message, PublishBatch sends
+	// every message to its topic argument instead
+	Topic string
 	Key   string
 	Value []byte
 }
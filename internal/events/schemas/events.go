@@ -23,11 +23,14 @@ const (
 	EventTypeCacheWarmed      EventType = "cache.warmed"
 
 	// Command events
-	EventTypeCommandReceived   EventType = "command.received"
-	EventTypeCommandProcessed  EventType = "command.processed"
-	EventTypeCommandFailed     EventType = "command.failed"
-	EventTypeCommandCompleted  EventType = "command.completed"
-	EventTypeCommandRollbacked EventType = "command.rollbacked"
+	EventTypeCommandReceived      EventType = "command.received"
+	EventTypeCommandStarted       EventType = "command.started"
+	EventTypeCommandProcessed     EventType = "command.processed"
+	EventTypeCommandFailed        EventType = "command.failed"
+	EventTypeCommandCompleted     EventType = "command.completed"
+	EventTypeCommandRollbacked    EventType = "command.rollbacked"
+	EventTypeCommandCancelled     EventType = "command.cancelled"
+	EventTypeCommandStatusChanged EventType = "command.status_changed"
 
 	// Message queue events
 	EventTypeMessageDeadLettered EventType = "message.dead_lettered"
@@ -71,6 +74,15 @@ type CommandReceivedEvent struct {
 	UserID      string `json:"userId"`
 }
 
+// CommandStartedEvent is emitted when a worker starts an attempt of a command
+type CommandStartedEvent struct {
+	Event
+	CommandID   string `json:"commandId"`
+	CommandType string `json:"commandType"`
+	WorkerID    string `json:"workerId,omitempty"`
+	Attempt     int    `json:"attempt"`
+}
+
 type CommandProcessedEvent struct {
 	Event
 	CommandID    string `json:"commandId"`
@@ -84,6 +96,45 @@ type CommandFailedEvent struct {
 	CommandType string `json:"commandType"`
 	Error       string `json:"error"`
 	ErrorCode   string `json:"errorCode"`
+	// Status is failed, dead, or retrying when another attempt is scheduled
+	Status        string     `json:"status"`
+	Attempt       int        `json:"attempt"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+}
+
+// CommandStatusChangedEvent is emitted for status transitions without an
+// event of their own, such as the scheduler releasing a due command
+type CommandStatusChangedEvent struct {
+	Event
+	CommandID      string `json:"commandId"`
+	CommandType    string `json:"commandType"`
+	PreviousStatus string `json:"previousStatus"`
+	Status         string `json:"status"`
+}
+
+type CommandCancelledEvent struct {
+	Event
+	CommandID      string `json:"commandId"`
+	CommandType    string `json:"commandType"`
+	PreviousStatus string `json:"previousStatus"`
+	CancelledBy    string `json:"cancelledBy"`
+	Reason         string `json:"reason,omitempty"`
+	// InFlight is set when the command was running and has only been
+	// signalled to stop
+	InFlight bool `json:"inFlight"`
+}
+
+// CommandRollbackedEvent is emitted when a saga step has been undone by its
+// compensating command
+type CommandRollbackedEvent struct {
+	Event
+	CommandID      string `json:"commandId"`
+	CommandType    string `json:"commandType"`
+	CompensationID string `json:"compensationId"`
+	SagaID         string `json:"sagaId"`
+	SagaName       string `json:"sagaName"`
+	Step           string `json:"step"`
+	Reason         string `json:"reason,omitempty"`
 }
 
 // Cache events
@@ -0,0 +1,65 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/linkmeAman/universal-middleware/internal/cache"
+	"github.com/linkmeAman/universal-middleware/internal/command"
+	"github.com/linkmeAman/universal-middleware/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// CacheInvalidateHandler handles cache.invalidate commands. The payload names
+// the keys to delete, a key pattern, or both:
+//
+//	{"keys": ["user:1", "user:2"], "pattern": "orders:user:1:*"}
+type CacheInvalidateHandler struct {
+	cache *cache.RedisCache
+	log   *logger.Logger
+}
+
+// NewCacheInvalidateHandler creates a handler invalidating entries of cache
+func NewCacheInvalidateHandler(cache *cache.RedisCache, log *logger.Logger) *CacheInvalidateHandler {
+	return &CacheInvalidateHandler{cache: cache, log: log}
+}
+
+// HandleCommand deletes the keys and pattern named in the payload
+func (h *CacheInvalidateHandler) HandleCommand(ctx context.Context, cmd *command.Command) error {
+	var keys []string
+	if raw, ok := cmd.Payload["keys"].([]interface{}); ok {
+		for _, k := range raw {
+			key, ok := k.(string)
+			if !ok {
+				return command.Permanent(fmt.Errorf("keys must be strings"))
+			}
+			keys = append(keys, key)
+		}
+	}
+	pattern, _ := cmd.Payload["pattern"].(string)
+
+	if len(keys) == 0 && pattern == "" {
+		return command.Permanent(fmt.Errorf("payload names no keys or pattern"))
+	}
+
+	if err := h.cache.Delete(ctx, keys...); err != nil {
+		return fmt.Errorf("failed to delete cache keys: %w", err)
+	}
+	if pattern != "" {
+		if err := h.cache.InvalidateByPattern(ctx, pattern); err != nil {
+			return fmt.Errorf("failed to invalidate cache pattern %s: %w", pattern, err)
+		}
+	}
+
+	h.log.Info("Invalidated cache entries",
+		zap.String("command_id", cmd.ID),
+		zap.Int("keys", len(keys)),
+		zap.String("pattern", pattern),
+	)
+	return nil
+}
+
+// CanHandle reports whether the handler handles the command type
+func (h *CacheInvalidateHandler) CanHandle(cmdType string) bool {
+	return cmdType == command.CommandTypeCacheInvalidate
+}
@@ -0,0 +1,174 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/go-redis/redis/v8"
+	"github.com/linkmeAman/universal-middleware/internal/command"
+	"github.com/linkmeAman/universal-middleware/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Rejecter stores the failure of commands that were refused before they
+// were started
+type Rejecter interface {
+	Reject(ctx context.Context, cmd *command.Command) error
+}
+
+// CommandHandler runs the commands consumed from command.CommandTopic on a
+// command processor. The processor writes the outcome of every attempt to the
+// commands table and queues its lifecycle events in the outbox; the handler
+// additionally refreshes the status cache read by the API gateway.
+//
+// Handle returns once the command has run, so commands of a partition run
+// one at a time in the order they were published.
+type CommandHandler struct {
+	processor  *command.Processor
+	rejecter   Rejecter
+	statuses   *redis.Client
+	log        *logger.Logger
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// NewCommandHandler creates a command handler. The rejecter and the status
+// cache client may be nil.
+func NewCommandHandler(processor *command.Processor, rejecter Rejecter, statuses *redis.Client, log *logger.Logger) *CommandHandler {
+	return &CommandHandler{
+		processor:  processor,
+		rejecter:   rejecter,
+		statuses:   statuses,
+		log:        log,
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 5 * time.Second,
+	}
+}
+
+// Handle decodes a command message and runs the command. A command that could
+// not be run, because the queue was full or the database was unavailable, is
+// tried again with a growing backoff. Errors are returned only when the
+// processor stopped or ctx is done before the command ran, and the consumer
+// must then not mark the message.
+func (h *CommandHandler) Handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	cmd, err := decodeCommand(msg.Value)
+	if err != nil {
+		// Delivering the message again would fail the same way
+		h.log.Error("Dropping malformed command message",
+			zap.String("topic", msg.Topic),
+			zap.Int32("partition", msg.Partition),
+			zap.Int64("offset", msg.Offset),
+			zap.Error(err),
+		)
+		return nil
+	}
+
+	err = h.process(ctx, cmd)
+	switch {
+	case errors.Is(err, command.ErrLeaseHeld):
+		// A redelivered command another processor is running
+		h.log.Info("Command is already running elsewhere",
+			zap.String("command_id", cmd.ID),
+		)
+		return nil
+	case errors.Is(err, command.ErrLeaseLost):
+		// The reaper has taken the command over and owns its state
+		return nil
+	case errors.Is(err, command.ErrCommandFinished):
+		// A redelivered command that has already finished or was cancelled
+		h.log.Info("Command has already finished",
+			zap.String("command_id", cmd.ID),
+		)
+		return nil
+	case errors.Is(err, command.ErrCommandNotDue):
+		// The scheduler publishes the command again once it is due
+		return nil
+	case err != nil && !settled(cmd.Status):
+		return fmt.Errorf("command %s was not run: %w", cmd.ID, err)
+	}
+
+	// The outcome is stored even if the consumer session has just ended
+	ctx = context.WithoutCancel(ctx)
+
+	if cmd.ErrorDetails != nil && cmd.ErrorDetails.Code == "VALIDATION_ERROR" && h.rejecter != nil {
+		if rejErr := h.rejecter.Reject(ctx, cmd); rejErr != nil {
+			h.log.Error("Failed to store rejected command",
+				zap.String("command_id", cmd.ID),
+				zap.Error(rejErr),
+			)
+		}
+	}
+
+	if h.statuses != nil {
+		if cacheErr := command.CacheStatus(ctx, h.statuses, cmd); cacheErr != nil {
+			h.log.Warn("Failed to cache command status",
+				zap.String("command_id", cmd.ID),
+				zap.Error(cacheErr),
+			)
+		}
+	}
+
+	return nil
+}
+
+// process runs cmd until its attempt is settled or refused, backing off
+// between attempts that could not run. ctx ends with the consumer session;
+// a command that has started is not interrupted by a rebalance.
+func (h *CommandHandler) process(ctx context.Context, cmd *command.Command) error {
+	backoff := h.minBackoff
+	for {
+		err := h.processor.Process(context.WithoutCancel(ctx), cmd)
+		if err == nil || settled(cmd.Status) || !retryable(err) {
+			return err
+		}
+
+		h.log.Warn("Command could not be run, retrying",
+			zap.String("command_id", cmd.ID),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, h.maxBackoff)
+	}
+}
+
+// retryable reports whether running a command again may succeed. Refused
+// leases are handled by the caller; a stopped processor never runs it.
+func retryable(err error) bool {
+	switch {
+	case errors.Is(err, command.ErrLeaseHeld),
+		errors.Is(err, command.ErrLeaseLost),
+		errors.Is(err, command.ErrCommandFinished),
+		errors.Is(err, command.ErrCommandNotDue),
+		errors.Is(err, command.ErrProcessorStopped),
+		errors.Is(err, command.ErrCommandCancelled):
+		return false
+	}
+	return true
+}
+
+// settled reports whether the outcome of an attempt has been decided, so
+// the command message does not need to be delivered again
+func settled(status command.Status) bool {
+	return status.IsTerminal() || status == command.StatusRetrying
+}
+
+// decodeCommand rebuilds a command from a message published on
+// command.CommandTopic
+func decodeCommand(data []byte) (*command.Command, error) {
+	var cmd command.Command
+	if err := cmd.Unmarshal(data); err != nil {
+		return nil, fmt.Errorf("failed to decode command: %w", err)
+	}
+	if cmd.ID == "" || cmd.Type == "" {
+		return nil, errors.New("command message has no ID or type")
+	}
+	return &cmd, nil
+}
@@ -0,0 +1,213 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/linkmeAman/universal-middleware/internal/command"
+	"github.com/linkmeAman/universal-middleware/test/testutil"
+)
+
+type warmupHandler struct {
+	handled []*command.Command
+	err     error
+}
+
+func (h *warmupHandler) HandleCommand(ctx context.Context, cmd *command.Command) error {
+	h.handled = append(h.handled, cmd)
+	return h.err
+}
+
+func (h *warmupHandler) CanHandle(cmdType string) bool {
+	return cmdType == command.CommandTypeCacheWarmup
+}
+
+type fakeRejecter struct {
+	rejected []*command.Command
+}
+
+func (r *fakeRejecter) Reject(ctx context.Context, cmd *command.Command) error {
+	r.rejected = append(r.rejected, cmd)
+	return nil
+}
+
+func newTestHandler(t *testing.T, handler command.Handler) (*CommandHandler, *fakeRejecter) {
+	log := testutil.NewTestLogger(t)
+	p := command.NewProcessor(command.ProcessorConfig{MaxWorkers: 1}, log)
+	t.Cleanup(p.Stop)
+	p.RegisterHandler(handler)
+
+	rejecter := &fakeRejecter{}
+	return NewCommandHandler(p, rejecter, nil, log), rejecter
+}
+
+func commandMessage(t *testing.T, cmd *command.Command) *sarama.ConsumerMessage {
+	data, err := cmd.Marshal()
+	require.NoError(t, err)
+	return &sarama.ConsumerMessage{Topic: command.CommandTopic, Key: []byte(cmd.ID), Value: data}
+}
+
+func TestDecodeCommand(t *testing.T) {
+	cmd := command.NewCommand(command.CommandTypeCacheWarmup, map[string]interface{}{"pattern": "user:*"})
+	cmd.EntityID = "user-1"
+	cmd.RetryCount = 2
+	cmd.CallbackURL = "https://hooks.example.com/done"
+
+	decoded, err := decodeCommand(commandMessage(t, cmd).Value)
+	require.NoError(t, err)
+	assert.Equal(t, cmd.ID, decoded.ID)
+	assert.Equal(t, cmd.Type, decoded.Type)
+	assert.Equal(t, "user-1", decoded.EntityID)
+	assert.Equal(t, 2, decoded.RetryCount)
+	assert.Equal(t, cmd.MaxRetries, decoded.MaxRetries)
+	assert.Equal(t, cmd.CallbackURL, decoded.CallbackURL)
+	assert.Equal(t, "user:*", decoded.Payload["pattern"])
+
+	_, err = decodeCommand([]byte(`{"email":"someone@example.com"}`))
+	assert.Error(t, err)
+	_, err = decodeCommand([]byte(`not json`))
+	assert.Error(t, err)
+}
+
+func TestCommandHandlerRunsCommand(t *testing.T) {
+	handler := &warmupHandler{}
+	h, rejecter := newTestHandler(t, handler)
+
+	cmd := command.NewCommand(command.CommandTypeCacheWarmup, map[string]interface{}{})
+	require.NoError(t, h.Handle(context.Background(), commandMessage(t, cmd)))
+
+	require.Len(t, handler.handled, 1)
+	assert.Equal(t, cmd.ID, handler.handled[0].ID)
+	assert.Equal(t, command.StatusCompleted, handler.handled[0].Status)
+	assert.Empty(t, rejecter.rejected)
+}
+
+func TestCommandHandlerSettlesFailedCommand(t *testing.T) {
+	// The failure is recorded on the command, the message is not redelivered
+	h, _ := newTestHandler(t, &warmupHandler{err: errors.New("cache unavailable")})
+
+	cmd := command.NewCommand(command.CommandTypeCacheWarmup, map[string]interface{}{})
+	assert.NoError(t, h.Handle(context.Background(), commandMessage(t, cmd)))
+}
+
+func TestCommandHandlerRejectsInvalidPayload(t *testing.T) {
+	h, rejecter := newTestHandler(t, &warmupHandler{})
+
+	cmd := command.NewCommand(command.CommandTypeUserCreate, map[string]interface{}{})
+	require.NoError(t, h.Handle(context.Background(), commandMessage(t, cmd)))
+
+	require.Len(t, rejecter.rejected, 1)
+	assert.Equal(t, cmd.ID, rejecter.rejected[0].ID)
+	assert.Equal(t, command.StatusFailed, rejecter.rejected[0].Status)
+}
+
+func TestCommandHandlerSkipsMalformedMessage(t *testing.T) {
+	handler := &warmupHandler{}
+	h, _ := newTestHandler(t, handler)
+
+	msg := &sarama.ConsumerMessage{Topic: command.CommandTopic, Value: []byte(`{"type":`)}
+	assert.NoError(t, h.Handle(context.Background(), msg))
+	assert.Empty(t, handler.handled)
+}
+
+// refusingLeases refuses every lease with err
+type refusingLeases struct {
+	err error
+}
+
+func (l refusingLeases) Acquire(ctx context.Context, cmd *command.Command, owner string, ttl time.Duration) error {
+	return l.err
+}
+
+func (l refusingLeases) Renew(ctx context.Context, commandID, owner string, ttl time.Duration) error {
+	return nil
+}
+
+func (l refusingLeases) Release(ctx context.Context, cmd *command.Command, owner string) error {
+	return nil
+}
+
+func TestCommandHandlerSkipsRefusedLease(t *testing.T) {
+	for _, err := range []error{command.ErrLeaseHeld, command.ErrCommandFinished, command.ErrCommandNotDue} {
+		t.Run(err.Error(), func(t *testing.T) {
+			log := testutil.NewTestLogger(t)
+			p := command.NewProcessor(command.ProcessorConfig{MaxWorkers: 1, Leases: refusingLeases{err: err}}, log)
+			t.Cleanup(p.Stop)
+			handler := &warmupHandler{}
+			p.RegisterHandler(handler)
+			h := NewCommandHandler(p, nil, nil, log)
+
+			cmd := command.NewCommand(command.CommandTypeCacheWarmup, map[string]interface{}{})
+			assert.NoError(t, h.Handle(context.Background(), commandMessage(t, cmd)))
+			assert.Empty(t, handler.handled)
+		})
+	}
+}
+
+// flakyLeases fails the first failures leases with err
+type flakyLeases struct {
+	refusingLeases
+	mu       sync.Mutex
+	failures int
+}
+
+func (l *flakyLeases) Acquire(ctx context.Context, cmd *command.Command, owner string, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failures > 0 {
+		l.failures--
+		return l.err
+	}
+	return nil
+}
+
+func newRetryingHandler(t *testing.T, leases command.LeaseStore, handler command.Handler) (*CommandHandler, *command.Processor) {
+	log := testutil.NewTestLogger(t)
+	p := command.NewProcessor(command.ProcessorConfig{MaxWorkers: 1, Leases: leases}, log)
+	t.Cleanup(p.Stop)
+	p.RegisterHandler(handler)
+
+	h := NewCommandHandler(p, nil, nil, log)
+	h.minBackoff = time.Millisecond
+	h.maxBackoff = 5 * time.Millisecond
+	return h, p
+}
+
+func TestCommandHandlerRetriesCommandNotRun(t *testing.T) {
+	leases := &flakyLeases{refusingLeases: refusingLeases{err: errors.New("database unavailable")}, failures: 2}
+	handler := &warmupHandler{}
+	h, _ := newRetryingHandler(t, leases, handler)
+
+	cmd := command.NewCommand(command.CommandTypeCacheWarmup, map[string]interface{}{})
+	require.NoError(t, h.Handle(context.Background(), commandMessage(t, cmd)))
+	require.Len(t, handler.handled, 1)
+	assert.Equal(t, command.StatusCompleted, handler.handled[0].Status)
+}
+
+func TestCommandHandlerFailsCommandNotRun(t *testing.T) {
+	// The consumer must not mark these messages, they are delivered again
+	t.Run("session ended", func(t *testing.T) {
+		leases := &flakyLeases{refusingLeases: refusingLeases{err: errors.New("database unavailable")}, failures: 1 << 30}
+		h, _ := newRetryingHandler(t, leases, &warmupHandler{})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		cmd := command.NewCommand(command.CommandTypeCacheWarmup, map[string]interface{}{})
+		assert.Error(t, h.Handle(ctx, commandMessage(t, cmd)))
+	})
+
+	t.Run("processor stopped", func(t *testing.T) {
+		h, p := newRetryingHandler(t, &flakyLeases{}, &warmupHandler{})
+		p.Stop()
+
+		cmd := command.NewCommand(command.CommandTypeCacheWarmup, map[string]interface{}{})
+		assert.ErrorIs(t, h.Handle(context.Background(), commandMessage(t, cmd)), command.ErrProcessorStopped)
+	})
+}
@@ -17,20 +17,22 @@ type Service struct {
 	log       *logger.Logger
 }
 
-// NewService creates a new processor service
-func NewService(brokers []string, topic string, groupID string, minBytes int, maxBytes int, log *logger.Logger) (*Service, error) {
+// NewService creates a new processor service passing every message consumed
+// from topic to handler
+func NewService(brokers []string, topic string, groupID string, minBytes int, maxBytes int, handler consumer.Handler, log *logger.Logger) (*Service, error) {
 	// Create event consumer with min/max bytes and initial offset
 	consumer, err := consumer.NewConsumer(consumer.ConsumerConfig{
-		Brokers:          brokers,
-		Topics:           []string{topic},
-		GroupID:          groupID,
-		MinBytes:         minBytes,
-		MaxBytes:         maxBytes,
-		MaxWait:          5 * time.Second,
-		InitialOffset:    sarama.OffsetNewest, // Start from newest messages
+		Brokers:  brokers,
+		Topics:   []string{topic},
+		GroupID:  groupID,
+		MinBytes: minBytes,
+		MaxBytes: maxBytes,
+		MaxWait:  5 * time.Second,
+		// Commands published before the group first joined must still run
+		InitialOffset:    sarama.OffsetOldest,
 		SessionTimeout:   10 * time.Second,
 		RebalanceTimeout: 15 * time.Second,
-	}, nil, log)
+	}, handler, log)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create consumer: %w", err)
 	}
@@ -54,6 +56,16 @@ func NewService(brokers []string, topic string, groupID string, minBytes int, ma
 	}, nil
 }
 
+// Publisher returns the producer for downstream events
+func (s *Service) Publisher() *publisher.Producer {
+	return s.publisher
+}
+
+// Ping checks that the consumer is running
+func (s *Service) Ping() error {
+	return s.consumer.Ping()
+}
+
 // Start begins processing events
 func (s *Service) Start() error {
 	return s.consumer.Start()
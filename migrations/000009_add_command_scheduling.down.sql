@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_commands_scheduled;
+ALTER TABLE commands DROP COLUMN IF EXISTS scheduled_for;
@@ -0,0 +1,4 @@
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS scheduled_for TIMESTAMPTZ;
+
+-- Only scheduled commands are scanned by the scheduler, keep the index small
+CREATE INDEX IF NOT EXISTS idx_commands_scheduled ON commands(scheduled_for) WHERE status = 'scheduled';
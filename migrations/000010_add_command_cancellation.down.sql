@@ -0,0 +1,3 @@
+ALTER TABLE commands DROP COLUMN IF EXISTS cancel_reason;
+ALTER TABLE commands DROP COLUMN IF EXISTS cancelled_by;
+ALTER TABLE commands DROP COLUMN IF EXISTS cancelled_at;
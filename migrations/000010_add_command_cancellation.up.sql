@@ -0,0 +1,3 @@
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMPTZ;
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS cancelled_by VARCHAR(255);
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS cancel_reason TEXT;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_commands_retrying;
+DROP INDEX IF EXISTS idx_commands_lease;
+ALTER TABLE commands DROP COLUMN IF EXISTS heartbeat_at;
+ALTER TABLE commands DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE commands DROP COLUMN IF EXISTS lease_owner;
+ALTER TABLE commands DROP COLUMN IF EXISTS max_retries;
@@ -0,0 +1,10 @@
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS max_retries INTEGER NOT NULL DEFAULT 3;
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS lease_owner VARCHAR(255);
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ;
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS heartbeat_at TIMESTAMPTZ;
+
+-- The reaper only looks at running commands
+CREATE INDEX IF NOT EXISTS idx_commands_lease ON commands(lease_expires_at) WHERE status = 'processing';
+
+-- Retries are released by the scheduler once due
+CREATE INDEX IF NOT EXISTS idx_commands_retrying ON commands(scheduled_for) WHERE status = 'retrying';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_commands_dead;
+DROP TABLE IF EXISTS command_attempts;
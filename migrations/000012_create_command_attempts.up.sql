@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS command_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    command_id UUID NOT NULL,
+    attempt INTEGER NOT NULL,
+    worker_id VARCHAR(255),
+    status VARCHAR(50) NOT NULL,
+    error_code VARCHAR(100),
+    error TEXT,
+    started_at TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ NOT NULL,
+    next_attempt_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_command_attempts_command ON command_attempts(command_id, attempt);
+
+-- Dead commands are listed per type for inspection and replay
+CREATE INDEX IF NOT EXISTS idx_commands_dead ON commands(type, created_at) WHERE status = 'dead';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_sagas_unfinished;
+DROP TABLE IF EXISTS sagas;
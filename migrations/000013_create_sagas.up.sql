@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS sagas (
+    id UUID PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}',
+    steps JSONB NOT NULL DEFAULT '[]',
+    current_step INTEGER NOT NULL DEFAULT 0,
+    correlation_id VARCHAR(255),
+    error TEXT,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+-- Unfinished sagas are resumed on startup
+CREATE INDEX IF NOT EXISTS idx_sagas_unfinished ON sagas(created_at) WHERE status IN ('running', 'compensating');
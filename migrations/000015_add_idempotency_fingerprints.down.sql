@@ -0,0 +1,3 @@
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS client_id;
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS response;
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS fingerprint;
@@ -0,0 +1,3 @@
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS fingerprint VARCHAR(64);
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS response JSONB;
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS client_id VARCHAR(255);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS command_transitions (
+    id BIGSERIAL PRIMARY KEY,
+    command_id UUID NOT NULL,
+    from_status VARCHAR(50),
+    to_status VARCHAR(50) NOT NULL,
+    worker_id VARCHAR(255),
+    attempt INTEGER,
+    error_code VARCHAR(100),
+    error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_command_transitions_command ON command_transitions(command_id, id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_commands_correlation;
+DROP INDEX IF EXISTS idx_commands_user;
+ALTER TABLE commands DROP COLUMN IF EXISTS correlation_id;
+ALTER TABLE commands DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1,6 @@
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS user_id VARCHAR(255);
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS correlation_id VARCHAR(255);
+
+-- Command listings filter by submitter and correlation ID, newest first
+CREATE INDEX IF NOT EXISTS idx_commands_user ON commands(user_id, created_at) WHERE user_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_commands_correlation ON commands(correlation_id, created_at) WHERE correlation_id IS NOT NULL;
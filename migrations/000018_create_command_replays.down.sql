@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_commands_failed;
+DROP TABLE IF EXISTS command_replays;
+ALTER TABLE commands DROP COLUMN IF EXISTS error_code;
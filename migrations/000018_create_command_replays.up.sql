@@ -0,0 +1,15 @@
+-- Failed commands are selected for replay by the code of their last error
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS error_code VARCHAR(100);
+
+CREATE TABLE IF NOT EXISTS command_replays (
+    id UUID PRIMARY KEY,
+    requested_by VARCHAR(255) NOT NULL,
+    reason TEXT,
+    filter JSONB NOT NULL,
+    payload_patch JSONB,
+    command_ids JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_command_replays_created ON command_replays(created_at);
+CREATE INDEX IF NOT EXISTS idx_commands_failed ON commands(type, created_at) WHERE status = 'failed';
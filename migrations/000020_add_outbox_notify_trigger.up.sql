@@ -0,0 +1,12 @@
+-- Wake outbox relays as soon as a message is written. The payload stays
+-- empty, listeners fetch pending messages themselves.
+CREATE OR REPLACE FUNCTION notify_outbox_message() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('outbox_messages', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER outbox_messages_notify
+    AFTER INSERT ON outbox_messages
+    FOR EACH ROW EXECUTE FUNCTION notify_outbox_message();
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_outbox_messages_next_attempt_at;
+UPDATE outbox_messages SET status = 'failed' WHERE status IN ('retrying', 'dead');
+ALTER TABLE outbox_messages DROP COLUMN IF EXISTS next_attempt_at;
@@ -0,0 +1,9 @@
+-- Failed messages are retried at next_attempt_at until they run out of
+-- retries and become dead
+ALTER TABLE outbox_messages ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP WITH TIME ZONE;
+
+-- Messages left failed by the old relay were never retried, give them
+-- another chance
+UPDATE outbox_messages SET status = 'retrying', next_attempt_at = NOW() WHERE status = 'failed';
+
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_next_attempt_at ON outbox_messages(next_attempt_at) WHERE status = 'retrying';
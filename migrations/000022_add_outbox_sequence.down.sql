@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_outbox_messages_aggregate_sequence;
+ALTER TABLE outbox_messages DROP COLUMN IF EXISTS sequence;
@@ -0,0 +1,18 @@
+-- Number messages in the order they were written so each aggregate's
+-- messages can be published strictly in sequence. Existing messages are
+-- numbered by creation time.
+ALTER TABLE outbox_messages ADD COLUMN IF NOT EXISTS sequence BIGINT;
+
+UPDATE outbox_messages m
+SET sequence = ordered.n
+FROM (SELECT id, row_number() OVER (ORDER BY created_at, id) AS n FROM outbox_messages) ordered
+WHERE m.id = ordered.id;
+
+ALTER TABLE outbox_messages ALTER COLUMN sequence SET NOT NULL;
+ALTER TABLE outbox_messages ALTER COLUMN sequence ADD GENERATED BY DEFAULT AS IDENTITY;
+SELECT setval(pg_get_serial_sequence('outbox_messages', 'sequence'), COALESCE(MAX(sequence), 0) + 1, false)
+FROM outbox_messages;
+
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_aggregate_sequence
+    ON outbox_messages(aggregate_type, aggregate_id, sequence)
+    WHERE status IN ('pending', 'retrying');
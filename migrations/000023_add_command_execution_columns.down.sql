@@ -0,0 +1,4 @@
+ALTER TABLE commands DROP COLUMN IF EXISTS retry_backoff_ms;
+ALTER TABLE commands DROP COLUMN IF EXISTS timeout_ms;
+ALTER TABLE commands DROP COLUMN IF EXISTS schema_version;
+ALTER TABLE commands DROP COLUMN IF EXISTS priority;
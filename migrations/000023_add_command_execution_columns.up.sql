@@ -0,0 +1,7 @@
+-- Keep how a command runs with the command, so commands released by the
+-- scheduler or requeued by a replay are dispatched as they were submitted.
+-- Existing rows get the defaults of new commands.
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS priority SMALLINT NOT NULL DEFAULT 2;
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS schema_version VARCHAR(32);
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS timeout_ms BIGINT NOT NULL DEFAULT 30000;
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS retry_backoff_ms BIGINT NOT NULL DEFAULT 5000;
@@ -0,0 +1,14 @@
+-- Callbacks of finished commands are queued with their final status and
+-- delivered from here, so pending deliveries survive restarts. Rows are
+-- deleted once delivered or given up on.
+CREATE TABLE IF NOT EXISTS command_callbacks (
+    command_id UUID PRIMARY KEY REFERENCES commands(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_command_callbacks_next_attempt_at ON command_callbacks(next_attempt_at);
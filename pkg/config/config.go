@@ -35,16 +35,76 @@ type BackendConfig struct {
 }
 
 type CommandConfig struct {
-	MaxWorkers     int           `mapstructure:"max_workers"`
-	QueueSize      int           `mapstructure:"queue_size"`
-	DefaultTimeout time.Duration `mapstructure:"default_timeout"`
+	MaxWorkers     int                 `mapstructure:"max_workers"`
+	QueueSize      int                 `mapstructure:"queue_size"`
+	DefaultTimeout time.Duration       `mapstructure:"default_timeout"`
+	MaxQueueWait   time.Duration       `mapstructure:"max_queue_wait"`
+	LeaseTTL       time.Duration       `mapstructure:"lease_ttl"`
+	ReaperInterval time.Duration       `mapstructure:"reaper_interval"`
+	RetryPolicies  []RetryPolicyConfig `mapstructure:"retry_policies"`
+	// OrderByEntity runs the commands of an entity one at a time, across
+	// replicas through Redis locks expiring after EntityLockTTL
+	OrderByEntity bool          `mapstructure:"order_by_entity"`
+	EntityLockTTL time.Duration `mapstructure:"entity_lock_ttl"`
+	// SchemaDir holds extra payload schemas, named <type>[.v<n>].json
+	SchemaDir string `mapstructure:"schema_dir"`
+	// HandledTypes lists the command types the processors have handlers
+	// for, submissions of other types are rejected. Every type is accepted
+	// while it is empty.
+	HandledTypes []string `mapstructure:"handled_types"`
+	// CallbackSecret signs completion callbacks, callback URLs are rejected
+	// while it is empty. Callbacks to loopback, private and link-local
+	// addresses are refused unless CallbackAllowPrivateNetworks is set.
+	CallbackSecret               string        `mapstructure:"callback_secret"`
+	CallbackTimeout              time.Duration `mapstructure:"callback_timeout"`
+	CallbackMaxAttempts          int           `mapstructure:"callback_max_attempts"`
+	CallbackAllowPrivateNetworks bool          `mapstructure:"callback_allow_private_networks"`
+	// IdempotencyTTL is how long idempotency keys are honoured, overridden
+	// per client (X-Client-ID header) by IdempotencyClientTTLs. Expired keys
+	// are purged every IdempotencySweepInterval. IdempotencyFingerprintKey
+	// keys the stored request hashes and must be the same on every gateway.
+	IdempotencyTTL            time.Duration            `mapstructure:"idempotency_ttl"`
+	IdempotencyClientTTLs     map[string]time.Duration `mapstructure:"idempotency_client_ttls"`
+	IdempotencySweepInterval  time.Duration            `mapstructure:"idempotency_sweep_interval"`
+	IdempotencyFingerprintKey string                   `mapstructure:"idempotency_fingerprint_key"`
+	// SensitiveFields adds payload fields, as JSON Pointers per command
+	// type, that are redacted from responses and logs. They are encrypted
+	// at rest with the keys of EncryptionKeyFile when it is set.
+	SensitiveFields   map[string][]string `mapstructure:"sensitive_fields"`
+	EncryptionKeyFile string              `mapstructure:"encryption_key_file"`
+}
+
+// RetryPolicyConfig configures the backoff between attempts of a command type.
+// An empty command type sets the default policy.
+type RetryPolicyConfig struct {
+	CommandType string        `mapstructure:"command_type"`
+	Strategy    string        `mapstructure:"strategy"`
+	Initial     time.Duration `mapstructure:"initial"`
+	Max         time.Duration `mapstructure:"max"`
+	Multiplier  float64       `mapstructure:"multiplier"`
+	Jitter      float64       `mapstructure:"jitter"`
 }
 
 type OutboxConfig struct {
 	BatchSize       int           `mapstructure:"batch_size"`
+	MaxBatchSize    int           `mapstructure:"max_batch_size"`
 	PollingInterval time.Duration `mapstructure:"polling_interval"`
-	RetryInterval   time.Duration `mapstructure:"retry_interval"`
-	MaxRetries      int           `mapstructure:"max_retries"`
+	// RetryInterval is the delay before the first retry of a message,
+	// doubling per retry up to MaxRetryInterval
+	RetryInterval    time.Duration `mapstructure:"retry_interval"`
+	MaxRetryInterval time.Duration `mapstructure:"max_retry_interval"`
+	MaxRetries       int           `mapstructure:"max_retries"`
+	// Listen wakes the relay through LISTEN/NOTIFY as messages are written,
+	// polling only every FallbackInterval instead of every PollingInterval
+	Listen           bool          `mapstructure:"listen"`
+	FallbackInterval time.Duration `mapstructure:"fallback_interval"`
+	// OrderByAggregate publishes each aggregate's messages strictly in
+	// sequence, keyed by aggregate ID
+	OrderByAggregate bool `mapstructure:"order_by_aggregate"`
+	// TransactionalID publishes every batch in a Kafka transaction, with
+	// the relaying service and host name appended so each relay has its
+	// own ID
+	TransactionalID string `mapstructure:"transactional_id"`
 }
 
 type ServerConfig struct {
@@ -176,6 +236,7 @@ func Load() (*Config, error) {
 	viper.SetDefault("ratelimit.max_tokens", 100)
 	viper.SetDefault("ratelimit.window", "1m")
 	viper.SetDefault("ratelimit.burst_size", 10)
+	viper.SetDefault("outbox.listen", true)
 
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
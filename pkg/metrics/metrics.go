@@ -35,6 +35,21 @@ type Metrics struct {
     WSMessagesIn      prometheus.Counter
     WSMessagesOut     prometheus.Counter
     WSMessageDropped  prometheus.Counter
+    
+    // Command metrics
+    CommandQueueDepth      *prometheus.GaugeVec
+    CommandQueueWait       *prometheus.HistogramVec
+    CommandQueueRejected   *prometheus.CounterVec
+    CommandHandlerDuration *prometheus.HistogramVec
+    CommandHandlerPanics   *prometheus.CounterVec
+    
+    // Outbox metrics
+    OutboxPublishLatency  *prometheus.HistogramVec
+    OutboxPublishDuration *prometheus.HistogramVec
+    OutboxBatchSize       prometheus.Histogram
+    OutboxWakeups         *prometheus.CounterVec
+    OutboxRetries         *prometheus.CounterVec
+    OutboxDeadMessages    *prometheus.CounterVec
 }
 
 func New(namespace string) *Metrics {
@@ -180,6 +195,98 @@ func New(namespace string) *Metrics {
                 Help:      "Total WebSocket messages dropped due to backpressure",
             },
         ),
+        CommandQueueDepth: promauto.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Name:      "command_queue_depth",
+                Help:      "Current number of commands waiting for a worker",
+            },
+            []string{"priority"},
+        ),
+        CommandQueueWait: promauto.NewHistogramVec(
+            prometheus.HistogramOpts{
+                Namespace: namespace,
+                Name:      "command_queue_wait_seconds",
+                Help:      "Time commands spend queued before a worker picks them up",
+                Buckets:   []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30, 60},
+            },
+            []string{"priority"},
+        ),
+        CommandQueueRejected: promauto.NewCounterVec(
+            prometheus.CounterOpts{
+                Namespace: namespace,
+                Name:      "command_queue_rejected_total",
+                Help:      "Total commands rejected because the queue was full",
+            },
+            []string{"priority"},
+        ),
+        CommandHandlerDuration: promauto.NewHistogramVec(
+            prometheus.HistogramOpts{
+                Namespace: namespace,
+                Name:      "command_handler_duration_seconds",
+                Help:      "Time command handlers take per command type and outcome",
+                Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+            },
+            []string{"type", "handler", "outcome"},
+        ),
+        CommandHandlerPanics: promauto.NewCounterVec(
+            prometheus.CounterOpts{
+                Namespace: namespace,
+                Name:      "command_handler_panics_total",
+                Help:      "Total panics recovered from command handlers",
+            },
+            []string{"type", "handler"},
+        ),
+        OutboxPublishLatency: promauto.NewHistogramVec(
+            prometheus.HistogramOpts{
+                Namespace: namespace,
+                Name:      "outbox_publish_latency_seconds",
+                Help:      "Time from writing an outbox message to publishing it",
+                Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+            },
+            []string{"topic"},
+        ),
+        OutboxPublishDuration: promauto.NewHistogramVec(
+            prometheus.HistogramOpts{
+                Namespace: namespace,
+                Name:      "outbox_publish_duration_seconds",
+                Help:      "Time the broker takes to accept an outbox message",
+                Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+            },
+            []string{"topic", "status"},
+        ),
+        OutboxBatchSize: promauto.NewHistogram(
+            prometheus.HistogramOpts{
+                Namespace: namespace,
+                Name:      "outbox_batch_size",
+                Help:      "Messages fetched per outbox batch",
+                Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+            },
+        ),
+        OutboxWakeups: promauto.NewCounterVec(
+            prometheus.CounterOpts{
+                Namespace: namespace,
+                Name:      "outbox_wakeups_total",
+                Help:      "Times the outbox relay woke up, by notification or fallback poll",
+            },
+            []string{"reason"},
+        ),
+        OutboxRetries: promauto.NewCounterVec(
+            prometheus.CounterOpts{
+                Namespace: namespace,
+                Name:      "outbox_retries_total",
+                Help:      "Outbox messages scheduled for another publish attempt",
+            },
+            []string{"topic"},
+        ),
+        OutboxDeadMessages: promauto.NewCounterVec(
+            prometheus.CounterOpts{
+                Namespace: namespace,
+                Name:      "outbox_dead_messages_total",
+                Help:      "Outbox messages given up on after exhausting their retries",
+            },
+            []string{"topic"},
+        ),
     }
 }
 